@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// compareGrammars parses the files of the directory corpus with the
+// grammars in the files a and b, writing each input they disagree on to
+// out. It returns an error if there are any.
+func compareGrammars(a, b, corpus string, out io.Writer) error {
+	langA, err := loadGrammar(a)
+	if err != nil {
+		return err
+	}
+	langB, err := loadGrammar(b)
+	if err != nil {
+		return err
+	}
+	found, err := peg.Compare(langA, langB, os.DirFS(corpus))
+	if err != nil {
+		return err
+	}
+	for _, d := range found {
+		fmt.Fprintln(out, d)
+	}
+	if len(found) > 0 {
+		return errors.New(fmt.Sprintf("%s and %s disagree on %d inputs", a, b, len(found)))
+	}
+	fmt.Fprintf(out, "ok %s and %s agree on %s\n", a, b, corpus)
+	return nil
+}
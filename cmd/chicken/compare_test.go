@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareGrammars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chicken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"old.peg":       "list <- item+\nitem <- ~'[a-z]+' ' '^?\n",
+		"new.peg":       "list <- item+\nitem <- ~'[a-z0-9]+' ' '^?\n",
+		"corpus/a.txt":  "a b",
+		"corpus/b.txt":  "a 1",
+		"corpus/.x.txt": "1",
+	}
+	for name, text := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(text), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old, new, corpus := filepath.Join(dir, "old.peg"), filepath.Join(dir, "new.peg"), filepath.Join(dir, "corpus")
+
+	var out bytes.Buffer
+	if err := compareGrammars(old, old, corpus, &out); err != nil {
+		t.Error(err)
+	}
+	if exp := "ok " + old + " and " + old + " agree on " + corpus + "\n"; out.String() != exp {
+		t.Errorf("got %q, exp %q", out.String(), exp)
+	}
+
+	out.Reset()
+	err = compareGrammars(old, new, corpus, &out)
+	if exp := old + " and " + new + " disagree on 1 inputs"; err == nil || err.Error() != exp {
+		t.Errorf("got error %v, exp %q", err, exp)
+	}
+	if exp := "b.txt: only accepted by the second language; the first says: unexpected input at offset 2\n"; out.String() != exp {
+		t.Errorf("got %q, exp %q", out.String(), exp)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// docGrammar writes the Markdown documentation of the grammar named by
+// args to the file given with -o, or to out.
+func docGrammar(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("doc", flag.ContinueOnError)
+	output := flags.String("o", "", "the `file` to write the documentation to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		usage()
+	}
+	// Flags may follow the grammar too.
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		usage()
+	}
+	lang, err := loadGrammar(args[0])
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	writeDoc(&buf, filepath.Base(args[0]), lang)
+	if *output == "" {
+		_, err = out.Write(buf.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(*output, buf.Bytes(), 0666)
+}
+
+// writeDoc writes a section for each rule of lang, with its ## comments,
+// its definition, links to the rules it refers to and is referred to by,
+// and the inputs of the %test directives for it.
+func writeDoc(w io.Writer, title string, lang *peg.Language) {
+	rules := lang.Rules()
+	refs := make(map[string][]string, len(rules))
+	users := make(map[string][]string, len(rules))
+	for _, rule := range rules {
+		refs[rule] = lang.References(rule)
+		for _, ref := range refs[rule] {
+			users[ref] = append(users[ref], rule)
+		}
+	}
+	matches := make(map[string][]string)
+	fails := make(map[string][]string)
+	start := lang.StartRule()
+	for _, test := range lang.Tests() {
+		rule := test.Rule
+		if rule == "" {
+			rule = start
+		}
+		if test.Fails {
+			fails[rule] = append(fails[rule], test.Input)
+		} else {
+			matches[rule] = append(matches[rule], test.Input)
+		}
+	}
+
+	fmt.Fprintf(w, "# %s\n\nInput is parsed with %s.\n\n", title, ruleLink(start))
+	for _, rule := range rules {
+		fmt.Fprintf(w, "- %s\n", ruleLink(rule))
+	}
+	for _, rule := range rules {
+		fmt.Fprintf(w, "\n<a id=\"%s\"></a>\n## %s\n\n", ruleAnchor(rule), rule)
+		if doc := lang.RuleDoc(rule); doc != "" {
+			fmt.Fprintf(w, "%s\n\n", doc)
+		}
+		fmt.Fprintf(w, "```\n%s\n```\n", lang.RuleText(rule))
+		writeLinks(w, "Uses", refs[rule])
+		writeLinks(w, "Used by", users[rule])
+		writeInputs(w, "Matches", matches[rule])
+		writeInputs(w, "Rejects", fails[rule])
+	}
+}
+
+func writeLinks(w io.Writer, label string, rules []string) {
+	if len(rules) == 0 {
+		return
+	}
+	links := make([]string, len(rules))
+	for i, rule := range rules {
+		links[i] = ruleLink(rule)
+	}
+	fmt.Fprintf(w, "\n%s: %s\n", label, strings.Join(links, ", "))
+}
+
+func writeInputs(w io.Writer, label string, inputs []string) {
+	if len(inputs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s:\n\n", label)
+	for _, input := range inputs {
+		fmt.Fprintf(w, "- %s\n", inlineCode(input))
+	}
+}
+
+func ruleLink(rule string) string {
+	return fmt.Sprintf("[%s](#%s)", rule, ruleAnchor(rule))
+}
+
+// ruleAnchor returns the id of the section of rule. Rule names are
+// identifiers, which need no escaping.
+func ruleAnchor(rule string) string {
+	return "rule-" + rule
+}
+
+// inlineCode writes s as Markdown code, quoted if it spans lines or has
+// other control characters.
+func inlineCode(s string) string {
+	if strings.IndexFunc(s, func(r rune) bool { return r < ' ' }) >= 0 {
+		s = strconv.Quote(s)
+	}
+	if s == "" || strings.Contains(s, "`") {
+		return "`` " + s + " ``"
+	}
+	return "`" + s + "`"
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDoc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chicken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	grammar := filepath.Join(dir, "list.peg")
+	src := "## A list of words.\nlist <- item+\n## A word and a space.\nitem <- ~'[a-z]+' ' '^\n%test 'a bc' matches\n%test 'a`b' fails item\n"
+	if err := ioutil.WriteFile(grammar, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	exp := "# list.peg\n\nInput is parsed with [list](#rule-list).\n\n" +
+		"- [list](#rule-list)\n- [item](#rule-item)\n" +
+		"\n<a id=\"rule-list\"></a>\n## list\n\nA list of words.\n\n```\nlist <- item+\n```\n" +
+		"\nUses: [item](#rule-item)\n\nMatches:\n\n- `a bc`\n" +
+		"\n<a id=\"rule-item\"></a>\n## item\n\nA word and a space.\n\n```\nitem <- ~`[a-z]+` ' '^\n```\n" +
+		"\nUsed by: [list](#rule-list)\n\nRejects:\n\n- `` a`b ``\n"
+
+	var out bytes.Buffer
+	if err := docGrammar([]string{grammar}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != exp {
+		t.Errorf("got:\n%s\nexp:\n%s", out.String(), exp)
+	}
+
+	md := filepath.Join(dir, "list.md")
+	if err := docGrammar([]string{grammar, "-o", md}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(md); err != nil || string(b) != exp {
+		t.Errorf("wrote %q, %v", b, err)
+	}
+}
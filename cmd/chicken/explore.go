@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// exploreGrammar writes an HTML report of the grammar named by args to the
+// file given with -o, or to out. Given a corpus directory with -corpus,
+// the report shows how often its parses called each rule.
+func exploreGrammar(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("explore", flag.ContinueOnError)
+	output := flags.String("o", "", "the `file` to write the report to")
+	corpus := flags.String("corpus", "", "a `directory` of inputs to count rule calls with")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		usage()
+	}
+	// Flags may follow the grammar too.
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		usage()
+	}
+	lang, err := loadGrammar(args[0], peg.Profile(true))
+	if err != nil {
+		return err
+	}
+	var cov *coverage
+	if *corpus != "" {
+		if cov, err = measure(lang, *corpus); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeExplorer(&buf, filepath.Base(args[0]), lang, cov); err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = out.Write(buf.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(*output, buf.Bytes(), 0666)
+}
+
+// coverage is how the inputs of a corpus used the rules of a grammar.
+type coverage struct {
+	files  int
+	failed []string // the inputs that didn't parse.
+	stats  map[string]peg.RuleStats
+	max    int // the most calls of a rule.
+}
+
+// measure parses the files of corpus with lang, which must be profiled,
+// and collects the calls of its rules.
+func measure(lang *peg.Language, corpus string) (*coverage, error) {
+	files, err := corpusFiles(corpus)
+	if err != nil {
+		return nil, err
+	}
+	lang.ResetStats()
+	cov := &coverage{files: len(files), stats: make(map[string]peg.RuleStats)}
+	for _, file := range files {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := lang.ParseBytes(input); err != nil {
+			rel, _ := filepath.Rel(corpus, file)
+			cov.failed = append(cov.failed, rel)
+		}
+	}
+	for _, st := range lang.Stats() {
+		cov.stats[st.Rule] = st
+		if st.Calls > cov.max {
+			cov.max = st.Calls
+		}
+	}
+	return cov, nil
+}
+
+// heat returns the color of a rule called calls times: grey if never, and
+// from orange to red as it nears the most called rule.
+func (c *coverage) heat(calls int) string {
+	if calls == 0 {
+		return "#e0e0e0"
+	}
+	return fmt.Sprintf("#ff%02x00", 220-220*calls/c.max)
+}
+
+type exploreRule struct {
+	Name       string
+	Doc        string
+	Definition template.HTML // with its references linked.
+	Uses       []string
+	UsedBy     []string
+	First      []string
+	Calls      int
+	Failures   int
+	Heat       string
+}
+
+type exploreNode struct {
+	Name       string
+	X, Y, W, H int
+	TextX      int
+	TextY      int
+	Fill       string
+}
+
+type exploreEdge struct {
+	X1, Y1, X2, Y2 int
+}
+
+type explorePage struct {
+	Title    string
+	Start    string
+	Rules    []exploreRule
+	Nodes    []exploreNode
+	Edges    []exploreEdge
+	Width    int
+	Height   int
+	Coverage bool
+	Files    int
+	Failed   []string
+}
+
+// writeExplorer writes the report of lang to w: a graph of the references
+// between its rules, and for each rule its definition, with the rules it
+// refers to linked, what the rule can start with and, if cov isn't nil, how
+// often the corpus called it.
+func writeExplorer(w io.Writer, title string, lang *peg.Language, cov *coverage) error {
+	rules := lang.Rules()
+	isRule := make(map[string]bool, len(rules))
+	refs := make(map[string][]string, len(rules))
+	users := make(map[string][]string, len(rules))
+	for _, rule := range rules {
+		isRule[rule] = true
+		refs[rule] = lang.References(rule)
+		for _, ref := range refs[rule] {
+			users[ref] = append(users[ref], rule)
+		}
+	}
+	page := explorePage{Title: title, Start: lang.StartRule(), Coverage: cov != nil}
+	if cov != nil {
+		page.Files = cov.files
+		page.Failed = cov.failed
+	}
+	for _, rule := range rules {
+		r := exploreRule{
+			Name:       rule,
+			Doc:        lang.RuleDoc(rule),
+			Definition: linkRules(lang.RuleText(rule), isRule),
+			Uses:       refs[rule],
+			UsedBy:     users[rule],
+			First:      lang.FirstSet(rule),
+		}
+		if cov != nil {
+			st := cov.stats[rule]
+			r.Calls, r.Failures, r.Heat = st.Calls, st.Failures, cov.heat(st.Calls)
+		}
+		page.Rules = append(page.Rules, r)
+	}
+	layoutGraph(&page, rules, refs)
+	return exploreTemplate.Execute(w, page)
+}
+
+// Sizes of the graph, in pixels.
+const (
+	nodeHeight = 24
+	layerGap   = 48
+	nodeGap    = 16
+	charWidth  = 8
+)
+
+// layoutGraph places a node for each rule on the page, the start rule at
+// the top and every other rule one layer below the nearest rule referring
+// to it. Rules the start rule never reaches take the bottom layer.
+func layoutGraph(page *explorePage, rules []string, refs map[string][]string) {
+	depth := make(map[string]int, len(rules))
+	if page.Start != "" {
+		depth[page.Start] = 0
+		queue := []string{page.Start}
+		for len(queue) > 0 {
+			rule := queue[0]
+			queue = queue[1:]
+			for _, ref := range refs[rule] {
+				if _, ok := depth[ref]; !ok {
+					depth[ref] = depth[rule] + 1
+					queue = append(queue, ref)
+				}
+			}
+		}
+	}
+	deepest := -1
+	for _, d := range depth {
+		if d > deepest {
+			deepest = d
+		}
+	}
+	var layers [][]string
+	for _, rule := range rules {
+		d, ok := depth[rule]
+		if !ok {
+			d = deepest + 1
+		}
+		for len(layers) <= d {
+			layers = append(layers, nil)
+		}
+		layers[d] = append(layers[d], rule)
+	}
+
+	fills := make(map[string]string, len(page.Rules))
+	for _, r := range page.Rules {
+		fills[r.Name] = r.Heat
+	}
+	nodes := make(map[string]exploreNode, len(rules))
+	for d, layer := range layers {
+		x := nodeGap
+		y := nodeGap + d*(nodeHeight+layerGap)
+		for _, rule := range layer {
+			w := charWidth*len(rule) + 2*nodeGap
+			fill := fills[rule]
+			if fill == "" {
+				fill = "#ffffff"
+			}
+			n := exploreNode{Name: rule, X: x, Y: y, W: w, H: nodeHeight, TextX: x + w/2, TextY: y + nodeHeight*2/3, Fill: fill}
+			nodes[rule] = n
+			page.Nodes = append(page.Nodes, n)
+			x += w + nodeGap
+		}
+		if x > page.Width {
+			page.Width = x
+		}
+		page.Height = y + nodeHeight + nodeGap
+	}
+	for _, rule := range rules {
+		from := nodes[rule]
+		for _, ref := range refs[rule] {
+			if ref == rule {
+				continue
+			}
+			to := nodes[ref]
+			page.Edges = append(page.Edges, exploreEdge{from.X + from.W/2, from.Y + nodeHeight, to.X + to.W/2, to.Y})
+		}
+	}
+}
+
+// linkRules escapes the definition def and links the names in it of the
+// rules in isRule to their sections. Quoted literals and regexps, and the
+// names of externals, predicates and captures, are left alone.
+func linkRules(def string, isRule map[string]bool) template.HTML {
+	var buf bytes.Buffer
+	for i := 0; i < len(def); {
+		c := def[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for j < len(def) && def[j] != c {
+				if def[j] == '\\' && c != '`' {
+					j++
+				}
+				j++
+			}
+			if j < len(def) {
+				j++ // the closing quote.
+			} else {
+				j = len(def)
+			}
+			buf.WriteString(html.EscapeString(def[i:j]))
+			i = j
+		case isIdentStart(c):
+			j := i + 1
+			// Names may be qualified by the namespace of an imported grammar.
+			for j < len(def) && (isIdentStart(def[j]) || def[j] >= '0' && def[j] <= '9' || def[j] == '.' && j+1 < len(def) && isIdentStart(def[j+1])) {
+				j++
+			}
+			name := def[i:j]
+			label := j < len(def) && def[j] == ':'
+			named := i > 0 && (def[i-1] == '@' || def[i-1] == '=' || def[i-1] == '{')
+			if isRule[name] && !label && !named {
+				fmt.Fprintf(&buf, `<a href="#%s">%s</a>`, ruleAnchor(name), name)
+			} else {
+				buf.WriteString(name)
+			}
+			i = j
+		default:
+			buf.WriteString(html.EscapeString(def[i : i+1]))
+			i++
+		}
+	}
+	return template.HTML(buf.String())
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+var exploreTemplate = template.Must(template.New("explore").Funcs(template.FuncMap{
+	"anchor": ruleAnchor,
+	"join":   strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre, code { font-family: monospace; }
+pre { background: #f6f6f6; padding: 0.5em; }
+section { border-top: 1px solid #ccc; margin-top: 1em; }
+svg text { font-family: monospace; font-size: 13px; }
+svg line { stroke: #888; }
+.heat { display: inline-block; width: 1em; height: 1em; vertical-align: middle; border: 1px solid #888; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Input is parsed with <a href="#{{anchor .Start}}">{{.Start}}</a>.</p>
+{{- if .Coverage}}
+<p>Rule calls are counted over {{.Files}} corpus files{{if .Failed}}, of which {{len .Failed}} failed to parse: {{join .Failed ", "}}{{end}}.</p>
+{{- end}}
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+<defs><marker id="arrow" viewBox="0 0 8 8" refX="8" refY="4" markerWidth="8" markerHeight="8" orient="auto"><path d="M0,0 L8,4 L0,8 z" fill="#888"/></marker></defs>
+{{- range .Edges}}
+<line x1="{{.X1}}" y1="{{.Y1}}" x2="{{.X2}}" y2="{{.Y2}}" marker-end="url(#arrow)"/>
+{{- end}}
+{{- range .Nodes}}
+<a href="#{{anchor .Name}}"><rect x="{{.X}}" y="{{.Y}}" width="{{.W}}" height="{{.H}}" rx="4" fill="{{.Fill}}" stroke="#444"/><text x="{{.TextX}}" y="{{.TextY}}" text-anchor="middle">{{.Name}}</text></a>
+{{- end}}
+</svg>
+{{- range .Rules}}
+<section id="{{anchor .Name}}">
+<h2>{{if $.Coverage}}<span class="heat" style="background: {{.Heat}}"></span> {{end}}{{.Name}}</h2>
+{{- if .Doc}}
+<p>{{.Doc}}</p>
+{{- end}}
+<pre>{{.Definition}}</pre>
+{{- if $.Coverage}}
+<p>Called {{.Calls}} times, failing {{.Failures}}.</p>
+{{- end}}
+{{- if .Uses}}
+<p>Uses: {{range $i, $r := .Uses}}{{if $i}}, {{end}}<a href="#{{anchor $r}}">{{$r}}</a>{{end}}</p>
+{{- end}}
+{{- if .UsedBy}}
+<p>Used by: {{range $i, $r := .UsedBy}}{{if $i}}, {{end}}<a href="#{{anchor $r}}">{{$r}}</a>{{end}}</p>
+{{- end}}
+{{- if .First}}
+<p>Starts with: {{range $i, $t := .First}}{{if $i}}, {{end}}<code>{{$t}}</code>{{end}}</p>
+{{- end}}
+</section>
+{{- end}}
+</body>
+</html>
+`))
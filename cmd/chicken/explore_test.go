@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chicken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	grammar := filepath.Join(dir, "list.peg")
+	src := "## A list of <words>.\nlist <- item+\nitem <- word ' '^\nword <- ~'[a-z]+'\nunused <- 'item'\n"
+	if err := ioutil.WriteFile(grammar, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	corpus := filepath.Join(dir, "corpus")
+	if err := os.Mkdir(corpus, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, input := range map[string]string{"a": "a bc d", "b": "e f", "bad": "1"} {
+		if err := ioutil.WriteFile(filepath.Join(corpus, name), []byte(input), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := exploreGrammar([]string{grammar}, &out); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, exp := range []string{
+		"<title>list.peg</title>",
+		`Input is parsed with <a href="#rule-list">list</a>.`,
+		`<section id="rule-item">`,
+		"<p>A list of &lt;words&gt;.</p>",
+		// References are linked, but literals aren't.
+		`<pre><a href="#rule-item">item</a> &lt;- <a href="#rule-word">word</a> &#39; &#39;^</pre>`,
+		`<pre><a href="#rule-unused">unused</a> &lt;- &#39;item&#39;</pre>`,
+		`Uses: <a href="#rule-word">word</a>`,
+		`Used by: <a href="#rule-list">list</a>`,
+		"Starts with: <code>~`[a-z]&#43;`</code>",
+		// The graph puts word in the third layer, unused in the fourth.
+		`<a href="#rule-word"><rect x="16" y="160"`,
+		`<a href="#rule-unused"><rect x="16" y="232"`,
+		`<line x1="48" y1="40" x2="48" y2="88" marker-end="url(#arrow)"/>`,
+	} {
+		if !strings.Contains(report, exp) {
+			t.Errorf("report lacks %s:\n%s", exp, report)
+		}
+	}
+	if strings.Contains(report, "Called") {
+		t.Errorf("report without a corpus has calls:\n%s", report)
+	}
+
+	html := filepath.Join(dir, "list.html")
+	if err := exploreGrammar([]string{grammar, "-corpus", corpus, "-o", html}, &out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = string(b)
+	for _, exp := range []string{
+		"counted over 3 corpus files, of which 1 failed to parse: bad.",
+		`<span class="heat" style="background: #ff0000"></span> word`,
+		`<rect x="16" y="16" width="64" height="24" rx="4" fill="#ff8a00"`,
+		`<span class="heat" style="background: #e0e0e0"></span> unused`,
+		"Called 8 times, failing 3.",
+	} {
+		if !strings.Contains(report, exp) {
+			t.Errorf("report lacks %s:\n%s", exp, report)
+		}
+	}
+}
+
+func TestLinkRules(t *testing.T) {
+	isRule := map[string]bool{"a": true, "lex.b": true}
+	for _, tt := range []struct {
+		def, exp string
+	}{
+		{"a <- lex.b 'a' @a", `<a href="#rule-a">a</a> &lt;- <a href="#rule-lex.b">lex.b</a> &#39;a&#39; @a`},
+		{"a <- x:a. ", `<a href="#rule-a">a</a> &lt;- x:<a href="#rule-a">a</a>. `},
+	} {
+		if got := string(linkRules(tt.def, isRule)); got != tt.exp {
+			t.Errorf("%q: got %s, expected %s", tt.def, got, tt.exp)
+		}
+	}
+}
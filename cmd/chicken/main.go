@@ -0,0 +1,188 @@
+// Command chicken is a collection of tools for working with peg grammars.
+//
+// Usage:
+//
+//	chicken parse [-format text|json|sexpr|dot] [-errors plain|caret|color|json] grammar.peg [input]
+//	chicken repl grammar.peg [rule]
+//	chicken test grammar.peg
+//	chicken doc grammar.peg [-o grammar.md]
+//	chicken explore grammar.peg [-corpus corpus/] [-o grammar.html]
+//	chicken serve [-addr localhost:8080]
+//	chicken watch grammar.peg corpus/
+//	chicken compare old.peg new.peg corpus/
+//	chicken memo grammar.peg corpus/
+//	chicken trace grammar.peg input trace.bin
+//	chicken replay trace.bin [input]
+//
+// parse prints the tree of input, or of the standard input, in the chosen
+// format, and parse errors in the chosen error format. repl parses lines
+// read from the standard input with the root rule, or the given one. test
+// runs the %test directives of the grammar and reports the failures, and
+// warns about alternatives of choices that can never match. watch
+// parses the files in the corpus directory again whenever they or the
+// grammar change, and prints which failed and how their trees changed.
+// compare parses the files in the corpus directory with two versions of a
+// grammar and prints the inputs only one of them accepts or that they
+// parse into different trees. memo profiles parsing the corpus and prints
+// the %memo directive of the rules whose memoization pays off for it.
+// doc writes Markdown documentation of the grammar, with a section for
+// each rule, to the standard output or the file given with -o. explore
+// writes an HTML report of the grammar instead, with a graph of the rules,
+// the rules each refers to and is referred to by, and what each can start
+// with. Given a corpus, the report shows how often parsing it called each
+// rule. serve runs a playground on the address given with -addr: a web
+// page to try grammars on inputs, which posts them to /parse to get the
+// tree as JSON, or the errors of the grammar or the input with their
+// positions.
+// trace parses input with the grammar and records the rules tried into a
+// trace file. replay steps through a recorded trace interactively; given
+// the input that was parsed, it also shows where each rule was tried.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chicken parse [-format text|json|sexpr|dot] [-errors plain|caret|color|json] grammar.peg [input]")
+	fmt.Fprintln(os.Stderr, "       chicken repl grammar.peg [rule]")
+	fmt.Fprintln(os.Stderr, "       chicken test grammar.peg")
+	fmt.Fprintln(os.Stderr, "       chicken doc grammar.peg [-o grammar.md]")
+	fmt.Fprintln(os.Stderr, "       chicken explore grammar.peg [-corpus corpus/] [-o grammar.html]")
+	fmt.Fprintln(os.Stderr, "       chicken serve [-addr localhost:8080]")
+	fmt.Fprintln(os.Stderr, "       chicken watch grammar.peg corpus/")
+	fmt.Fprintln(os.Stderr, "       chicken compare old.peg new.peg corpus/")
+	fmt.Fprintln(os.Stderr, "       chicken memo grammar.peg corpus/")
+	fmt.Fprintln(os.Stderr, "       chicken trace grammar.peg input trace.bin")
+	fmt.Fprintln(os.Stderr, "       chicken replay trace.bin [input]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch args := os.Args[2:]; os.Args[1] {
+	case "parse":
+		err = parseFile(args, os.Stdin, os.Stdout)
+	case "repl":
+		if len(args) != 1 && len(args) != 2 {
+			usage()
+		}
+		err = replGrammar(args, os.Stdin, os.Stdout)
+	case "test":
+		if len(args) != 1 {
+			usage()
+		}
+		err = testGrammar(args[0], os.Stdout)
+	case "doc":
+		err = docGrammar(args, os.Stdout)
+	case "explore":
+		err = exploreGrammar(args, os.Stdout)
+	case "serve":
+		err = serveGrammars(args, os.Stdout)
+	case "watch":
+		if len(args) != 2 {
+			usage()
+		}
+		err = newWatcher(args[0], args[1], os.Stdout).watch(500 * time.Millisecond)
+	case "compare":
+		if len(args) != 3 {
+			usage()
+		}
+		err = compareGrammars(args[0], args[1], args[2], os.Stdout)
+	case "memo":
+		if len(args) != 2 {
+			usage()
+		}
+		err = memoGrammar(args[0], args[1], os.Stdout)
+	case "trace":
+		if len(args) != 3 {
+			usage()
+		}
+		err = record(args[0], args[1], args[2])
+	case "replay":
+		if len(args) != 1 && len(args) != 2 {
+			usage()
+		}
+		err = replayFile(args, os.Stdin, os.Stdout)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "chicken:", err)
+		os.Exit(1)
+	}
+}
+
+// record parses input with grammar and writes the trace to out. The trace
+// is written even if the input fails to parse.
+func record(grammar, input, out string) error {
+	lang, err := loadGrammar(grammar)
+	if err != nil {
+		return err
+	}
+	s, err := peg.NewFileSource(input)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	trace := &peg.Trace{}
+	s.Record(trace)
+	_, perr := lang.ParseSource(s)
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if _, err := trace.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return perr
+}
+
+func replGrammar(args []string, in io.Reader, out io.Writer) error {
+	lang, err := loadGrammar(args[0])
+	if err != nil {
+		return err
+	}
+	r := &repl{lang: lang, format: "text", out: out}
+	if len(args) == 2 {
+		r.rule = args[1]
+	}
+	r.run(bufio.NewScanner(in))
+	return nil
+}
+
+func replayFile(args []string, in io.Reader, out io.Writer) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	trace, err := peg.ReadTrace(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	r := &replay{trace: trace, out: out}
+	if len(args) == 2 {
+		if r.input, err = peg.NewFileSource(args[1]); err != nil {
+			return err
+		}
+		defer r.input.Close()
+	}
+	r.run(bufio.NewScanner(in))
+	return nil
+}
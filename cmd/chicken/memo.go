@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// memoGrammar profiles parsing the files of the directory corpus with the
+// grammar in the file name, and writes the %memo directive of the rules
+// worth memoizing to out.
+func memoGrammar(name, corpus string, out io.Writer) error {
+	lang, err := loadGrammar(name)
+	if err != nil {
+		return err
+	}
+	_, rules, err := lang.TuneMemo(os.DirFS(corpus))
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		fmt.Fprintf(out, "no rule of %s is worth memoizing for %s\n", name, corpus)
+		return nil
+	}
+	fmt.Fprintf(out, "%%memo %s\n", strings.Join(rules, " "))
+	return nil
+}
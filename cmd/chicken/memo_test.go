@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoGrammar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chicken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	grammar, corpus := filepath.Join(dir, "prgm.peg"), filepath.Join(dir, "corpus")
+	if err := ioutil.WriteFile(grammar, []byte("prgm <- a / b\na <- num 'a'\nb <- num 'b'\nnum <- ~'[0-9]+'\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(corpus, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(corpus, "b.txt"), []byte(strings.Repeat("1", 100000)+"b"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := memoGrammar(grammar, corpus, &out); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "%memo num\n"; out.String() != exp {
+		t.Errorf("got %q, exp %q", out.String(), exp)
+	}
+}
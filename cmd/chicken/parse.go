@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// parseFile parses the input named by args, or in if there is none, with
+// the grammar in args[0] and writes the tree to out.
+func parseFile(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("parse", flag.ContinueOnError)
+	format := flags.String("format", "text", "the `format` of the tree: text, json, sexpr or dot")
+	errs := flags.String("errors", "plain", "the `format` of parse errors: plain, caret, color or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	formatter, err := errorFormatter(*errs)
+	if err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) != 1 && len(args) != 2 {
+		usage()
+	}
+	lang, err := loadGrammar(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	input, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	tree, err := lang.ParseBytes(input)
+	if err != nil {
+		return errors.New(formatter.FormatError(err, input))
+	}
+	return writeTree(out, tree, *format)
+}
+
+// errorFormatter returns the formatter of parse errors called name.
+func errorFormatter(name string) (peg.ErrorFormatter, error) {
+	switch name {
+	case "plain":
+		return peg.PlainFormatter{}, nil
+	case "caret":
+		return peg.CaretFormatter{}, nil
+	case "color":
+		return peg.CaretFormatter{Color: true}, nil
+	case "json":
+		return peg.JSONFormatter{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown error format %s", name))
+}
+
+// loadGrammar compiles the grammar in the file name with opts.
+func loadGrammar(name string, opts ...peg.Option) (*peg.Language, error) {
+	// Grammars may import others from their directory.
+	return peg.NewLanguageFS(os.DirFS(filepath.Dir(name)), filepath.Base(name), opts...)
+}
+
+// writeTree writes tree to out in the named format.
+func writeTree(out io.Writer, tree *peg.ParseTree, format string) error {
+	switch format {
+	case "text":
+		_, err := io.WriteString(out, tree.String())
+		return err
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tree)
+	case "sexpr":
+		_, err := fmt.Fprintln(out, tree.SExpr())
+		return err
+	case "dot":
+		return tree.WriteDot(out)
+	}
+	return errors.New(fmt.Sprintf("unknown format %s", format))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chicken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	grammar := filepath.Join(dir, "list.peg")
+	if err := ioutil.WriteFile(grammar, []byte("list <- item+\nitem <- ~'[a-z]+' ' '^"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format, exp string
+	}{
+		{"sexpr", "(item+ (item \"a\") (item \"bc\"))\n"},
+		{"json", "{\n  \"type\": \"item+\",\n  \"pos\": 0,\n  \"end\": 4,\n  \"children\": [\n    {\n      \"type\": \"item\",\n      \"text\": \"a\",\n      \"pos\": 0,\n      \"end\": 1\n    },\n    {\n      \"type\": \"item\",\n      \"text\": \"bc\",\n      \"pos\": 2,\n      \"end\": 4\n    }\n  ]\n}\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := parseFile([]string{"-format", tt.format, grammar}, strings.NewReader("a bc"), &out); err != nil {
+			t.Errorf("%s: %s", tt.format, err)
+			continue
+		}
+		if out.String() != tt.exp {
+			t.Errorf("%s: got\n%s\nexp\n%s", tt.format, out.String(), tt.exp)
+		}
+	}
+
+	if err := parseFile([]string{grammar}, strings.NewReader("1"), ioutil.Discard); err == nil {
+		t.Errorf("expected a parse error")
+	}
+	if err := parseFile([]string{"-format", "xml", grammar}, strings.NewReader("a"), ioutil.Discard); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+	err = parseFile([]string{"-errors", "caret", grammar}, strings.NewReader("1"), ioutil.Discard)
+	if err == nil || !strings.HasPrefix(err.Error(), "1:1: expected") || !strings.HasSuffix(err.Error(), "\n1\n^") {
+		t.Errorf("unexpected caret error %v", err)
+	}
+	if err := parseFile([]string{"-errors", "xml", grammar}, strings.NewReader("a"), ioutil.Discard); err == nil {
+		t.Errorf("expected an error for an unknown error format")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+const replHelp = `enter a line of input to parse it, or a command:
+  :rule name     parse with the rule name
+  :rules         list the rules of the grammar
+  :format name   print trees as text, json, sexpr or dot
+  :q             quit
+`
+
+// repl parses lines of input with a grammar.
+type repl struct {
+	lang   *peg.Language
+	rule   string // the rule to parse with, or "" for the root rule.
+	format string
+	out    io.Writer
+}
+
+func (r *repl) run(in *bufio.Scanner) {
+	fmt.Fprintln(r.out, ":h for help")
+	for fmt.Fprint(r.out, "> "); in.Scan(); fmt.Fprint(r.out, "> ") {
+		line := in.Text()
+		if strings.HasPrefix(line, ":") {
+			if !r.command(strings.TrimSpace(line[1:])) {
+				return
+			}
+			continue
+		}
+		r.parse(line)
+	}
+}
+
+// command runs a single command and reports whether to continue.
+func (r *repl) command(cmd string) bool {
+	verb, arg := cmd, ""
+	if i := strings.IndexByte(cmd, ' '); i >= 0 {
+		verb, arg = cmd[:i], strings.TrimSpace(cmd[i+1:])
+	}
+	switch verb {
+	case "rule":
+		for _, name := range r.lang.Rules() {
+			if name == arg {
+				r.rule = arg
+				return true
+			}
+		}
+		fmt.Fprintf(r.out, "undefined rule %s\n", arg)
+	case "rules":
+		fmt.Fprintln(r.out, strings.Join(r.lang.Rules(), " "))
+	case "format":
+		switch arg {
+		case "text", "json", "sexpr", "dot":
+			r.format = arg
+		default:
+			fmt.Fprintf(r.out, "unknown format %s\n", arg)
+		}
+	case "q":
+		return false
+	default:
+		fmt.Fprint(r.out, replHelp)
+	}
+	return true
+}
+
+// parse prints the tree of line, or the error with a caret under the
+// offset where it occurred.
+func (r *repl) parse(line string) {
+	s := peg.SourceFromBytes([]byte(line))
+	var tree *peg.ParseTree
+	var err error
+	if r.rule == "" {
+		tree, err = r.lang.ParseSource(s)
+	} else {
+		tree, err = r.lang.ParseRule(r.rule, s)
+	}
+	if err != nil {
+		fmt.Fprintln(r.out, peg.CaretFormatter{}.FormatError(err, []byte(line)))
+		return
+	}
+	writeTree(r.out, tree, r.format)
+	if tree.End < len(line) {
+		err := &peg.ParseError{Pos: tree.End, Msg: "unparsed input"}
+		fmt.Fprintln(r.out, peg.CaretFormatter{}.FormatError(err, []byte(line)))
+	}
+}
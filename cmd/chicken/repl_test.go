@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+func TestRepl(t *testing.T) {
+	lang, err := peg.NewParser(strings.NewReader("list <- item+\nitem <- ~'[a-z]+' ' '^\nnumber <- ~'[0-9]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	r := &repl{lang: lang, format: "sexpr", out: &out}
+	r.run(bufio.NewScanner(strings.NewReader("ab c\n1\n:rule number\n12 x\n:rule nope\n:q\nab\n")))
+	exp := `:h for help
+> (item+ (item "ab") (item "c"))
+> 1:1: expected ~` + "`[a-z]+`" + `
+1
+^
+> > (number "12")
+1:3: unparsed input
+12 x
+  ^
+> undefined rule nope
+> `
+	if out.String() != exp {
+		t.Errorf("got:\n%s\nexp:\n%s", out.String(), exp)
+	}
+}
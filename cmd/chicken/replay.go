@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+const replayHelp = `commands:
+  <enter>, n   next event
+  b            previous event
+  f            next failure
+  m            next match
+  r rule       next attempt of rule
+  g n          go to event n
+  c            print the remaining events
+  q            quit
+`
+
+// replay steps through a recorded trace.
+type replay struct {
+	trace *peg.Trace
+	input *peg.Source // the parsed input, if known.
+	out   io.Writer
+	i     int // the current event.
+}
+
+func (r *replay) run(in *bufio.Scanner) {
+	fmt.Fprintf(r.out, "%d events, %d rules; h for help\n", len(r.trace.Events), len(r.trace.Rules))
+	if len(r.trace.Events) == 0 {
+		return
+	}
+	r.show()
+	for fmt.Fprint(r.out, "> "); in.Scan(); fmt.Fprint(r.out, "> ") {
+		if !r.command(strings.TrimSpace(in.Text())) {
+			return
+		}
+	}
+}
+
+// command runs a single command and reports whether to continue.
+func (r *replay) command(cmd string) bool {
+	verb, arg := cmd, ""
+	if i := strings.IndexByte(cmd, ' '); i >= 0 {
+		verb, arg = cmd[:i], strings.TrimSpace(cmd[i+1:])
+	}
+	switch verb {
+	case "", "n":
+		r.seek(r.i+1, nil)
+	case "b":
+		r.seek(r.i-1, nil)
+	case "f":
+		r.seek(r.i+1, func(e peg.TraceEvent) bool { return e.Kind == peg.TraceFail })
+	case "m":
+		r.seek(r.i+1, func(e peg.TraceEvent) bool { return e.Kind == peg.TraceMatch })
+	case "r":
+		r.seek(r.i+1, func(e peg.TraceEvent) bool {
+			return e.Kind == peg.TraceEnter && r.trace.Rules[e.Rule] == arg
+		})
+	case "g":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintln(r.out, "g needs an event number")
+			return true
+		}
+		r.seek(n, nil)
+	case "c":
+		for r.i+1 < len(r.trace.Events) {
+			r.i++
+			r.show()
+		}
+	case "q":
+		return false
+	default:
+		fmt.Fprint(r.out, replayHelp)
+	}
+	return true
+}
+
+// seek moves to the first event from i on that satisfies ok, or to i if ok
+// is nil.
+func (r *replay) seek(i int, ok func(peg.TraceEvent) bool) {
+	for ; ok != nil && i < len(r.trace.Events); i++ {
+		if ok(r.trace.Events[i]) {
+			break
+		}
+	}
+	if i < 0 || i >= len(r.trace.Events) {
+		fmt.Fprintln(r.out, "no such event")
+		return
+	}
+	r.i = i
+	r.show()
+}
+
+// depth is the number of rules entered but not left before event i.
+func (r *replay) depth(i int) int {
+	depth := 0
+	for _, e := range r.trace.Events[:i] {
+		if e.Kind == peg.TraceEnter {
+			depth++
+		} else {
+			depth--
+		}
+	}
+	if r.trace.Events[i].Kind != peg.TraceEnter {
+		depth--
+	}
+	return depth
+}
+
+func (r *replay) show() {
+	e := r.trace.Events[r.i]
+	fmt.Fprintf(r.out, "%5d %s%s %s at %s", r.i, strings.Repeat("  ", r.depth(r.i)), e.Kind, r.trace.Rules[e.Rule], r.position(e.Pos))
+	if e.Kind == peg.TraceMatch {
+		fmt.Fprintf(r.out, "-%s", r.position(e.End))
+		if r.input != nil {
+			text := r.input.Bytes()[e.Pos:e.End]
+			if len(text) > 40 {
+				text = text[:40]
+			}
+			fmt.Fprintf(r.out, " %q", text)
+		}
+	}
+	fmt.Fprintln(r.out)
+}
+
+func (r *replay) position(offset int) string {
+	if r.input == nil || offset > len(r.input.Bytes()) {
+		return strconv.Itoa(offset)
+	}
+	line, col := r.input.Position(offset)
+	return fmt.Sprintf("%d:%d", line, col)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+func TestReplay(t *testing.T) {
+	lang, err := peg.NewParser(strings.NewReader("prgm <- a / b\na <- 'a'\nb <- 'b'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := peg.SourceFromBytes([]byte("b"))
+	trace := &peg.Trace{}
+	s.Record(trace)
+	if _, err := lang.ParseSource(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// The trace survives serialization.
+	var buf bytes.Buffer
+	if _, err := trace.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if trace, err = peg.ReadTrace(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	r := &replay{trace: trace, input: s, out: &out}
+	r.run(bufio.NewScanner(strings.NewReader("f\nr b\nc\n")))
+	exp := `6 events, 3 rules; h for help
+    0 enter prgm at 1:1
+>     2   fail a at 1:1
+>     3   enter b at 1:1
+>     4   match b at 1:1-1:2 "b"
+    5 match prgm at 1:1-1:2 "b"
+> `
+	if out.String() != exp {
+		t.Errorf("got:\n%s\nexp:\n%s", out.String(), exp)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// Limits of the playground, which compiles grammars and parses inputs from
+// anyone who can reach it.
+const (
+	maxRequestBytes = 1 << 20
+	maxPlayCalls    = 1000000
+	maxPlayDepth    = 1000
+)
+
+// serveGrammars runs the playground on the address given with -addr.
+func serveGrammars(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:8080", "the `address` to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		usage()
+	}
+	fmt.Fprintf(out, "serving the playground on http://%s/\n", *addr)
+	return http.ListenAndServe(*addr, newPlayground())
+}
+
+// playRequest is what is posted to /parse: a grammar, an input, and the
+// rule to parse it with, or the root rule if empty.
+type playRequest struct {
+	Grammar string `json:"grammar"`
+	Input   string `json:"input"`
+	Rule    string `json:"rule"`
+}
+
+// playResponse holds the tree of the input or, if the grammar or the input
+// was rejected, the errors.
+type playResponse struct {
+	Tree     *peg.ParseTree `json:"tree,omitempty"`
+	Errors   []playError    `json:"errors,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+}
+
+// playError is an error of the grammar or of the input, as Source says.
+// Errors of the grammar have a Pos of -1 but the Line and Col where they
+// are in it.
+type playError struct {
+	Source string `json:"source"`
+	peg.ErrorDetail
+}
+
+func newPlayground() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, playgroundPage)
+	})
+	mux.HandleFunc("/parse", playParse)
+	return mux
+}
+
+func playParse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "the grammar and input must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+	var req playRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, resp := play(req)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// play compiles the grammar of req and parses its input, returning the
+// status to answer with and the response.
+func play(req playRequest) (int, playResponse) {
+	lang, err := peg.NewLanguage(req.Grammar, peg.MaxRuleCalls(maxPlayCalls), peg.MaxDepth(maxPlayDepth))
+	if err != nil {
+		return http.StatusBadRequest, playResponse{Errors: grammarErrors(err)}
+	}
+	src := peg.SourceFromBytes([]byte(req.Input))
+	var tree *peg.ParseTree
+	if req.Rule == "" {
+		tree, err = lang.ParseSource(src)
+	} else {
+		tree, err = lang.ParseRule(req.Rule, src)
+	}
+	var resp playResponse
+	for _, warning := range src.Warnings() {
+		resp.Warnings = append(resp.Warnings, warning.String())
+	}
+	if err != nil {
+		resp.Errors = []playError{{"input", peg.Detail(err, []byte(req.Input))}}
+		return http.StatusUnprocessableEntity, resp
+	}
+	resp.Tree = tree
+	return http.StatusOK, resp
+}
+
+// grammarErrors lists the problems of a grammar that failed to compile.
+func grammarErrors(err error) []playError {
+	var errs peg.GrammarErrors
+	switch e := err.(type) {
+	case peg.GrammarErrors:
+		errs = e
+	case *peg.GrammarError:
+		errs = peg.GrammarErrors{e}
+	default:
+		return []playError{{"grammar", peg.ErrorDetail{Pos: -1, Msg: err.Error()}}}
+	}
+	list := make([]playError, len(errs))
+	for i, e := range errs {
+		list[i] = playError{"grammar", peg.ErrorDetail{Pos: -1, Line: e.Line, Col: e.Col, Rule: e.Rule, Msg: e.Msg}}
+	}
+	return list
+}
+
+const playgroundPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>chicken playground</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+textarea { width: 100%; font-family: monospace; }
+pre { background: #f6f6f6; padding: 0.5em; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>chicken playground</h1>
+<p>Grammar:</p>
+<textarea id="grammar" rows="12">%whitespace ws
+sum <- num more*
+more <- op num
+op <- '+' / '-'
+num <- ~'[0-9]+'
+ws <- ~'[ ]+'</textarea>
+<p>Input, parsed with the rule <input id="rule" placeholder="the root rule">:</p>
+<textarea id="input" rows="4">1 + 2 - 3</textarea>
+<p><button id="parse">Parse</button></p>
+<pre id="result"></pre>
+<script>
+document.getElementById("parse").onclick = function() {
+	var result = document.getElementById("result");
+	fetch("parse", {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({
+			grammar: document.getElementById("grammar").value,
+			input: document.getElementById("input").value,
+			rule: document.getElementById("rule").value
+		})
+	}).then(function(resp) { return resp.json(); }).then(function(resp) {
+		result.className = resp.errors ? "error" : "";
+		if (resp.errors) {
+			result.textContent = resp.errors.map(function(e) {
+				var at = e.line ? e.line + ":" + e.col + ": " : "";
+				return e.source + ": " + at + e.message;
+			}).join("\n");
+		} else {
+			result.textContent = JSON.stringify(resp.tree, null, 2);
+		}
+		if (resp.warnings) {
+			result.textContent += "\n" + resp.warnings.join("\n");
+		}
+	}, function(err) {
+		result.className = "error";
+		result.textContent = String(err);
+	});
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlayground(t *testing.T) {
+	srv := httptest.NewServer(newPlayground())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("got page %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	grammar := "list <- item+\nitem <- ~'[a-z]+' ' '^"
+	for _, test := range []struct {
+		req    string
+		status int
+		exp    string
+	}{
+		{`{"grammar": "` + grammar + `", "input": "a b"}`, http.StatusOK,
+			`{"tree":{"type":"item+","pos":0,"end":3,"children":[{"type":"item","text":"a","pos":0,"end":1},{"type":"item","text":"b","pos":2,"end":3}]}}`},
+		{`{"grammar": "` + grammar + `", "input": "b", "rule": "item"}`, http.StatusOK,
+			`{"tree":{"type":"item","text":"b","pos":0,"end":1}}`},
+		{`{"grammar": "` + grammar + `", "input": "1"}`, http.StatusUnprocessableEntity,
+			`{"errors":[{"source":"input","pos":0,"line":1,"col":1,"message":"expected ~` + "`[a-z]+`" + `","expected":["~` + "`[a-z]+`" + `"],"found":"1"}]}`},
+		{`{"grammar": "list <- item+", "input": "a"}`, http.StatusBadRequest,
+			`{"errors":[{"source":"grammar","pos":-1,"line":1,"col":9,"rule":"list","message":"undefined rule item"}]}`},
+		{`{"grammar": "a <- / 'x'", "input": "x"}`, http.StatusBadRequest,
+			`{"errors":[{"source":"grammar","pos":-1,"line":1,"col":6,"rule":"a","message":"expected expression before '/'"}]}`},
+		{`{"grammar": 1}`, http.StatusBadRequest, ""},
+	} {
+		resp, err := http.Post(srv.URL+"/parse", "application/json", strings.NewReader(strings.Replace(test.req, "\n", `\n`, -1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body json.RawMessage
+		derr := json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: got status %d, exp %d", test.req, resp.StatusCode, test.status)
+		}
+		if test.exp != "" && (derr != nil || string(body) != test.exp) {
+			t.Errorf("%s: got %s, exp %s", test.req, body, test.exp)
+		}
+	}
+
+	resp, err = http.Get(srv.URL + "/parse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /parse: got status %d", resp.StatusCode)
+	}
+}
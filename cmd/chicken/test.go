@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// testGrammar runs the %test directives of the grammar in the file name,
+// writing each failure and a summary to out, after warnings about the
+// alternatives of its choices that never match. It returns an error if any
+// test failed.
+func testGrammar(name string, out io.Writer) error {
+	lang, err := loadGrammar(name)
+	if err != nil {
+		return err
+	}
+	for _, a := range lang.Ambiguities() {
+		fmt.Fprintf(out, "%s: warning: %s\n", name, a)
+	}
+	total := len(lang.Tests())
+	err = lang.RunTests()
+	if err == nil {
+		fmt.Fprintf(out, "ok %s: %d tests\n", name, total)
+		return nil
+	}
+	failures, ok := err.(peg.GrammarErrors)
+	if !ok {
+		return err
+	}
+	for _, f := range failures {
+		// The files of grammars are relative to the directory of name.
+		failure := *f
+		failure.File = filepath.Join(filepath.Dir(name), f.File)
+		fmt.Fprintf(out, "%s\n", &failure)
+	}
+	return errors.New(fmt.Sprintf("%d of %d tests failed", len(failures), total))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGrammarTests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chicken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	grammar := filepath.Join(dir, "list.peg")
+	if err := ioutil.WriteFile(grammar, []byte("list <- item+\nitem <- ~'[a-z]+' ' '^\n%test 'a bc' matches\n%test '1' fails\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := testGrammar(grammar, &out); err != nil {
+		t.Error(err)
+	}
+	if exp := "ok " + grammar + ": 2 tests\n"; out.String() != exp {
+		t.Errorf("got %q, expected %q", out.String(), exp)
+	}
+
+	if err := ioutil.WriteFile(grammar, []byte("list <- item+\nitem <- ~'[a-z]+' ' '^\n%test '1' matches\n%test 'a' fails item\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	err = testGrammar(grammar, &out)
+	if err == nil || err.Error() != "2 of 2 tests failed" {
+		t.Errorf("unexpected error %v", err)
+	}
+	exp := grammar + ":3:1: %test '1' matches: expected ~`[a-z]+` at offset 0: \"1\"\n" +
+		grammar + ":4:1: item: %test 'a' fails item: input matched\n"
+	if out.String() != exp {
+		t.Errorf("got:\n%s\nexp:\n%s", out.String(), exp)
+	}
+
+	if err := ioutil.WriteFile(grammar, []byte("op <- '=' / '=='\n%test '=' matches\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := testGrammar(grammar, &out); err != nil {
+		t.Error(err)
+	}
+	exp = grammar + ": warning: op: alternative 2, '==', never matches: alternative 1, '=', matches the start of it\n" +
+		"ok " + grammar + ": 1 tests\n"
+	if out.String() != exp {
+		t.Errorf("got:\n%s\nexp:\n%s", out.String(), exp)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// watcher parses a corpus with a grammar again whenever one of them
+// changes.
+type watcher struct {
+	grammar string
+	corpus  string // a directory of inputs.
+	out     io.Writer
+	stamps  map[string]string         // modification stamps of the files seen.
+	trees   map[string]*peg.ParseTree // the trees parsed by the last check.
+}
+
+func newWatcher(grammar, corpus string, out io.Writer) *watcher {
+	return &watcher{grammar: grammar, corpus: corpus, out: out, trees: make(map[string]*peg.ParseTree)}
+}
+
+// watch checks the corpus every time a file changes, polling every
+// interval. It only returns if the corpus can't be read.
+func (w *watcher) watch(interval time.Duration) error {
+	for {
+		changed, err := w.changed()
+		if err != nil {
+			return err
+		}
+		if changed {
+			w.check()
+		}
+		time.Sleep(interval)
+	}
+}
+
+// corpusFiles returns the files of the directory corpus in lexical order,
+// skipping hidden ones.
+func corpusFiles(corpus string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(corpus, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != corpus {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// changed reports whether the grammar or the corpus changed since it was
+// last called.
+func (w *watcher) changed() (bool, error) {
+	files, err := corpusFiles(w.corpus)
+	if err != nil {
+		return false, err
+	}
+	stamps := make(map[string]string, len(files)+1)
+	for _, name := range append(files, w.grammar) {
+		if info, err := os.Stat(name); err == nil {
+			stamps[name] = fmt.Sprintf("%d %d", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	changed := len(stamps) != len(w.stamps)
+	for name, stamp := range stamps {
+		if w.stamps[name] != stamp {
+			changed = true
+		}
+	}
+	w.stamps = stamps
+	return changed, nil
+}
+
+// check compiles the grammar, parses every input and prints which passed,
+// which failed and how the trees changed since the last check.
+func (w *watcher) check() {
+	fmt.Fprintf(w.out, "--- %s\n", time.Now().Format("15:04:05"))
+	lang, err := loadGrammar(w.grammar)
+	if err != nil {
+		fmt.Fprintf(w.out, "%s: %s\n", w.grammar, err)
+		return
+	}
+	files, err := corpusFiles(w.corpus)
+	if err != nil {
+		fmt.Fprintln(w.out, err)
+		return
+	}
+	passed, failed := 0, 0
+	trees := make(map[string]*peg.ParseTree, len(files))
+	for _, name := range files {
+		// The files are read rather than mapped, as an editor may truncate
+		// one while it is parsed.
+		input, err := ioutil.ReadFile(name)
+		if err != nil {
+			failed++
+			fmt.Fprintf(w.out, "FAIL %s: %s\n", name, err)
+			continue
+		}
+		tree, err := lang.ParseBytes(input)
+		if err == nil && tree.End < len(input) {
+			err = errors.New(fmt.Sprintf("unparsed input at offset %d", tree.End))
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(w.out, "FAIL %s: %s\n", name, err)
+			continue
+		}
+		trees[name] = tree
+		passed++
+		if old, ok := w.trees[name]; ok {
+			if edits := peg.Diff(old, tree); len(edits) > 0 {
+				fmt.Fprintf(w.out, "changed %s:\n", name)
+				for _, edit := range edits {
+					fmt.Fprintf(w.out, "  %s\n", edit)
+				}
+			}
+		}
+	}
+	w.trees = trees
+	fmt.Fprintf(w.out, "%d passed, %d failed\n", passed, failed)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chicken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, text string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("list.peg", "list <- item+\nitem <- ~'[a-z]+' ' '^")
+	if err := os.Mkdir(filepath.Join(dir, "corpus"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	write("corpus/a.txt", "a b")
+	write("corpus/b.txt", "a 1")
+
+	var out bytes.Buffer
+	w := newWatcher(filepath.Join(dir, "list.peg"), filepath.Join(dir, "corpus"), &out)
+	if changed, err := w.changed(); err != nil || !changed {
+		t.Fatalf("expected a change at the start, got %v %v", changed, err)
+	}
+	w.check()
+	if !strings.Contains(out.String(), "b.txt: unparsed input at offset 2\n1 passed, 1 failed\n") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if changed, _ := w.changed(); changed {
+		t.Errorf("expected no change")
+	}
+
+	out.Reset()
+	write("corpus/a.txt", "a bc d")
+	write("corpus/b.txt", "a")
+	if changed, _ := w.changed(); !changed {
+		t.Errorf("expected a change")
+	}
+	w.check()
+	exp := "changed " + filepath.Join(dir, "corpus", "a.txt") + ":\n" +
+		"  ~ item+/item[1] at 2: (item \"b\") -> (item \"bc\")\n" +
+		"  + item+/item[2] at 5: (item \"d\")\n"
+	for _, s := range []string{exp, "2 passed, 0 failed\n"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected %q in:\n%s", s, out.String())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// astField is a field of the struct generated for a rule.
+type astField struct {
+	peg.Field
+	ident string
+	types []string // the node types the field is built from.
+}
+
+// generateAST writes a struct for the nodes of every rule of lang to buf,
+// with a field for every rule its body refers to, and a function building
+// it from a parse tree. Fields are named after their label, or else after
+// the rule they refer to, and references inside closures are slices.
+func generateAST(buf *bytes.Buffer, lang *peg.Language, decls map[string]string) error {
+	decls["parts"] = ""
+	rules := lang.Rules()
+	for _, rule := range rules {
+		typ := exported(rule)
+		if err := declare(decls, typ, rule); err != nil {
+			return err
+		}
+		if err := declare(decls, "Build"+typ, rule); err != nil {
+			return err
+		}
+	}
+	for _, rule := range rules {
+		writeRule(buf, lang, rule, decls)
+	}
+
+	buf.WriteString(`
+// parts calls add with the children of a node of rule, leaving out the
+// leaves of the rule itself, or with the node if the rule produced a node
+// of another rule.
+func parts(node *peg.ParseTree, rule string, add func(*peg.ParseTree)) {
+	if node.Type != rule {
+		add(node)
+		return
+	}
+	for _, child := range node.Children {
+		if child.Type != rule || child.Data == nil {
+			add(child)
+		}
+	}
+}
+`)
+	return nil
+}
+
+// writeRule writes the struct of rule and its build function.
+func writeRule(buf *bytes.Buffer, lang *peg.Language, rule string, decls map[string]string) {
+	typ := exported(rule)
+	taken := map[string]bool{"Node": true}
+	var fields []astField
+	// The closures of the rule, whose matches are parts of its nodes.
+	var closures []string
+	own := make(map[string]bool)
+	for _, f := range lang.Fields(rule) {
+		if f.Closure != "" && !own[f.Closure] {
+			own[f.Closure] = true
+			closures = append(closures, f.Closure)
+		}
+		ident := exported(f.Name)
+		for i := 2; taken[ident]; i++ {
+			ident = exported(f.Name) + strconv.Itoa(i)
+		}
+		taken[ident] = true
+		fields = append(fields, astField{f, ident, lang.NodeTypes(f.Rule)})
+	}
+
+	fmt.Fprintf(buf, "\n// %s is a node of the rule %s.\n", typ, rule)
+	fmt.Fprintf(buf, "type %s struct {\n", typ)
+	buf.WriteString("Node *peg.ParseTree\n")
+	for _, f := range fields {
+		if f.Closure != "" {
+			fmt.Fprintf(buf, "%s []*%s\n", f.ident, exported(f.Rule))
+		} else {
+			fmt.Fprintf(buf, "%s *%s\n", f.ident, exported(f.Rule))
+		}
+	}
+	buf.WriteString("}\n\n")
+
+	fmt.Fprintf(buf, "// Build%s converts a node of the rule %s and the nodes below it.\n", typ, rule)
+	buf.WriteString("// Fields referring to the same rule are filled in order.\n")
+	fmt.Fprintf(buf, "func Build%s(node *peg.ParseTree) *%s {\n", typ, typ)
+	buf.WriteString("if node == nil {\nreturn nil\n}\n")
+	var cases bytes.Buffer
+	for i, f := range fields {
+		var conds []string
+		for _, t := range f.types {
+			if own[t] {
+				continue
+			}
+			cond := "part.Type == " + typeName(t, decls)
+			if t == rule {
+				// Leaves of the rule itself hold data.
+				cond = "(" + cond + " && part.Data == nil)"
+			}
+			conds = append(conds, cond)
+		}
+		// Nodes of external matchers are not known.
+		if len(conds) == 0 {
+			continue
+		}
+		cond := strings.Join(conds, " || ")
+		if len(conds) > 1 {
+			cond = "(" + cond + ")"
+		}
+		fmt.Fprintf(&cases, "case field <= %d && %s:\n", i, cond)
+		if f.Closure != "" {
+			fmt.Fprintf(&cases, "n.%s, field = append(n.%s, Build%s(part)), %d\n", f.ident, f.ident, exported(f.Rule), i)
+		} else {
+			fmt.Fprintf(&cases, "n.%s, field = Build%s(part), %d\n", f.ident, exported(f.Rule), i+1)
+		}
+	}
+	if cases.Len() == 0 {
+		fmt.Fprintf(buf, "return &%s{Node: node}\n}\n", typ)
+		return
+	}
+	fmt.Fprintf(buf, "n := &%s{Node: node}\n", typ)
+	buf.WriteString("field := 0\nvar add func(part *peg.ParseTree)\nadd = func(part *peg.ParseTree) {\nswitch {\n")
+	buf.Write(cases.Bytes())
+	if len(closures) > 0 {
+		conds := make([]string, len(closures))
+		for i, t := range closures {
+			conds[i] = "part.Type == " + strconv.Quote(t)
+		}
+		fmt.Fprintf(buf, "case %s:\nfor _, child := range part.Children {\nadd(child)\n}\n", strings.Join(conds, " || "))
+	}
+	buf.WriteString("}\n}\n")
+	fmt.Fprintf(buf, "parts(node, %s, add)\nreturn n\n}\n", ruleConst(rule))
+}
+
+// typeName returns the constant of the rule typ, or typ quoted if it is
+// the type of some other node.
+func typeName(typ string, decls map[string]string) string {
+	if decls[ruleConst(typ)] == typ {
+		return ruleConst(typ)
+	}
+	return strconv.Quote(typ)
+}
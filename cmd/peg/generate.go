@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// generate returns the Go source defining the language of the grammar
+// text, read from the file name, in package pkg. With ast, it also defines
+// a struct for the nodes of every rule.
+func generate(name, text, pkg string, ast bool) ([]byte, error) {
+	lang, err := peg.NewParser(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	canonical := lang.Grammar()
+	if _, err := peg.NewParser(strings.NewReader(canonical)); err != nil {
+		return nil, errors.New(fmt.Sprintf("canonical form of %s does not compile: %s", name, err))
+	}
+
+	var buf bytes.Buffer
+	base := filepath.Base(name)
+	flags := ""
+	if ast {
+		flags = " -ast"
+	}
+	fmt.Fprintf(&buf, "// Code generated by peg -grammar %s%s; DO NOT EDIT.\n\n", base, flags)
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	buf.WriteString("import (\n\t\"strings\"\n\n\t\"github.com/Logiraptor/chicken/peg\"\n)\n\n")
+
+	buf.WriteString("// The rules of the grammar, which are the types of the nodes they produce.\nconst (\n")
+	// The identifiers declared, with the rules they were declared for.
+	decls := map[string]string{"grammar": "", "NewLanguage": ""}
+	for _, rule := range lang.Rules() {
+		ident := ruleConst(rule)
+		if err := declare(decls, ident, rule); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%s = %s\n", ident, strconv.Quote(rule))
+	}
+	buf.WriteString(")\n\n")
+
+	fmt.Fprintf(&buf, "const grammar = %s\n\n", quoteGrammar(canonical))
+	fmt.Fprintf(&buf, "// NewLanguage compiles the grammar of %s.\n", base)
+	buf.WriteString("func NewLanguage(opts ...peg.Option) (*peg.Language, error) {\n")
+	buf.WriteString("return peg.NewParser(strings.NewReader(grammar), opts...)\n}\n")
+	if ast {
+		if err := generateAST(&buf, lang, decls); err != nil {
+			return nil, err
+		}
+	}
+	return format.Source(buf.Bytes())
+}
+
+// declare records that ident is declared for rule, unless it already is.
+func declare(decls map[string]string, ident, rule string) error {
+	if other, ok := decls[ident]; ok {
+		if other == "" {
+			return errors.New(fmt.Sprintf("rule %s is named %s, which is reserved", rule, ident))
+		}
+		return errors.New(fmt.Sprintf("rules %s and %s are both named %s", other, rule, ident))
+	}
+	decls[ident] = rule
+	return nil
+}
+
+// ruleConst returns the name of the constant for rule: Rule followed by
+// the exported name of the rule.
+func ruleConst(rule string) string {
+	return "Rule" + exported(rule)
+}
+
+// exported returns the words of name, split at underscores and
+// capitalized.
+func exported(name string) string {
+	var buf bytes.Buffer
+	for _, word := range strings.Split(name, "_") {
+		if word == "" {
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(word)
+		buf.WriteRune(unicode.ToUpper(r))
+		buf.WriteString(word[n:])
+	}
+	return buf.String()
+}
+
+// quoteGrammar writes text as a raw string, so that the rules stay on lines
+// of their own. Backquotes are added as interpreted strings.
+func quoteGrammar(text string) string {
+	if strings.ContainsRune(text, '\r') {
+		return strconv.Quote(text)
+	}
+	return "`" + strings.Replace(text, "`", "` + \"`\" + `", -1) + "`"
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	grammar := "%memo expr\nexpr <- term_list\nterm_list <- term+\nterm <- ~`[a-z]+` / '`'"
+	src, err := generate("testdata/lang.peg", grammar, "lang", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "lang_gen.go", src, 0); err != nil {
+		t.Fatalf("generated invalid Go: %s\n%s", err, src)
+	}
+	out := string(src)
+	for _, exp := range []string{
+		"// Code generated by peg -grammar lang.peg; DO NOT EDIT.\n",
+		"package lang\n",
+		"RuleExpr     = \"expr\"\n",
+		"RuleTermList = \"term_list\"\n",
+		"const grammar = `%memo expr\nexpr <- term_list\n",
+		"term <- ~` + \"`\" + `[a-z]+` + \"`\" + ` / '` + \"`\" + `'\n`\n",
+		"func NewLanguage(opts ...peg.Option) (*peg.Language, error) {",
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("expected %q in:\n%s", exp, out)
+		}
+	}
+
+	// The output only depends on the grammar.
+	again, err := generate("other/lang.peg", grammar, "lang", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != out {
+		t.Errorf("output changed between runs:\n%s\n%s", out, again)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, grammar := range []string{
+		"a <- b",
+		"a_b <- aB\naB <- 'x'",
+	} {
+		if _, err := generate("lang.peg", grammar, "lang", false); err == nil {
+			t.Errorf("expected error for %q", grammar)
+		}
+	}
+}
+
+func TestGenerateAST(t *testing.T) {
+	// The example package is generated from its grammar and tests the
+	// structs it gets.
+	text, err := ioutil.ReadFile("internal/expr/expr.peg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate("internal/expr/expr.peg", string(text), "expr", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked, err := ioutil.ReadFile("internal/expr/expr_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != string(checked) {
+		t.Errorf("internal/expr/expr_gen.go is out of date, run go generate")
+	}
+
+	for _, grammar := range []string{
+		"new_language <- 'x'",
+		"rule_a <- 'x'\na <- 'x'",
+		"build_a <- a\na <- 'x'",
+	} {
+		if _, err := generate("lang.peg", grammar, "lang", true); err == nil {
+			t.Errorf("expected error for %q", grammar)
+		}
+		if _, err := generate("lang.peg", grammar, "lang", false); err != nil {
+			t.Errorf("%q: %s", grammar, err)
+		}
+	}
+}
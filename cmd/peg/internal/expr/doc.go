@@ -0,0 +1,5 @@
+// Package expr is built from expr.peg by peg -ast, to check the structs it
+// generates.
+package expr
+
+//go:generate go run github.com/Logiraptor/chicken/cmd/peg -grammar expr.peg -out expr_gen.go -ast
@@ -0,0 +1,77 @@
+package expr
+
+import (
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+	lang, err := NewLanguage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("[1,[2],3]+4+5+6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := BuildSum(tree)
+	if sum.Node != tree {
+		t.Errorf("expected the node of the tree")
+	}
+
+	list := sum.Left.List
+	if list == nil || sum.Left.Num != nil {
+		t.Fatalf("expected a list on the left, got %+v", sum.Left)
+	}
+	if num := list.Value.Num; num == nil || string(num.Node.Data) != "1" {
+		t.Errorf("expected 1 as the first value, got %+v", list.Value)
+	}
+	if len(list.More) != 2 {
+		t.Fatalf("expected 2 more values, got %d", len(list.More))
+	}
+	if inner := list.More[0].Value.List; inner == nil || string(inner.Value.Num.Node.Data) != "2" || len(inner.More) != 0 {
+		t.Errorf("expected [2] as the second value, got %+v", list.More[0].Value)
+	}
+	if num := list.More[1].Value.Num; num == nil || string(num.Node.Data) != "3" {
+		t.Errorf("expected 3 as the third value, got %+v", list.More[1].Value)
+	}
+
+	if num := sum.Right.Num; num == nil || string(num.Node.Data) != "4" {
+		t.Errorf("expected 4 on the right, got %+v", sum.Right)
+	}
+	var rest string
+	for _, term := range sum.Rest {
+		rest += string(term.Num.Node.Data)
+	}
+	if rest != "56" {
+		t.Errorf("expected terms 5 and 6, got %q", rest)
+	}
+
+	if len(sum.Tags) != 0 {
+		t.Errorf("expected no tags, got %d", len(sum.Tags))
+	}
+
+	if BuildSum(nil) != nil {
+		t.Errorf("expected no sum without a node")
+	}
+}
+
+func TestBuildClosure(t *testing.T) {
+	// The tags keep their '#', so they don't collapse into their numbers
+	// and are only found through the node of the closure.
+	lang, err := NewLanguage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("1+2#7#8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := BuildSum(tree)
+	var tags string
+	for _, tag := range sum.Tags {
+		tags += string(tag.Num.Node.Data)
+	}
+	if tags != "78" {
+		t.Errorf("expected tags 7 and 8, got %q in %s", tags, tree.SExpr())
+	}
+}
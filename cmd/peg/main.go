@@ -0,0 +1,57 @@
+// Command peg generates a Go file that defines the language of a grammar,
+// for use with go generate:
+//
+//	//go:generate peg -grammar lang.peg -out parser_gen.go -pkg mylang
+//
+// The generated file holds the grammar in its canonical form, as printed
+// by Language.Grammar, a constant for the name of every rule and a
+// NewLanguage function that compiles the grammar. The grammar is checked
+// when the file is generated, and the output only depends on the grammar,
+// so it can be committed and reviewed.
+//
+// With -ast, the file also defines a struct for the nodes of every rule,
+// named after the rule, and a BuildRule function converting parse trees to
+// it. Structs have a field for every rule the body refers to, named after
+// its label or else the rule, which is a slice if it is part of a closure.
+// The parse tree of a node is kept in its Node field.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	grammar := flag.String("grammar", "", "the grammar `file` to generate a parser for")
+	out := flag.String("out", "", "the `file` to write, instead of standard output")
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "the package `name` of the generated file")
+	ast := flag.Bool("ast", false, "also generate a struct for the nodes of every rule")
+	flag.Parse()
+	if *grammar == "" || *pkg == "" || flag.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: peg -grammar lang.peg [-out parser_gen.go] [-pkg mylang] [-ast]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if err := run(*grammar, *out, *pkg, *ast); err != nil {
+		fmt.Fprintln(os.Stderr, "peg:", err)
+		os.Exit(1)
+	}
+}
+
+func run(grammar, out, pkg string, ast bool) error {
+	text, err := ioutil.ReadFile(grammar)
+	if err != nil {
+		return err
+	}
+	src, err := generate(grammar, string(text), pkg, ast)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return ioutil.WriteFile(out, src, 0666)
+}
@@ -0,0 +1,210 @@
+package peg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Ambiguity is an alternative of a choice that can never match, because an
+// alternative before it matches wherever it could. Alternatives are
+// counted from 1.
+type Ambiguity struct {
+	Rule string
+	Alt  int // the alternative that never matches.
+	By   int // the alternative that matches in its place.
+	Msg  string
+}
+
+func (a Ambiguity) String() string {
+	return a.Rule + ": " + a.Msg
+}
+
+// Ambiguities returns the dead alternatives of the choices of each rule, in
+// the order of the rules. Since a choice commits to the first of its
+// alternatives that matches, a later one never matches when an earlier one
+// cannot fail, such as 'a'? or a discarded lexeme, or when the earlier one
+// is a choice of literals and regexps that matches where any match of the
+// later one starts. That is the case if each of the literals and regexps
+// that the FirstSet of the later one holds is among those of the earlier
+// one, as in ident / call where call <- ident '(' ')', is a literal that starts with one of
+// its literals, as in '=' / '==', or is a literal that one of its regexps
+// matches the start of.
+//
+// The check is approximate: alternatives that other rules, predicates or
+// external matchers keep apart may be reported, and it says nothing about
+// alternatives that merely overlap.
+func (l *Language) Ambiguities() []Ambiguity {
+	_, names := l.ruleNames()
+	f := &firstSets{names: names, sets: make(map[*Lexeme]firstSet), active: make(map[*Lexeme]bool)}
+	var found []Ambiguity
+	for _, r := range l.rules {
+		if r.params != nil || r.lex.kind != kindDefinition {
+			continue
+		}
+		seen := make(map[*Lexeme]bool)
+		var walk func(lex *Lexeme)
+		walk = func(lex *Lexeme) {
+			if seen[lex] || lex.kind == kindDefinition {
+				return
+			}
+			seen[lex] = true
+			if lex.kind == kindChoice || lex.kind == kindAlternate {
+				found = append(found, l.deadAlternatives(r.name, lex.Dependencies, f)...)
+			}
+			for _, dep := range lex.Dependencies {
+				walk(dep)
+			}
+		}
+		walk(r.lex.Dependencies[0])
+	}
+	return found
+}
+
+// deadAlternatives returns the alternatives of the choice alts in rule that
+// an earlier one shadows.
+func (l *Language) deadAlternatives(rule string, alts []*Lexeme, f *firstSets) []Ambiguity {
+	var found []Ambiguity
+	alt := func(i int) string {
+		return expression(alts[i], f.names, false)
+	}
+	for j := 1; j < len(alts); j++ {
+		later := f.first(alts[j])
+		for i := 0; i < j; i++ {
+			var why string
+			if !canFail(alts[i], make(map[*Lexeme]bool)) {
+				why = "matches the empty input"
+			} else if !later.nullable && len(later.terms) > 0 {
+				why = l.covers(terminals(alts[i]), later.lexes, f)
+			}
+			if why != "" {
+				found = append(found, Ambiguity{rule, j + 1, i + 1, fmt.Sprintf("alternative %d, %s, never matches: alternative %d, %s, %s", j+1, alt(j), i+1, alt(i), why)})
+				break
+			}
+		}
+	}
+	return found
+}
+
+// covers returns how the terminals earlier match wherever one of lexes
+// does, or "" unless they do for every one of them.
+func (l *Language) covers(earlier, lexes []*Lexeme, f *firstSets) string {
+	if len(earlier) == 0 {
+		return ""
+	}
+	why := "matches wherever it starts"
+	for _, lex := range lexes {
+		covered := false
+		for _, e := range earlier {
+			if shadows, prefix := l.shadows(e, lex, f); shadows {
+				covered = true
+				if prefix {
+					why = "matches the start of it"
+				}
+				break
+			}
+		}
+		if !covered {
+			return ""
+		}
+	}
+	return why
+}
+
+// shadows reports whether the terminal e matches wherever lex does, and
+// whether it does because it matches a part of the text of lex.
+func (l *Language) shadows(e, lex *Lexeme, f *firstSets) (shadows, prefix bool) {
+	if expression(e, f.names, true) == expression(lex, f.names, true) {
+		return true, false
+	}
+	if lex.kind != kindLiteral {
+		return false, false
+	}
+	switch e.kind {
+	case kindLiteral:
+		if l.directives.caseInsensitive || l.config.caseInsensitive {
+			return len(lex.text) >= len(e.text) && strings.EqualFold(lex.text[:len(e.text)], e.text), true
+		}
+		return strings.HasPrefix(lex.text, e.text), true
+	case kindRegexp:
+		// A folded literal also matches text the regexp may not, and a
+		// regexp that looks past its match may not match where the literal
+		// is followed by more.
+		if l.directives.caseInsensitive || l.config.caseInsensitive || lookingAhead(e.text) {
+			return false, false
+		}
+		re, err := regexp.Compile(`^(?:` + e.text + `)`)
+		return err == nil && re.MatchString(lex.text), true
+	}
+	return false, false
+}
+
+// lookingAhead reports whether the regexp re may assert what follows it.
+func lookingAhead(re string) bool {
+	return strings.Contains(re, "$") || strings.Contains(re, `\b`) || strings.Contains(re, `\B`) || strings.Contains(re, `\z`)
+}
+
+// terminals returns the literals, regexps and other matchers that lex is a
+// choice of, or nil if it is anything else.
+func terminals(lex *Lexeme) []*Lexeme {
+	switch lex.kind {
+	case kindLiteral, kindRegexp, kindExternal:
+		return []*Lexeme{lex}
+	case kindCall:
+		if strings.HasPrefix(lex.text, "warn(") {
+			return nil
+		}
+		return []*Lexeme{lex}
+	case kindDefinition, kindMemo, kindCapture:
+		return terminals(lex.Dependencies[0])
+	case kindChoice, kindAlternate:
+		var all []*Lexeme
+		for _, dep := range lex.Dependencies {
+			sub := terminals(dep)
+			if sub == nil {
+				return nil
+			}
+			all = append(all, sub...)
+		}
+		return all
+	}
+	return nil
+}
+
+// canFail reports whether lex may fail to match. Only lexemes that surely
+// match, such as options and discarded lexemes, are reported as not.
+func canFail(lex *Lexeme, active map[*Lexeme]bool) bool {
+	if active[lex] {
+		return true
+	}
+	active[lex] = true
+	defer delete(active, lex)
+	switch lex.kind {
+	case kindLiteral:
+		return lex.text != ""
+	case kindStar, kindOption, kindDiscard:
+		return false
+	case kindPlus, kindRepeat:
+		if min, _ := closureBounds(lex); min == 0 {
+			return false
+		}
+		return canFail(lex.Dependencies[0], active)
+	case kindConcat:
+		for _, dep := range lex.Dependencies {
+			if canFail(dep, active) {
+				return true
+			}
+		}
+		return false
+	case kindChoice, kindAlternate:
+		for _, dep := range lex.Dependencies {
+			if !canFail(dep, active) {
+				return false
+			}
+		}
+		return true
+	case kindDefinition, kindMemo, kindScope, kindCapture:
+		return canFail(lex.Dependencies[0], active)
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package peg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAmbiguities(t *testing.T) {
+	for _, tc := range []struct {
+		grammar string
+		opts    []Option
+		exp     []string
+	}{
+		{"op <- '=' / '=='", nil, []string{"op: alternative 2, '==', never matches: alternative 1, '=', matches the start of it"}},
+		{"op <- '==' / '='", nil, nil},
+		{"x <- 'a'? / 'b' / 'c'", nil, []string{
+			"x: alternative 2, 'b', never matches: alternative 1, 'a'?, matches the empty input",
+			"x: alternative 3, 'c', never matches: alternative 1, 'a'?, matches the empty input",
+		}},
+		{"x <- 'a'^ / 'b'", nil, []string{"x: alternative 2, 'b', never matches: alternative 1, 'a'^, matches the empty input"}},
+		{"x <- ident / call\ncall <- ident '(' ')'\nident <- ~'[a-z]+'", nil, []string{"x: alternative 2, call, never matches: alternative 1, ident, matches wherever it starts"}},
+		{"x <- call / ident\ncall <- ident '(' ')'\nident <- ~'[a-z]+'", nil, nil},
+		{"x <- name / 'if'\nname <- ~'[a-z]+'", nil, []string{"x: alternative 2, 'if', never matches: alternative 1, name, matches the start of it"}},
+		{"%keywords if\nx <- name / 'if'\nname <- ~'[a-z]+'", nil, nil},
+		{"x <- name / 'if'\nname <- ~'[a-z]+'", []Option{CaseInsensitive()}, nil},
+		{"x <- name / 'if'\nname <- ~'[a-z]+\\b'", nil, nil},
+		{"x <- op / add\nadd <- '+=' y\nop <- '+' / '-'\ny <- 'y'", nil, []string{"x: alternative 2, add, never matches: alternative 1, op, matches the start of it"}},
+		{"list <- item ',' list / item\nitem <- 'a' / 'ab'", nil, []string{"item: alternative 2, 'ab', never matches: alternative 1, 'a', matches the start of it"}},
+		{"x <- 'b' 'a' / 'a'", nil, []string{"x: alternative 2, 'a', never matches: alternative 1, 'a', matches wherever it starts"}},
+		{"x <- ba / 'b'\nba <- 'b' 'a'", nil, nil},
+	} {
+		lang, err := NewLanguage(tc.grammar, tc.opts...)
+		if err != nil {
+			t.Errorf("%q: %v", tc.grammar, err)
+			continue
+		}
+		var got []string
+		for _, a := range lang.Ambiguities() {
+			got = append(got, a.String())
+		}
+		if !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%q: got %q, exp %q", tc.grammar, got, tc.exp)
+		}
+	}
+}
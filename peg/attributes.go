@@ -0,0 +1,151 @@
+package peg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AttrFunc computes a synthesized attribute of node, typically from the
+// attributes of its children, which it gets from e.
+type AttrFunc func(e *Evaluation, node *ParseTree) (interface{}, error)
+
+// InheritFunc computes an inherited attribute of child, a child of parent,
+// typically from the attributes of parent and of the siblings of child.
+type InheritFunc func(e *Evaluation, parent, child *ParseTree) (interface{}, error)
+
+// Attribute declares an attribute of the nodes of a parse tree. It is
+// either synthesized, flowing up the tree from the leaves, or inherited,
+// flowing down from the root.
+type Attribute struct {
+	Name string
+	// Synthesized holds the functions computing the attribute, by the type
+	// of the node. The function under "" computes it for nodes of the other
+	// types.
+	Synthesized map[string]AttrFunc
+	// Inherited holds the functions computing the attribute of the children
+	// of a node, by the type of the node. The children of nodes of other
+	// types inherit the attribute of their parent as it is.
+	Inherited map[string]InheritFunc
+	// Root is the inherited attribute of the root of the tree.
+	Root interface{}
+}
+
+// AttributeError reports the failure to compute an attribute of a node.
+type AttributeError struct {
+	Attr string
+	Node *ParseTree
+	Err  error
+}
+
+func (e *AttributeError) Error() string {
+	return fmt.Sprintf("attribute %s of %s at offset %d: %s", e.Attr, e.Node.Type, e.Node.Pos, e.Err)
+}
+
+// attrKey identifies the attribute of a node.
+type attrKey struct {
+	node *ParseTree
+	name string
+}
+
+// Evaluation holds the attributes computed for a parse tree.
+type Evaluation struct {
+	attrs   map[string]*Attribute
+	parents map[*ParseTree]*ParseTree
+	values  map[attrKey]interface{}
+	pending map[attrKey]bool // the attributes being computed.
+}
+
+// Evaluate computes the attributes attrs of every node of tree for which
+// they are defined: synthesized attributes for the nodes of the types that
+// have a function, and inherited ones for all nodes. Attributes are
+// computed on demand, so each is computed after the attributes it gets,
+// in whatever order the functions ask for them, and only once. The first
+// error is returned, as is an attribute that depends on itself.
+func Evaluate(tree *ParseTree, attrs []Attribute) (*Evaluation, error) {
+	e := &Evaluation{
+		attrs:   make(map[string]*Attribute, len(attrs)),
+		parents: make(map[*ParseTree]*ParseTree),
+		values:  make(map[attrKey]interface{}),
+		pending: make(map[attrKey]bool),
+	}
+	for i := range attrs {
+		a := &attrs[i]
+		if _, ok := e.attrs[a.Name]; ok {
+			return nil, errors.New(fmt.Sprintf("attribute %s is declared twice", a.Name))
+		}
+		if a.Synthesized != nil && a.Inherited != nil {
+			return nil, errors.New(fmt.Sprintf("attribute %s is both synthesized and inherited", a.Name))
+		}
+		e.attrs[a.Name] = a
+	}
+	var nodes []*ParseTree
+	var walk func(node *ParseTree)
+	walk = func(node *ParseTree) {
+		for _, child := range node.Children {
+			e.parents[child] = node
+			walk(child)
+		}
+		nodes = append(nodes, node)
+	}
+	walk(tree)
+	for _, node := range nodes {
+		for i := range attrs {
+			a := &attrs[i]
+			if a.Synthesized != nil && a.Synthesized[node.Type] == nil && a.Synthesized[""] == nil {
+				continue
+			}
+			if _, err := e.Get(node, a.Name); err != nil {
+				return e, err
+			}
+		}
+	}
+	return e, nil
+}
+
+// Get returns the attribute name of node, computing it if it hasn't been
+// yet. Attribute functions call it for the attributes theirs depend on.
+func (e *Evaluation) Get(node *ParseTree, name string) (interface{}, error) {
+	key := attrKey{node, name}
+	if v, ok := e.values[key]; ok {
+		return v, nil
+	}
+	a, ok := e.attrs[name]
+	if !ok {
+		return nil, &AttributeError{name, node, errors.New("undeclared attribute")}
+	}
+	if e.pending[key] {
+		return nil, &AttributeError{name, node, errors.New("attribute depends on itself")}
+	}
+	e.pending[key] = true
+	defer delete(e.pending, key)
+	v, err := e.compute(a, node)
+	if err != nil {
+		if _, ok := err.(*AttributeError); !ok {
+			err = &AttributeError{name, node, err}
+		}
+		return nil, err
+	}
+	e.values[key] = v
+	return v, nil
+}
+
+func (e *Evaluation) compute(a *Attribute, node *ParseTree) (interface{}, error) {
+	if a.Synthesized != nil {
+		fn := a.Synthesized[node.Type]
+		if fn == nil {
+			fn = a.Synthesized[""]
+		}
+		if fn == nil {
+			return nil, errors.New("no function for nodes of this type")
+		}
+		return fn(e, node)
+	}
+	parent, ok := e.parents[node]
+	if !ok {
+		return a.Root, nil
+	}
+	if fn := a.Inherited[parent.Type]; fn != nil {
+		return fn(e, parent, node)
+	}
+	return e.Get(parent, a.Name)
+}
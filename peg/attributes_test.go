@@ -0,0 +1,146 @@
+package peg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	lang, err := NewLanguage("sum <- term more*\nmore <- '+'^ term\nterm <- num / var\nnum <- ~'[0-9]+'\nvar <- ~'[a-z]+'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// all checks that the types of the children of node are int.
+	all := func(e *Evaluation, node *ParseTree) (interface{}, error) {
+		for _, child := range node.Children {
+			typ, err := e.Get(child, "type")
+			if err != nil {
+				return nil, err
+			}
+			if typ != "int" {
+				return nil, errors.New("cannot add " + typ.(string))
+			}
+		}
+		return "int", nil
+	}
+	attrs := []Attribute{
+		{
+			Name: "type",
+			Synthesized: map[string]AttrFunc{
+				"num":   func(e *Evaluation, node *ParseTree) (interface{}, error) { return "int", nil },
+				"sum":   all,
+				"more*": all,
+				"var": func(e *Evaluation, node *ParseTree) (interface{}, error) {
+					env, err := e.Get(node, "env")
+					if err != nil {
+						return nil, err
+					}
+					typ, ok := env.(map[string]string)[string(node.Data)]
+					if !ok {
+						return nil, errors.New("undefined variable")
+					}
+					return typ, nil
+				},
+			},
+		},
+		{Name: "env", Root: map[string]string{"x": "int", "s": "string"}},
+	}
+	for _, tt := range []struct {
+		input string
+		err   string
+	}{
+		{"1+2+x", ""},
+		{"x", ""},
+		{"1+s", "attribute type of more* at offset 1: cannot add string"},
+		{"1+y", "attribute type of var at offset 2: undefined variable"},
+	} {
+		tree, err := lang.ParseString(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := Evaluate(tree, attrs)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %s", tt.input, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.input, err)
+			continue
+		}
+		if typ, err := e.Get(tree, "type"); typ != "int" || err != nil {
+			t.Errorf("%q: got type %v, %v", tt.input, typ, err)
+		}
+	}
+}
+
+func TestEvaluateInherited(t *testing.T) {
+	lang, err := NewLanguage("list <- '[' item* ']'\nitem <- list / 'a'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("[a[a]]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// depth counts the lists around a node; height is the depth of the
+	// deepest leaf below it.
+	attrs := []Attribute{
+		{
+			Name: "depth",
+			Root: 0,
+			Inherited: map[string]InheritFunc{
+				"list": func(e *Evaluation, parent, child *ParseTree) (interface{}, error) {
+					d, err := e.Get(parent, "depth")
+					if err != nil {
+						return nil, err
+					}
+					return d.(int) + 1, nil
+				},
+			},
+		},
+		{
+			Name: "height",
+			Synthesized: map[string]AttrFunc{
+				"": func(e *Evaluation, node *ParseTree) (interface{}, error) {
+					if len(node.Children) == 0 {
+						return e.Get(node, "depth")
+					}
+					max := 0
+					for _, child := range node.Children {
+						h, err := e.Get(child, "height")
+						if err != nil {
+							return nil, err
+						}
+						if h.(int) > max {
+							max = h.(int)
+						}
+					}
+					return max, nil
+				},
+			},
+		},
+	}
+	e, err := Evaluate(tree, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := e.Get(tree, "height"); h != 2 {
+		t.Errorf("got height %v", h)
+	}
+
+	cyclic := []Attribute{{
+		Name: "a",
+		Synthesized: map[string]AttrFunc{
+			"": func(e *Evaluation, node *ParseTree) (interface{}, error) { return e.Get(node, "a") },
+		},
+	}}
+	if _, err := Evaluate(tree, cyclic); err == nil {
+		t.Errorf("expected an error for a cyclic attribute")
+	}
+	twice := []Attribute{{Name: "a"}, {Name: "a"}}
+	if _, err := Evaluate(tree, twice); err == nil {
+		t.Errorf("expected an error for an attribute declared twice")
+	}
+}
@@ -0,0 +1,70 @@
+package peg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// newSpaceLexer returns the whitespace of %auto_whitespace, which matches
+// a run of Unicode spaces or one of comments: a line comment, given by the
+// text starting it, or a block comment, given by the texts starting and
+// ending it separated by a space.
+func newSpaceLexer(comments []string) (*Lexeme, error) {
+	var lines, opens, closes [][]byte
+	for _, comment := range comments {
+		switch fields := strings.Fields(comment); len(fields) {
+		case 1:
+			lines = append(lines, []byte(fields[0]))
+		case 2:
+			opens, closes = append(opens, []byte(fields[0])), append(closes, []byte(fields[1]))
+		default:
+			return nil, errors.New(fmt.Sprintf("invalid comment %q: expected a line comment such as // or a block comment such as /* */", comment))
+		}
+	}
+	return &Lexeme{
+		Name: "whitespace",
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			rest := s.buf[pos:]
+			n := 0
+			for n < len(rest) {
+				r, size := utf8.DecodeRune(rest[n:])
+				if !unicode.IsSpace(r) {
+					break
+				}
+				n += size
+			}
+			if n == 0 {
+				n = commentLen(rest, lines, opens, closes)
+			}
+			if n > 0 && pos+n == len(s.buf) {
+				s.Starve(1)
+			}
+			return nil, nil, n
+		},
+	}, nil
+}
+
+// commentLen returns the length of the comment that buf starts with, or 0
+// if it starts with none or with a block comment that isn't closed.
+func commentLen(buf []byte, lines, opens, closes [][]byte) int {
+	for _, start := range lines {
+		if bytes.HasPrefix(buf, start) {
+			if end := bytes.IndexByte(buf, '\n'); end >= 0 {
+				return end
+			}
+			return len(buf)
+		}
+	}
+	for i, open := range opens {
+		if bytes.HasPrefix(buf, open) {
+			if end := bytes.Index(buf[len(open):], closes[i]); end >= 0 {
+				return len(open) + end + len(closes[i])
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAutoWhitespace(t *testing.T) {
+	grammar := "%token ident\ncall <- ident '(' ident ')' ';'\nident <- ~'[a-z]' ~'[a-z0-9]*'"
+	for _, tc := range []struct {
+		name    string
+		grammar string
+		opts    []Option
+		input   string
+		ok      bool
+	}{
+		{"option", grammar, []Option{AutoWhitespace()}, " f ( x1 )\n;", true},
+		{"no whitespace", grammar, nil, "f (x1);", false},
+		{"token", grammar, []Option{AutoWhitespace()}, "f(x 1);", false},
+		{"line comment", grammar, []Option{AutoWhitespace("//", "#")}, "f( // the argument\n x1 # done\n);", true},
+		{"block comment", grammar, []Option{AutoWhitespace("/* */")}, "f/* a */(/**/x1);", true},
+		{"unclosed comment", grammar, []Option{AutoWhitespace("/* */")}, "f(/* x1);", false},
+		{"directive", "%auto_whitespace '//'\n" + grammar, nil, "f ( x1 ) ; // call", true},
+	} {
+		lang, err := NewLanguage(tc.grammar, tc.opts...)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		_, err = lang.ParseString(tc.input)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("%s: parsing %q: got %v", tc.name, tc.input, err)
+		}
+	}
+}
+
+func TestAutoWhitespaceGrammar(t *testing.T) {
+	lang, err := NewLanguage("x <- 'a' 'b'", AutoWhitespace("#", "/* */"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "%auto_whitespace '#' '/* */'\n"; !strings.HasPrefix(lang.Grammar(), exp) {
+		t.Errorf("Grammar() = %q, exp it to start with %q", lang.Grammar(), exp)
+	}
+	for _, tc := range []struct {
+		grammar string
+		opts    []Option
+		exp     string
+	}{
+		{"x <- 'a'", []Option{AutoWhitespace("/* */ */")}, `invalid comment "/* */ */"`},
+		{"%auto_whitespace\n%whitespace ws\nx <- 'a'\nws <- ' '", nil, "exclude each other"},
+		{"%auto_whitespace ws\nx <- 'a'", nil, "takes the comments to skip"},
+	} {
+		_, err := NewLanguage(tc.grammar, tc.opts...)
+		if err == nil || !strings.Contains(err.Error(), tc.exp) {
+			t.Errorf("%q: got %v, exp %q", tc.grammar, err, tc.exp)
+		}
+	}
+	if _, err := NewLanguage("%auto_whitespace\n%whitespace ws\nx <- 'a' 'b'\nws <- ' '", Whitespace("ws")); err != nil {
+		t.Errorf("the Whitespace option should replace %%auto_whitespace: %v", err)
+	}
+}
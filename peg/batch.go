@@ -0,0 +1,73 @@
+package peg
+
+import (
+	"context"
+	"io/ioutil"
+	"runtime"
+	"sync"
+)
+
+// FileResult is the outcome of parsing one of the files given to
+// ParseFiles.
+type FileResult struct {
+	Path string
+	Tree *ParseTree
+	Err  error // set if the file could not be read or parsed.
+}
+
+// ParseFiles parses the files at paths with lang on concurrency goroutines,
+// or one per CPU if concurrency is not positive, and sends the result of
+// each file on the returned channel as soon as it is done. Results are in
+// no particular order; the channel is closed once every file was parsed.
+//
+// A Language may be shared by any number of parses, so the workers use
+// lang as is. Files are read into memory rather than mapped, so the trees
+// remain valid after ParseFiles is done. When ctx is cancelled, files that
+// were not started are skipped and the channel is closed as soon as the
+// parses in progress finish; the caller can tell by checking ctx.Err().
+func ParseFiles(ctx context.Context, lang *Language, paths []string, concurrency int) <-chan FileResult {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if concurrency > len(paths) {
+		concurrency = len(paths)
+	}
+	work := make(chan string)
+	results := make(chan FileResult, concurrency)
+	go func() {
+		defer close(work)
+		for _, path := range paths {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case work <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for path := range work {
+				r := FileResult{Path: path}
+				var src []byte
+				if src, r.Err = ioutil.ReadFile(path); r.Err == nil {
+					r.Tree, r.Err = lang.ParseBytes(src)
+				}
+				select {
+				case results <- r:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
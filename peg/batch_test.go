@@ -0,0 +1,81 @@
+package peg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFiles(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("list <- item+\nitem <- ~'[a-z]+' ' '^"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var paths []string
+	exp := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		src := strings.Repeat("ab ", i%5+1)
+		if i%7 == 3 {
+			src = "1"
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+		exp[path] = src
+	}
+	missing := filepath.Join(dir, "missing.txt")
+	paths = append(paths, missing)
+
+	for _, concurrency := range []int{0, 1, 4, 100} {
+		seen := make(map[string]bool)
+		for r := range ParseFiles(context.Background(), lang, paths, concurrency) {
+			if seen[r.Path] {
+				t.Errorf("%s reported twice", r.Path)
+			}
+			seen[r.Path] = true
+			switch src := exp[r.Path]; {
+			case r.Path == missing:
+				if r.Err == nil {
+					t.Errorf("expected an error for a missing file")
+				}
+			case src == "1":
+				if r.Err == nil {
+					t.Errorf("%s: expected a parse error", r.Path)
+				}
+			case r.Err != nil:
+				t.Errorf("%s: %s", r.Path, r.Err)
+			default:
+				if want, _ := lang.ParseString(src); !r.Tree.Equal(want) {
+					t.Errorf("%s: got %s, expected %s", r.Path, r.Tree.SExpr(), want.SExpr())
+				}
+			}
+		}
+		if len(seen) != len(paths) {
+			t.Errorf("concurrency %d: got %d results, expected %d", concurrency, len(seen), len(paths))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n := 0
+	for range ParseFiles(ctx, lang, paths, 2) {
+		n++
+	}
+	if n != 0 {
+		t.Errorf("cancelled ParseFiles returned %d results", n)
+	}
+	if _, ok := <-ParseFiles(context.Background(), lang, nil, 4); ok {
+		t.Errorf("expected no results without paths")
+	}
+}
@@ -0,0 +1,150 @@
+package peg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// builtinRules are available to every grammar under their name unless the
+// grammar defines a rule with the same name.
+var builtinRules = map[string]func() *Lexeme{
+	"any":   NewAnyLexer,
+	"int":   intLexer,
+	"float": floatLexer,
+	"hex":   hexLexer,
+	"bool":  boolLexer,
+	"byte":  NewByteLexer,
+	"u8":    func() *Lexeme { return NewIntLexer("u8", 1, binary.BigEndian, false) },
+	"i8":    func() *Lexeme { return NewIntLexer("i8", 1, binary.BigEndian, true) },
+	"u16be": func() *Lexeme { return NewIntLexer("u16be", 2, binary.BigEndian, false) },
+	"u16le": func() *Lexeme { return NewIntLexer("u16le", 2, binary.LittleEndian, false) },
+	"i16be": func() *Lexeme { return NewIntLexer("i16be", 2, binary.BigEndian, true) },
+	"i16le": func() *Lexeme { return NewIntLexer("i16le", 2, binary.LittleEndian, true) },
+	"u32be": func() *Lexeme { return NewIntLexer("u32be", 4, binary.BigEndian, false) },
+	"u32le": func() *Lexeme { return NewIntLexer("u32le", 4, binary.LittleEndian, false) },
+	"i32be": func() *Lexeme { return NewIntLexer("i32be", 4, binary.BigEndian, true) },
+	"i32le": func() *Lexeme { return NewIntLexer("i32le", 4, binary.LittleEndian, true) },
+	"u64be": func() *Lexeme { return NewIntLexer("u64be", 8, binary.BigEndian, false) },
+	"u64le": func() *Lexeme { return NewIntLexer("u64le", 8, binary.LittleEndian, false) },
+	"i64be": func() *Lexeme { return NewIntLexer("i64be", 8, binary.BigEndian, true) },
+	"i64le": func() *Lexeme { return NewIntLexer("i64le", 8, binary.LittleEndian, true) },
+}
+
+// NewByteLexer matches any single byte. Repetitions of it, such as byte{4},
+// produce a single leaf holding all of the matched bytes.
+func NewByteLexer() *Lexeme {
+	id := newNodeType("byte")
+	return &Lexeme{
+		Name:  "byte",
+		merge: true,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			if pos >= len(s.buf) {
+				s.Starve(1)
+				return nil, s.expected(pos, "byte"), 0
+			}
+			return s.leaf(&ParseTree{Type: "byte", ID: id.get(s), Data: s.buf[pos : pos+1], Pos: pos, End: pos + 1}), nil, 1
+		},
+	}
+}
+
+// NewIntLexer matches a size byte integer in the given byte order. The
+// leaf's Value holds the decoded integer as the Go type of that size, such
+// as uint16 or int32.
+func NewIntLexer(typ string, size int, order binary.ByteOrder, signed bool) *Lexeme {
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: typ,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			if pos+size > len(s.buf) {
+				s.Starve(pos + size - len(s.buf))
+				return nil, s.expected(pos, fmt.Sprintf("%d byte integer", size)), 0
+			}
+			data := s.buf[pos : pos+size]
+			var value interface{}
+			switch size {
+			case 1:
+				value = data[0]
+				if signed {
+					value = int8(data[0])
+				}
+			case 2:
+				value = order.Uint16(data)
+				if signed {
+					value = int16(order.Uint16(data))
+				}
+			case 4:
+				value = order.Uint32(data)
+				if signed {
+					value = int32(order.Uint32(data))
+				}
+			case 8:
+				value = order.Uint64(data)
+				if signed {
+					value = int64(order.Uint64(data))
+				}
+			}
+			return s.leaf(&ParseTree{Type: typ, ID: id.get(s), Data: data, Value: value, Pos: pos, End: pos + size}), nil, size
+		},
+	}
+}
+
+// NewRepeatLexer matches lex at least min and at most max times. A negative
+// max places no upper bound on the number of matches.
+func NewRepeatLexer(lex *Lexeme, min, max int) *Lexeme {
+	suffix := "{" + strconv.Itoa(min) + "}"
+	if max < 0 {
+		suffix = "{" + strconv.Itoa(min) + ",}"
+	} else if max != min {
+		suffix = "{" + strconv.Itoa(min) + "," + strconv.Itoa(max) + "}"
+	}
+	var typ lazyType
+	return &Lexeme{
+		Name:         lex.Name + suffix,
+		Dependencies: []*Lexeme{lex},
+		kind:         kindRepeat,
+		text:         suffix,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			start := pos
+			resp := &ParseTree{}
+			resp.Type, resp.ID = typ.get(s, lex, suffix)
+			b := treeBuilder{s: s}
+			ntokens := s.ntokens
+			for count := 0; max < 0 || count < max; count++ {
+				m := s.mark()
+				next, err, off := lex.Lexer(s, pos)
+				if err != nil {
+					s.reset(m)
+					if count < min {
+						return nil, err, 0
+					}
+					break
+				}
+				if !lex.merge {
+					b.add(next, pos, off)
+				}
+				pos += off
+				if off == 0 {
+					break
+				}
+			}
+			if s.tokenize {
+				if lex.merge {
+					s.ntokens = ntokens
+					s.token(resp.Type, start, pos)
+				}
+				return nil, nil, pos - start
+			}
+			if lex.merge {
+				resp.Data = s.buf[start:pos]
+			} else {
+				resp.Children, resp.Trailing = b.done()
+			}
+			resp.Pos, resp.End = start, pos
+			if s.dropsEmpty(resp) {
+				return nil, nil, 0
+			}
+			return s.node(resp), nil, pos - start
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBinaryPrimitives(t *testing.T) {
+	parser, err := NewParser(strings.NewReader("header <- \\x7F^ 'ELF'^ u8 byte{3} u16le i32be"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := []byte("\x7FELF\x02abc\x34\x12\xFF\xFF\xFF\xFE")
+	tree, err := parser.ParseBytes(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "header",
+		Children: []*ParseTree{
+			&ParseTree{Type: "u8", Data: []byte{2}},
+			&ParseTree{Type: "byte{3}", Data: []byte("abc")},
+			&ParseTree{Type: "u16le", Data: []byte{0x34, 0x12}},
+			&ParseTree{Type: "i32be", Data: []byte{0xFF, 0xFF, 0xFF, 0xFE}},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	values := []interface{}{uint8(2), nil, uint16(0x1234), int32(-2)}
+	for i, exp := range values {
+		if got := tree.Children[i].Value; got != exp {
+			t.Errorf("incorrect value for %s: %#v exp: %#v", tree.Children[i].Type, got, exp)
+		}
+	}
+
+	if _, err := parser.ParseBytes(input[:len(input)-1]); err == nil {
+		t.Errorf("expected error for truncated input")
+	}
+}
+
+var repeatTestTable = []ParseTest{
+	ParseTest{
+		"prgm <- a{2}\na <- 'a'",
+		"aaa",
+		&ParseTree{
+			Type: "a{2}",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "a", Data: []byte("a")},
+			},
+		},
+	},
+	ParseTest{
+		"prgm <- a{1,} 'b'\na <- 'a'",
+		"aaab",
+		&ParseTree{
+			Type: "prgm",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a{1,}", Children: []*ParseTree{
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "a", Data: []byte("a")},
+				}},
+				&ParseTree{Type: "prgm", Data: []byte("b")},
+			},
+		},
+	},
+	ParseTest{
+		"prgm <- a{0,1} 'b'\na <- 'a'",
+		"b",
+		&ParseTree{
+			Type: "prgm",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a{0,1}"},
+				&ParseTree{Type: "prgm", Data: []byte("b")},
+			},
+		},
+	},
+}
+
+func TestRepeat(t *testing.T) {
+	for _, tc := range repeatTestTable {
+		parser, err := NewParser(strings.NewReader(tc.language))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := parser.ParseString(tc.input)
+		if err != nil {
+			t.Error(tc.language, err)
+			continue
+		}
+		if err := treeCompare(tree, tc.exp); err != nil {
+			t.Error(tc.language, err)
+		}
+	}
+
+	parser, err := NewParser(strings.NewReader("prgm <- a{2,3}\na <- 'a'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseString("a"); err == nil {
+		t.Errorf("expected error below minimum repetitions")
+	}
+	if _, err := NewParser(strings.NewReader("prgm <- a{3,2}\na <- 'a'")); err == nil {
+		t.Errorf("expected error for inverted bounds")
+	}
+}
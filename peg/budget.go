@@ -0,0 +1,168 @@
+package peg
+
+import (
+	"context"
+	"fmt"
+	"unsafe"
+)
+
+// MaxRuleCalls stops a parse with a *BudgetError once it has invoked the
+// rules of the grammar more than n times. Zero removes the limit.
+func MaxRuleCalls(n int) Option {
+	return func(l *Language) {
+		l.maxCalls = n
+	}
+}
+
+// MaxBacktrack stops a parse with a *BudgetError once failed rule attempts
+// have looked at more than n bytes of input in total before the parser
+// backtracked over them. A failure is counted at every rule it makes fail,
+// so deeply nested failures weigh more. Zero removes the limit.
+func MaxBacktrack(n int) Option {
+	return func(l *Language) {
+		l.maxBacktrack = n
+	}
+}
+
+// MaxDepth stops a parse with a *BudgetError once rules are nested more
+// than n deep, which bounds the stack that deeply nested or recursive input
+// can use. Zero removes the limit.
+func MaxDepth(n int) Option {
+	return func(l *Language) {
+		l.maxDepth = n
+	}
+}
+
+// WithMaxNodes stops a parse with a *BudgetError once it has built more
+// than n nodes, which bounds the memory a crafted input can make the tree
+// take. Nodes of attempts the parser backtracked over count too, as they
+// were allocated all the same. Zero removes the limit.
+func WithMaxNodes(n int) Option {
+	return func(l *Language) {
+		l.maxNodes = n
+	}
+}
+
+// WithMaxTreeBytes is like WithMaxNodes, but limits the bytes taken by the
+// nodes and their lists of children. Data that shares memory with the input
+// is not counted.
+func WithMaxTreeBytes(n int) Option {
+	return func(l *Language) {
+		l.maxTreeBytes = n
+	}
+}
+
+// BudgetError is returned by parses that exceeded MaxRuleCalls,
+// MaxBacktrack, MaxDepth, WithMaxNodes or WithMaxTreeBytes. Rule and Pos
+// identify the rule invocation that went over the limit, or the type and
+// position of the node for the limits on the tree; Line and Col are Pos in
+// the input, both 1-based.
+type BudgetError struct {
+	Limit string // "rule calls", "backtracked bytes", "nested rules", "nodes" or "tree bytes".
+	Max   int
+	Rule  string
+	Pos   int
+	Line  int
+	Col   int
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%d:%d: parse exceeded %d %s in rule %s at offset %d", e.Line, e.Col, e.Max, e.Limit, e.Rule, e.Pos)
+}
+
+// budget counts what a parse has spent against the limits of its language.
+type budget struct {
+	calls     int
+	backtrack int
+	depth     int // the rules currently being matched.
+	nodes     int
+	treeBytes int
+}
+
+// parseAbort is panicked with to unwind a parse that exceeded its budget,
+// whose context is done, or whose user code panicked.
+type parseAbort struct {
+	err error
+}
+
+func (s *Source) overBudget(limit string, max int, name string, pos int) {
+	line, col := s.Position(pos)
+	panic(parseAbort{&BudgetError{Limit: limit, Max: max, Rule: name, Pos: pos, Line: line, Col: col}})
+}
+
+// contextChecks is how many rule calls a parse makes between checks of its
+// context.
+const contextChecks = 1024
+
+// SetContext makes parses of the source stop with the error of ctx once it
+// is done. The context is checked as rules are called, once every so many
+// calls, so a parse stops soon after but not at once.
+func (s *Source) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// spendCall counts an invocation of the rule name, which lasts until the
+// matching call of leaveRule.
+func (s *Source) spendCall(name string, pos int) {
+	s.budget.calls++
+	if s.ctx != nil && s.budget.calls%contextChecks == 1 {
+		if err := s.ctx.Err(); err != nil {
+			panic(parseAbort{err})
+		}
+	}
+	if max := s.lang.maxCalls; max > 0 && s.budget.calls > max {
+		s.overBudget("rule calls", max, name, pos)
+	}
+	s.budget.depth++
+	if max := s.lang.maxDepth; max > 0 && s.budget.depth > max {
+		s.overBudget("nested rules", max, name, pos)
+	}
+}
+
+// leaveRule ends the rule invocation counted last by spendCall.
+func (s *Source) leaveRule() {
+	s.budget.depth--
+}
+
+// spendBacktrack counts the depth bytes a failed attempt of name looked at.
+func (s *Source) spendBacktrack(name string, pos, depth int) {
+	if depth <= 0 {
+		return
+	}
+	s.budget.backtrack += depth
+	if max := s.lang.maxBacktrack; max > 0 && s.budget.backtrack > max {
+		s.overBudget("backtracked bytes", max, name, pos)
+	}
+}
+
+// nodeSize is the size of a node without its children and data.
+const nodeSize = int(unsafe.Sizeof(ParseTree{}))
+
+// node counts the node t against the limits on the tree and returns it.
+func (s *Source) node(t *ParseTree) *ParseTree {
+	if s.budget == nil {
+		return t
+	}
+	s.budget.nodes++
+	if max := s.lang.maxNodes; max > 0 && s.budget.nodes > max {
+		s.overBudget("nodes", max, t.Type, t.Pos)
+	}
+	s.budget.treeBytes += nodeSize + cap(t.Children)*int(unsafe.Sizeof(t))
+	if max := s.lang.maxTreeBytes; max > 0 && s.budget.treeBytes > max {
+		s.overBudget("tree bytes", max, t.Type, t.Pos)
+	}
+	return t
+}
+
+// catchAbort recovers from a parseAbort, storing its error in err.
+func catchAbort(tree **ParseTree, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	abort, ok := r.(parseAbort)
+	if !ok {
+		panic(r)
+	}
+	*tree, *err = nil, abort.err
+}
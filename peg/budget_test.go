@@ -0,0 +1,119 @@
+package peg
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBudget(t *testing.T) {
+	grammar := "prgm <- long / short\nlong <- 'a' 'b' 'c' 'd'\nshort <- 'a'+"
+	tests := []struct {
+		name  string
+		opt   Option
+		input string
+		err   *BudgetError
+	}{
+		{"unlimited", MaxRuleCalls(0), "abcaaa", nil},
+		{"calls within", MaxRuleCalls(3), "abcaaa", nil},
+		{"calls exceeded", MaxRuleCalls(2), "abcaaa", &BudgetError{Limit: "rule calls", Max: 2, Rule: "short", Pos: 0, Line: 1, Col: 1}},
+		{"backtrack within", MaxBacktrack(3), "abca", nil},
+		{"backtrack exceeded", MaxBacktrack(2), "abca", &BudgetError{Limit: "backtracked bytes", Max: 2, Rule: "long", Pos: 0, Line: 1, Col: 1}},
+		{"depth within", MaxDepth(2), "abcaaa", nil},
+		{"depth exceeded", MaxDepth(1), "abcaaa", &BudgetError{Limit: "nested rules", Max: 1, Rule: "long", Pos: 0, Line: 1, Col: 1}},
+		// The leaves of long are built before it fails.
+		{"nodes within", WithMaxNodes(5), "abcaaa", nil},
+		{"nodes exceeded", WithMaxNodes(4), "abcaaa", &BudgetError{Limit: "nodes", Max: 4, Rule: "short+", Pos: 0, Line: 1, Col: 1}},
+		{"tree bytes within", WithMaxTreeBytes(5*nodeSize + 8), "abcaaa", nil},
+		{"tree bytes exceeded", WithMaxTreeBytes(4 * nodeSize), "abcaaa", &BudgetError{Limit: "tree bytes", Max: 4 * nodeSize, Rule: "short+", Pos: 0, Line: 1, Col: 1}},
+	}
+	for _, tt := range tests {
+		lang, err := NewParser(strings.NewReader(grammar), tt.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = lang.ParseString(tt.input)
+		if tt.err == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		berr, ok := err.(*BudgetError)
+		if !ok {
+			t.Errorf("%s: expected a budget error, got %v", tt.name, err)
+			continue
+		}
+		if *berr != *tt.err {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.err, berr)
+		}
+	}
+}
+
+func TestBudgetExponential(t *testing.T) {
+	// Every a is matched by both alternatives of x, so the work doubles
+	// with each a.
+	grammar := "prgm <- x\nx <- xb / xc\nxb <- 'a' y 'b'\nxc <- 'a' y 'c'\ny <- x / 'z'"
+	lang, err := NewParser(strings.NewReader(grammar), MaxRuleCalls(10000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lang.ParseString(strings.Repeat("a", 40) + "z" + strings.Repeat("c", 40))
+	berr, ok := err.(*BudgetError)
+	if !ok {
+		t.Fatalf("expected a budget error, got %v", err)
+	}
+	if berr.Rule == "prgm" {
+		t.Errorf("unexpected rule %s", berr.Rule)
+	}
+	if !strings.Contains(berr.Error(), "rule calls") {
+		t.Errorf("unexpected message %q", berr.Error())
+	}
+}
+
+func TestBudgetTree(t *testing.T) {
+	lang, err := NewLanguage("list <- item*\nitem <- group / 'a'\ngroup <- '[' list ']'", WithMaxNodes(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lang.ParseString(strings.Repeat("[a]", 100)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = lang.ParseString(strings.Repeat("[a]", 1e5))
+	if berr, ok := err.(*BudgetError); !ok || berr.Limit != "nodes" {
+		t.Fatalf("expected a budget error, got %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	lang, err := NewLanguage("prgm <- item+\nitem <- &{tick} 'a'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ticks := 0
+	lang.RegisterPredicate("tick", func(s *Source, pos int) bool {
+		if ticks++; ticks == 10 {
+			cancel()
+		}
+		return true
+	})
+	input := strings.Repeat("a", 5000)
+
+	s := SourceFromBytes([]byte(input[:5]))
+	s.SetContext(ctx)
+	if _, err := lang.ParseSource(s); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	ticks = 0
+	s = SourceFromBytes([]byte(input))
+	s.SetContext(ctx)
+	if _, err := lang.ParseSource(s); err != context.Canceled {
+		t.Errorf("got %v, exp %v", err, context.Canceled)
+	}
+	// The parse stopped at the first check after the cancellation.
+	if ticks > contextChecks {
+		t.Errorf("parse went on for %d items", ticks)
+	}
+}
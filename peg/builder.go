@@ -0,0 +1,195 @@
+package peg
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// Grammar builds a language in Go rather than from grammar text:
+//
+//	g := peg.NewGrammar()
+//	g.Rule("sum").Seq(g.Ref("num"), g.Star(g.Seq(g.Discard(g.Lit("+")), g.Ref("num")))).
+//		Rule("num").Is(g.Regexp(`[0-9]+`))
+//	lang, err := g.Compile()
+//
+// The rules go through the same checks and resolution as those of a
+// grammar, and the language can be printed with Grammar, extended and
+// profiled like any other. Unlike grammar text, expressions nest freely.
+type Grammar struct {
+	rules []*RuleBuilder
+	errs  GrammarErrors
+}
+
+// RuleBuilder defines a rule of a Grammar.
+type RuleBuilder struct {
+	g    *Grammar
+	name string
+	body *Expr
+}
+
+// Expr is an expression of a Grammar. Expressions are built for the rule
+// that uses them, so that literals and regexps produce leaves named after
+// the rule, as in grammar text.
+type Expr struct {
+	build func(rule string, refs *[]string) *Lexeme
+}
+
+// NewGrammar returns an empty Grammar.
+func NewGrammar() *Grammar {
+	return &Grammar{}
+}
+
+// Rule starts the definition of the rule name. The first rule is the root
+// rule unless the Start option says otherwise.
+func (g *Grammar) Rule(name string) *RuleBuilder {
+	for _, r := range g.rules {
+		if r.name == name {
+			g.errs = append(g.errs, &GrammarError{Rule: name, Msg: fmt.Sprintf("rule %s is already defined", name)})
+		}
+	}
+	r := &RuleBuilder{g: g, name: name}
+	g.rules = append(g.rules, r)
+	return r
+}
+
+// Is defines the rule as e and returns the grammar, for defining the next
+// rule.
+func (r *RuleBuilder) Is(e *Expr) *Grammar {
+	r.body = e
+	return r.g
+}
+
+// Seq defines the rule as the sequence of parts, as in a <- b c.
+func (r *RuleBuilder) Seq(parts ...*Expr) *Grammar {
+	return r.Is(r.g.Seq(parts...))
+}
+
+// Choice defines the rule as the ordered choice of alts, as in a <- b / c.
+func (r *RuleBuilder) Choice(alts ...*Expr) *Grammar {
+	return r.Is(r.g.Choice(alts...))
+}
+
+// Compile builds the language of the rules defined so far.
+func (g *Grammar) Compile(opts ...Option) (*Language, error) {
+	errs := append(GrammarErrors(nil), g.errs...)
+	for _, r := range g.rules {
+		if r.body == nil {
+			errs = append(errs, &GrammarError{Rule: r.name, Msg: "empty rule body"})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	rules := make([]rule, len(g.rules))
+	for i, r := range g.rules {
+		var refs []string
+		lex := r.body.build(r.name, &refs)
+		rules[i] = rule{name: r.name, lex: lex, refs: refs}
+	}
+	return compile(context.Background(), "", rules, opts)
+}
+
+// Ref refers to the rule name, which may be defined later.
+func (g *Grammar) Ref(name string) *Expr {
+	return &Expr{func(rule string, refs *[]string) *Lexeme {
+		*refs = append(*refs, name)
+		return NewRuleLexer(name)
+	}}
+}
+
+// Lit matches the literal text.
+func (g *Grammar) Lit(text string) *Expr {
+	return &Expr{func(rule string, refs *[]string) *Lexeme {
+		return NewLiteralLexer(rule, text)
+	}}
+}
+
+// Regexp matches the regular expression pattern, as ~'pattern' does.
+func (g *Grammar) Regexp(pattern string) *Expr {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		g.errs = append(g.errs, &GrammarError{Msg: fmt.Sprintf("regexp %q: %s", pattern, err)})
+	}
+	return &Expr{func(rule string, refs *[]string) *Lexeme {
+		return NewRegexpLexer(rule, re)
+	}}
+}
+
+// Match matches m, as a literal or regexp would.
+func (g *Grammar) Match(m Matcher) *Expr {
+	return &Expr{func(rule string, refs *[]string) *Lexeme {
+		return NewMatcherLexer(rule, m)
+	}}
+}
+
+// Seq matches parts one after another. A sequence of one part is that
+// part.
+func (g *Grammar) Seq(parts ...*Expr) *Expr {
+	return &Expr{func(rule string, refs *[]string) *Lexeme {
+		lexes := g.build(parts, rule, refs)
+		if len(lexes) == 1 {
+			return lexes[0]
+		}
+		return NewConcatLexer(rule, lexes)
+	}}
+}
+
+// Choice matches the first of alts that matches.
+func (g *Grammar) Choice(alts ...*Expr) *Expr {
+	return &Expr{func(rule string, refs *[]string) *Lexeme {
+		lexes := g.build(alts, rule, refs)
+		if len(lexes) == 1 {
+			return lexes[0]
+		}
+		return NewChoiceLexer(rule, lexes...)
+	}}
+}
+
+// Star matches e zero or more times, as e* does.
+func (g *Grammar) Star(e *Expr) *Expr {
+	return g.wrap(e, NewStarClosure)
+}
+
+// Plus matches e one or more times, as e+ does.
+func (g *Grammar) Plus(e *Expr) *Expr {
+	return g.wrap(e, NewPlusClosure)
+}
+
+// Opt matches e or nothing, as e? does.
+func (g *Grammar) Opt(e *Expr) *Expr {
+	return g.wrap(e, NewOptionClosure)
+}
+
+// Discard matches e and leaves it out of the tree, as e^ does.
+func (g *Grammar) Discard(e *Expr) *Expr {
+	return g.wrap(e, NewDiscardLexer)
+}
+
+// External calls the matcher registered as name, as @name does.
+func (g *Grammar) External(name string) *Expr {
+	return &Expr{func(rule string, refs *[]string) *Lexeme {
+		return NewExternalLexer(name)
+	}}
+}
+
+// Predicate consults the predicate registered as name, as &{name} does.
+func (g *Grammar) Predicate(name string) *Expr {
+	return &Expr{func(rule string, refs *[]string) *Lexeme {
+		return NewPredicateLexer(name)
+	}}
+}
+
+func (g *Grammar) wrap(e *Expr, fn func(*Lexeme) *Lexeme) *Expr {
+	return &Expr{func(rule string, refs *[]string) *Lexeme {
+		return fn(e.build(rule, refs))
+	}}
+}
+
+func (g *Grammar) build(exprs []*Expr, rule string, refs *[]string) []*Lexeme {
+	lexes := make([]*Lexeme, len(exprs))
+	for i, e := range exprs {
+		lexes[i] = e.build(rule, refs)
+	}
+	return lexes
+}
@@ -0,0 +1,72 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGrammarBuilder(t *testing.T) {
+	g := NewGrammar()
+	g.Rule("sum").Seq(g.Ref("num"), g.Star(g.Seq(g.Discard(g.Lit("+")), g.Ref("num")))).
+		Rule("num").Choice(g.Regexp(`[0-9]+`), g.Lit("x"))
+	lang, err := g.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("1+x+23")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "sum",
+		Children: []*ParseTree{
+			&ParseTree{Type: "num", Data: []byte("1")},
+			&ParseTree{Type: "sum*", Children: []*ParseTree{
+				&ParseTree{Type: "num", Data: []byte("x")},
+				&ParseTree{Type: "num", Data: []byte("23")},
+			}},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		t.Error(err)
+	}
+	if rules := strings.Join(lang.Rules(), " "); rules != "sum num" {
+		t.Errorf("unexpected rules %s", rules)
+	}
+	if out, exp := lang.Grammar(), "sum <- num ('+'^ num)*\nnum <- ~`[0-9]+` / 'x'\n"; out != exp {
+		t.Errorf("Grammar() = %q, expected %q", out, exp)
+	}
+
+	// Built languages take options and can be extended.
+	lang, err = g.Compile(Start("num"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lang.AddRule("extra", NewRuleLexer("sum")); err != nil {
+		t.Error(err)
+	}
+	if tree, err := lang.ParseString("7"); err != nil || tree.Type != "num" {
+		t.Errorf("unexpected result %v, %v", tree, err)
+	}
+}
+
+func TestGrammarBuilderErrors(t *testing.T) {
+	g := NewGrammar()
+	g.Rule("a").Seq(g.Ref("b"), g.Regexp("[")).
+		Rule("a").Is(g.Lit("a")).
+		Rule("c")
+	_, err := g.Compile()
+	exp := "regexp \"[\": error parsing regexp: missing closing ]: `[`\na: rule a is already defined\nc: empty rule body"
+	if err == nil || err.Error() != exp {
+		t.Errorf("Compile() = %v, expected\n%s", err, exp)
+	}
+
+	g = NewGrammar()
+	g.Rule("a").Seq(g.Ref("b"), g.Lit("x"))
+	if _, err := g.Compile(); err == nil || err.Error() != "a: undefined rule b" {
+		t.Errorf("Compile() = %v, expected an undefined rule", err)
+	}
+	if _, err := g.Compile(WithRule("b", NewLiteralLexer("b", "b"))); err != nil {
+		t.Errorf("rule bound with WithRule was not found: %s", err)
+	}
+}
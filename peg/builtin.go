@@ -0,0 +1,259 @@
+package peg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// builtinCalls constructs the matchers that can be called from a grammar
+// as name(args...). Each receives the name of the enclosing rule and the
+// literal arguments of the call.
+var builtinCalls = map[string]func(rule string, args []string) (*Lexeme, error){
+	"balanced": func(rule string, args []string) (*Lexeme, error) {
+		if len(args) != 2 || args[0] == "" || args[1] == "" || args[0] == args[1] {
+			return nil, errors.New("expected two distinct, non-empty delimiters")
+		}
+		return NewBalancedLexer(rule, args[0], args[1]), nil
+	},
+	"string": func(rule string, args []string) (*Lexeme, error) {
+		if len(args) == 1 {
+			args = append(args, "")
+		}
+		if len(args) != 2 || len(args[0]) != 1 || len(args[1]) > 1 {
+			return nil, errors.New("expected a one byte quote and an optional one byte escape")
+		}
+		return NewStringLexer(rule, args[0][0], args[1]), nil
+	},
+	"keyword": func(rule string, args []string) (*Lexeme, error) {
+		if len(args) != 1 || args[0] == "" {
+			return nil, errors.New("expected a keyword")
+		}
+		return NewKeywordLexer(rule, args[0]), nil
+	},
+	"timestamp": func(rule string, args []string) (*Lexeme, error) {
+		if len(args) != 1 || args[0] == "" {
+			return nil, errors.New("expected a time layout")
+		}
+		return NewTimestampLexer(rule, args[0]), nil
+	},
+	"warn": func(rule string, args []string) (*Lexeme, error) {
+		if len(args) != 1 || args[0] == "" {
+			return nil, errors.New("expected a warning message")
+		}
+		return NewWarnLexer(rule, args[0]), nil
+	},
+}
+
+// NewBalancedLexer matches a region starting with open and ending with the
+// matching close, skipping over arbitrary content and nested pairs.
+func NewBalancedLexer(typ, open, close string) *Lexeme {
+	obytes, cbytes := []byte(open), []byte(close)
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: typ,
+		kind: kindCall,
+		text: "balanced(" + quoteLiteral(open) + ", " + quoteLiteral(close) + ")",
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			if !bytes.HasPrefix(s.buf[pos:], obytes) {
+				s.starveLiteral(obytes, pos, false)
+				return nil, s.expected(pos, quoteLiteral(open)), 0
+			}
+			depth := 0
+			for i := pos; i < len(s.buf); {
+				switch {
+				case bytes.HasPrefix(s.buf[i:], obytes):
+					depth++
+					i += len(obytes)
+				case bytes.HasPrefix(s.buf[i:], cbytes):
+					depth--
+					i += len(cbytes)
+					if depth == 0 {
+						return s.leaf(&ParseTree{
+							Type: typ,
+							ID:   id.get(s),
+							Data: s.buf[pos:i],
+							Pos:  pos,
+							End:  i,
+						}), nil, i - pos
+					}
+				default:
+					i++
+				}
+			}
+			s.Starve(1)
+			return nil, s.failed(pos, fmt.Sprintf("unbalanced %q", open)), 0
+		},
+	}
+}
+
+// NewSkipUntilLexer matches everything up to the next occurrence of
+// delimiter, which it leaves unmatched, as ..'delimiter' does in a grammar.
+// It is what (!delimiter any)* would match, found with a single search,
+// except that it fails if the delimiter never occurs.
+func NewSkipUntilLexer(typ, delimiter string) *Lexeme {
+	dbytes, quoted := []byte(delimiter), quoteLiteral(delimiter)
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: typ,
+		kind: kindCall,
+		text: ".." + quoteLiteral(delimiter),
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			var n int
+			if len(dbytes) == 1 {
+				n = bytes.IndexByte(s.buf[pos:], dbytes[0])
+			} else {
+				n = bytes.Index(s.buf[pos:], dbytes)
+			}
+			if n < 0 {
+				s.Starve(1)
+				return nil, s.expected(len(s.buf), quoted), 0
+			}
+			return s.leaf(&ParseTree{
+				Type: typ,
+				ID:   id.get(s),
+				Data: s.buf[pos : pos+n],
+				Pos:  pos,
+				End:  pos + n,
+			}), nil, n
+		},
+	}
+}
+
+// NewStringLexer matches a string delimited by quote, in which escape
+// followed by n, t, r, xNN or uNNNN stands for the character it denotes in
+// a grammar literal, and followed by any other byte stands for that byte.
+// An escape equal to quote means a doubled quote stands for one quote, and
+// an empty escape means the string has no escapes. The leaf holds the
+// decoded string, both as Data and as a string in Value; in Lossless mode
+// Data is the matched input instead.
+func NewStringLexer(typ string, quote byte, escape string) *Lexeme {
+	text := "string(" + quoteLiteral(string(quote)) + ")"
+	if escape != "" {
+		text = "string(" + quoteLiteral(string(quote)) + ", " + quoteLiteral(escape) + ")"
+	}
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: typ,
+		kind: kindCall,
+		text: text,
+		unparse: func(leaf *ParseTree) []byte {
+			return encodeString(leaf, quote, escape)
+		},
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			skip := s.skipWhitespace(pos)
+			start := pos + skip
+			if start == len(s.buf) {
+				s.Starve(1)
+			}
+			if start == len(s.buf) || s.buf[start] != quote {
+				return nil, s.expected(start, quoteLiteral(string(quote))), 0
+			}
+			data, end, err := s.decodeString(start, quote, escape)
+			if err != nil {
+				return nil, err, 0
+			}
+			tree := &ParseTree{
+				Type:    typ,
+				ID:      id.get(s),
+				Data:    data,
+				Value:   string(data),
+				Pos:     start,
+				End:     end,
+				Leading: s.skipped(start, skip),
+			}
+			if s.lossless() {
+				tree.Data = s.buf[start:end]
+			}
+			return s.leaf(tree), nil, end - pos
+		},
+	}
+}
+
+// stringEscapes holds the letters escaping control characters in strings.
+var stringEscapes = [256]byte{'\n': 'n', '\t': 't', '\r': 'r'}
+
+// encodeString writes the string of a leaf of NewStringLexer back as the
+// input it was decoded from.
+func encodeString(leaf *ParseTree, quote byte, escape string) []byte {
+	data := leaf.Data
+	if v, ok := leaf.Value.(string); ok {
+		data = []byte(v)
+	}
+	buf := []byte{quote}
+	for i := 0; i < len(data); i++ {
+		switch {
+		case escape == "":
+		case escape != string(quote) && stringEscapes[data[i]] != 0:
+			buf = append(buf, escape...)
+			buf = append(buf, stringEscapes[data[i]])
+			continue
+		case data[i] == quote:
+			buf = append(buf, escape...)
+		case escape != string(quote) && bytes.HasPrefix(data[i:], []byte(escape)):
+			buf = append(buf, escape...)
+		}
+		buf = append(buf, data[i])
+	}
+	return append(buf, quote)
+}
+
+// decodeString decodes the string starting with quote at offset start,
+// returning its contents and the offset just past the closing quote.
+func (s *Source) decodeString(start int, quote byte, escape string) ([]byte, int, error) {
+	buf := s.buf
+	var data []byte
+	for i := start + 1; i < len(buf); i++ {
+		c := buf[i]
+		switch {
+		case c == quote && escape == string(quote):
+			if i+1 < len(buf) && buf[i+1] == quote {
+				data = append(data, quote)
+				i++
+				continue
+			}
+			return data, i + 1, nil
+		case c == quote:
+			return data, i + 1, nil
+		case escape != "" && c == escape[0]:
+			if i++; i == len(buf) {
+				break
+			}
+			switch c = buf[i]; c {
+			case 'n':
+				data = append(data, '\n')
+			case 't':
+				data = append(data, '\t')
+			case 'r':
+				data = append(data, '\r')
+			case 'x', 'u':
+				width := 2
+				if c == 'u' {
+					width = 4
+				}
+				if i+width >= len(buf) {
+					s.Starve(i + width + 2 - len(buf))
+					return nil, 0, s.failed(i-1, "incomplete escape")
+				}
+				v, err := strconv.ParseUint(string(buf[i+1:i+1+width]), 16, 32)
+				if err != nil {
+					return nil, 0, s.failed(i-1, "invalid escape")
+				}
+				if c == 'x' {
+					data = append(data, byte(v))
+				} else {
+					data = utf8.AppendRune(data, rune(v))
+				}
+				i += width
+			default:
+				data = append(data, c)
+			}
+		default:
+			data = append(data, c)
+		}
+	}
+	s.Starve(1)
+	return nil, 0, s.failed(start, "unterminated string")
+}
@@ -0,0 +1,183 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+var balancedTestTable = []ParseTest{
+	ParseTest{
+		"prgm <- 'f' balanced('(', ')')",
+		"f(a(b)c)",
+		&ParseTree{
+			Type: "prgm",
+			Children: []*ParseTree{
+				&ParseTree{Type: "prgm", Data: []byte("f")},
+				&ParseTree{Type: "prgm", Data: []byte("(a(b)c)")},
+			},
+		},
+	},
+	ParseTest{
+		"prgm <- island+\nisland <- balanced('{{', '}}') / ~'[^{]+'",
+		"a{{b{{c}}}}d",
+		&ParseTree{
+			Type: "island+",
+			Children: []*ParseTree{
+				&ParseTree{Type: "island", Data: []byte("a")},
+				&ParseTree{Type: "island", Data: []byte("{{b{{c}}}}")},
+				&ParseTree{Type: "island", Data: []byte("d")},
+			},
+		},
+	},
+}
+
+func TestBalanced(t *testing.T) {
+	for _, tc := range balancedTestTable {
+		parser, err := NewParser(strings.NewReader(tc.language))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := parser.ParseString(tc.input)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if err := treeCompare(tree, tc.exp); err != nil {
+			t.Error(err)
+		}
+	}
+
+	parser, err := NewParser(strings.NewReader("prgm <- balanced('(', ')')"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseString("((a)"); err == nil {
+		t.Errorf("expected error for unbalanced input")
+	}
+
+	if _, err := NewParser(strings.NewReader("prgm <- balanced('(')")); err == nil {
+		t.Errorf("expected error for missing delimiter")
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		language string
+		input    string
+		exp      string
+	}{
+		{`prgm <- string('"', '\\')`, `"a\"b\\c\n\x41é\q"`, "a\"b\\c\nAéq"},
+		{`prgm <- string('\'', '\'')`, `'it''s'`, "it's"},
+		{`prgm <- string('|')`, `|a\n|`, `a\n`},
+		{`prgm <- string('"', '\\')`, `""`, ""},
+	}
+	for _, test := range tests {
+		parser, err := NewParser(strings.NewReader(test.language))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := parser.ParseString(test.input)
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err)
+			continue
+		}
+		if string(tree.Data) != test.exp || tree.Value != test.exp || tree.End != len(test.input) {
+			t.Errorf("%s: got %q, %v ending at %d", test.input, tree.Data, tree.Value, tree.End)
+		}
+		if g := parser.Grammar(); g != test.language+"\n" {
+			t.Errorf("Grammar() = %q", g)
+		}
+	}
+
+	parser, err := NewParser(strings.NewReader("%whitespace ws\nprgm <- string('\"', '\\\\')+\nws <- ' '"), Lossless(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := `"a\tb" "c"`
+	tree, err := parser.ParseString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := string(tree.Text()); text != input {
+		t.Errorf("lossless text %q", text)
+	}
+	if tree.Children[0].Value != "a\tb" {
+		t.Errorf("unexpected value %v", tree.Children[0].Value)
+	}
+
+	for _, input := range []string{`"abc`, `"a\`, `"\x4"`, `x""`} {
+		if _, err := parser.ParseString(input); err == nil {
+			t.Errorf("expected error for %s", input)
+		}
+	}
+	for _, language := range []string{"prgm <- string()", "prgm <- string('ab')", "prgm <- string('\"', '\\\\', 'x')"} {
+		if _, err := NewParser(strings.NewReader(language)); err == nil {
+			t.Errorf("expected error for %s", language)
+		}
+	}
+}
+
+var skipUntilTestTable = []ParseTest{
+	ParseTest{
+		"comment <- '/*' ..'*/' '*/'",
+		"/* a * b / c */",
+		&ParseTree{
+			Type: "comment",
+			Children: []*ParseTree{
+				&ParseTree{Type: "comment", Data: []byte("/*")},
+				&ParseTree{Type: "comment", Data: []byte(" a * b / c ")},
+				&ParseTree{Type: "comment", Data: []byte("*/")},
+			},
+		},
+	},
+	ParseTest{
+		"line <- ..'\\n' '\\n'^",
+		"\n",
+		&ParseTree{Type: "line", Data: []byte("")},
+	},
+	ParseTest{
+		"stmts <- stmt+\nstmt <- ..`;` ';'^",
+		"a;b c;",
+		&ParseTree{
+			Type: "stmt+",
+			Children: []*ParseTree{
+				&ParseTree{Type: "stmt", Data: []byte("a")},
+				&ParseTree{Type: "stmt", Data: []byte("b c")},
+			},
+		},
+	},
+}
+
+func TestSkipUntil(t *testing.T) {
+	for _, tc := range skipUntilTestTable {
+		parser, err := NewParser(strings.NewReader(tc.language))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := parser.ParseString(tc.input)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if err := treeCompare(tree, tc.exp); err != nil {
+			t.Error(err)
+		}
+	}
+
+	parser, err := NewParser(strings.NewReader("comment <- '/*' ..'*/' '*/'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseString("/* open"); err == nil {
+		t.Errorf("expected error for a missing delimiter")
+	}
+	if g := parser.Grammar(); g != "comment <- '/*' ..'*/' '*/'\n" {
+		t.Errorf("Grammar() = %q", g)
+	}
+
+	for _, language := range []string{"prgm <- ..''", "prgm <- .'x'", "prgm <- ..x"} {
+		if _, err := NewParser(strings.NewReader(language)); err == nil {
+			t.Errorf("expected error for %s", language)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package peg
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// capture is an immutable list of labeled matches visible to backreferences
+// in the current rule.
+type capture struct {
+	label string
+	value []byte
+	prev  *capture
+}
+
+func (c *capture) lookup(label string) ([]byte, bool) {
+	for ; c != nil; c = c.prev {
+		if c.label == label {
+			return c.value, true
+		}
+	}
+	return nil, false
+}
+
+// NewCaptureLexer records the text matched by lex under label, so that a
+// later backreference in the same rule can match it again.
+func NewCaptureLexer(label string, lex *Lexeme) *Lexeme {
+	return &Lexeme{
+		Name:         label + ":" + lex.Name,
+		Dependencies: []*Lexeme{lex},
+		kind:         kindCapture,
+		text:         label,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			tree, err, n := lex.Lexer(s, pos)
+			if err != nil {
+				return nil, err, 0
+			}
+			s.captures = &capture{label, s.buf[pos : pos+n], s.captures}
+			return tree, nil, n
+		},
+	}
+}
+
+// NewBackrefLexer matches the text most recently captured under label.
+func NewBackrefLexer(typ, label string) *Lexeme {
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: "=" + label,
+		kind: kindBackref,
+		text: label,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			value, ok := s.captures.lookup(label)
+			if !ok {
+				return nil, s.failed(pos, "backreference to unset label "+label), 0
+			}
+			if !bytes.HasPrefix(s.buf[pos:], value) {
+				s.starveLiteral(value, pos, false)
+				return nil, s.expected(pos, fmt.Sprintf("%q (=%s)", value, label)), 0
+			}
+			return s.leaf(&ParseTree{
+				Type: typ,
+				ID:   id.get(s),
+				Data: s.buf[pos : pos+len(value)],
+				Pos:  pos,
+				End:  pos + len(value),
+			}), nil, len(value)
+		},
+	}
+}
+
+// NewCaptureScope gives each match of lex its own set of captures, hiding
+// those of the enclosing rule and discarding its own when it returns.
+func NewCaptureScope(lex *Lexeme) *Lexeme {
+	return &Lexeme{
+		Name:         lex.Name,
+		Dependencies: []*Lexeme{lex},
+		kind:         kindScope,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			outer := s.captures
+			s.captures = nil
+			tree, err, n := lex.Lexer(s, pos)
+			s.captures = outer
+			return tree, err, n
+		},
+	}
+}
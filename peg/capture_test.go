@@ -0,0 +1,48 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+const markupGrammar = `doc <- elem
+elem <- '<'^ t:name '>'^ content* '</'^ =t '>'^
+content <- elem / ~'[^<]+'
+name <- ~'[a-z]+'`
+
+func TestBackreference(t *testing.T) {
+	parser, err := NewParser(strings.NewReader(markupGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := parser.ParseString("<a>x<b>y</b></a>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "elem",
+		Children: []*ParseTree{
+			&ParseTree{Type: "name", Data: []byte("a")},
+			&ParseTree{Type: "content*", Children: []*ParseTree{
+				&ParseTree{Type: "content", Data: []byte("x")},
+				&ParseTree{Type: "elem", Children: []*ParseTree{
+					&ParseTree{Type: "name", Data: []byte("b")},
+					&ParseTree{Type: "content*", Children: []*ParseTree{
+						&ParseTree{Type: "content", Data: []byte("y")},
+					}},
+					&ParseTree{Type: "elem", Data: []byte("b")},
+				}},
+			}},
+			&ParseTree{Type: "elem", Data: []byte("a")},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		dumpTree(tree, "")
+		t.Error(err)
+	}
+
+	if _, err := parser.ParseString("<a><b></a></b>"); err == nil {
+		t.Errorf("expected mismatched tags to fail")
+	}
+}
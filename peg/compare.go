@@ -0,0 +1,94 @@
+package peg
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// Divergence is an input that two languages parse differently: one of them
+// accepts it and the other doesn't, or both do with different trees.
+type Divergence struct {
+	Name       string // the path of the input in the corpus.
+	ErrA, ErrB error  // why each language rejected the input, if it did.
+	Edits      []Edit // how the tree of the first language becomes that of the second.
+}
+
+func (d Divergence) String() string {
+	switch {
+	case d.ErrA != nil:
+		return fmt.Sprintf("%s: only accepted by the second language; the first says: %v", d.Name, d.ErrA)
+	case d.ErrB != nil:
+		return fmt.Sprintf("%s: only accepted by the first language; the second says: %v", d.Name, d.ErrB)
+	}
+	edits := make([]string, len(d.Edits))
+	for i, e := range d.Edits {
+		edits[i] = "\t" + e.String()
+	}
+	return fmt.Sprintf("%s: the trees differ:\n%s", d.Name, strings.Join(edits, "\n"))
+}
+
+// Compare parses every file of corpus with a and with b, such as two
+// versions of a grammar, and returns the inputs they disagree on in the
+// order of their paths. A language accepts an input if its root rule
+// matches all of it, as for %test. Inputs both reject are not reported, whatever the
+// errors, and trees are compared as Diff does, so inputs whose trees only
+// differ in positions are not either. Hidden files and directories, whose
+// names start with a dot, are skipped.
+func Compare(a, b *Language, corpus fs.FS) ([]Divergence, error) {
+	var found []Divergence
+	err := walkCorpus(corpus, func(path string, input []byte) {
+		treeA, errA := a.parseWhole(input)
+		treeB, errB := b.parseWhole(input)
+		switch {
+		case errA != nil && errB != nil:
+		case errA != nil || errB != nil:
+			found = append(found, Divergence{Name: path, ErrA: errA, ErrB: errB})
+		default:
+			if edits := Diff(treeA, treeB); len(edits) > 0 {
+				found = append(found, Divergence{Name: path, Edits: edits})
+			}
+		}
+	})
+	return found, err
+}
+
+// walkCorpus calls fn with the path and contents of every file of corpus
+// but the hidden ones, in lexical order.
+func walkCorpus(corpus fs.FS, fn func(path string, input []byte)) error {
+	return fs.WalkDir(corpus, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && path != "." {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		input, err := fs.ReadFile(corpus, path)
+		if err != nil {
+			return err
+		}
+		fn(path, input)
+		return nil
+	})
+}
+
+// parseWhole parses input with the root rule, failing unless the match
+// covers all of it but trailing whitespace.
+func (l *Language) parseWhole(input []byte) (*ParseTree, error) {
+	s := SourceFromBytes(input)
+	l.normalize(s)
+	tree, err, n := l.parseAt(l.root, s, 0, false)
+	if err == nil {
+		n += s.skipWhitespace(n)
+		if n < len(s.buf) {
+			return nil, s.failed(n, "unexpected input")
+		}
+	}
+	return tree, err
+}
@@ -0,0 +1,61 @@
+package peg
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCompare(t *testing.T) {
+	old, err := NewLanguage("sum <- num more*\nmore <- '+'^ num\nnum <- ~'[0-9]+'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The new version also accepts - and names the operands of + terms.
+	new, err := NewLanguage("sum <- num more*\nmore <- op num\nop <- '-'\nnum <- ~'[0-9]+' / term\nterm <- '(' num ')'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus := fstest.MapFS{
+		"same.txt":        {Data: []byte("12")},
+		"both/fail.txt":   {Data: []byte("x")},
+		"old/plus.txt":    {Data: []byte("1+2")},
+		"new/minus.txt":   {Data: []byte("1-2")},
+		".hidden/bad.txt": {Data: []byte("1-2")},
+	}
+	found, err := Compare(old, new, corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range found {
+		got = append(got, d.Name)
+		if (d.ErrA != nil) == (d.ErrB != nil) {
+			t.Errorf("%s: ErrA %v, ErrB %v, exp exactly one", d.Name, d.ErrA, d.ErrB)
+		}
+	}
+	if exp := []string{"new/minus.txt", "old/plus.txt"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %q, exp %q", got, exp)
+	}
+}
+
+func TestCompareTrees(t *testing.T) {
+	a, err := NewLanguage("list <- item+\nitem <- ~'[a-z]+' ' '?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewLanguage("list <- item+\nitem <- ~'[a-z]+' ' '^?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := Compare(a, b, fstest.MapFS{"one": {Data: []byte("a")}, "two": {Data: []byte("a b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Name != "two" || len(found[0].Edits) == 0 {
+		t.Fatalf("got %v, exp the trees of two to differ", found)
+	}
+	if s := found[0].String(); s[:len("two: the trees differ:\n")] != "two: the trees differ:\n" {
+		t.Errorf("String() = %q", s)
+	}
+}
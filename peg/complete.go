@@ -0,0 +1,56 @@
+package peg
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Completion is a terminal that could legally follow the input before the
+// cursor.
+type Completion struct {
+	Type     string // the type of the leaf it would produce.
+	Expected string // the terminal in grammar notation.
+	Text     string // the text of a literal; empty for other terminals.
+	Start    int    // where the terminal would start, before any typed prefix.
+}
+
+// Complete parses input up to offset and returns the terminals that could
+// come next, including literals whose beginning was already typed. Since
+// alternatives are only tried until one matches, completions that depend on
+// an earlier alternative failing are not found.
+func (l *Language) Complete(input []byte, offset int) []Completion {
+	s := SourceFromBytes(input[:offset])
+	s.completions = make(map[Completion]bool)
+	l.parse(s)
+
+	list := make([]Completion, 0, len(s.completions))
+	for c := range s.completions {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Start != list[j].Start {
+			return list[i].Start > list[j].Start
+		}
+		return list[i].Expected < list[j].Expected
+	})
+	return list
+}
+
+// complete records a terminal that failed at pos if it could match once
+// more input follows. fold compares literals regardless of case.
+func (s *Source) complete(typ string, pos int, expected, literal string, fold bool) {
+	if s.completions == nil || s.skipping {
+		return
+	}
+	rest := s.buf[pos:]
+	if literal == "" {
+		if len(rest) != 0 {
+			return
+		}
+	} else if len(rest) >= len(literal) {
+		return
+	} else if prefix := []byte(literal[:len(rest)]); !bytes.Equal(rest, prefix) && !(fold && bytes.EqualFold(rest, prefix)) {
+		return
+	}
+	s.completions[Completion{typ, expected, literal, pos}] = true
+}
@@ -0,0 +1,42 @@
+package peg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	grammar := "%whitespace ws\nstmt <- let / print\nlet <- 'let' name '=' value\nprint <- 'print' value\nname <- ~'[a-z]+'\nvalue <- name / 'true' / 'false'\nws <- ~' +'"
+	lang, err := NewParser(strings.NewReader(grammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		input string
+		exp   []Completion
+	}{
+		{"", []Completion{
+			{Type: "let", Expected: "'let'", Text: "let", Start: 0},
+			{Type: "print", Expected: "'print'", Text: "print", Start: 0},
+		}},
+		{"pr", []Completion{
+			{Type: "print", Expected: "'print'", Text: "print", Start: 0},
+		}},
+		{"let x ", []Completion{
+			{Type: "let", Expected: "'='", Text: "=", Start: 6},
+		}},
+		{"let x = ", []Completion{
+			{Type: "value", Expected: "'false'", Text: "false", Start: 8},
+			{Type: "value", Expected: "'true'", Text: "true", Start: 8},
+			{Type: "name", Expected: "~`[a-z]+`", Start: 8},
+		}},
+	} {
+		// The cursor is at the end of the input; text after it is ignored.
+		input := []byte(tc.input + " ignored")
+		got := lang.Complete(input, len(tc.input))
+		if !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%q: got %v, exp %v", tc.input, got, tc.exp)
+		}
+	}
+}
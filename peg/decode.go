@@ -0,0 +1,95 @@
+package peg
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"sync"
+)
+
+// The patterns of the decoding built in rules. Like other leaves, they are
+// matched after skipping whitespace.
+var (
+	intPattern   = regexp.MustCompile(`^[+-]?[0-9]+`)
+	floatPattern = regexp.MustCompile(`^[+-]?(?:[0-9]+\.[0-9]*|\.[0-9]+|[0-9]+)(?:[eE][+-]?[0-9]+)?`)
+	hexPattern   = regexp.MustCompile(`^0[xX][0-9A-Fa-f]+`)
+	boolPattern  = regexp.MustCompile(`^(?:true|false)\b`)
+)
+
+func intLexer() *Lexeme {
+	return NewDecodingLexer("int", "integer", intPattern, func(text []byte) (interface{}, error) {
+		return strconv.ParseInt(string(text), 10, 64)
+	})
+}
+
+func floatLexer() *Lexeme {
+	return NewDecodingLexer("float", "number", floatPattern, func(text []byte) (interface{}, error) {
+		return strconv.ParseFloat(string(text), 64)
+	})
+}
+
+func hexLexer() *Lexeme {
+	return NewDecodingLexer("hex", "hexadecimal integer", hexPattern, func(text []byte) (interface{}, error) {
+		return strconv.ParseUint(string(text[2:]), 16, 64)
+	})
+}
+
+func boolLexer() *Lexeme {
+	return NewDecodingLexer("bool", "boolean", boolPattern, func(text []byte) (interface{}, error) {
+		return text[0] == 't', nil
+	})
+}
+
+// NewDecodingLexer matches valid after skipping whitespace, like
+// NewRegexpLexer, and stores what decode makes of the matched text in the
+// leaf's Value. A failing match is reported as expecting what, and text
+// that decode rejects, such as an integer out of range, fails the parse
+// with its error.
+//
+// The built in rules int, float, hex and bool are decoding lexemes, whose
+// Values are an int64, a float64, a uint64 and a bool.
+func NewDecodingLexer(typ, what string, valid *regexp.Regexp, decode func(text []byte) (interface{}, error)) *Lexeme {
+	var once sync.Once
+	var prog *syntax.Prog
+	compiled := func() *syntax.Prog {
+		once.Do(func() { prog = compileProg(valid.String()) })
+		return prog
+	}
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: typ,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			skip := s.skipWhitespace(pos)
+			pos += skip
+			match := s.consumeRegexp(valid, compiled, pos)
+			if match == nil {
+				s.complete(typ, pos, what, "", false)
+				return nil, s.expected(pos, what), 0
+			}
+			value, err := s.decode(typ, pos, decode, match)
+			if err != nil {
+				if num, ok := err.(*strconv.NumError); ok {
+					err = num.Err
+				}
+				return nil, s.failed(pos, fmt.Sprintf("invalid %s %q: %s", what, match, err)), 0
+			}
+			return s.leaf(&ParseTree{
+				Type:    typ,
+				ID:      id.get(s),
+				Data:    match,
+				Value:   value,
+				Pos:     pos,
+				End:     pos + len(match),
+				Leading: s.skipped(pos, skip),
+			}), nil, skip + len(match)
+		},
+	}
+}
+
+// decode calls the decoder of the leaf typ at pos on text, stopping the
+// parse if it panics.
+func (s *Source) decode(typ string, pos int, decode func(text []byte) (interface{}, error), text []byte) (interface{}, error) {
+	defer s.guard(typ, pos)
+	return decode(text)
+}
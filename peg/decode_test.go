@@ -0,0 +1,62 @@
+package peg
+
+import (
+	"testing"
+)
+
+func TestDecodingLexer(t *testing.T) {
+	for _, tt := range []struct {
+		grammar string
+		input   string
+		want    interface{} // the Value of the leaf, or nil if the parse fails.
+	}{
+		{"n <- int", "42", int64(42)},
+		{"n <- int", "-7", int64(-7)},
+		{"n <- int", "+7", int64(7)},
+		{"n <- int", "x", nil},
+		{"n <- int", "99999999999999999999", nil},
+		{"n <- float", "1.5", 1.5},
+		{"n <- float", "-.5e2", -50.0},
+		{"n <- float", "3", 3.0},
+		{"n <- float", "1e999", nil},
+		{"n <- hex", "0xFF", uint64(255)},
+		{"n <- hex", "0X1f", uint64(31)},
+		{"n <- hex", "ff", nil},
+		{"n <- bool", "true", true},
+		{"n <- bool", "false", false},
+		{"n <- bool", "truest", nil},
+		{"n <- flag ';'^\nflag <- bool", "true;", true},
+		{"n <- int\nint <- ~'[0-9]+'", "42", nil},
+	} {
+		lang, err := NewLanguage(tt.grammar)
+		if err != nil {
+			t.Errorf("%q: %s", tt.grammar, err)
+			continue
+		}
+		tree, err := lang.ParseString(tt.input)
+		if tt.want == nil {
+			if err == nil && tree.Value != nil {
+				t.Errorf("%q on %q: got %v (%T), expected no value", tt.grammar, tt.input, tree.Value, tree.Value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q on %q: %s", tt.grammar, tt.input, err)
+			continue
+		}
+		if tree.Value != tt.want {
+			t.Errorf("%q on %q: got %v (%T), want %v (%T)", tt.grammar, tt.input, tree.Value, tree.Value, tt.want, tt.want)
+		}
+	}
+}
+
+func TestDecodingError(t *testing.T) {
+	lang, err := NewLanguage("n <- int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lang.ParseString("99999999999999999999")
+	if err == nil || err.Error() != `invalid integer "99999999999999999999": value out of range at offset 0` {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package peg
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// EditKind is the kind of an Edit.
+type EditKind int
+
+const (
+	Insert EditKind = iota // B was inserted.
+	Remove                 // A was removed.
+	Change                 // A was changed into B.
+)
+
+// Edit is a difference between two parse trees. Path locates the node by
+// the types of its ancestors and its index among their children, as in
+// prgm/stmt[1], in the tree holding it: b for insertions, a otherwise.
+type Edit struct {
+	Kind EditKind
+	Path string
+	A, B *ParseTree
+}
+
+func (e Edit) String() string {
+	switch e.Kind {
+	case Insert:
+		return fmt.Sprintf("+ %s at %d: %s", e.Path, e.B.Pos, e.B.SExpr())
+	case Remove:
+		return fmt.Sprintf("- %s at %d: %s", e.Path, e.A.Pos, e.A.SExpr())
+	}
+	return fmt.Sprintf("~ %s at %d: %s -> %s", e.Path, e.A.Pos, e.A.SExpr(), e.B.SExpr())
+}
+
+// Diff returns the edits that turn the tree a into b, in the order of the
+// nodes. Children are aligned to maximize how similar the matched pairs
+// are, so that a node inserted among its siblings is reported as such
+// rather than as a change of every sibling after it. Matched nodes are
+// compared recursively; leaves whose Data differs are reported as a
+// Change, as are roots of different types. Positions are ignored, so the
+// trees of inputs that only differ in layout have no edits.
+func Diff(a, b *ParseTree) []Edit {
+	var edits []Edit
+	switch {
+	case a == nil && b == nil:
+	case a == nil:
+		edits = append(edits, Edit{Insert, b.Type, nil, b})
+	case b == nil:
+		edits = append(edits, Edit{Remove, a.Type, a, nil})
+	case a.Type != b.Type:
+		edits = append(edits, Edit{Change, a.Type, a, b})
+	default:
+		edits = diffTrees(edits, a.Type, a, b)
+	}
+	return edits
+}
+
+// diffTrees appends the edits between a and b, which have the same type
+// and are at path, to edits.
+func diffTrees(edits []Edit, path string, a, b *ParseTree) []Edit {
+	if !bytes.Equal(a.Data, b.Data) {
+		edits = append(edits, Edit{Change, path, a, b})
+	}
+	as, bs := a.Children, b.Children
+	alignChildren(as, bs, func(i, j int) {
+		switch {
+		case j < 0:
+			edits = append(edits, Edit{Remove, childPath(path, as[i], i), as[i], nil})
+		case i < 0:
+			edits = append(edits, Edit{Insert, childPath(path, bs[j], j), nil, bs[j]})
+		default:
+			edits = diffTrees(edits, childPath(path, as[i], i), as[i], bs[j])
+		}
+	})
+	return edits
+}
+
+// alignChildren aligns the children as and bs to maximize how similar the
+// matched pairs are, and calls fn, in order, with the indexes of each pair
+// and with -1 for the other index of the children matched with none.
+func alignChildren(as, bs []*ParseTree, fn func(i, j int)) {
+	aleaves, bleaves := make([]map[string]int, len(as)), make([]map[string]int, len(bs))
+	for i, c := range as {
+		aleaves[i] = leafSet(c, map[string]int{})
+	}
+	for j, c := range bs {
+		bleaves[j] = leafSet(c, map[string]int{})
+	}
+	score := make([][]float64, len(as))
+	for i := range as {
+		score[i] = make([]float64, len(bs))
+		for j := range bs {
+			score[i][j] = similarity(as[i], bs[j], aleaves[i], bleaves[j])
+		}
+	}
+	// best[i][j] is the highest total score of an alignment of as[i:] and
+	// bs[j:].
+	best := make([][]float64, len(as)+1)
+	for i := range best {
+		best[i] = make([]float64, len(bs)+1)
+	}
+	for i := len(as) - 1; i >= 0; i-- {
+		for j := len(bs) - 1; j >= 0; j-- {
+			best[i][j] = best[i+1][j]
+			if best[i][j+1] > best[i][j] {
+				best[i][j] = best[i][j+1]
+			}
+			if m := score[i][j] + best[i+1][j+1]; score[i][j] > 0 && m > best[i][j] {
+				best[i][j] = m
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(as) || j < len(bs) {
+		switch {
+		case i < len(as) && j < len(bs) && score[i][j] > 0 && best[i][j] == score[i][j]+best[i+1][j+1]:
+			fn(i, j)
+			i++
+			j++
+		case j == len(bs) || i < len(as) && best[i][j] == best[i+1][j]:
+			fn(i, -1)
+			i++
+		default:
+			fn(-1, j)
+			j++
+		}
+	}
+}
+
+// similarity scores how alike a and b are, from 1 for equal trees down to
+// 0 for trees that should not be matched: trees of different types, or
+// inner nodes without leaves in common. Leaves of the same type always
+// match, so that a changed leaf is reported as a Change.
+func similarity(a, b *ParseTree, aleaves, bleaves map[string]int) float64 {
+	if a.Type != b.Type {
+		return 0
+	}
+	if len(a.Children) == 0 && len(b.Children) == 0 {
+		if bytes.Equal(a.Data, b.Data) {
+			return 1
+		}
+		return 0.25
+	}
+	common, total := 0, 0
+	for leaf, n := range aleaves {
+		if m := bleaves[leaf]; m < n {
+			common += m
+		} else {
+			common += n
+		}
+		total += n
+	}
+	for _, n := range bleaves {
+		total += n
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(2*common) / float64(total)
+}
+
+// leafSet counts the leaves of t by type and data into set.
+func leafSet(t *ParseTree, set map[string]int) map[string]int {
+	if len(t.Children) == 0 {
+		set[t.Type+"\x00"+string(t.Data)]++
+	}
+	for _, c := range t.Children {
+		leafSet(c, set)
+	}
+	return set
+}
+
+func childPath(path string, child *ParseTree, i int) string {
+	return fmt.Sprintf("%s/%s[%d]", path, child.Type, i)
+}
@@ -0,0 +1,49 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("%whitespace ws\nprgm <- stmt+\nstmt <- name '='^ num ';'^\nname <- ~'[a-z]+'\nnum <- ~'[0-9]+'\nws <- ~'[ \\n]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(src string) *ParseTree {
+		tree, err := lang.ParseString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+
+	if edits := Diff(parse("a=1;b=2;"), parse("a = 1;\nb = 2;")); len(edits) != 0 {
+		t.Errorf("layout produced edits %v", edits)
+	}
+
+	edits := Diff(parse("a=1;b=2;c=3;"), parse("a=1;x=0;b=5;"))
+	exp := []string{
+		`+ stmt+/stmt[1] at 4: (stmt (name "x") (num "0"))`,
+		`~ stmt+/stmt[1]/num[1] at 6: (num "2") -> (num "5")`,
+		`- stmt+/stmt[2] at 8: (stmt (name "c") (num "3"))`,
+	}
+	var got []string
+	for _, e := range edits {
+		got = append(got, e.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("got\n%s\nexp\n%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+
+	a, b := parse("a=1;"), parse("a=1;b=2;")
+	if edits := Diff(a.Children[0], b); len(edits) != 1 || edits[0].Kind != Change || edits[0].Path != "stmt" {
+		t.Errorf("unexpected root edits %v", edits)
+	}
+	if edits := Diff(nil, a); len(edits) != 1 || edits[0].Kind != Insert {
+		t.Errorf("unexpected edits against nil %v", edits)
+	}
+	if edits := Diff(a, nil); len(edits) != 1 || edits[0].Kind != Remove {
+		t.Errorf("unexpected edits against nil %v", edits)
+	}
+}
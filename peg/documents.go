@@ -0,0 +1,44 @@
+package peg
+
+import (
+	"io"
+)
+
+// ParseAll parses the documents of r one after another with the root rule
+// until the input is exhausted, as in streams of concatenated messages or
+// newline delimited JSON. The Pos and End of each tree are offsets into the
+// whole input. A %whitespace rule is skipped between documents and after
+// the last one.
+//
+// Documents are parsed strictly even in a Tolerant language, since
+// recovery would let the first document take all of the input. Limits set
+// with MaxRuleCalls and MaxBacktrack apply to each document. On error,
+// the documents parsed before the failing one are returned with it.
+func (l *Language) ParseAll(r io.Reader) ([]*ParseTree, error) {
+	s, err := NewSource(r)
+	if err != nil {
+		return nil, err
+	}
+	return l.parseAll(s)
+}
+
+func (l *Language) parseAll(s *Source) ([]*ParseTree, error) {
+	l.normalize(s)
+	var trees []*ParseTree
+	pos := 0
+	for {
+		s.lang = l
+		if pos += s.skipWhitespace(pos); pos == len(s.buf) {
+			return trees, nil
+		}
+		tree, err, n := l.parseAt(l.root, s, pos, false)
+		if err != nil {
+			return trees, err
+		}
+		if n == 0 {
+			return trees, s.failed(pos, "empty document")
+		}
+		trees = append(trees, tree)
+		pos += n
+	}
+}
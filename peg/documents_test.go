@@ -0,0 +1,49 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAll(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("%whitespace ws\nmsg <- '{' field* '}'\nfield <- ~'[a-z]+'\nws <- ~'[ \\n]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trees, err := lang.ParseAll(strings.NewReader("{a b}\n{}{c}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []struct {
+		pos, end int
+		sexpr    string
+	}{
+		{0, 5, `(msg (msg "{") (field* (field "a") (field "b")) (msg "}"))`},
+		{6, 8, `(msg (msg "{") (field* "") (msg "}"))`},
+		{8, 11, `(msg (msg "{") (field* (field "c")) (msg "}"))`},
+	}
+	if len(trees) != len(exp) {
+		t.Fatalf("got %d documents, exp %d", len(trees), len(exp))
+	}
+	for i, e := range exp {
+		if trees[i].Pos != e.pos || trees[i].End != e.end || trees[i].SExpr() != e.sexpr {
+			t.Errorf("%d: got %s at %d-%d", i, trees[i].SExpr(), trees[i].Pos, trees[i].End)
+		}
+	}
+
+	if trees, err := lang.ParseAll(strings.NewReader("  ")); err != nil || len(trees) != 0 {
+		t.Errorf("blank input: %v, %v", trees, err)
+	}
+	trees, err = lang.ParseAll(strings.NewReader("{a} {b"))
+	if err == nil || len(trees) != 1 {
+		t.Errorf("expected the first document and an error, got %v, %v", trees, err)
+	}
+
+	empty, err := NewParser(strings.NewReader("msg <- 'a'*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := empty.ParseAll(strings.NewReader("aab")); err == nil || !strings.Contains(err.Error(), "empty document at offset 2") {
+		t.Errorf("expected an empty document error, got %v", err)
+	}
+}
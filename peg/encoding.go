@@ -0,0 +1,139 @@
+package peg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding identifies the character encoding of raw input. The encodings
+// are those a byte order mark tells apart, and Latin-1 for older files;
+// they take a few lines each with unicode/utf16, which keeps the package
+// free of dependencies outside the standard library. Input in other
+// encodings can be read through a decoder of golang.org/x/text/encoding,
+// which is an io.Reader, and parsed with Parse.
+type Encoding int
+
+const (
+	UTF8 Encoding = iota
+	UTF16LE
+	UTF16BE
+	Latin1
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case UTF8:
+		return "UTF-8"
+	case UTF16LE:
+		return "UTF-16LE"
+	case UTF16BE:
+		return "UTF-16BE"
+	case Latin1:
+		return "ISO-8859-1"
+	}
+	return "UNKNOWN"
+}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// DetectEncoding inspects buf for a byte order mark. It returns the encoding
+// and the length of the mark, or ok == false if buf does not start with one.
+func DetectEncoding(buf []byte) (enc Encoding, bomLen int, ok bool) {
+	switch {
+	case bytes.HasPrefix(buf, bomUTF8):
+		return UTF8, len(bomUTF8), true
+	case bytes.HasPrefix(buf, bomUTF16LE):
+		return UTF16LE, len(bomUTF16LE), true
+	case bytes.HasPrefix(buf, bomUTF16BE):
+		return UTF16BE, len(bomUTF16BE), true
+	}
+	return UTF8, 0, false
+}
+
+// NewDecodedSource is like NewSource, but transcodes the input to UTF-8
+// before parsing. The encoding is taken from a byte order mark if present,
+// otherwise fallback is assumed. The mark itself is not part of the source.
+func NewDecodedSource(in io.Reader, fallback Encoding) (*Source, error) {
+	buf, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = decodeBOM(buf, fallback); err != nil {
+		return nil, err
+	}
+	return SourceFromBytes(buf), nil
+}
+
+// DecodeInput makes every parse of the language through Parse, ParseString,
+// ParseBytes, ParseSource and ParseRule transcode its input to UTF-8 first,
+// as NewDecodedSource does: in the encoding its byte order mark names, or
+// else in fallback. The mark is dropped, so the offsets of the tree refer
+// to the decoded text, which starts after it.
+func DecodeInput(fallback Encoding) Option {
+	return func(l *Language) {
+		l.decode, l.fallback = true, fallback
+	}
+}
+
+// decodeInput transcodes the buffer of s, once, if l is parsed with
+// DecodeInput.
+func (l *Language) decodeInput(s *Source) error {
+	if !l.decode || s.decoded {
+		return nil
+	}
+	buf, err := decodeBOM(s.buf, l.fallback)
+	if err != nil {
+		return err
+	}
+	s.buf, s.lines, s.decoded = buf, nil, true
+	return nil
+}
+
+// decodeBOM transcodes buf from the encoding its byte order mark names, or
+// else from fallback, and leaves the mark out.
+func decodeBOM(buf []byte, fallback Encoding) ([]byte, error) {
+	enc, bomLen, ok := DetectEncoding(buf)
+	if !ok {
+		enc = fallback
+	}
+	return decode(buf[bomLen:], enc)
+}
+
+func decode(buf []byte, enc Encoding) ([]byte, error) {
+	switch enc {
+	case UTF8:
+		return buf, nil
+	case Latin1:
+		out := make([]byte, 0, len(buf))
+		for _, b := range buf {
+			out = utf8.AppendRune(out, rune(b))
+		}
+		return out, nil
+	case UTF16LE, UTF16BE:
+		if len(buf)%2 != 0 {
+			return nil, errors.New("odd number of bytes in UTF-16 input")
+		}
+		units := make([]uint16, len(buf)/2)
+		for i := range units {
+			if enc == UTF16LE {
+				units[i] = uint16(buf[2*i]) | uint16(buf[2*i+1])<<8
+			} else {
+				units[i] = uint16(buf[2*i])<<8 | uint16(buf[2*i+1])
+			}
+		}
+		out := make([]byte, 0, len(buf))
+		for _, r := range utf16.Decode(units) {
+			out = utf8.AppendRune(out, r)
+		}
+		return out, nil
+	}
+	return nil, errors.New("unknown encoding: " + enc.String())
+}
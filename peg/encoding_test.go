@@ -0,0 +1,72 @@
+package peg
+
+import (
+	"bytes"
+	"testing"
+)
+
+type DecodeTest struct {
+	input    []byte
+	fallback Encoding
+	exp      string
+}
+
+var decodeTestTable = []DecodeTest{
+	DecodeTest{[]byte("plain"), UTF8, "plain"},
+	DecodeTest{[]byte("\xEF\xBB\xBFbom"), Latin1, "bom"},
+	DecodeTest{[]byte("\xFF\xFEh\x00\xE9\x00"), UTF8, "hé"},
+	DecodeTest{[]byte("\xFE\xFF\x00h\x00\xE9"), UTF8, "hé"},
+	DecodeTest{[]byte("h\x00i\x00"), UTF16LE, "hi"},
+	DecodeTest{[]byte("caf\xE9"), Latin1, "café"},
+}
+
+func TestDecodedSource(t *testing.T) {
+	for _, tc := range decodeTestTable {
+		s, err := NewDecodedSource(bytes.NewReader(tc.input), tc.fallback)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(s.buf) != tc.exp {
+			t.Errorf("incorrect decoding of %q: %q exp: %q", tc.input, s.buf, tc.exp)
+		}
+	}
+}
+
+func TestDecodeOddUTF16(t *testing.T) {
+	_, err := NewDecodedSource(bytes.NewReader([]byte("\xFF\xFEh")), UTF8)
+	if err == nil {
+		t.Errorf("expected error for truncated UTF-16 input")
+	}
+}
+
+func TestDecodeInput(t *testing.T) {
+	lang, err := NewLanguage("word <- ~'[a-zé]+'", DecodeInput(Latin1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Input without a mark is taken as Latin-1, which ASCII reads as.
+	for _, tc := range decodeTestTable {
+		if tc.fallback == UTF16LE {
+			continue
+		}
+		tree, err := lang.ParseBytes(tc.input)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		if string(tree.Data) != tc.exp {
+			t.Errorf("incorrect decoding of %q: %q exp: %q", tc.input, tree.Data, tc.exp)
+		}
+	}
+	if _, err := lang.ParseBytes([]byte("\xFF\xFEh")); err == nil {
+		t.Errorf("expected error for truncated UTF-16 input")
+	}
+	plain, err := NewLanguage("word <- ~'[a-zé]+'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree, err := plain.ParseBytes([]byte("caf\xE9")); err == nil && string(tree.Data) == "café" {
+		t.Errorf("input decoded without DecodeInput")
+	}
+}
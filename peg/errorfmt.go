@@ -0,0 +1,101 @@
+package peg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ErrorFormatter renders an error returned by parsing src. Formatters work
+// from the ErrorDetail of the error, so any of them can render any error.
+type ErrorFormatter interface {
+	FormatError(err error, src []byte) string
+}
+
+// ErrorDetail is the structured form of an error, as ErrorFormatters see
+// it. Line and Col are 1-based and columns count bytes. Errors that are not
+// about a place in the input have a Pos of -1 and only a Msg.
+type ErrorDetail struct {
+	Pos      int      `json:"pos"`
+	Line     int      `json:"line,omitempty"`
+	Col      int      `json:"col,omitempty"`
+	Rule     string   `json:"rule,omitempty"`
+	Msg      string   `json:"message"`
+	Expected []string `json:"expected,omitempty"`
+	Found    string   `json:"found,omitempty"`
+}
+
+// Detail returns the structured form of err, an error returned by parsing
+// src.
+func Detail(err error, src []byte) ErrorDetail {
+	d := ErrorDetail{Pos: -1, Msg: err.Error()}
+	switch e := err.(type) {
+	case *ParseError:
+		d.Pos, d.Msg, d.Expected, d.Found, d.Rule = e.Pos, e.message(), e.Expected, e.Found, e.Rule
+	case *BudgetError:
+		d.Pos, d.Rule = e.Pos, e.Rule
+		d.Msg = fmt.Sprintf("parse exceeded %d %s", e.Max, e.Limit)
+	}
+	if d.Pos >= 0 {
+		d.Line, d.Col = SourceFromBytes(src).Position(d.Pos)
+	}
+	return d
+}
+
+// PlainFormatter renders errors as their Error method does.
+type PlainFormatter struct{}
+
+func (PlainFormatter) FormatError(err error, src []byte) string {
+	return err.Error()
+}
+
+// CaretFormatter renders errors as line:col: message, followed by the line
+// of the input with a caret under the column. With Color set, it uses ANSI
+// escapes to highlight the position, the message and the caret.
+type CaretFormatter struct {
+	Color bool
+}
+
+func (f CaretFormatter) FormatError(err error, src []byte) string {
+	d := Detail(err, src)
+	bold, red, green, reset := "", "", "", ""
+	if f.Color {
+		bold, red, green, reset = "\x1b[1m", "\x1b[31m", "\x1b[32m", "\x1b[0m"
+	}
+	msg := d.Msg
+	if d.Rule != "" {
+		msg += " in rule " + d.Rule
+	}
+	if d.Pos < 0 {
+		return red + msg + reset
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s%d:%d:%s %s%s%s\n", bold, d.Line, d.Col, reset, red, msg, reset)
+	line := string(SourceFromBytes(src).Line(d.Line))
+	col := d.Col - 1
+	if col > len(line) {
+		col = len(line)
+	}
+	// Keep tabs, so that the caret lines up however wide they are shown.
+	indent := strings.Map(func(c rune) rune {
+		if c == '\t' {
+			return c
+		}
+		return ' '
+	}, line[:col])
+	fmt.Fprintf(&buf, "%s\n%s%s^%s", line, indent, green, reset)
+	return buf.String()
+}
+
+// JSONFormatter renders the ErrorDetail of errors as a JSON object on a
+// single line.
+type JSONFormatter struct{}
+
+func (JSONFormatter) FormatError(err error, src []byte) string {
+	data, merr := json.Marshal(Detail(err, src))
+	if merr != nil {
+		return err.Error()
+	}
+	return string(data)
+}
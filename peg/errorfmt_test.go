@@ -0,0 +1,61 @@
+package peg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestErrorFormatters(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("prgm <- line line\nline <- ~`\\s*[a-z]+` ';' '\\n'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := []byte("ab;\n\tcd\n")
+	_, perr := lang.ParseBytes(src)
+	if perr == nil {
+		t.Fatal("expected a parse error")
+	}
+
+	tests := []struct {
+		f   ErrorFormatter
+		exp string
+	}{
+		{PlainFormatter{}, `expected ';' at offset 7: "\n"`},
+		{CaretFormatter{}, "2:4: expected ';'\n\tcd\n\t  ^"},
+		{CaretFormatter{Color: true}, "\x1b[1m2:4:\x1b[0m \x1b[31mexpected ';'\x1b[0m\n\tcd\n\t  \x1b[32m^\x1b[0m"},
+		{JSONFormatter{}, `{"pos":7,"line":2,"col":4,"message":"expected ';'","expected":["';'"],"found":"\n"}`},
+	}
+	for _, test := range tests {
+		if got := test.f.FormatError(perr, src); got != test.exp {
+			t.Errorf("%T: got %q, exp %q", test.f, got, test.exp)
+		}
+	}
+
+	budget := &BudgetError{Limit: "rule calls", Max: 3, Rule: "line", Pos: 4, Line: 2, Col: 1}
+	if got := (CaretFormatter{}).FormatError(budget, src); got != "2:1: parse exceeded 3 rule calls in rule line\n\tcd\n^" {
+		t.Errorf("budget error: %q", got)
+	}
+	if got := (JSONFormatter{}).FormatError(errors.New("boom"), src); got != `{"pos":-1,"message":"boom"}` {
+		t.Errorf("plain error: %q", got)
+	}
+	if got := (CaretFormatter{}).FormatError(errors.New("boom"), src); got != "boom" {
+		t.Errorf("plain error: %q", got)
+	}
+}
+
+func TestFailureDetail(t *testing.T) {
+	lang, err := NewParser(strings.NewReader(`prgm <- 'x' string('"', '\\')`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := []byte(`x"abc`)
+	_, err = lang.ParseBytes(src)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	d := Detail(err, src)
+	if d.Pos != 1 || d.Msg != "unterminated string" || d.Expected != nil || err.Error() != "unterminated string at offset 1" {
+		t.Errorf("unexpected detail %+v of %s", d, err)
+	}
+}
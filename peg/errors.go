@@ -0,0 +1,154 @@
+package peg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GrammarError describes a problem found while compiling a grammar, or a
+// failing %test of one. Line and Col locate it in the grammar text; both are
+// 1-based, and columns count bytes. Problems of rules built in Go have no
+// position, and a Line of 0.
+type GrammarError struct {
+	File string // the file of the grammar, if loaded by NewLanguageFS.
+	Rule string // the rule being defined, or empty outside of rules.
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *GrammarError) Error() string {
+	if e.File != "" {
+		err := *e
+		err.File = ""
+		if e.Line == 0 {
+			return fmt.Sprintf("%s: %s", e.File, err.Error())
+		}
+		return fmt.Sprintf("%s:%s", e.File, err.Error())
+	}
+	if e.Line == 0 {
+		if e.Rule == "" {
+			return e.Msg
+		}
+		return fmt.Sprintf("%s: %s", e.Rule, e.Msg)
+	}
+	if e.Rule == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Col, e.Rule, e.Msg)
+}
+
+// GrammarErrors lists every problem found in a grammar, in the order they
+// appear in the text.
+type GrammarErrors []*GrammarError
+
+func (e GrammarErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ParseError reports what the input was expected to contain at the offset
+// where it failed to match. Failures that are no missing expectation, such
+// as an unterminated string, have a Msg instead.
+type ParseError struct {
+	Pos      int
+	Expected []string // the alternatives, in grammar notation.
+	Msg      string   // set when Expected is empty.
+	Found    string   // an excerpt of the input at Pos.
+	Rule     string   // the lexeme whose user code panicked, if one did.
+}
+
+// guard stops the parse with a ParseError at pos if the user code what
+// panics, so that a bug in it fails one parse rather than the program.
+// User code is that of registered matchers and predicates, Matchers,
+// decoders, rules defined in Go, WrapLexeme middleware and Listeners. It
+// must be deferred.
+func (s *Source) guard(what string, pos int) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if _, ok := r.(parseAbort); ok {
+		panic(r) // user code that parses went over the budget.
+	}
+	err := s.failed(pos, fmt.Sprintf("%s panicked: %v", what, r)).(*ParseError)
+	err.Rule = what
+	panic(parseAbort{err})
+}
+
+func (e *ParseError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("%s at offset %d", e.Msg, e.Pos)
+	}
+	return fmt.Sprintf("%s at offset %d: %q", e.message(), e.Pos, e.Found)
+}
+
+// message describes the error without its position.
+func (e *ParseError) message() string {
+	if len(e.Expected) == 0 {
+		return e.Msg
+	}
+	expected := strings.Join(e.Expected, " or ")
+	if n := len(e.Expected); n > 2 {
+		expected = strings.Join(e.Expected[:n-1], ", ") + " or " + e.Expected[n-1]
+	}
+	return "expected " + expected
+}
+
+// expected returns a ParseError for a single expectation at pos.
+func (s *Source) expected(pos int, what string) error {
+	err := s.failed(pos, "").(*ParseError)
+	err.Expected = []string{what}
+	return err
+}
+
+// failed returns a ParseError with the message msg at pos.
+func (s *Source) failed(pos int, msg string) error {
+	if pos > s.farthest {
+		s.farthest = pos
+	}
+	end := pos + 10
+	if end > len(s.buf) {
+		end = len(s.buf)
+	}
+	return &ParseError{Pos: pos, Msg: msg, Found: string(s.buf[pos:end])}
+}
+
+// mergeErrors combines the errors of failed alternatives. The expectations
+// of the parse errors at the furthest offset are merged; other errors are
+// only returned if there are no parse errors.
+func mergeErrors(errs []error) error {
+	var merged *ParseError
+	for _, err := range errs {
+		e, ok := err.(*ParseError)
+		if !ok {
+			continue
+		}
+		switch {
+		case merged == nil || e.Pos > merged.Pos:
+			merged = &ParseError{Pos: e.Pos, Expected: append([]string(nil), e.Expected...), Msg: e.Msg, Found: e.Found}
+		case e.Pos == merged.Pos:
+			for _, exp := range e.Expected {
+				if !contains(merged.Expected, exp) {
+					merged.Expected = append(merged.Expected, exp)
+				}
+			}
+		}
+	}
+	if merged == nil {
+		return errs[len(errs)-1]
+	}
+	return merged
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package peg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// WithRule defines the rule name as lex, so that a grammar can refer to
+// rules written in Go, such as a hand tuned number lexer, alongside the
+// ones it defines:
+//
+//	lang, err := peg.NewParser(grammar, peg.WithRule("number", number))
+//
+// If the grammar defines the rule too, lex replaces its definition. As with
+// AddRule, lex may refer to the rules of the grammar with NewRuleLexer and
+// must not be used for another language.
+func WithRule(name string, lex *Lexeme) Option {
+	return func(l *Language) {
+		l.bound = append(l.bound, rule{name: name, lex: lex})
+	}
+}
+
+// AddRule returns a new language that also has the rule name, matched by
+// lex. The receiver is left unchanged. The grammar may already refer to
+// the rule, and lex may refer to the rules of the grammar with
+// NewRuleLexer. Those references are resolved against the new language,
+// so lex must not be added to another language, and rules added earlier
+// keep referring to the language they were added to.
+func (l *Language) AddRule(name string, lex *Lexeme) (*Language, error) {
+	if l.hasRule(name) {
+		return nil, errors.New(fmt.Sprintf("rule %s is already defined", name))
+	}
+	return l.withRule(name, lex)
+}
+
+// ReplaceRule is like AddRule, but redefines an existing rule. The other
+// rules of the new language refer to the new definition.
+func (l *Language) ReplaceRule(name string, lex *Lexeme) (*Language, error) {
+	if !l.hasRule(name) {
+		return nil, errors.New(fmt.Sprintf("undefined rule %s", name))
+	}
+	return l.withRule(name, lex)
+}
+
+func (l *Language) hasRule(name string) bool {
+	for _, r := range l.rules {
+		if r.name == name && r.params == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// guarded wraps lex, the rule name defined in Go, so that its panics fail
+// the parse with a ParseError, as those of registered matchers do.
+func guarded(name string, lex *Lexeme) *Lexeme {
+	return &Lexeme{
+		Name:         lex.Name,
+		Dependencies: []*Lexeme{lex},
+		kind:         kindWrap,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			defer s.guard(name, pos)
+			return lex.Lexer(s, pos)
+		},
+	}
+}
+
+// withRule compiles the grammar of l again with the rule name defined as
+// lex, keeping the options, matchers and predicates of l.
+func (l *Language) withRule(name string, lex *Lexeme) (*Language, error) {
+	if l.rules == nil {
+		return nil, errors.New("language was not compiled from a grammar")
+	}
+	added := append(append([]rule(nil), l.added...), rule{name: name, lex: lex})
+	return l.recompile(added, l.opts)
+}
+
+// recompile compiles the grammar of l with the rules added and the options
+// opts, keeping the matchers and predicates of l.
+func (l *Language) recompile(added []rule, opts []Option) (*Language, error) {
+	next, err := compile(context.Background(), l.source, added, opts)
+	if err != nil {
+		return nil, err
+	}
+	for name, fn := range l.matchers {
+		next.Register(name, fn)
+	}
+	for name, fn := range l.predicates {
+		next.RegisterPredicate(name, fn)
+	}
+	return next, nil
+}
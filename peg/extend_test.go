@@ -0,0 +1,109 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddRule(t *testing.T) {
+	host, err := NewParser(strings.NewReader("prgm <- stmt+\nstmt <- assign / plugin\nassign <- ~'[a-z]+' '='^ expr ';'^\nplugin <- '!'\nexpr <- ~'[0-9]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// print <- 'print ' expr ';'
+	print := NewConcatLexer("print", []*Lexeme{
+		NewDiscardLexer(NewLiteralLexer("print", "print ")),
+		NewRuleLexer("expr"),
+		NewDiscardLexer(NewLiteralLexer("print", ";")),
+	})
+	ext, err := host.AddRule("print", print)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext, err = ext.ReplaceRule("plugin", NewRuleLexer("print"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := ext.ParseString("a=1;print 2;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "stmt+",
+		Children: []*ParseTree{
+			&ParseTree{Type: "assign", Children: []*ParseTree{
+				&ParseTree{Type: "assign", Data: []byte("a")},
+				&ParseTree{Type: "expr", Data: []byte("1")},
+			}},
+			&ParseTree{Type: "expr", Data: []byte("2")},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		t.Error(err)
+	}
+	if _, err := host.ParseString("print 2;"); err == nil {
+		t.Errorf("the host language changed")
+	}
+	if rules := strings.Join(ext.Rules(), " "); rules != "prgm stmt assign plugin expr print" {
+		t.Errorf("unexpected rules %s", rules)
+	}
+
+	if _, err := host.AddRule("expr", print); err == nil {
+		t.Errorf("expected an error adding an existing rule")
+	}
+	if _, err := host.ReplaceRule("missing", print); err == nil {
+		t.Errorf("expected an error replacing an undefined rule")
+	}
+	if _, err := (&Language{root: print}).AddRule("x", print); err == nil {
+		t.Errorf("expected an error for a language without a grammar")
+	}
+}
+
+func TestWithRule(t *testing.T) {
+	number := &Lexeme{Name: "number", Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+		n := 0
+		for pos+n < len(s.Bytes()) && '0' <= s.Bytes()[pos+n] && s.Bytes()[pos+n] <= '9' {
+			n++
+		}
+		if n == 0 {
+			return nil, s.expected(pos, "number"), 0
+		}
+		return &ParseTree{Type: "number", Data: s.Bytes()[pos : pos+n], Pos: pos, End: pos + n}, nil, n
+	}}
+	name := NewConcatLexer("name", []*Lexeme{NewDiscardLexer(NewLiteralLexer("name", "$")), NewRuleLexer("ident")})
+	grammar := "sum <- term more*\nmore <- '+'^ term\nterm <- number / name\nident <- ~'[a-z]+'"
+	lang, err := NewParser(strings.NewReader(grammar), WithRule("number", number), WithRule("name", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("1+$x+23")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "sum",
+		Children: []*ParseTree{
+			&ParseTree{Type: "number", Data: []byte("1")},
+			&ParseTree{Type: "more*", Children: []*ParseTree{
+				&ParseTree{Type: "ident", Data: []byte("x")},
+				&ParseTree{Type: "number", Data: []byte("23")},
+			}},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		t.Error(err)
+	}
+
+	// Bound rules survive extending the language.
+	ext, err := lang.AddRule("extra", NewLiteralLexer("extra", "!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ext.ParseString("1+$x"); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewParser(strings.NewReader(grammar)); err == nil {
+		t.Errorf("expected undefined rules without WithRule")
+	}
+}
@@ -0,0 +1,306 @@
+package peg
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Field is a reference in the body of a rule to another rule, whose nodes
+// become children of the rule's nodes.
+type Field struct {
+	Name string // the label of the reference, or the rule it refers to.
+	Rule string // the rule referred to.
+	// Closure is the type of the node holding the matches of the closure
+	// the reference is part of, if any.
+	Closure string
+}
+
+// Fields returns the references of the body of rule, in order, for tools
+// that generate types for the nodes of a grammar. Discarded references
+// produce no nodes and are left out, as are references to rules that are
+// not listed by Rules.
+func (l *Language) Fields(rule string) []Field {
+	r, ok := l.rule(rule)
+	if !ok {
+		return nil
+	}
+	var fields []Field
+	l.fields(r.lex.Dependencies[0], "", "", &fields)
+	return fields
+}
+
+func (l *Language) fields(lex *Lexeme, label, closure string, out *[]Field) {
+	switch lex.kind {
+	case kindDefinition:
+		if _, ok := l.rule(lex.text); ok {
+			if label == "" {
+				label = lex.text
+			}
+			*out = append(*out, Field{Name: label, Rule: lex.text, Closure: closure})
+		}
+		return
+	case kindCapture:
+		label = lex.text
+	case kindPlus, kindStar, kindRepeat, kindLazy:
+		closure = closureType(lex)
+	case kindConcat, kindChoice, kindAlternate:
+		label = ""
+	case kindMemo, kindScope, kindOption, kindWrap:
+	default:
+		return
+	}
+	for _, dep := range lex.Dependencies {
+		l.fields(dep, label, closure, out)
+	}
+}
+
+// References returns the rules listed by Rules that the body of rule
+// refers to, discarded or not, in the order they first appear. References
+// through template instances are included.
+func (l *Language) References(rule string) []string {
+	r, ok := l.rule(rule)
+	if !ok {
+		return nil
+	}
+	var refs []string
+	seen := make(map[*Lexeme]bool)
+	var walk func(lex *Lexeme)
+	walk = func(lex *Lexeme) {
+		if seen[lex] {
+			return
+		}
+		seen[lex] = true
+		if lex.kind == kindDefinition {
+			if _, ok := l.rule(lex.text); ok {
+				if !contains(refs, lex.text) {
+					refs = append(refs, lex.text)
+				}
+				return
+			}
+		}
+		for _, dep := range lex.Dependencies {
+			walk(dep)
+		}
+	}
+	for _, dep := range r.lex.Dependencies {
+		walk(dep)
+	}
+	return refs
+}
+
+// FirstSet returns what the input can start with where rule matches: the
+// literals, regexps and other matchers, as Grammar writes them, that the
+// rule may try first, in the order they appear. Literals and regexps that
+// can match the empty string, and lexemes that may match nothing, such as
+// options and discarded ones, let what follows them start the input too.
+func (l *Language) FirstSet(rule string) []string {
+	r, ok := l.rule(rule)
+	if !ok {
+		return nil
+	}
+	_, names := l.ruleNames()
+	f := &firstSets{names: names, sets: make(map[*Lexeme]firstSet), active: make(map[*Lexeme]bool)}
+	return f.first(r.lex).terms
+}
+
+// firstSet is what matches of a lexeme start with, and whether they can be
+// empty. lexes holds the lexeme of each term.
+type firstSet struct {
+	terms    []string
+	lexes    []*Lexeme
+	nullable bool
+}
+
+func (s *firstSet) add(sub firstSet) {
+	for i, term := range sub.terms {
+		if !contains(s.terms, term) {
+			s.terms = append(s.terms, term)
+			s.lexes = append(s.lexes, sub.lexes[i])
+		}
+	}
+}
+
+type firstSets struct {
+	names  map[*Lexeme]string
+	sets   map[*Lexeme]firstSet
+	active map[*Lexeme]bool // left recursion contributes nothing new.
+}
+
+func (f *firstSets) first(lex *Lexeme) firstSet {
+	if set, ok := f.sets[lex]; ok {
+		return set
+	}
+	if f.active[lex] {
+		return firstSet{}
+	}
+	f.active[lex] = true
+	defer delete(f.active, lex)
+	var set firstSet
+	switch lex.kind {
+	case kindLiteral:
+		set = firstSet{[]string{expression(lex, f.names, true)}, []*Lexeme{lex}, lex.text == ""}
+	case kindRegexp:
+		empty := regexp.MustCompile(`^(?:` + lex.text + `)$`).MatchString("")
+		set = firstSet{[]string{expression(lex, f.names, true)}, []*Lexeme{lex}, empty}
+	case kindConcat:
+		set.nullable = true
+		for _, dep := range lex.Dependencies {
+			sub := f.first(dep)
+			set.add(sub)
+			if !sub.nullable {
+				set.nullable = false
+				break
+			}
+		}
+	case kindAlternate, kindChoice:
+		for _, dep := range lex.Dependencies {
+			sub := f.first(dep)
+			set.add(sub)
+			set.nullable = set.nullable || sub.nullable
+		}
+	case kindStar, kindOption, kindDiscard:
+		set.add(f.first(lex.Dependencies[0]))
+		set.nullable = true
+	case kindPlus, kindRepeat, kindLazy:
+		set = f.first(lex.Dependencies[0])
+		if min, _ := closureBounds(lex); min == 0 {
+			set.nullable = true
+		}
+	case kindDefinition, kindMemo, kindScope, kindWrap, kindCapture:
+		set = f.first(lex.Dependencies[0])
+	case kindPredicate:
+		set.nullable = true
+	case kindCall:
+		if strings.HasPrefix(lex.text, "warn(") {
+			set.nullable = true
+			break
+		}
+		set.terms, set.lexes = []string{lex.text}, []*Lexeme{lex}
+	default:
+		set.terms, set.lexes = []string{expression(lex, f.names, true)}, []*Lexeme{lex}
+	}
+	f.sets[lex] = set
+	return set
+}
+
+// NodeTypes returns the sorted types of the nodes that matches of rule
+// produce. A rule defined as a choice of other rules produces their nodes
+// rather than its own, one defined as a closure produces the node of the
+// closure, and a sequence may be replaced by its only child, as the node
+// of an inline rule is by all of its children. Nodes built
+// by external matchers are not known.
+func (l *Language) NodeTypes(rule string) []string {
+	r, ok := l.rule(rule)
+	if !ok {
+		return nil
+	}
+	types := make(map[string]bool)
+	l.nodeTypes(r.lex, types, map[*Lexeme]bool{})
+	var sorted []string
+	for typ := range types {
+		sorted = append(sorted, typ)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func (l *Language) nodeTypes(lex *Lexeme, types map[string]bool, seen map[*Lexeme]bool) {
+	if seen[lex] {
+		return
+	}
+	seen[lex] = true
+	switch lex.kind {
+	case kindConcat:
+		if l.inline[lex.Name] {
+			// The children replace the node of an inline rule.
+			for _, dep := range lex.Dependencies {
+				if produces(dep) != producesNever {
+					l.nodeTypes(dep, types, seen)
+				}
+			}
+			break
+		}
+		if !l.collapses(lex.Name) {
+			types[lex.Name] = true
+			break
+		}
+		var always, maybe []*Lexeme
+		for _, dep := range lex.Dependencies {
+			switch produces(dep) {
+			case producesAlways:
+				always = append(always, dep)
+			case producesMaybe:
+				maybe = append(maybe, dep)
+			}
+		}
+		// A lone child replaces the sequence.
+		if len(always) != 1 || len(maybe) > 0 {
+			types[lex.Name] = true
+		}
+		if len(always) == 1 {
+			l.nodeTypes(always[0], types, seen)
+		} else if len(always) == 0 {
+			for _, dep := range maybe {
+				l.nodeTypes(dep, types, seen)
+			}
+		}
+	case kindUnknown, kindLiteral, kindRegexp, kindCall:
+		if !l.inline[lex.Name] {
+			types[lex.Name] = true
+		}
+	case kindPlus, kindStar, kindRepeat, kindLazy:
+		if l.inline[operandName(lex.Dependencies[0])] {
+			l.nodeTypes(lex.Dependencies[0], types, seen)
+			break
+		}
+		types[closureType(lex)] = true
+	case kindDefinition, kindMemo, kindScope, kindWrap, kindCapture, kindOption, kindChoice, kindAlternate:
+		for _, dep := range lex.Dependencies {
+			l.nodeTypes(dep, types, seen)
+		}
+	}
+}
+
+// closureType returns the type of the nodes of the closure lex. Closures
+// are named after their operand when they match, which is resolved by now.
+func closureType(lex *Lexeme) string {
+	switch lex.kind {
+	case kindPlus:
+		return operandName(lex.Dependencies[0]) + "+"
+	case kindStar:
+		return operandName(lex.Dependencies[0]) + "*"
+	case kindLazy:
+		return operandName(lex.Dependencies[0]) + lex.text[:1]
+	}
+	return operandName(lex.Dependencies[0]) + lex.text
+}
+
+const (
+	producesNever = iota
+	producesMaybe
+	producesAlways
+)
+
+// produces tells whether matches of lex produce a node.
+func produces(lex *Lexeme) int {
+	switch lex.kind {
+	case kindDiscard, kindPredicate:
+		return producesNever
+	case kindOption:
+		return producesMaybe
+	case kindMemo, kindScope, kindWrap, kindCapture:
+		return produces(lex.Dependencies[0])
+	}
+	return producesAlways
+}
+
+// rule returns the rule name, if it is listed by Rules.
+func (l *Language) rule(name string) (rule, bool) {
+	for _, r := range l.rules {
+		if r.name == name && r.params == nil && !r.instance {
+			return r, true
+		}
+	}
+	return rule{}, false
+}
@@ -0,0 +1,126 @@
+package peg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFields(t *testing.T) {
+	grammar := "%memo num\n" +
+		"sum <- left:value '+'^ right:value rest:term* num?\n" +
+		"value <- array / num\n" +
+		"array <- '[' value? ']'\n" +
+		"term <- '+'^ num\n" +
+		"list <- term*\n" +
+		"pair <- num{2}\n" +
+		"alias <- num\n" +
+		"neg <- '-'^ num?\n" +
+		"num <- ~'[0-9]+'"
+	lang, err := NewLanguage(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		rule   string
+		fields []Field
+		types  []string
+	}{
+		{"sum", []Field{
+			{"left", "value", ""},
+			{"right", "value", ""},
+			{"rest", "term", "term*"},
+			{"num", "num", ""},
+		}, []string{"sum"}},
+		{"value", []Field{{"array", "array", ""}, {"num", "num", ""}}, []string{"array", "num"}},
+		{"array", []Field{{"value", "value", ""}}, []string{"array"}},
+		{"term", []Field{{"num", "num", ""}}, []string{"num"}},
+		{"neg", []Field{{"num", "num", ""}}, []string{"neg", "num"}},
+		{"list", []Field{{"term", "term", "term*"}}, []string{"term*"}},
+		{"pair", []Field{{"num", "num", "num{2}"}}, []string{"num{2}"}},
+		{"alias", []Field{{"num", "num", ""}}, []string{"num"}},
+		{"num", nil, []string{"num"}},
+		{"missing", nil, nil},
+	} {
+		if fields := lang.Fields(tt.rule); !reflect.DeepEqual(fields, tt.fields) {
+			t.Errorf("%s: got fields %v, expected %v", tt.rule, fields, tt.fields)
+		}
+		if types := lang.NodeTypes(tt.rule); !reflect.DeepEqual(types, tt.types) {
+			t.Errorf("%s: got node types %v, expected %v", tt.rule, types, tt.types)
+		}
+	}
+
+	// The types are those of the trees, in which a labeled closure is
+	// named after the label.
+	tree, err := lang.ParseString("[1]+2+3+4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, child := range tree.Children {
+		got = append(got, child.Type)
+	}
+	if exp := []string{"array", "num", "term*"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("got children %v, expected %v", got, exp)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	grammar := "%memo num\n" +
+		"expr <- term more*\n" +
+		"more <- op^ term\n" +
+		"term <- '(' expr ')' / num / num\n" +
+		"op <- '+'\n" +
+		"alias <- num\n" +
+		"list(x) <- x*\n" +
+		"nums <- list(num)\n" +
+		"num <- ~'[0-9]+'"
+	lang, err := NewLanguage(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rule, exp := range map[string][]string{
+		"expr":    {"term", "more"},
+		"more":    {"op", "term"},
+		"term":    {"expr", "num"},
+		"op":      nil,
+		"alias":   {"num"},
+		"nums":    {"num"},
+		"missing": nil,
+	} {
+		if refs := lang.References(rule); !reflect.DeepEqual(refs, exp) {
+			t.Errorf("References(%s) = %v, exp %v", rule, refs, exp)
+		}
+	}
+}
+
+func TestFirstSet(t *testing.T) {
+	grammar := "expr <- sign? term more*\n" +
+		"more <- op term\n" +
+		"sign <- '-' / '+'\n" +
+		"term <- group / num / name\n" +
+		"group <- '(' expr ')'\n" +
+		"op <- '+'^ / '*'\n" +
+		"list <- ws^ item*\n" +
+		"item <- &{upper} name\n" +
+		"ws <- ~'[ ]*'\n" +
+		"left <- field / name\n" +
+		"field <- left '.' name\n" +
+		"num <- ~'[0-9]+'\n" +
+		"name <- ~'[a-z]+'"
+	lang, err := NewLanguage(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rule, exp := range map[string][]string{
+		"expr":    {"'-'", "'+'", "'('", "~`[0-9]+`", "~`[a-z]+`"},
+		"op":      {"'+'", "'*'"},
+		"list":    {"~`[ ]*`", "~`[a-z]+`"},
+		"item":    {"~`[a-z]+`"},
+		"left":    {"~`[a-z]+`"},
+		"missing": nil,
+	} {
+		if first := lang.FirstSet(rule); !reflect.DeepEqual(first, exp) {
+			t.Errorf("FirstSet(%s) = %v, exp %v", rule, first, exp)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package peg
+
+import "strings"
+
+// FlattenClosures sets whether closures that repeat themselves through
+// their children produce one node for the whole chain. A list written as
+//
+//	list <- item more*
+//	more <- ','^ item more*
+//
+// otherwise nests a more* node in every more, so that the depth of the
+// tree grows with the length of the list. Flattened, the more* node has a
+// more child for each item. A closure node that is the child of one of its
+// own type is replaced by its children as well.
+func FlattenClosures(flatten bool) Option {
+	return func(l *Language) {
+		l.flatten = flatten
+	}
+}
+
+// flattenClosures flattens the closures of tree once it is parsed, which
+// visits every node once; flattening each closure as it is built would copy
+// the children of the closures inside it again at every level. The nodes of
+// the tree are copied rather than changed, since memoized matches may share
+// them.
+func (l *Language) flattenClosures(tree *ParseTree) *ParseTree {
+	if !l.flatten || tree == nil {
+		return tree
+	}
+	return l.flattenNode(tree)
+}
+
+func (l *Language) flattenNode(node *ParseTree) *ParseTree {
+	if len(node.Children) == 0 {
+		return node
+	}
+	copied := *node
+	if strings.IndexAny(node.Type, "*+{") > 0 {
+		var trailing []byte
+		copied.Children, trailing = l.flattenChildren(node.Type, node.Children)
+		if len(trailing) > 0 {
+			copied.Trailing = append(trailing, copied.Trailing...)
+		}
+		return &copied
+	}
+	copied.Children = make([]*ParseTree, len(node.Children))
+	for i, child := range node.Children {
+		copied.Children[i] = l.flattenNode(child)
+	}
+	return &copied
+}
+
+// flattenChildren returns the children kids of a closure node of type typ,
+// with the nodes of typ among them, and the nodes of typ the others end in,
+// replaced by their own children, all appended to one slice. In lossless
+// trees the trivia of a replaced node moves to the nodes around it, or is
+// returned if there are none.
+func (l *Language) flattenChildren(typ string, kids []*ParseTree) ([]*ParseTree, []byte) {
+	var out []*ParseTree
+	var pending []byte // trivia waiting for the next node.
+	add := func(kid *ParseTree) {
+		if len(pending) > 0 {
+			copied := *kid
+			copied.Leading = append(pending, kid.Leading...)
+			kid, pending = &copied, nil
+		}
+		out = append(out, kid)
+	}
+	trail := func(trivia []byte) {
+		if !l.lossless || len(pending)+len(trivia) == 0 {
+			return
+		}
+		if len(out) == 0 {
+			pending = append(pending, trivia...)
+			return
+		}
+		last := *out[len(out)-1]
+		last.Trailing = append(append(append([]byte(nil), last.Trailing...), pending...), trivia...)
+		out[len(out)-1], pending = &last, nil
+	}
+	var walk func(kids []*ParseTree)
+	walk = func(kids []*ParseTree) {
+		for _, kid := range kids {
+			if kid.Type == typ {
+				if l.lossless {
+					pending = append(pending, kid.Leading...)
+				}
+				walk(kid.Children)
+				trail(kid.Trailing)
+				continue
+			}
+			n := len(kid.Children)
+			if n == 0 || kid.Children[n-1].Type != typ {
+				add(l.flattenNode(kid))
+				continue
+			}
+			inner := kid.Children[n-1]
+			copied := *kid
+			copied.Children = make([]*ParseTree, n-1)
+			for i, child := range kid.Children[:n-1] {
+				copied.Children[i] = l.flattenNode(child)
+			}
+			copied.End, copied.Trailing = inner.Pos, nil
+			if n > 1 {
+				copied.End = kid.Children[n-2].End
+			}
+			add(&copied)
+			if l.lossless {
+				pending = append(pending, inner.Leading...)
+			}
+			walk(inner.Children)
+			trail(inner.Trailing)
+			trail(kid.Trailing)
+		}
+	}
+	walk(kids)
+	trail(nil)
+	return out, pending
+}
@@ -0,0 +1,110 @@
+package peg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFlattenClosures(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		grammar string
+		input   string
+		exp     string
+	}{
+		{
+			name:    "chain",
+			grammar: "list <- item more*\nmore <- ','^ item more*\nitem <- ~'[a-z]+'",
+			input:   "a,b,c,d",
+			exp:     `(list (item "a") (more* (more (item "b")) (more (item "c")) (more (item "d"))))`,
+		},
+		{
+			name:    "other closure",
+			grammar: "list <- item more+\nmore <- ','^ pair more*\npair <- item item\nitem <- ~'[a-z]'",
+			input:   "a,bc,de",
+			exp:     `(list (item "a") (more+ (more (pair (item "b") (item "c")) (more* (more (pair (item "d") (item "e")))))))`,
+		},
+		{
+			name:    "flat",
+			grammar: "list <- item more*\nmore <- ',' item\nitem <- ~'[a-z]+'",
+			input:   "a,b",
+			exp:     `(list (item "a") (more* (more (more ",") (item "b"))))`,
+		},
+	} {
+		lang, err := NewLanguage(tc.grammar, FlattenClosures(true))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		tree, err := lang.ParseString(tc.input)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := tree.SExpr(); got != tc.exp {
+			t.Errorf("%s: got %s, exp %s", tc.name, got, tc.exp)
+		}
+	}
+}
+
+func TestFlattenClosuresLossless(t *testing.T) {
+	grammar := "%whitespace ws\nlist <- item more*\nmore <- ','^ item more*\nitem <- ~'[a-z]+'\nws <- ~'[ ]+'"
+	input := "a , b ,c"
+	lang, err := NewLanguage(grammar, FlattenClosures(true), Lossless(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := string(tree.Text()); text != input {
+		t.Errorf("Text() = %q, exp %q", text, input)
+	}
+	if exp := `(list (item "a") (more* (more (item "b")) (more (item "c"))))`; tree.SExpr() != exp {
+		t.Errorf("got %s, exp %s", tree.SExpr(), exp)
+	}
+}
+
+func TestFlattenClosuresLong(t *testing.T) {
+	grammar := "list <- item more*\nmore <- ','^ item more*\nitem <- ~'[a-z]+'"
+	lang, err := NewLanguage(grammar, FlattenClosures(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 8000
+	input := "a" + strings.Repeat(",b", n-1)
+	tree, err := lang.ParseString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	more := tree.Children[1]
+	if more.Type != "more*" || len(more.Children) != n-1 {
+		t.Fatalf("got %s with %d children, exp more* with %d", more.Type, len(more.Children), n-1)
+	}
+	for i, child := range more.Children {
+		if child.Type != "more" || len(child.Children) != 1 || child.Children[0].Type != "item" {
+			t.Fatalf("child %d: got %s", i, child.SExpr())
+		}
+	}
+}
+
+func BenchmarkFlattenClosures(b *testing.B) {
+	grammar := "list <- item more*\nmore <- ','^ item more*\nitem <- ~'[a-z]+'"
+	input := "a" + strings.Repeat(",b", 7999)
+	for _, flatten := range []bool{false, true} {
+		lang, err := NewLanguage(grammar, FlattenClosures(flatten))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("flatten=%v", flatten), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if _, err := lang.ParseString(input); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
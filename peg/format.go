@@ -0,0 +1,79 @@
+package peg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// jsonTree is the JSON form of a ParseTree. Leaves have text, inner nodes
+// have children.
+type jsonTree struct {
+	Type     string       `json:"type"`
+	Text     *string      `json:"text,omitempty"`
+	Value    interface{}  `json:"value,omitempty"`
+	Pos      int          `json:"pos"`
+	End      int          `json:"end"`
+	Children []*ParseTree `json:"children,omitempty"`
+}
+
+// MarshalJSON encodes the tree as an object with the fields type, pos, end
+// and either the text of a leaf or the children of a node. Decoded values
+// of typed leaves are included as value.
+func (p *ParseTree) MarshalJSON() ([]byte, error) {
+	t := jsonTree{Type: p.Type, Value: p.Value, Pos: p.Pos, End: p.End, Children: p.Children}
+	if len(p.Children) == 0 {
+		text := string(p.Data)
+		t.Text = &text
+	}
+	return json.Marshal(t)
+}
+
+// SExpr writes the tree as an S-expression, with leaves as (type "text")
+// and nodes as (type child...).
+func (p *ParseTree) SExpr() string {
+	var buf bytes.Buffer
+	p.writeSExpr(&buf)
+	return buf.String()
+}
+
+func (p *ParseTree) writeSExpr(buf *bytes.Buffer) {
+	buf.WriteByte('(')
+	buf.WriteString(p.Type)
+	if len(p.Children) == 0 {
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.Quote(string(p.Data)))
+	}
+	for _, child := range p.Children {
+		buf.WriteByte(' ')
+		child.writeSExpr(buf)
+	}
+	buf.WriteByte(')')
+}
+
+// WriteDot writes the tree as a graph in the DOT language of Graphviz.
+func (p *ParseTree) WriteDot(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph tree {\n\tnode [shape=box];\n")
+	n := 0
+	var walk func(t *ParseTree) int
+	walk = func(t *ParseTree) int {
+		id := n
+		n++
+		label := t.Type
+		if len(t.Children) == 0 {
+			label += "\n" + strconv.Quote(string(t.Data))
+		}
+		fmt.Fprintf(&buf, "\tn%d [label=%s];\n", id, strconv.Quote(label))
+		for _, child := range t.Children {
+			fmt.Fprintf(&buf, "\tn%d -> n%d;\n", id, walk(child))
+		}
+		return id
+	}
+	walk(p)
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
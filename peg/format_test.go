@@ -0,0 +1,44 @@
+package peg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("list <- item+\nitem <- ~'[a-z\"]+' ' '^"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("a b\" ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"type":"item+","pos":0,"end":5,"children":[{"type":"item","text":"a","pos":0,"end":1},{"type":"item","text":"b\"","pos":2,"end":4}]}`
+	if string(out) != exp {
+		t.Errorf("json: got %s, exp %s", out, exp)
+	}
+
+	if out, exp := tree.SExpr(), `(item+ (item "a") (item "b\""))`; out != exp {
+		t.Errorf("sexpr: got %s, exp %s", out, exp)
+	}
+
+	var buf bytes.Buffer
+	if err := tree.WriteDot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dot := "digraph tree {\n\tnode [shape=box];\n" +
+		"\tn0 [label=\"item+\"];\n" +
+		"\tn1 [label=\"item\\n\\\"a\\\"\"];\n\tn0 -> n1;\n" +
+		"\tn2 [label=\"item\\n\\\"b\\\\\\\"\\\"\"];\n\tn0 -> n2;\n}\n"
+	if buf.String() != dot {
+		t.Errorf("dot: got\n%s\nexp\n%s", buf.String(), dot)
+	}
+}
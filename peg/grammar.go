@@ -0,0 +1,300 @@
+package peg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// kind identifies the construct a lexeme was built from, so that the
+// grammar can be reconstructed from a compiled language.
+type kind int
+
+const (
+	kindUnknown kind = iota // built outside this package; printed by name.
+	kindLiteral
+	kindRegexp
+	kindRule
+	kindConcat
+	kindPlus
+	kindStar
+	kindOption
+	kindAlternate
+	kindDiscard
+	kindExternal
+	kindPredicate
+	kindCapture
+	kindBackref
+	kindRepeat
+	kindScope
+	kindCall
+	kindMemo
+	kindChoice
+	kindDefinition
+	kindWrap
+	kindLazy
+)
+
+// rule is a named definition of the grammar.
+type rule struct {
+	name     string
+	lex      *Lexeme
+	alias    string   // the rule referred to if the body is a single reference.
+	params   []string // the parameters of a template, which is no rule itself.
+	instance bool     // whether the rule instantiates a template.
+	refs     []string // the rules lex refers to, if it was built in Go.
+	doc      string   // the ## comments before the rule.
+}
+
+// definition marks the body of the rule name. Rules are traced, listened
+// to, profiled and counted against the parse budget at their definitions,
+// and stream parsers keep their results there, unless predicates might
+// depend on more than the input.
+func definition(name string, lex *Lexeme) *Lexeme {
+	return &Lexeme{
+		Name:         lex.Name,
+		Dependencies: []*Lexeme{lex},
+		kind:         kindDefinition,
+		text:         name,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			switch {
+			case s.stats != nil, s.budget != nil:
+				return s.measureRule(name, lex, pos)
+			case s.trace != nil, s.listener != nil:
+				return s.traceRule(name, lex, pos)
+			case s.stream && len(s.lang.predicates) == 0:
+				return s.memoized(lex, pos)
+			}
+			return lex.Lexer(s, pos)
+		},
+	}
+}
+
+// Rules returns the names of the rules of the grammar in the order they are
+// defined. Templates and their instances are left out.
+func (l *Language) Rules() []string {
+	var names []string
+	for _, r := range l.rules {
+		if r.params == nil && !r.instance {
+			names = append(names, r.name)
+		}
+	}
+	return names
+}
+
+// StartRule returns the name of the rule that parses start with, which is
+// the first one unless %start or the Start option names another.
+func (l *Language) StartRule() string {
+	for _, r := range l.rules {
+		if r.lex == l.root {
+			return r.name
+		}
+	}
+	if l.root == nil {
+		return ""
+	}
+	return l.root.Name
+}
+
+// RuleDoc returns the text of the ## comments that precede the definition
+// of rule, a line for each comment.
+func (l *Language) RuleDoc(name string) string {
+	r, _ := l.rule(name)
+	return r.doc
+}
+
+// Grammar reconstructs the text of the grammar from the compiled rules.
+// Lexemes constructed outside of the grammar are
+// written by name, and nested sequences, which the grammar cannot express
+// yet, are written in parentheses.
+func (l *Language) Grammar() string {
+	rules, names := l.ruleNames()
+	var buf bytes.Buffer
+	d := l.directives
+	if d.indent {
+		buf.WriteString("%indent\n")
+	}
+	if d.caseInsensitive {
+		buf.WriteString("%case_insensitive\n")
+	}
+	if d.start != "" {
+		fmt.Fprintf(&buf, "%%start %s\n", d.start)
+	}
+	if d.whitespace != "" {
+		fmt.Fprintf(&buf, "%%whitespace %s\n", d.whitespace)
+	}
+	if d.autoWhitespace {
+		buf.WriteString("%auto_whitespace")
+		for _, comment := range d.comments {
+			buf.WriteString(" " + quoteLiteral(comment))
+		}
+		buf.WriteString("\n")
+	}
+	if len(d.memo) > 0 {
+		fmt.Fprintf(&buf, "%%memo %s\n", strings.Join(d.memo, " "))
+	}
+	if len(d.keywords) > 0 {
+		fmt.Fprintf(&buf, "%%keywords %s\n", strings.Join(d.keywords, " "))
+	}
+	if len(d.identifiers) > 0 {
+		fmt.Fprintf(&buf, "%%identifier %s\n", strings.Join(d.identifiers, " "))
+	}
+	for _, dep := range d.deprecated {
+		fmt.Fprintf(&buf, "%%deprecated %s %s\n", dep.rule, quoteLiteral(dep.msg))
+	}
+	if len(d.tokens) > 0 {
+		fmt.Fprintf(&buf, "%%token %s\n", strings.Join(d.tokens, " "))
+	}
+	if len(d.inline) > 0 {
+		fmt.Fprintf(&buf, "%%inline %s\n", strings.Join(d.inline, " "))
+	}
+	for _, r := range rules {
+		if r.instance {
+			continue
+		}
+		if r.doc != "" {
+			fmt.Fprintf(&buf, "## %s\n", strings.Replace(r.doc, "\n", "\n## ", -1))
+		}
+		fmt.Fprintln(&buf, r.text(names))
+	}
+	for _, test := range d.tests {
+		fmt.Fprintln(&buf, test)
+	}
+	return buf.String()
+}
+
+// RuleText returns the definition of rule as Grammar writes it, or "" if
+// the grammar has no such rule.
+func (l *Language) RuleText(name string) string {
+	r, ok := l.rule(name)
+	if !ok {
+		return ""
+	}
+	_, names := l.ruleNames()
+	return r.text(names)
+}
+
+// ruleNames returns the rules of the grammar and the names of their
+// lexemes, which expressions refer to them by.
+func (l *Language) ruleNames() ([]rule, map[*Lexeme]string) {
+	rules := l.rules
+	if rules == nil && l.root != nil {
+		rules = []rule{{name: l.root.Name, lex: l.root}}
+	}
+	names := make(map[*Lexeme]string, len(rules))
+	for _, r := range rules {
+		if _, ok := names[r.lex]; !ok {
+			names[r.lex] = r.name
+		}
+	}
+	return rules, names
+}
+
+// text writes the definition of r.
+func (r rule) text(names map[*Lexeme]string) string {
+	body := r.alias
+	if body == "" {
+		body = expression(r.lex, names, true)
+	}
+	name := r.name
+	if r.params != nil {
+		name += "(" + strings.Join(r.params, ", ") + ")"
+	}
+	return name + " <- " + body
+}
+
+// expression writes lex as grammar text. Rules other than the one being
+// defined at the top level are written as references.
+func expression(lex *Lexeme, names map[*Lexeme]string, top bool) string {
+	if name, ok := names[lex]; ok && !top {
+		return name
+	}
+	operand := func(i int) string {
+		return expression(lex.Dependencies[i], names, false)
+	}
+	switch lex.kind {
+	case kindLiteral:
+		return quoteLiteral(lex.text)
+	case kindRegexp:
+		if !strings.ContainsRune(lex.text, '`') {
+			return "~`" + lex.text + "`"
+		}
+		return "~'" + strings.Replace(lex.text, "'", "\\'", -1) + "'"
+	case kindRule:
+		return lex.text
+	case kindConcat:
+		parts := make([]string, len(lex.Dependencies))
+		for i := range lex.Dependencies {
+			parts[i] = operand(i)
+		}
+		if top {
+			return strings.Join(parts, " ")
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	case kindPlus:
+		return operand(0) + "+"
+	case kindStar:
+		return operand(0) + "*"
+	case kindOption:
+		return operand(0) + "?"
+	case kindDiscard:
+		return operand(0) + "^"
+	case kindRepeat, kindLazy:
+		return operand(0) + lex.text
+	case kindAlternate:
+		return operand(0) + " / " + operand(1)
+	case kindChoice:
+		alts := make([]string, len(lex.Dependencies))
+		for i := range lex.Dependencies {
+			alts[i] = operand(i)
+		}
+		return strings.Join(alts, " / ")
+	case kindExternal:
+		return "@" + lex.text
+	case kindPredicate:
+		return "&{" + lex.text + "}"
+	case kindCapture:
+		return lex.text + ":" + operand(0)
+	case kindBackref:
+		return "=" + lex.text
+	case kindScope, kindMemo, kindDefinition:
+		return expression(lex.Dependencies[0], names, top)
+	case kindWrap:
+		// A wrapped rule is written as a reference, a wrapped body as the
+		// body.
+		_, named := names[lex.Dependencies[0]]
+		return expression(lex.Dependencies[0], names, top && !named)
+	case kindCall:
+		return lex.text
+	}
+	return lex.Name
+}
+
+// quoteLiteral writes s as a single quoted literal, escaping the characters
+// that unquote decodes and any byte that is not printable.
+func quoteLiteral(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('\'')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\\' || r == '\'':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString("\\n")
+		case r == '\t':
+			buf.WriteString("\\t")
+		case r == '\r':
+			buf.WriteString("\\r")
+		case r == utf8.RuneError && size == 1, r < ' ', r == 0x7f:
+			fmt.Fprintf(&buf, "\\x%02X", s[i])
+		default:
+			buf.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
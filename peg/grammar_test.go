@@ -0,0 +1,119 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+var grammarTestTable = []struct {
+	grammar, exp string
+}{
+	{
+		"prgm <- a b+ c?\na <- 'a'\nb <- ~'\\d+'\nc <- 'c'",
+		"prgm <- a b+ c?\na <- 'a'\nb <- ~`\\d+`\nc <- 'c'\n",
+	},
+	{
+		"prgm <- a / b\na <- \"it's\\n\"\nb <- a",
+		"prgm <- a / b\na <- 'it\\'s\\n'\nb <- a\n",
+	},
+	{
+		"prgm <- t:a ' '^ =t @ext &{pred} byte{2,} balanced('(', ')')\na <- \\x7F",
+		"prgm <- t:a ' '^ =t @ext &{pred} byte{2,} balanced('(', ')')\na <- '\\x7F'\n",
+	},
+	{
+		"prgm <- a*\na <- 'a'\na <- 'b'",
+		"prgm <- a*\na <- 'b'\n",
+	},
+}
+
+func TestGrammar(t *testing.T) {
+	for _, tc := range grammarTestTable {
+		lang, err := NewParser(strings.NewReader(tc.grammar))
+		if err != nil {
+			t.Error(tc.grammar, err)
+			continue
+		}
+		out := lang.Grammar()
+		if out != tc.exp {
+			t.Errorf("Grammar() = %q, exp %q", out, tc.exp)
+			continue
+		}
+		// The output is a grammar for the same language.
+		again, err := NewParser(strings.NewReader(out))
+		if err != nil {
+			t.Error(out, err)
+			continue
+		}
+		if again.Grammar() != out {
+			t.Errorf("round trip changed %q to %q", out, again.Grammar())
+		}
+	}
+}
+
+func TestAliasRule(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("prgm <- x+\nx <- y\ny <- 'y'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("yy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Children) != 2 {
+		t.Errorf("expected two matches, got %s", tree)
+	}
+}
+
+func TestRuleDoc(t *testing.T) {
+	grammar := `# The language of sums.
+%whitespace ws
+## A program is a sum.
+prgm <- sum # the root
+## Sums add numbers,
+##   one after another.
+sum <- num more* # num
+	# more numbers
+more <- '+'^ num
+#not a doc
+## 
+num <- ~'[0-9]+'
+ws <- ' '`
+	lang, err := NewLanguage(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rule, exp := range map[string]string{
+		"prgm": "A program is a sum.",
+		"sum":  "Sums add numbers,\n  one after another.",
+		"more": "",
+		"num":  "",
+		"ws":   "",
+	} {
+		if doc := lang.RuleDoc(rule); doc != exp {
+			t.Errorf("RuleDoc(%s) = %q, exp %q", rule, doc, exp)
+		}
+	}
+	if _, err := lang.ParseString("1 + 2"); err != nil {
+		t.Error(err)
+	}
+	if text := lang.RuleText("sum"); text != "sum <- num more*" {
+		t.Errorf("RuleText(sum) = %q", text)
+	}
+	if start := lang.StartRule(); start != "prgm" {
+		t.Errorf("StartRule() = %q", start)
+	}
+	if text := lang.RuleText("missing"); text != "" {
+		t.Errorf("RuleText(missing) = %q", text)
+	}
+	printed := lang.Grammar()
+	if !strings.Contains(printed, "## Sums add numbers,\n##   one after another.\nsum <- ") {
+		t.Errorf("Grammar() lost the docs:\n%s", printed)
+	}
+	again, err := NewLanguage(printed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc := again.RuleDoc("sum"); doc != lang.RuleDoc("sum") {
+		t.Errorf("reparsed RuleDoc(sum) = %q", doc)
+	}
+}
@@ -0,0 +1,139 @@
+package grammars
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// ArithGrammar is the grammar of arithmetic expressions over decimal
+// numbers, with + - * /, unary minus and parentheses. Binary operators are
+// left associative, and * and / bind tighter than + and -.
+const ArithGrammar = `%whitespace ws
+expr <- sum end
+sum <- list(product, addop)
+product <- list(factor, mulop)
+factor <- number / group / neg
+group <- '(' sum ')'
+neg <- '-' factor
+addop <- '+' / '-'
+mulop <- '*' / '/'
+number <- ~` + "`" + `[0-9]+(?:\.[0-9]+)?` + "`" + `
+end <- ~` + "`" + `$` + "`" + `
+ws <- ~` + "`" + `[ \t\r\n]+` + "`" + `
+` + list
+
+var arithLang = peg.MustNewLanguage(ArithGrammar)
+
+// NewArith compiles ArithGrammar.
+func NewArith(opts ...peg.Option) (*peg.Language, error) {
+	return peg.NewParser(strings.NewReader(ArithGrammar), opts...)
+}
+
+// ArithExpr is an arithmetic expression.
+type ArithExpr interface {
+	// Eval returns the value of the expression. Division by zero follows
+	// the rules of float64.
+	Eval() float64
+	// String returns the expression with every operation in parentheses.
+	String() string
+}
+
+// ArithNumber is a number.
+type ArithNumber struct {
+	Value float64
+}
+
+// ArithNeg is the negation of X.
+type ArithNeg struct {
+	X ArithExpr
+}
+
+// ArithBinary is X Op Y, where Op is one of + - * /.
+type ArithBinary struct {
+	Op   byte
+	X, Y ArithExpr
+}
+
+func (n *ArithNumber) Eval() float64 { return n.Value }
+func (n *ArithNeg) Eval() float64    { return -n.X.Eval() }
+
+func (b *ArithBinary) Eval() float64 {
+	x, y := b.X.Eval(), b.Y.Eval()
+	switch b.Op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	}
+	return x / y
+}
+
+func (n *ArithNumber) String() string { return strconv.FormatFloat(n.Value, 'g', -1, 64) }
+func (n *ArithNeg) String() string    { return "(-" + n.X.String() + ")" }
+func (b *ArithBinary) String() string {
+	return "(" + b.X.String() + " " + string(b.Op) + " " + b.Y.String() + ")"
+}
+
+// ParseArith parses an arithmetic expression.
+func ParseArith(src []byte) (ArithExpr, error) {
+	tree, err := parseAll(arithLang, src)
+	if err != nil {
+		return nil, err
+	}
+	return ToArith(tree)
+}
+
+// ToArith converts a tree parsed with ArithGrammar.
+func ToArith(tree *peg.ParseTree) (ArithExpr, error) {
+	switch tree.Type {
+	case "expr":
+		if len(tree.Children) != 2 {
+			return nil, unexpected(tree)
+		}
+		return ToArith(tree.Children[0])
+	case "list(product, addop)", "list(factor, mulop)":
+		if len(tree.Children) != 2 {
+			return nil, unexpected(tree)
+		}
+		x, err := ToArith(tree.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, more := range tree.Children[1].Children {
+			if len(more.Children) != 2 || len(more.Children[0].Data) != 1 {
+				return nil, unexpected(more)
+			}
+			y, err := ToArith(more.Children[1])
+			if err != nil {
+				return nil, err
+			}
+			x = &ArithBinary{Op: more.Children[0].Data[0], X: x, Y: y}
+		}
+		return x, nil
+	case "group":
+		if len(tree.Children) != 3 {
+			return nil, unexpected(tree)
+		}
+		return ToArith(tree.Children[1])
+	case "neg":
+		if len(tree.Children) != 2 {
+			return nil, unexpected(tree)
+		}
+		x, err := ToArith(tree.Children[1])
+		if err != nil {
+			return nil, err
+		}
+		return &ArithNeg{x}, nil
+	case "number":
+		n, err := strconv.ParseFloat(string(tree.Data), 64)
+		if err != nil {
+			return nil, err
+		}
+		return &ArithNumber{n}, nil
+	}
+	return nil, unexpected(tree)
+}
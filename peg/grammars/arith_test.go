@@ -0,0 +1,37 @@
+package grammars
+
+import "testing"
+
+func TestParseArith(t *testing.T) {
+	tests := []struct {
+		src  string
+		tree string
+		val  float64
+	}{
+		{"42", "42", 42},
+		{"1 + 2 * 3", "(1 + (2 * 3))", 7},
+		{"8 - 4 - 2", "((8 - 4) - 2)", 2},
+		{"8 / 4 / 2", "((8 / 4) / 2)", 1},
+		{"(1 + 2) * -3.5", "((1 + 2) * (-3.5))", -10.5},
+		{" --1 ", "(-(-1))", 1},
+	}
+	for _, test := range tests {
+		expr, err := ParseArith([]byte(test.src))
+		if err != nil {
+			t.Errorf("%q: %s", test.src, err)
+			continue
+		}
+		if s := expr.String(); s != test.tree {
+			t.Errorf("%q: got %s, exp %s", test.src, s, test.tree)
+		}
+		if v := expr.Eval(); v != test.val {
+			t.Errorf("%q: got %v, exp %v", test.src, v, test.val)
+		}
+	}
+
+	for _, src := range []string{"", "1 +", "(1", "1 2", "* 2", "1 ** 2"} {
+		if _, err := ParseArith([]byte(src)); err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package grammars
+
+import (
+	"strings"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// CSVGrammar is the grammar of comma separated values, as defined by RFC
+// 4180. Lines may end in \n or \r\n, and quoted fields may span lines.
+const CSVGrammar = `csv <- list(record, eol) end
+record <- list(field, ',')
+field <- quoted / bare
+quoted <- ~` + "`" + `"(?:[^"]|"")*"` + "`" + `
+bare <- ~` + "`" + `[^,"\r\n]*` + "`" + `
+eol <- ~` + "`" + `\r?\n` + "`" + `
+end <- ~` + "`" + `$` + "`" + `
+` + list
+
+var csvLang = peg.MustNewLanguage(CSVGrammar)
+
+// NewCSV compiles CSVGrammar.
+func NewCSV(opts ...peg.Option) (*peg.Language, error) {
+	return peg.NewParser(strings.NewReader(CSVGrammar), opts...)
+}
+
+// CSVRecord is a record of a CSV file, holding its fields with the quotes
+// removed.
+type CSVRecord []string
+
+// ParseCSV parses a CSV file.
+func ParseCSV(src []byte) ([]CSVRecord, error) {
+	tree, err := parseAll(csvLang, src)
+	if err != nil {
+		return nil, err
+	}
+	return ToCSV(tree)
+}
+
+// ToCSV converts a tree parsed with CSVGrammar. Like encoding/csv, it skips
+// empty lines.
+func ToCSV(tree *peg.ParseTree) ([]CSVRecord, error) {
+	if tree.Type != "csv" || len(tree.Children) != 2 {
+		return nil, unexpected(tree)
+	}
+	var records []CSVRecord
+	for _, rec := range items(tree.Children[0]) {
+		var record CSVRecord
+		for _, field := range items(rec) {
+			switch field.Type {
+			case "quoted":
+				text := string(field.Data[1 : len(field.Data)-1])
+				record = append(record, strings.Replace(text, `""`, `"`, -1))
+			case "bare":
+				record = append(record, string(field.Data))
+			default:
+				return nil, unexpected(field)
+			}
+		}
+		if len(record) == 1 && record[0] == "" && rec.Children[0].Type == "bare" {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,35 @@
+package grammars
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		src string
+		exp []CSVRecord
+	}{
+		{"", nil},
+		{"a", []CSVRecord{{"a"}}},
+		{"a,b\nc,d\n", []CSVRecord{{"a", "b"}, {"c", "d"}}},
+		{"a,,\r\n\n\"\",x", []CSVRecord{{"a", "", ""}, {"", "x"}}},
+		{"\"say \"\"hi\"\"\",\"a,b\nc\"", []CSVRecord{{`say "hi"`, "a,b\nc"}}},
+	}
+	for _, test := range tests {
+		records, err := ParseCSV([]byte(test.src))
+		if err != nil {
+			t.Errorf("%q: %s", test.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(records, test.exp) {
+			t.Errorf("%q: got %q, exp %q", test.src, records, test.exp)
+		}
+	}
+
+	for _, src := range []string{`"a`, `a"b`, `"a"b`} {
+		if _, err := ParseCSV([]byte(src)); err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package grammars contains grammars for common formats, JSON, CSV, INI
+// and arithmetic expressions, each with a typed syntax tree and functions
+// converting parse trees to it. They are meant both as tested parsers and
+// as starting points for new grammars.
+//
+// Required punctuation is kept in the grammars rather than discarded with
+// ^, since a discarded lexeme also matches when it is missing. The
+// conversions skip it.
+package grammars
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// list is the template the grammars use for separated lists. It must be
+// added to the text of a grammar calling it.
+const list = `list(item, sep) <- item more(item, sep)*
+more(item, sep) <- sep item`
+
+// parseAll parses all of src with lang.
+func parseAll(lang *peg.Language, src []byte) (*peg.ParseTree, error) {
+	tree, err := lang.ParseBytes(src)
+	if err != nil {
+		return nil, err
+	}
+	if tree.End < len(src) {
+		return nil, errors.New(fmt.Sprintf("unexpected input at offset %d", tree.End))
+	}
+	return tree, nil
+}
+
+// items returns the items of a tree matched by the list template, which
+// holds the first item and the repetitions of the separator and the next
+// item, in that order. The separators are left out.
+func items(tree *peg.ParseTree) []*peg.ParseTree {
+	if len(tree.Children) != 2 {
+		return nil
+	}
+	resp := []*peg.ParseTree{tree.Children[0]}
+	for _, more := range tree.Children[1].Children {
+		if len(more.Children) == 2 {
+			resp = append(resp, more.Children[1])
+		}
+	}
+	return resp
+}
+
+// child returns the first child of tree of type typ, or nil.
+func child(tree *peg.ParseTree, typ string) *peg.ParseTree {
+	for _, c := range tree.Children {
+		if c.Type == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+func unexpected(tree *peg.ParseTree) error {
+	return errors.New(fmt.Sprintf("unexpected %s node at offset %d", tree.Type, tree.Pos))
+}
@@ -0,0 +1,125 @@
+package grammars
+
+import (
+	"strings"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// INIGrammar is the grammar of INI files: [section] headers and key = value
+// pairs, one per line, with comments on lines of their own starting with ;
+// or #.
+const INIGrammar = `ini <- line* end
+line <- section / pair / blank
+section <- ~` + "`" + `[ \t]*\[` + "`" + ` name ~` + "`" + `\][ \t]*` + "`" + ` eol
+pair <- ~` + "`" + `[ \t]*` + "`" + ` key ~` + "`" + `=[ \t]*` + "`" + ` value eol
+blank <- ~` + "`" + `[ \t]*(?:[;#][^\r\n]*)?` + "`" + ` eol
+name <- ~` + "`" + `[^\]\r\n]*` + "`" + `
+key <- ~` + "`" + `[^\s=\[;#][^=\r\n]*` + "`" + `
+value <- ~` + "`" + `[^\r\n]*` + "`" + `
+eol <- ~` + "`" + `\r?\n|$` + "`" + `
+end <- ~` + "`" + `$` + "`"
+
+var iniLang = peg.MustNewLanguage(INIGrammar)
+
+// NewINI compiles INIGrammar.
+func NewINI(opts ...peg.Option) (*peg.Language, error) {
+	return peg.NewParser(strings.NewReader(INIGrammar), opts...)
+}
+
+// INI is an INI file. The pairs before the first header are in a section
+// named "".
+type INI struct {
+	Sections []INISection
+}
+
+// INISection is a section of an INI file, holding its pairs in order.
+type INISection struct {
+	Name  string
+	Pairs []INIPair
+}
+
+// INIPair is a key = value pair, with the whitespace around the key and the
+// value removed.
+type INIPair struct {
+	Key, Value string
+}
+
+// ParseINI parses an INI file.
+func ParseINI(src []byte) (*INI, error) {
+	tree, err := parseAll(iniLang, src)
+	if err != nil {
+		return nil, err
+	}
+	return ToINI(tree)
+}
+
+// ToINI converts a tree parsed with INIGrammar.
+func ToINI(tree *peg.ParseTree) (*INI, error) {
+	if tree.Type != "ini" || len(tree.Children) != 2 {
+		return nil, unexpected(tree)
+	}
+	ini := &INI{Sections: []INISection{{}}}
+	for _, line := range tree.Children[0].Children {
+		switch line.Type {
+		case "section":
+			name := child(line, "name")
+			if name == nil {
+				return nil, unexpected(line)
+			}
+			ini.Sections = append(ini.Sections, INISection{Name: strings.TrimSpace(string(name.Data))})
+		case "pair":
+			key, value := child(line, "key"), child(line, "value")
+			if key == nil || value == nil {
+				return nil, unexpected(line)
+			}
+			last := &ini.Sections[len(ini.Sections)-1]
+			last.Pairs = append(last.Pairs, INIPair{
+				Key:   strings.TrimSpace(string(key.Data)),
+				Value: strings.TrimSpace(string(value.Data)),
+			})
+		case "blank":
+		default:
+			return nil, unexpected(line)
+		}
+	}
+	if len(ini.Sections[0].Pairs) == 0 {
+		ini.Sections = ini.Sections[1:]
+	}
+	return ini, nil
+}
+
+// Get returns the value of the last pair with key in the last section
+// named section.
+func (ini *INI) Get(section, key string) (string, bool) {
+	for i := len(ini.Sections) - 1; i >= 0; i-- {
+		if ini.Sections[i].Name != section {
+			continue
+		}
+		pairs := ini.Sections[i].Pairs
+		for j := len(pairs) - 1; j >= 0; j-- {
+			if pairs[j].Key == key {
+				return pairs[j].Value, true
+			}
+		}
+		break
+	}
+	return "", false
+}
+
+// Map returns the pairs of each section by key. Sections with the same name
+// are merged, and later pairs win.
+func (ini *INI) Map() map[string]map[string]string {
+	resp := make(map[string]map[string]string, len(ini.Sections))
+	for _, section := range ini.Sections {
+		pairs := resp[section.Name]
+		if pairs == nil {
+			pairs = make(map[string]string, len(section.Pairs))
+			resp[section.Name] = pairs
+		}
+		for _, pair := range section.Pairs {
+			pairs[pair.Key] = pair.Value
+		}
+	}
+	return resp
+}
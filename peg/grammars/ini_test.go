@@ -0,0 +1,46 @@
+package grammars
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseINI(t *testing.T) {
+	src := "; settings\nroot = /srv\n\n[server]\n  host=example.com \nport = 8080\r\n# comment\n[client]\nretries = \n[server]\nport = 9090"
+	ini, err := ParseINI([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &INI{Sections: []INISection{
+		{Name: "", Pairs: []INIPair{{"root", "/srv"}}},
+		{Name: "server", Pairs: []INIPair{{"host", "example.com"}, {"port", "8080"}}},
+		{Name: "client", Pairs: []INIPair{{"retries", ""}}},
+		{Name: "server", Pairs: []INIPair{{"port", "9090"}}},
+	}}
+	if !reflect.DeepEqual(ini, exp) {
+		t.Errorf("got %+v, exp %+v", ini, exp)
+	}
+
+	if v, ok := ini.Get("server", "port"); !ok || v != "9090" {
+		t.Errorf("Get(server, port) = %q, %v", v, ok)
+	}
+	if _, ok := ini.Get("server", "host"); ok {
+		t.Errorf("Get(server, host) looked past the last server section")
+	}
+	if v, ok := ini.Get("", "root"); !ok || v != "/srv" {
+		t.Errorf("Get(, root) = %q, %v", v, ok)
+	}
+	m := ini.Map()
+	if m["server"]["host"] != "example.com" || m["server"]["port"] != "9090" || len(m) != 3 {
+		t.Errorf("unexpected map %v", m)
+	}
+
+	if ini, err := ParseINI([]byte("")); err != nil || len(ini.Sections) != 0 {
+		t.Errorf("empty file: %v, %v", ini, err)
+	}
+	for _, src := range []string{"key", "[server", "= value", "[a]b = c"} {
+		if _, err := ParseINI([]byte(src)); err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package grammars
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// JSONGrammar is the grammar of JSON documents, as defined by RFC 8259. The
+// end rule matches the end of the input, after trailing whitespace.
+const JSONGrammar = `%whitespace ws
+document <- value end
+value <- object / array / string / number / true / false / null
+object <- '{' list(member, ',')? '}'
+member <- string ':' value
+array <- '[' list(value, ',')? ']'
+string <- ~` + "`" + `"(?:[^"\\\x00-\x1f]|\\["\\/bfnrt]|\\u[0-9a-fA-F]{4})*"` + "`" + `
+number <- ~` + "`" + `-?(?:0|[1-9][0-9]*)(?:\.[0-9]+)?(?:[eE][+-]?[0-9]+)?` + "`" + `
+true <- 'true'
+false <- 'false'
+null <- 'null'
+end <- ~` + "`" + `$` + "`" + `
+ws <- ~` + "`" + `[ \t\r\n]+` + "`" + `
+` + list
+
+var jsonLang = peg.MustNewLanguage(JSONGrammar)
+
+// NewJSON compiles JSONGrammar.
+func NewJSON(opts ...peg.Option) (*peg.Language, error) {
+	return peg.NewParser(strings.NewReader(JSONGrammar), opts...)
+}
+
+// JSONKind is the kind of a JSON value.
+type JSONKind int
+
+const (
+	JSONNull JSONKind = iota
+	JSONBool
+	JSONNumber
+	JSONString
+	JSONArray
+	JSONObject
+)
+
+// JSONValue is a JSON value. Only the fields of its kind are set. Objects
+// keep their members in order, including duplicate keys.
+type JSONValue struct {
+	Kind     JSONKind
+	Bool     bool
+	Number   float64
+	String   string
+	Elements []*JSONValue
+	Members  []JSONMember
+	Pos, End int
+}
+
+// JSONMember is a member of a JSON object.
+type JSONMember struct {
+	Key   string
+	Value *JSONValue
+}
+
+// ParseJSON parses a JSON document.
+func ParseJSON(src []byte) (*JSONValue, error) {
+	tree, err := parseAll(jsonLang, src)
+	if err != nil {
+		return nil, err
+	}
+	return ToJSON(tree)
+}
+
+// ToJSON converts a tree parsed with JSONGrammar.
+func ToJSON(tree *peg.ParseTree) (*JSONValue, error) {
+	v := &JSONValue{Pos: tree.Pos, End: tree.End}
+	switch tree.Type {
+	case "document":
+		if len(tree.Children) != 2 {
+			return nil, unexpected(tree)
+		}
+		return ToJSON(tree.Children[0])
+	case "null":
+		v.Kind = JSONNull
+	case "true", "false":
+		v.Kind, v.Bool = JSONBool, tree.Type == "true"
+	case "number":
+		n, err := strconv.ParseFloat(string(tree.Data), 64)
+		if err != nil {
+			return nil, err
+		}
+		v.Kind, v.Number = JSONNumber, n
+	case "string":
+		s, err := jsonString(tree)
+		if err != nil {
+			return nil, err
+		}
+		v.Kind, v.String = JSONString, s
+	case "array":
+		v.Kind = JSONArray
+		if elems := child(tree, "list(value, ',')"); elems != nil {
+			for _, elem := range items(elems) {
+				e, err := ToJSON(elem)
+				if err != nil {
+					return nil, err
+				}
+				v.Elements = append(v.Elements, e)
+			}
+		}
+	case "object":
+		v.Kind = JSONObject
+		if members := child(tree, "list(member, ',')"); members != nil {
+			for _, member := range items(members) {
+				if len(member.Children) != 3 {
+					return nil, unexpected(member)
+				}
+				key, err := jsonString(member.Children[0])
+				if err != nil {
+					return nil, err
+				}
+				value, err := ToJSON(member.Children[2])
+				if err != nil {
+					return nil, err
+				}
+				v.Members = append(v.Members, JSONMember{key, value})
+			}
+		}
+	default:
+		return nil, unexpected(tree)
+	}
+	return v, nil
+}
+
+// jsonString decodes the escapes of a string leaf.
+func jsonString(tree *peg.ParseTree) (string, error) {
+	if tree.Type != "string" {
+		return "", unexpected(tree)
+	}
+	var s string
+	err := json.Unmarshal(tree.Data, &s)
+	return s, err
+}
+
+// Interface returns the value as encoding/json decodes it into an empty
+// interface: nil, bool, float64, string, []interface{} or
+// map[string]interface{}, where the last of duplicate keys wins.
+func (v *JSONValue) Interface() interface{} {
+	switch v.Kind {
+	case JSONBool:
+		return v.Bool
+	case JSONNumber:
+		return v.Number
+	case JSONString:
+		return v.String
+	case JSONArray:
+		elems := make([]interface{}, len(v.Elements))
+		for i, e := range v.Elements {
+			elems[i] = e.Interface()
+		}
+		return elems
+	case JSONObject:
+		members := make(map[string]interface{}, len(v.Members))
+		for _, m := range v.Members {
+			members[m.Key] = m.Value.Interface()
+		}
+		return members
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package grammars
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	for _, src := range []string{
+		`null`,
+		`true`,
+		` [] `,
+		`{}`,
+		`-1.5e3`,
+		`"a\"b\\c\/é😀\n"`,
+		`[1, [2, []], {"a": null}]`,
+		`{"a": {"b": [true, false]}, "c": "d", "a": 0}`,
+	} {
+		v, err := ParseJSON([]byte(src))
+		if err != nil {
+			t.Errorf("%s: %s", src, err)
+			continue
+		}
+		var exp interface{}
+		if err := json.Unmarshal([]byte(src), &exp); err != nil {
+			t.Fatal(err)
+		}
+		if got := v.Interface(); !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: got %#v, exp %#v", src, got, exp)
+		}
+	}
+
+	v, err := ParseJSON([]byte(`{"b": 1, "a": 2, "b": 3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Members) != 3 || v.Members[1].Key != "a" || v.Members[2].Value.Number != 3 {
+		t.Errorf("unexpected members %v", v.Members)
+	}
+
+	for _, src := range []string{``, `[1 2]`, `[1,]`, `{"a" 1}`, `{a: 1}`, `01`, `"a`, `nul`, `[] x`, "\"\x01\""} {
+		if _, err := ParseJSON([]byte(src)); err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+}
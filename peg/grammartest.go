@@ -0,0 +1,90 @@
+package peg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// GrammarTest is an example input declared in a grammar with %test, as in
+//
+//	%test '1+2*3' matches expr
+//	%test '1+' fails
+//
+// An input matches if the rule, or the root rule, consumes all of it.
+type GrammarTest struct {
+	Input string
+	Rule  string // the rule to parse Input with, or empty for the root rule.
+	Fails bool   // whether Input is expected not to match.
+	Line  int    // the line of the directive in the grammar.
+	File  string // the file of the directive, if loaded by NewLanguageFS.
+}
+
+func (t GrammarTest) String() string {
+	s := "%test " + quoteLiteral(t.Input)
+	if t.Fails {
+		s += " fails"
+	} else {
+		s += " matches"
+	}
+	if t.Rule != "" {
+		s += " " + t.Rule
+	}
+	return s
+}
+
+// Tests returns the %test directives of the grammar, in the order they
+// appear.
+func (l *Language) Tests() []GrammarTest {
+	return append([]GrammarTest(nil), l.directives.tests...)
+}
+
+// RunTests runs the %test directives of the grammar. It returns nil if
+// all of them pass, and otherwise a GrammarErrors listing the failures, so
+// that a Go test can check a grammar with
+//
+//	if err := lang.RunTests(); err != nil {
+//		t.Error(err)
+//	}
+func (l *Language) RunTests() error {
+	var errs GrammarErrors
+	for _, test := range l.directives.tests {
+		if err := l.runTest(test); err != nil {
+			errs = append(errs, &GrammarError{File: test.File, Rule: test.Rule, Line: test.Line, Col: 1, Msg: test.String() + ": " + err.Error()})
+		}
+	}
+	if errs == nil {
+		return nil
+	}
+	return errs
+}
+
+// runTest returns why test fails, or nil if it passes.
+func (l *Language) runTest(test GrammarTest) error {
+	root := l.root
+	if test.Rule != "" {
+		root = nil
+		for _, r := range l.rules {
+			if r.name == test.Rule && r.params == nil {
+				root = r.lex
+			}
+		}
+		if root == nil {
+			return errors.New(fmt.Sprintf("undefined rule %s", test.Rule))
+		}
+	}
+	s := SourceFromBytes([]byte(test.Input))
+	_, err, n := l.parseAt(root, s, 0, false)
+	if err == nil {
+		n += s.skipWhitespace(n)
+		if n < len(test.Input) {
+			err = s.failed(n, "unexpected input")
+		}
+	}
+	switch {
+	case test.Fails && err == nil:
+		return errors.New("input matched")
+	case !test.Fails && err != nil:
+		return err
+	}
+	return nil
+}
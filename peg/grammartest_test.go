@@ -0,0 +1,64 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGrammarTests(t *testing.T) {
+	grammar := `%whitespace ws
+expr <- term more*
+more <- '+' term
+term <- ~'[0-9]+'
+ws <- ~'[ \t]+'
+%test '1+2 + 3 ' matches
+%test '1+' fails
+%test '12' matches term
+%test '1+2' fails term
+`
+	lang, err := NewParser(strings.NewReader(grammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lang.RunTests(); err != nil {
+		t.Error(err)
+	}
+	tests := lang.Tests()
+	if len(tests) != 4 {
+		t.Fatalf("expected 4 tests, got %v", tests)
+	}
+	if exp := (GrammarTest{Input: "1+2", Rule: "term", Fails: true, Line: 9}); tests[3] != exp {
+		t.Errorf("unexpected test %#v", tests[3])
+	}
+	out := lang.Grammar()
+	if !strings.HasSuffix(out, "%test '12' matches term\n%test '1+2' fails term\n") {
+		t.Errorf("tests missing from Grammar():\n%s", out)
+	}
+	if again, err := NewParser(strings.NewReader(out)); err != nil || len(again.Tests()) != 4 {
+		t.Errorf("printed grammar does not round trip: %v", err)
+	}
+
+	failing, err := NewParser(strings.NewReader("num <- ~'[0-9]+'\n%test '1a' matches\n%test '2' fails num\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "2:1: %test '1a' matches: unexpected input at offset 1\n3:1: num: %test '2' fails num: input matched"
+	if err := failing.RunTests(); err == nil || err.Error() != exp {
+		t.Errorf("RunTests() = %v, expected %s", err, exp)
+	}
+}
+
+func TestGrammarTestErrors(t *testing.T) {
+	for _, grammar := range []string{
+		"a <- 'a'\n%test 'a'",
+		"a <- 'a'\n%test 'a' passes",
+		"a <- 'a'\n%test a matches",
+		"a <- 'a'\n%test 'a' matches b",
+		"a <- 'a'\n%test 'a' matches a a",
+		"%start 'a'\na <- 'a'",
+	} {
+		if _, err := NewParser(strings.NewReader(grammar)); err == nil {
+			t.Errorf("expected error for %q", grammar)
+		}
+	}
+}
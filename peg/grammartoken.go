@@ -0,0 +1,89 @@
+package peg
+
+import (
+	"context"
+	"io"
+	"iter"
+)
+
+// GrammarToken is a token of a grammar, as TokenizeGrammar reads it.
+//
+// Kind is one of identifier, assignment, literal, regexp, byte, skip,
+// external, predicate, call, label, backref, directive, operator, doc,
+// comment, space, newline or error. Literals, regexps, bytes and skips
+// are quoted, externals start with @, and so on; Text has the token as
+// written, and Value without those delimiters. The Value of an error is
+// its message.
+type GrammarToken struct {
+	Kind  string
+	Text  string
+	Value string
+	// The offsets of the first byte of Text and of the byte following it,
+	// and the 1-based line and byte column of the first.
+	Pos, End  int
+	Line, Col int
+}
+
+// The kinds of the items of the lexer, which tell some items apart that
+// highlighting need not.
+var grammarTokenKinds = map[itemType]string{
+	itemError:      "error",
+	itemAssignment: "assignment",
+	itemQuote:      "literal",
+	itemLiteral:    "literal",
+	itemRawLiteral: "literal",
+	itemWhitespace: "space",
+	itemNewline:    "newline",
+	itemIdentifier: "identifier",
+	itemRegexp:     "regexp",
+	itemByte:       "byte",
+	itemSkipUntil:  "skip",
+	itemExternal:   "external",
+	itemPredicate:  "predicate",
+	itemDirective:  "directive",
+	itemCall:       "call",
+	itemLabel:      "label",
+	itemBackref:    "backref",
+	itemDoc:        "doc",
+	itemComment:    "comment",
+	itemClosure:    "operator",
+	itemPlus:       "operator",
+	itemAlternate:  "operator",
+	itemOptional:   "operator",
+	itemDiscard:    "operator",
+	itemRepeat:     "operator",
+	itemLazy:       "operator",
+	itemLParen:     "operator",
+	itemRParen:     "operator",
+	itemComma:      "operator",
+}
+
+// TokenizeGrammar returns the tokens of the grammar read from r, comments
+// and whitespace included, without compiling it. It is meant for editors
+// highlighting grammars and for tools analyzing them. The tokens cover
+// the grammar in order up to the first error, which is the last token.
+func TokenizeGrammar(r io.Reader) iter.Seq[GrammarToken] {
+	return func(yield func(GrammarToken) bool) {
+		l := newLexer(context.Background(), r)
+		l.comments = true
+		go l.run()
+		defer l.Close()
+		for it := range l.items {
+			if it.typ == itemEOF {
+				return
+			}
+			tok := GrammarToken{
+				Kind:  grammarTokenKinds[it.typ],
+				Text:  it.text,
+				Value: it.val,
+				Pos:   it.start,
+				End:   it.start + len(it.text),
+				Line:  it.line,
+				Col:   it.col - (it.pos - it.start),
+			}
+			if !yield(tok) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package peg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeGrammar(t *testing.T) {
+	for _, tt := range []struct {
+		grammar string
+		exp     []string // the kind and text of each token.
+	}{
+		{"a <- @b* # c", []string{"identifier a", "space  ", "assignment <-", "space  ", "external @b", "operator *", "space  ", "comment # c"}},
+		{"## A.\na <- ~'[0-9]'^", []string{"doc ## A.", "newline \n", "identifier a", "space  ", "assignment <-", "space  ", "regexp ~'[0-9]'", "operator ^"}},
+		{"%whitespace ws", []string{"directive %whitespace", "space  ", "identifier ws"}},
+		{"a <- 'b", []string{"identifier a", "space  ", "assignment <-", "space  ", "error 'b"}},
+	} {
+		var got []string
+		for tok := range TokenizeGrammar(strings.NewReader(tt.grammar)) {
+			got = append(got, tok.Kind+" "+tok.Text)
+		}
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Errorf("%q: got %q, expected %q", tt.grammar, got, tt.exp)
+		}
+	}
+}
+
+func TestTokenizeGrammarPositions(t *testing.T) {
+	grammar := "## Sums.\nsum <- num more*  # more\nmore <- '+' num\nnum <- ~'[0-9]+'\n"
+	var text strings.Builder
+	var num GrammarToken
+	for tok := range TokenizeGrammar(strings.NewReader(grammar)) {
+		if tok.Pos != text.Len() || tok.End != tok.Pos+len(tok.Text) || grammar[tok.Pos:tok.End] != tok.Text {
+			t.Errorf("%#v is out of place", tok)
+		}
+		text.WriteString(tok.Text)
+		if tok.Kind == "regexp" {
+			num = tok
+		}
+	}
+	if text.String() != grammar {
+		t.Errorf("tokens cover %q, expected %q", text.String(), grammar)
+	}
+	exp := GrammarToken{Kind: "regexp", Text: "~'[0-9]+'", Value: "[0-9]+", Pos: 57, End: 66, Line: 4, Col: 8}
+	if num != exp {
+		t.Errorf("got %#v, expected %#v", num, exp)
+	}
+
+	// Stopping early closes the lexer.
+	n := 0
+	for range TokenizeGrammar(strings.NewReader(grammar)) {
+		if n++; n == 3 {
+			break
+		}
+	}
+}
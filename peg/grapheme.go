@@ -0,0 +1,198 @@
+package peg
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Graphemes sets whether the built in any matches a grapheme cluster, a
+// character as a reader perceives it, rather than a single code point. A
+// cluster may be made of many code points, such as a letter followed by
+// combining accents, a flag of two regional indicators or an emoji joined
+// by zero width joiners, so that any{1,80} limits a line to what would be
+// 80 columns of plain text.
+func Graphemes(graphemes bool) Option {
+	return func(l *Language) {
+		l.graphemes = graphemes
+	}
+}
+
+// NewAnyLexer matches any single character: a code point, or a grapheme
+// cluster in languages built with Graphemes(true). Invalid UTF-8 is taken
+// a byte at a time. Repetitions of it, such as any{3}, produce a single
+// leaf holding all of the matched characters.
+func NewAnyLexer() *Lexeme {
+	id := newNodeType("any")
+	return &Lexeme{
+		Name:  "any",
+		merge: true,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			if pos >= len(s.buf) {
+				s.Starve(1)
+				return nil, s.expected(pos, "any character"), 0
+			}
+			var n int
+			if s.lang != nil && s.lang.graphemes {
+				n = graphemeLen(s.buf[pos:])
+				// Combining marks written later may extend the cluster.
+				if pos+n == len(s.buf) {
+					s.Starve(1)
+				}
+			} else {
+				_, n = utf8.DecodeRune(s.buf[pos:])
+			}
+			return s.leaf(&ParseTree{Type: "any", ID: id.get(s), Data: s.buf[pos : pos+n], Pos: pos, End: pos + n}), nil, n
+		},
+	}
+}
+
+// graphemeClass is the Grapheme_Cluster_Break property of a code point, as
+// far as the segmentation rules of Unicode Standard Annex #29 tell apart.
+type graphemeClass int
+
+const (
+	gcOther graphemeClass = iota
+	gcCR
+	gcLF
+	gcControl
+	gcExtend
+	gcZWJ
+	gcRegional
+	gcSpacingMark
+	gcL
+	gcV
+	gcT
+	gcLV
+	gcLVT
+	gcPictographic
+)
+
+func classifyGrapheme(r rune) graphemeClass {
+	switch {
+	case r == '\r':
+		return gcCR
+	case r == '\n':
+		return gcLF
+	case r == 0x200D:
+		return gcZWJ
+	case r == 0x200C, 0xFE00 <= r && r <= 0xFE0F, 0x1F3FB <= r && r <= 0x1F3FF, 0xE0020 <= r && r <= 0xE007F:
+		// Joiners, variation selectors, skin tones and emoji tags.
+		return gcExtend
+	case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r):
+		return gcExtend
+	case unicode.Is(unicode.Mc, r):
+		return gcSpacingMark
+	case unicode.IsControl(r), r == 0x2028, r == 0x2029:
+		return gcControl
+	case 0x1F1E6 <= r && r <= 0x1F1FF:
+		return gcRegional
+	case 0x1100 <= r && r <= 0x115F, 0xA960 <= r && r <= 0xA97C:
+		return gcL
+	case 0x1160 <= r && r <= 0x11A7, 0xD7B0 <= r && r <= 0xD7C6:
+		return gcV
+	case 0x11A8 <= r && r <= 0x11FF, 0xD7CB <= r && r <= 0xD7FB:
+		return gcT
+	case 0xAC00 <= r && r <= 0xD7A3:
+		if (r-0xAC00)%28 == 0 {
+			return gcLV
+		}
+		return gcLVT
+	case isPictographic(r):
+		return gcPictographic
+	}
+	return gcOther
+}
+
+// pictographic holds the blocks of emoji and other pictographs, which
+// zero width joiners combine into a single cluster.
+var pictographic = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00A9, 0x00AE, 5},
+		{0x203C, 0x2049, 13},
+		{0x2122, 0x2139, 23},
+		{0x2194, 0x21AA, 1},
+		{0x231A, 0x23FF, 1},
+		{0x24C2, 0x25FE, 1},
+		{0x2600, 0x27BF, 1},
+		{0x2934, 0x2935, 1},
+		{0x2B05, 0x2B55, 1},
+		{0x3030, 0x303D, 13},
+		{0x3297, 0x3299, 2},
+	},
+	R32: []unicode.Range32{
+		{0x1F000, 0x1F1E5, 1},
+		{0x1F200, 0x1F3FA, 1},
+		{0x1F400, 0x1FAFF, 1},
+	},
+}
+
+func isPictographic(r rune) bool {
+	return unicode.Is(pictographic, r)
+}
+
+// graphemeLen returns the length of the grapheme cluster at the start of
+// b, which must not be empty. It follows the extended cluster rules of
+// Unicode Standard Annex #29, except that prepended characters don't join
+// the character after them.
+func graphemeLen(b []byte) int {
+	r, n := utf8.DecodeRune(b)
+	if r == utf8.RuneError && n <= 1 {
+		return 1
+	}
+	prev := classifyGrapheme(r)
+	// Whether the cluster so far is a pictograph followed by extending
+	// characters, which a zero width joiner may join to the next one.
+	pictograph := prev == gcPictographic
+	regionals := 0
+	if prev == gcRegional {
+		regionals = 1
+	}
+	for n < len(b) {
+		r, size := utf8.DecodeRune(b[n:])
+		if r == utf8.RuneError && size <= 1 {
+			return n
+		}
+		next := classifyGrapheme(r)
+		if !graphemeJoins(prev, next, pictograph, regionals) {
+			return n
+		}
+		switch {
+		case next == gcPictographic:
+			pictograph = true
+		case next != gcExtend && next != gcZWJ:
+			pictograph = false
+		}
+		if next == gcRegional {
+			regionals++
+		}
+		prev = next
+		n += size
+	}
+	return n
+}
+
+// graphemeJoins reports whether there is no cluster boundary between
+// characters of the classes prev and next.
+func graphemeJoins(prev, next graphemeClass, pictograph bool, regionals int) bool {
+	switch {
+	case prev == gcCR && next == gcLF:
+		return true
+	case prev == gcCR, prev == gcLF, prev == gcControl:
+		return false
+	case next == gcCR, next == gcLF, next == gcControl:
+		return false
+	case prev == gcL:
+		return next == gcL || next == gcV || next == gcLV || next == gcLVT || next == gcExtend || next == gcZWJ || next == gcSpacingMark
+	case (prev == gcLV || prev == gcV) && (next == gcV || next == gcT):
+		return true
+	case (prev == gcLVT || prev == gcT) && next == gcT:
+		return true
+	case next == gcExtend, next == gcZWJ, next == gcSpacingMark:
+		return true
+	case prev == gcZWJ && next == gcPictographic:
+		return pictograph
+	case prev == gcRegional && next == gcRegional:
+		return regionals%2 == 1
+	}
+	return false
+}
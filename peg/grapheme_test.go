@@ -0,0 +1,54 @@
+package peg
+
+import (
+	"testing"
+)
+
+func TestAny(t *testing.T) {
+	for _, tt := range []struct {
+		grammar   string
+		input     string
+		graphemes bool
+		want      string // the text of the leaf, or "" if the parse fails.
+	}{
+		{"line <- any{3}", "abc", false, "abc"},
+		{"line <- any{3}", "ab", false, ""},
+		{"line <- any{2}", "\u00E9!", false, "\u00E9!"},
+		{"line <- any{2}", "e\u0301!", false, "e\u0301"},
+		{"line <- any{2}", "e\u0301!", true, "e\u0301!"},
+		{"line <- any{1,2}", "e\u0301\u0302x", true, "e\u0301\u0302x"},
+		{"line <- any{1}", "\U0001F1E9\U0001F1EA\U0001F1EB\U0001F1F7", true, "\U0001F1E9\U0001F1EA"},
+		{"line <- any{2}", "\U0001F1E9\U0001F1EA\U0001F1EB\U0001F1F7", true, "\U0001F1E9\U0001F1EA\U0001F1EB\U0001F1F7"},
+		{"line <- any{1}", "\U0001F469\u200D\U0001F4BB!", true, "\U0001F469\u200D\U0001F4BB"},
+		{"line <- any{1}", "\U0001F44D\U0001F3FD", true, "\U0001F44D\U0001F3FD"},
+		{"line <- any{1}", "\r\n", true, "\r\n"},
+		{"line <- any{1}", "\u1100\u1161\u11A8x", true, "\u1100\u1161\u11A8"},
+		{"line <- any{1}", "a\u200D\U0001F4BB", true, "a\u200D"},
+		{"line <- any{2}", "\xffa", true, "\xffa"},
+		{"line <- any{1,2} ';'", "e\u0301b;", true, "e\u0301b"},
+		{"any <- 'x'\nline <- any", "x", false, "x"},
+	} {
+		lang, err := NewLanguage(tt.grammar, Graphemes(tt.graphemes))
+		if err != nil {
+			t.Errorf("%q: %s", tt.grammar, err)
+			continue
+		}
+		tree, err := lang.ParseString(tt.input)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q on %q: expected an error", tt.grammar, tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q on %q: %s", tt.grammar, tt.input, err)
+			continue
+		}
+		if len(tree.Children) > 0 {
+			tree = tree.Children[0]
+		}
+		if string(tree.Data) != tt.want {
+			t.Errorf("%q on %q: got %q, want %q", tt.grammar, tt.input, tree.Data, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package peg
+
+import (
+	"fmt"
+)
+
+// indentLevel is an immutable stack of indentation widths, so that
+// backtracking only has to restore the top pointer.
+type indentLevel struct {
+	width int
+	prev  *indentLevel
+}
+
+const tabWidth = 8
+
+// indentation measures the first non-blank line starting at pos. It returns
+// the width of its leading whitespace and the offset of its first
+// non-whitespace byte. At the end of input the width is zero.
+func (s *Source) indentation(pos int) (width, end int) {
+	for {
+		width, end = 0, pos
+		for end < len(s.buf) && (s.buf[end] == ' ' || s.buf[end] == '\t') {
+			if s.buf[end] == '\t' {
+				width += tabWidth - width%tabWidth
+			} else {
+				width++
+			}
+			end++
+		}
+		if end == len(s.buf) {
+			s.Starve(1)
+			return 0, end
+		}
+		if s.buf[end] == '\r' && end+1 < len(s.buf) && s.buf[end+1] == '\n' {
+			end++
+		}
+		if s.buf[end] != '\n' {
+			return width, end
+		}
+		pos = end + 1
+	}
+}
+
+func (s *Source) atLineStart(pos int) bool {
+	return pos == 0 || pos == len(s.buf) || s.buf[pos-1] == '\n'
+}
+
+func (s *Source) indentWidth() int {
+	if s.indent == nil {
+		return 0
+	}
+	return s.indent.width
+}
+
+// NewIndentLexer matches the leading whitespace of a line that is indented
+// further than the current block and opens a new block. Blank lines before
+// it are consumed as well.
+func NewIndentLexer() *Lexeme {
+	return &Lexeme{
+		Name: "INDENT",
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			if !s.atLineStart(pos) {
+				return nil, s.expected(pos, "indent at start of line"), 0
+			}
+			width, end := s.indentation(pos)
+			if width <= s.indentWidth() || end == len(s.buf) {
+				return nil, s.expected(pos, "indent"), 0
+			}
+			s.indent = &indentLevel{width, s.indent}
+			return nil, nil, end - pos
+		},
+	}
+}
+
+// NewSamedentLexer matches the leading whitespace of a line that continues
+// the current block.
+func NewSamedentLexer() *Lexeme {
+	return &Lexeme{
+		Name: "SAMEDENT",
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			if !s.atLineStart(pos) {
+				return nil, s.expected(pos, "start of line"), 0
+			}
+			width, end := s.indentation(pos)
+			if width != s.indentWidth() || end == len(s.buf) {
+				return nil, s.expected(pos, fmt.Sprintf("indentation of %d", s.indentWidth())), 0
+			}
+			return nil, nil, end - pos
+		},
+	}
+}
+
+// NewDedentLexer closes the current block if the next line is indented less
+// than it. It consumes no input, so consecutive dedents close nested blocks.
+func NewDedentLexer() *Lexeme {
+	return &Lexeme{
+		Name: "DEDENT",
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			if s.indent == nil || !s.atLineStart(pos) {
+				return nil, s.expected(pos, "dedent"), 0
+			}
+			if width, _ := s.indentation(pos); width >= s.indent.width {
+				return nil, s.expected(pos, "dedent"), 0
+			}
+			s.indent = s.indent.prev
+			return nil, nil, 0
+		},
+	}
+}
+
+// indentBuiltins are the rules made available by the %indent pragma.
+func indentBuiltins() []*Lexeme {
+	return []*Lexeme{NewIndentLexer(), NewSamedentLexer(), NewDedentLexer()}
+}
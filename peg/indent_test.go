@@ -0,0 +1,65 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+const indentGrammar = `%indent
+prgm <- line+
+line <- SAMEDENT stmt
+stmt <- compound / simple
+compound <- name ':'^ nl^ block
+simple <- name nl^
+block <- INDENT stmt line* DEDENT
+name <- ~'[a-z]+'
+nl <- ~'\n|$'`
+
+func TestIndentation(t *testing.T) {
+	parser, err := NewParser(strings.NewReader(indentGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := parser.ParseString("a:\n  b\n\n  c:\n    d\ne\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "line+",
+		Children: []*ParseTree{
+			&ParseTree{Type: "compound", Children: []*ParseTree{
+				&ParseTree{Type: "name", Data: []byte("a")},
+				&ParseTree{Type: "block", Children: []*ParseTree{
+					&ParseTree{Type: "name", Data: []byte("b")},
+					&ParseTree{Type: "line*", Children: []*ParseTree{
+						&ParseTree{Type: "compound", Children: []*ParseTree{
+							&ParseTree{Type: "name", Data: []byte("c")},
+							&ParseTree{Type: "block", Children: []*ParseTree{
+								&ParseTree{Type: "name", Data: []byte("d")},
+								&ParseTree{Type: "line*"},
+							}},
+						}},
+					}},
+				}},
+			}},
+			&ParseTree{Type: "name", Data: []byte("e")},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		dumpTree(tree, "")
+		t.Error(err)
+	}
+}
+
+func TestInconsistentDedent(t *testing.T) {
+	parser, err := NewParser(strings.NewReader(indentGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, _ := parser.ParseString("a:\n    b\n  c\n")
+	if tree != nil && tree.End == 13 {
+		t.Errorf("inconsistent dedent was accepted: %v", tree)
+	}
+}
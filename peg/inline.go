@@ -0,0 +1,104 @@
+package peg
+
+import "strings"
+
+// inlineRules returns the rules whose nodes are replaced by their
+// children: those listed by %inline or the Inline option, and the private
+// ones, whose names, or the last part of a qualified name, start with an
+// underscore. A rule named _ alone, often the whitespace, is not private.
+func inlineRules(rules []rule, listed []string) map[string]bool {
+	inline := make(map[string]bool)
+	for _, r := range rules {
+		if r.params != nil {
+			continue
+		}
+		if name := r.name[strings.LastIndexByte(r.name, '.')+1:]; len(name) > 1 && name[0] == '_' || contains(listed, r.name) {
+			inline[r.name] = true
+		}
+	}
+	if len(inline) == 0 {
+		return nil
+	}
+	return inline
+}
+
+// inlined reports whether the nodes of type typ are replaced by their
+// children: those of inline rules and of closures of inline rules.
+func (l *Language) inlined(typ string) bool {
+	if i := strings.IndexAny(typ, "*+?{"); i > 0 {
+		typ = typ[:i]
+	}
+	return l.inline[typ]
+}
+
+// spliceInline replaces the nodes of the inline rules, and of their
+// closures, below the root of tree by their children. It copies the nodes
+// it changes, as flattenClosures does.
+func (l *Language) spliceInline(tree *ParseTree) *ParseTree {
+	if len(l.inline) == 0 || tree == nil || len(tree.Children) == 0 {
+		return tree
+	}
+	root := *tree
+	var trailing []byte
+	root.Children, trailing = l.splice(tree.Children)
+	if len(trailing) > 0 {
+		root.Trailing = append(trailing, root.Trailing...)
+	}
+	return &root
+}
+
+// splice returns kids with the nodes of inline rules replaced by their
+// children. In lossless trees the trivia of a replaced node moves to its
+// first and last children, and the input of a replaced node without
+// children moves before the next node, or is returned as trailing the
+// last one if there is none.
+func (l *Language) splice(kids []*ParseTree) ([]*ParseTree, []byte) {
+	var out []*ParseTree
+	var pending []byte // trivia waiting for the next node.
+	add := func(kid *ParseTree) {
+		if len(pending) > 0 {
+			copied := *kid
+			copied.Leading = append(pending, kid.Leading...)
+			kid, pending = &copied, nil
+		}
+		out = append(out, kid)
+	}
+	for _, kid := range kids {
+		if !l.inlined(kid.Type) {
+			if len(kid.Children) > 0 {
+				copied := *kid
+				var trailing []byte
+				copied.Children, trailing = l.splice(kid.Children)
+				if len(trailing) > 0 {
+					copied.Trailing = append(trailing, copied.Trailing...)
+				}
+				kid = &copied
+			}
+			add(kid)
+			continue
+		}
+		if len(kid.Children) == 0 {
+			if l.lossless {
+				pending = append(append(append(pending, kid.Leading...), kid.Data...), kid.Trailing...)
+			}
+			continue
+		}
+		children, trailing := l.splice(kid.Children)
+		if l.lossless {
+			pending = append(pending, kid.Leading...)
+			trailing = append(trailing, kid.Trailing...)
+		}
+		for _, child := range children {
+			add(child)
+		}
+		if len(trailing) > 0 {
+			pending = append(pending, trailing...)
+		}
+	}
+	if len(pending) > 0 && len(out) > 0 {
+		last := *out[len(out)-1]
+		last.Trailing = append(append([]byte(nil), last.Trailing...), pending...)
+		out[len(out)-1], pending = &last, nil
+	}
+	return out, pending
+}
@@ -0,0 +1,103 @@
+package peg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInline(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		grammar string
+		opts    []Option
+		input   string
+		exp     string
+	}{
+		{
+			name:    "private",
+			grammar: "list <- item _more*\n_more <- ',' item\nitem <- ~'[a-z]+'",
+			input:   "a,b,c",
+			exp:     `(list (item "a") (item "b") (item "c"))`,
+		},
+		{
+			name:    "directive",
+			grammar: "%inline more\nlist <- item more*\nmore <- ',' item\nitem <- ~'[a-z]+'",
+			input:   "a,b,c",
+			exp:     `(list (item "a") (item "b") (item "c"))`,
+		},
+		{
+			name:    "option",
+			grammar: "list <- item more*\nmore <- ',' item\nitem <- ~'[a-z]+'",
+			opts:    []Option{Inline("more")},
+			input:   "a,b,c",
+			exp:     `(list (item "a") (item "b") (item "c"))`,
+		},
+		{
+			name:    "nested",
+			grammar: "pair <- key _rest\n_rest <- ':' _values\n_values <- value ' ' value\nkey <- ~'[a-z]+'\nvalue <- ~'[a-z]+'",
+			input:   "a:b c",
+			exp:     `(pair (key "a") (value "b") (value "c"))`,
+		},
+		{
+			name:    "leaf",
+			grammar: "pair <- key _colon value\n_colon <- ':'\nkey <- ~'[a-z]+'\nvalue <- ~'[a-z]+'",
+			input:   "a:b",
+			exp:     `(pair (key "a") (value "b"))`,
+		},
+	} {
+		lang, err := NewLanguage(tc.grammar, tc.opts...)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		tree, err := lang.ParseString(tc.input)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := tree.SExpr(); got != tc.exp {
+			t.Errorf("%s: got %s, exp %s", tc.name, got, tc.exp)
+		}
+	}
+}
+
+func TestInlineLossless(t *testing.T) {
+	grammar := "%whitespace ws\nlist <- item _more* _end\n_more <- ','^ item\n_end <- ';'\nitem <- ~'[a-z]+'\nws <- ~'[ ]+'"
+	input := " a , b ,c ;"
+	lang, err := NewLanguage(grammar, Lossless(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := string(tree.Text()); text != input {
+		t.Errorf("Text() = %q, exp %q", text, input)
+	}
+	if exp := `(list (item "a") (item "b") (item "c"))`; tree.SExpr() != exp {
+		t.Errorf("got %s, exp %s", tree.SExpr(), exp)
+	}
+}
+
+func TestInlineNodeTypes(t *testing.T) {
+	lang, err := NewLanguage("%inline sep\nlist <- item _more*\n_more <- sep item\nitem <- ~'[a-z]+'\nsep <- ','")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		rule string
+		exp  []string
+	}{
+		{"_more", []string{"item"}},
+		{"sep", nil},
+	} {
+		if got := lang.NodeTypes(tc.rule); !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("NodeTypes(%s) = %v, exp %v", tc.rule, got, tc.exp)
+		}
+	}
+	if !strings.Contains(lang.Grammar(), "%inline sep\n") {
+		t.Errorf("Grammar() lacks %%inline:\n%s", lang.Grammar())
+	}
+}
@@ -0,0 +1,147 @@
+package peg
+
+import (
+	"io"
+	"sort"
+)
+
+// Input is random access to text. Matchers that look at the input through
+// Input rather than Source.Bytes don't depend on how a Source holds it, and
+// tools can look at text the same way before deciding to parse it:
+//
+//   - a *Source is an Input over its buffer, whether it holds a byte slice
+//     or, from NewFileSource, a file mapped into memory;
+//   - a *ReaderInput reads from an io.Reader as far as it is looked at;
+//   - other buffers, such as the ropes of editors, can implement it too.
+//
+// Parsing needs the whole input in one buffer, which the lexemes of the
+// grammar read directly: SourceFromInput copies any other Input into one.
+type Input interface {
+	// Len returns the length of the input.
+	Len() int
+	// Peek returns up to n bytes at pos, fewer at the end of the input.
+	Peek(pos, n int) []byte
+	// Slice returns the bytes from start to end, which must be within the
+	// input.
+	Slice(start, end int) []byte
+	// Position converts an offset into a 1-based line and column.
+	Position(offset int) (line, col int)
+}
+
+// Len returns the length of the input.
+func (s *Source) Len() int {
+	return len(s.buf)
+}
+
+// Peek returns up to n bytes at pos, fewer at the end of the input. The
+// slice must not be modified.
+func (s *Source) Peek(pos, n int) []byte {
+	if pos >= len(s.buf) {
+		return nil
+	}
+	if n > len(s.buf)-pos {
+		n = len(s.buf) - pos
+	}
+	return s.buf[pos : pos+n]
+}
+
+// Slice returns the input from start to end. The slice must not be
+// modified.
+func (s *Source) Slice(start, end int) []byte {
+	return s.buf[start:end]
+}
+
+// SourceFromInput returns a Source for parsing in. A *Source is returned
+// as it is; other inputs are read in full and copied, so parsing a
+// ReaderInput reads all of it, and a parse doesn't see later edits of the
+// buffer an Input was implemented over.
+func SourceFromInput(in Input) *Source {
+	if s, ok := in.(*Source); ok {
+		return s
+	}
+	return SourceFromBytes(append([]byte(nil), in.Slice(0, in.Len())...))
+}
+
+// ReaderInput is an Input that reads from an io.Reader on demand, so that
+// looking at the start of a long input doesn't read all of it. What has been
+// read is kept, as parsers may backtrack to any offset. Len reads the rest
+// of the input, and read errors other than io.EOF end the input early and
+// are reported by Err.
+type ReaderInput struct {
+	r     io.Reader
+	buf   []byte
+	lines []int // offsets of the line starts read so far.
+	err   error
+}
+
+// NewReaderInput returns an Input reading from r.
+func NewReaderInput(r io.Reader) *ReaderInput {
+	return &ReaderInput{r: r, lines: []int{0}}
+}
+
+// fill reads until at least n bytes are buffered or the input ends.
+func (in *ReaderInput) fill(n int) {
+	for len(in.buf) < n && in.err == nil {
+		if cap(in.buf)-len(in.buf) < 4096 {
+			grown := make([]byte, len(in.buf), 2*cap(in.buf)+4096)
+			copy(grown, in.buf)
+			in.buf = grown
+		}
+		start := len(in.buf)
+		m, err := in.r.Read(in.buf[start:cap(in.buf)])
+		in.buf = in.buf[:start+m]
+		for i, b := range in.buf[start:] {
+			if b == '\n' {
+				in.lines = append(in.lines, start+i+1)
+			}
+		}
+		in.err = err
+	}
+}
+
+// Len reads the rest of the input and returns its length.
+func (in *ReaderInput) Len() int {
+	for in.err == nil {
+		in.fill(len(in.buf) + 1)
+	}
+	return len(in.buf)
+}
+
+// Peek returns up to n bytes at pos, reading as far as needed.
+func (in *ReaderInput) Peek(pos, n int) []byte {
+	in.fill(pos + n)
+	if pos >= len(in.buf) {
+		return nil
+	}
+	if n > len(in.buf)-pos {
+		n = len(in.buf) - pos
+	}
+	return in.buf[pos : pos+n]
+}
+
+// Slice returns the input from start to end, reading as far as needed.
+func (in *ReaderInput) Slice(start, end int) []byte {
+	in.fill(end)
+	return in.buf[start:end]
+}
+
+// Position converts offset into a 1-based line and column, reading as far
+// as needed. Offsets past the end of the input are clamped.
+func (in *ReaderInput) Position(offset int) (line, col int) {
+	in.fill(offset)
+	if offset < 0 {
+		offset = 0
+	} else if offset > len(in.buf) {
+		offset = len(in.buf)
+	}
+	line = sort.Search(len(in.lines), func(i int) bool { return in.lines[i] > offset })
+	return line, offset - in.lines[line-1] + 1
+}
+
+// Err returns the error that ended reading early, if any.
+func (in *ReaderInput) Err() error {
+	if in.err == io.EOF {
+		return nil
+	}
+	return in.err
+}
@@ -0,0 +1,106 @@
+package peg
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestInput(t *testing.T) {
+	text := "ab\ncd\n\nef"
+	f, err := ioutil.TempFile("", "peg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(text)
+	f.Close()
+	file, err := NewFileSource(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for _, tt := range []struct {
+		name string
+		in   func() Input
+	}{
+		{"bytes", func() Input { return SourceFromBytes([]byte(text)) }},
+		{"file", func() Input { return file }},
+		{"reader", func() Input { return NewReaderInput(iotest.OneByteReader(strings.NewReader(text))) }},
+	} {
+		in := tt.in()
+		if got := string(in.Peek(1, 3)); got != "b\nc" {
+			t.Errorf("%s: peeked %q", tt.name, got)
+		}
+		if got := string(in.Peek(7, 5)); got != "ef" {
+			t.Errorf("%s: peeked %q at the end", tt.name, got)
+		}
+		if got := in.Peek(20, 1); got != nil {
+			t.Errorf("%s: peeked %q past the end", tt.name, got)
+		}
+		if got := string(in.Slice(3, 5)); got != "cd" {
+			t.Errorf("%s: sliced %q", tt.name, got)
+		}
+		for _, p := range []PositionTest{{0, 1, 1}, {4, 2, 2}, {7, 4, 1}, {9, 4, 3}, {50, 4, 3}} {
+			if line, col := in.Position(p.offset); line != p.line || col != p.col {
+				t.Errorf("%s: offset %d is at %d:%d, expected %d:%d", tt.name, p.offset, line, col, p.line, p.col)
+			}
+		}
+		if in.Len() != len(text) {
+			t.Errorf("%s: got length %d", tt.name, in.Len())
+		}
+
+		lang, err := NewLanguage("lines <- line+\nline <- ~'[a-z]*\\n*'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := lang.ParseSource(SourceFromInput(tt.in()))
+		if err != nil || tree.End != len(text) {
+			t.Errorf("%s: parsed %v, %v", tt.name, tree, err)
+		}
+	}
+}
+
+func TestReaderInput(t *testing.T) {
+	// Only what is looked at is read.
+	in := NewReaderInput(iotest.OneByteReader(strings.NewReader("abcdef")))
+	in.Peek(0, 2)
+	if len(in.buf) != 2 {
+		t.Errorf("read %q to peek at 2 bytes", in.buf)
+	}
+
+	fail := errors.New("broken")
+	in = NewReaderInput(&failingReader{"ab", fail})
+	if in.Len() != 2 || in.Err() != fail {
+		t.Errorf("got length %d and error %v", in.Len(), in.Err())
+	}
+	if in = NewReaderInput(strings.NewReader("ab")); in.Len() != 2 || in.Err() != nil {
+		t.Errorf("got length %d and error %v", in.Len(), in.Err())
+	}
+
+	// Sources are copies of the input.
+	s := SourceFromInput(in)
+	in.Slice(0, 2)[0] = 'x'
+	if string(s.buf) != "ab" {
+		t.Errorf("the source shares the input: %q", s.buf)
+	}
+}
+
+// failingReader returns text, then err.
+type failingReader struct {
+	text string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.text == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.text)
+	r.text = r.text[n:]
+	return n, nil
+}
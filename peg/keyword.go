@@ -0,0 +1,57 @@
+package peg
+
+import (
+	"unicode/utf8"
+)
+
+// NewKeywordLexer matches word after skipping whitespace, as a literal
+// does, but only where it is not followed by a letter, digit or underscore,
+// so that the keyword if doesn't match the start of the identifier iffy.
+func NewKeywordLexer(typ, word string) *Lexeme {
+	wbytes, quoted := []byte(word), quoteLiteral(word)
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: typ,
+		kind: kindCall,
+		text: "keyword(" + quoteLiteral(word) + ")",
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			skip := s.skipWhitespace(pos)
+			pos += skip
+			match := s.ConsumeLiteral(wbytes, pos)
+			if match == nil {
+				s.starveLiteral(wbytes, pos, false)
+				s.complete(typ, pos, quoted, word, false)
+				return nil, s.expected(pos, quoted), 0
+			}
+			end := pos + len(match)
+			if end == len(s.buf) {
+				// The word may yet continue.
+				s.Starve(1)
+			} else if r, _ := utf8.DecodeRune(s.buf[end:]); isIdentTailRune(r) {
+				return nil, s.expected(pos, quoted), 0
+			}
+			return s.leaf(&ParseTree{
+				Type:    typ,
+				ID:      id.get(s),
+				Data:    wbytes,
+				Pos:     pos,
+				End:     end,
+				Leading: s.skipped(pos, skip),
+			}), nil, skip + len(match)
+		},
+	}
+}
+
+// reservedIn returns the set of the keywords of %keywords if rule is one
+// of the %identifier rules, whose matches must not be keywords, and nil
+// otherwise.
+func (d *directives) reservedIn(rule string) map[string]bool {
+	if len(d.keywords) == 0 || !contains(d.identifiers, rule) {
+		return nil
+	}
+	reserved := make(map[string]bool, len(d.keywords))
+	for _, word := range d.keywords {
+		reserved[word] = true
+	}
+	return reserved
+}
@@ -0,0 +1,79 @@
+package peg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestKeyword(t *testing.T) {
+	const keywords = "%keywords if else\n%identifier name\n%whitespace ws\nstmt <- cond / name / note\ncond <- 'if' name 'else' name\nname <- ~'[a-z]+'\nnote <- '#' ~'[a-z]+'\nws <- ' '"
+	for _, tt := range []struct {
+		grammar string
+		input   string
+		leaves  string // the leaves joined with commas, or "" if the parse fails.
+	}{
+		{"stmt <- keyword('if') ~'[a-z]+'", "if", ""},
+		{"stmt <- keyword('if') ' ' ~'[a-z]+'", "if x", "if, ,x"},
+		{"stmt <- keyword('if') / ~'[a-z]+'", "iffy", "iffy"},
+		{"stmt <- keyword('if') / ~'[a-z_]+'", "if_", "if_"},
+		{"stmt <- keyword('if') ~'[(]'", "if(", "if,("},
+		{keywords, "if a else b", "if,a,else,b"},
+		{keywords, "iffy", "iffy"},
+		{keywords, "elsewhere", "elsewhere"},
+		{keywords, "if else else b", ""},
+		{keywords, "else", ""},
+		{keywords, "#else", "#,else"},
+		{"%keywords if\nstmt <- name\nname <- ~'[a-z]+'", "if", "if"},
+		{"%keywords if\n%identifier name\n%token name\nstmt <- name\nname <- ~'[a-z]' ~'[a-z]*'", "if", ""},
+		{"%keywords if\n%identifier name\n%token name\nstmt <- name\nname <- ~'[a-z]' ~'[a-z]*'", "iffy", "iffy"},
+	} {
+		lang, err := NewLanguage(tt.grammar)
+		if err != nil {
+			t.Errorf("%q: %s", tt.grammar, err)
+			continue
+		}
+		tree, err := lang.ParseString(tt.input)
+		if err == nil && tree.End != len(tt.input) {
+			err = errors.New(fmt.Sprintf("stopped at offset %d", tree.End))
+		}
+		if tt.leaves == "" {
+			if err == nil {
+				t.Errorf("%q on %q: expected an error", tt.grammar, tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q on %q: %s", tt.grammar, tt.input, err)
+			continue
+		}
+		var leaves []string
+		var walk func(node *ParseTree)
+		walk = func(node *ParseTree) {
+			if node.Data != nil {
+				leaves = append(leaves, string(node.Data))
+			}
+			for _, child := range node.Children {
+				walk(child)
+			}
+		}
+		walk(tree)
+		if got := strings.Join(leaves, ","); got != tt.leaves {
+			t.Errorf("%q on %q: got leaves %q, want %q", tt.grammar, tt.input, got, tt.leaves)
+		}
+	}
+
+	lang, err := NewLanguage("%keywords if\n%identifier stmt\nstmt <- 'if' keyword('do')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := lang.Grammar(); g != "%keywords if\n%identifier stmt\nstmt <- 'if' keyword('do')\n" {
+		t.Errorf("Grammar() = %q", g)
+	}
+	for _, grammar := range []string{"%keywords\nstmt <- 'if'", "stmt <- keyword()", "%identifier\nstmt <- 'if'", "%identifier name\nstmt <- 'if'"} {
+		if _, err := NewLanguage(grammar); err == nil {
+			t.Errorf("expected an error for %q", grammar)
+		}
+	}
+}
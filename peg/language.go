@@ -1,19 +1,32 @@
 package peg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"regexp"
+	"regexp/syntax"
 	"strings"
+	"sync"
+	"time"
 )
 
+// LexFunc matches input starting at the given position. It returns the parse
+// tree, an error and the number of input bytes consumed.
+type LexFunc func(*Source, int) (*ParseTree, error, int)
+
 type Lexeme struct {
 	Name         string
 	Dependencies []*Lexeme
-	isResolved   bool // whether the deps are resolved.
-	// Lexer returns the parse tree, an error and the number of input bytes consumed.
-	Lexer func(*Source, int) (*ParseTree, error, int)
+	isResolved   bool                         // whether the deps are resolved.
+	merge        bool                         // whether repetitions form a single leaf.
+	kind         kind                         // the construct the lexeme was built from.
+	text         string                       // the literal, pattern, label or name it was built with.
+	follow       []*Lexeme                    // what a lazy closure stops before.
+	unparse      func(leaf *ParseTree) []byte // writes leaves whose Data is not their source.
+	Lexer        LexFunc
 }
 
 func (l *Lexeme) dumpTree(indent string) string {
@@ -28,9 +41,100 @@ func (l *Lexeme) String() string {
 	return l.dumpTree("")
 }
 
+// PredicateFunc reports whether parsing may continue at pos. Predicates
+// consume no input; the parse state is available through s.State().
+type PredicateFunc func(s *Source, pos int) bool
+
 // Language defines lexing and parsing capabilities for a peg defined language.
 type Language struct {
-	root *Lexeme
+	root         *Lexeme
+	rules        []rule                   // the rules of the grammar in definition order.
+	directives   directives               // the %pragmas of the grammar.
+	whitespace   *Lexeme                  // skipped before literals and regexps.
+	space        recognizer               // matches a run of whitespace at once, if it can.
+	matchers     map[string]LexFunc       // external matchers referenced by @name.
+	predicates   map[string]PredicateFunc // semantic predicates referenced by &{name}.
+	keepSingle   bool                     // whether sequences keep a lone child wrapped.
+	singles      map[string]bool          // per rule overrides of keepSingle.
+	inline       map[string]bool          // the rules whose nodes are spliced into their parents.
+	lossless     bool                     // whether trees keep discarded input.
+	dropEmpty    bool                     // whether closures that match nothing produce no node.
+	decode       bool                     // whether input is transcoded to UTF-8 before parsing.
+	fallback     Encoding                 // the encoding of input without a byte order mark.
+	flatten      bool                     // whether closures that repeat through their children produce one node.
+	tolerant     bool                     // whether parse errors are recovered from.
+	profile      bool                     // whether parses collect rule statistics.
+	graphemes    bool                     // whether any matches grapheme clusters.
+	maxCalls     int                      // rule invocations allowed per parse, if positive.
+	maxBacktrack int                      // backtracked bytes allowed per parse, if positive.
+	maxDepth     int                      // rule nesting allowed per parse, if positive.
+	maxNodes     int                      // nodes built per parse, if positive.
+	maxTreeBytes int                      // bytes of nodes built per parse, if positive.
+	traceOut     io.Writer                // where parses write the rules they try, if set.
+	collector    *Collector               // gathers metrics of the parses, if set.
+	tracer       Tracer                   // starts spans for the parses, if set.
+	spanDepth    int                      // how deep rules get spans of their own.
+	logger       *slog.Logger             // logs the parses, if set.
+	config       directives               // the directives set by options.
+	source       string                   // the text of the grammar.
+	added        []rule                   // the rules added with AddRule and ReplaceRule.
+	bound        []rule                   // the rules defined with WithRule.
+	opts         []Option                 // the options the language was built with.
+	types        typeTable                // the node types interned by parses.
+	statsMu      sync.Mutex
+	stats        map[string]*RuleStats
+}
+
+// Option configures a Language constructed by NewParser or NewLanguage.
+type Option func(*Language)
+
+// CollapseSingletons sets whether a sequence that produces a single child,
+// for instance because the other parts were discarded, is replaced by that
+// child. Collapsing is the default. If rules are given, the setting only
+// applies to the sequences of those rules.
+func CollapseSingletons(collapse bool, rules ...string) Option {
+	return func(l *Language) {
+		if len(rules) == 0 {
+			l.keepSingle = !collapse
+			return
+		}
+		if l.singles == nil {
+			l.singles = make(map[string]bool)
+		}
+		for _, rule := range rules {
+			l.singles[rule] = !collapse
+		}
+	}
+}
+
+// collapses reports whether sequences of rule replace a lone child by it.
+func (l *Language) collapses(rule string) bool {
+	if l == nil {
+		return true
+	}
+	if keep, ok := l.singles[rule]; ok {
+		return !keep
+	}
+	return !l.keepSingle
+}
+
+// Register makes fn available to the grammar as the external matcher @name.
+// Matchers are looked up when the input is parsed, so they can be registered
+// after the language is constructed, but not concurrently with a parse.
+func (l *Language) Register(name string, fn LexFunc) {
+	if l.matchers == nil {
+		l.matchers = make(map[string]LexFunc)
+	}
+	l.matchers[name] = fn
+}
+
+// RegisterPredicate makes fn available to the grammar as the semantic
+// predicate &{name}. Like matchers, predicates are looked up at parse time.
+func (l *Language) RegisterPredicate(name string, fn PredicateFunc) {
+	if l.predicates == nil {
+		l.predicates = make(map[string]PredicateFunc)
+	}
+	l.predicates[name] = fn
 }
 
 // ParseString is identical to Parse, but operates on string input.
@@ -38,59 +142,317 @@ func (l *Language) ParseString(source string) (*ParseTree, error) {
 	return l.Parse(strings.NewReader(source))
 }
 
+// MustParseString is like ParseString but panics if source does not parse,
+// for tests and for input known to be valid.
+func (l *Language) MustParseString(source string) *ParseTree {
+	tree, err := l.ParseString(source)
+	if err != nil {
+		panic(err)
+	}
+	return tree
+}
+
+// ParseBytes is identical to Parse, but operates on in-memory input.
+// The slice is used directly as the source buffer, so it must not be
+// modified while the returned tree is in use.
+func (l *Language) ParseBytes(source []byte) (*ParseTree, error) {
+	return l.parse(SourceFromBytes(source))
+}
+
 // Parse attemps to turn the input reader into a valid parse tree.
 func (l *Language) Parse(source io.Reader) (*ParseTree, error) {
 	s, err := NewSource(source)
 	if err != nil {
 		return nil, err
 	}
-	tree, err, _ := l.root.Lexer(s, 0)
+	return l.parse(s)
+}
+
+// ParseSource parses an already constructed source, such as one returned
+// by NewFileSource.
+func (l *Language) ParseSource(s *Source) (*ParseTree, error) {
+	return l.parse(s)
+}
+
+// ParseWithState parses input with state attached to the source, as with
+// SetState, so that predicates and external matchers can consult symbol
+// tables or settings of this parse through s.State() rather than globals.
+func (l *Language) ParseWithState(input io.Reader, state interface{}) (*ParseTree, error) {
+	s, err := NewSource(input)
+	if err != nil {
+		return nil, err
+	}
+	s.SetState(state)
+	return l.parse(s)
+}
+
+// skipWhitespace consumes repetitions of the %whitespace rule at pos and
+// returns their length.
+func (s *Source) skipWhitespace(pos int) int {
+	if s.lang == nil || s.lang.whitespace == nil || s.skipping {
+		return 0
+	}
+	if s.lang.space != nil && !s.inspected() {
+		if n := s.lang.space(s, pos); n > 0 {
+			return n
+		}
+		return 0
+	}
+	s.skipping = true
+	ntokens := s.ntokens
+	defer func() { s.skipping, s.ntokens = false, ntokens }()
+	offset := 0
+	for {
+		m := s.mark()
+		_, err, n := s.lang.whitespace.Lexer(s, pos+offset)
+		if err != nil || n == 0 {
+			s.reset(m)
+			return offset
+		}
+		offset += n
+	}
+}
+
+// skipped returns the whitespace skipped before pos as trivia.
+func (s *Source) skipped(pos, skip int) []byte {
+	if skip == 0 || !s.lossless() {
+		return nil
+	}
+	return s.buf[pos-skip : pos]
+}
+
+func (l *Language) parse(s *Source) (*ParseTree, error) {
+	return l.parseFrom(l.root, s)
+}
+
+// ParseRule parses s with the rule name of the grammar instead of the root
+// rule.
+func (l *Language) ParseRule(name string, s *Source) (*ParseTree, error) {
+	for _, r := range l.rules {
+		if r.name == name && r.params == nil {
+			return l.parseFrom(r.lex, s)
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("undefined rule %s", name))
+}
+
+func (l *Language) parseFrom(root *Lexeme, s *Source) (*ParseTree, error) {
+	if err := l.decodeInput(s); err != nil {
+		return nil, err
+	}
+	l.normalize(s)
+	tree, err, _ := l.parseAt(root, s, 0, l.tolerant)
 	return tree, err
 }
 
+// parseAt matches root at pos of s, returning the tree and the length of
+// the match.
+func (l *Language) parseAt(root *Lexeme, s *Source, pos int, tolerant bool) (tree *ParseTree, err error, n int) {
+	s.lang = l
+	if l.profile {
+		s.stats, s.visited = make(map[string]*RuleStats), make(map[memoKey]bool)
+		defer l.addStats(s.stats)
+	}
+	s.budget, s.nwarnings = nil, 0
+	// The span of the parse ends after the budget recovers from an abort,
+	// and after the spans of the rules, so it is started first.
+	var ctx context.Context
+	if l.tracer != nil {
+		var end func(error)
+		ctx, end = l.startParseSpan(root, s)
+		defer func() { end(err) }()
+	}
+	if l.logger != nil {
+		start := time.Now()
+		defer func() { l.logParse(root, s, start, err) }()
+	}
+	if s.listener == nil {
+		var ls listeners
+		if l.traceOut != nil {
+			ls = append(ls, &traceWriter{w: l.traceOut})
+		}
+		if l.collector != nil {
+			cl := &collectorListener{c: l.collector}
+			ls = append(ls, cl)
+			defer cl.abort()
+		}
+		if l.tracer != nil && l.spanDepth > 0 {
+			sl := &spanListener{tracer: l.tracer, depth: l.spanDepth, ctxs: []context.Context{ctx}}
+			ls = append(ls, sl)
+			defer sl.abort()
+		}
+		if l.logger != nil && l.logger.Enabled(s.logContext(), slog.LevelDebug) {
+			ls = append(ls, &logListener{logger: l.logger, ctx: s.logContext()})
+		}
+		if len(ls) == 1 {
+			s.listener = ls[0]
+		} else if len(ls) > 1 {
+			s.listener = ls
+		}
+		if len(ls) > 0 {
+			defer s.Listen(nil)
+		}
+	}
+	if l.maxCalls > 0 || l.maxBacktrack > 0 || l.maxDepth > 0 || l.maxNodes > 0 || l.maxTreeBytes > 0 || s.ctx != nil || l.tracer != nil {
+		s.budget = &budget{}
+	}
+	defer catchAbort(&tree, &err)
+	if tolerant {
+		tree, err = l.parseTolerant(root, s)
+		return l.spliceInline(l.flattenClosures(tree)), err, len(s.buf)
+	}
+	tree, err, n = root.Lexer(s, pos)
+	return l.spliceInline(l.flattenClosures(tree)), err, n
+}
+
 func NewLiteralLexer(typ, valid string) *Lexeme {
-	vbytes := []byte(valid)
+	vbytes, quoted := []byte(valid), quoteLiteral(valid)
+	id := newNodeType(typ)
 	return &Lexeme{
 		Name: typ,
+		kind: kindLiteral,
+		text: valid,
 		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			skip := s.skipWhitespace(pos)
+			pos += skip
 			match := s.ConsumeLiteral(vbytes, pos)
 			if match == nil {
-				neighborhood := pos
-				neighborEnd := pos + 10
-				if neighborEnd > len(s.buf) {
-					neighborEnd = len(s.buf)
-				}
-
-				return nil, errors.New(fmt.Sprintf("expected literal: %q at %q", valid, s.buf[neighborhood:neighborEnd])), 0
+				s.starveLiteral(vbytes, pos, false)
+				s.complete(typ, pos, quoted, valid, false)
+				return nil, s.expected(pos, quoted), 0
 			} else {
-				return &ParseTree{
-					Type: typ,
-					Data: vbytes,
-				}, nil, len(match)
+				return s.leaf(&ParseTree{
+					Type:    typ,
+					ID:      id.get(s),
+					Data:    vbytes,
+					Pos:     pos,
+					End:     pos + len(match),
+					Leading: s.skipped(pos, skip),
+				}), nil, skip + len(match)
 			}
 		},
 	}
 }
 
-func NewRegexpLexer(typ string, valid *regexp.Regexp) *Lexeme {
+// NewFoldLiteralLexer matches valid regardless of case. The leaf holds the
+// text of the input rather than the literal.
+func NewFoldLiteralLexer(typ, valid string) *Lexeme {
+	vbytes, quoted := []byte(valid), quoteLiteral(valid)
+	id := newNodeType(typ)
 	return &Lexeme{
 		Name: typ,
+		kind: kindLiteral,
+		text: valid,
 		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
-			match := s.Consume(valid, pos)
+			skip := s.skipWhitespace(pos)
+			match := s.ConsumeLiteralFold(vbytes, pos+skip)
 			if match == nil {
-				neighborhood := pos
-				neighborEnd := pos + 10
-				if neighborEnd > len(s.buf) {
-					neighborEnd = len(s.buf)
-				}
+				s.starveLiteral(vbytes, pos+skip, true)
+				s.complete(typ, pos+skip, quoted, valid, true)
+				return nil, s.expected(pos+skip, quoted), 0
+			}
+			return s.leaf(&ParseTree{
+				Type:    typ,
+				ID:      id.get(s),
+				Data:    match,
+				Pos:     pos + skip,
+				End:     pos + skip + len(match),
+				Leading: s.skipped(pos+skip, skip),
+			}), nil, skip + len(match)
+		},
+	}
+}
+
+func NewRegexpLexer(typ string, valid *regexp.Regexp) *Lexeme {
+	return newRegexpLexer(typ, valid, nil)
+}
 
-				return nil, errors.New(fmt.Sprintf("expected regex match: %q at %q", valid.String(), s.buf[neighborhood:neighborEnd])), 0
+// newRegexpLexer is NewRegexpLexer for the %identifier rules of grammars
+// with %keywords, whose leaves fail where the whole match is one of the
+// reserved words.
+func newRegexpLexer(typ string, valid *regexp.Regexp, reserved map[string]bool) *Lexeme {
+	// Streams need the program of the regexp to tell whether more input
+	// could change the match.
+	var once sync.Once
+	var prog *syntax.Prog
+	compiled := func() *syntax.Prog {
+		once.Do(func() { prog = compileProg(valid.String()) })
+		return prog
+	}
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: typ,
+		kind: kindRegexp,
+		text: valid.String(),
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			skip := s.skipWhitespace(pos)
+			pos += skip
+			match := s.consumeRegexp(valid, compiled, pos)
+			if match == nil {
+				s.complete(typ, pos, "~`"+valid.String()+"`", "", false)
+				return nil, s.expected(pos, "~`"+valid.String()+"`"), 0
+			} else if reserved[string(match)] {
+				return nil, s.failed(pos, fmt.Sprintf("%s is a keyword", match)), 0
 			} else {
-				return &ParseTree{
-					Type: typ,
-					Data: match,
-				}, nil, len(match)
+				return s.leaf(&ParseTree{
+					Type:    typ,
+					ID:      id.get(s),
+					Data:    match,
+					Pos:     pos,
+					End:     pos + len(match),
+					Leading: s.skipped(pos, skip),
+				}), nil, skip + len(match)
+			}
+		},
+	}
+}
+
+// NewExternalLexer delegates matching to the function registered under name
+// on the language being parsed.
+func NewExternalLexer(name string) *Lexeme {
+	return &Lexeme{
+		Name: "@" + name,
+		kind: kindExternal,
+		text: name,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			var fn LexFunc
+			if s.lang != nil {
+				fn = s.lang.matchers[name]
+			}
+			if fn == nil {
+				return nil, errors.New(fmt.Sprintf("no matcher registered for @%s", name)), 0
+			}
+			defer s.guard("@"+name, pos)
+			tree, err, n := fn(s, pos)
+			if tree != nil && s.tokenize {
+				s.leaves(tree)
+				tree = nil
 			}
+			return tree, err, n
+		},
+	}
+}
+
+// NewPredicateLexer succeeds without consuming input if the predicate
+// registered under name holds at the current position.
+func NewPredicateLexer(name string) *Lexeme {
+	return &Lexeme{
+		Name: "&{" + name + "}",
+		kind: kindPredicate,
+		text: name,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			var fn PredicateFunc
+			if s.lang != nil {
+				fn = s.lang.predicates[name]
+			}
+			if fn == nil {
+				return nil, errors.New(fmt.Sprintf("no predicate registered for &{%s}", name)), 0
+			}
+			defer s.guard("&{"+name+"}", pos)
+			if !fn(s, pos) {
+				return nil, s.failed(pos, "predicate &{"+name+"} failed"), 0
+			}
+			return nil, nil, 0
 		},
 	}
 }
@@ -98,6 +460,8 @@ func NewRegexpLexer(typ string, valid *regexp.Regexp) *Lexeme {
 func NewRuleLexer(rule string) *Lexeme {
 	return &Lexeme{
 		Name:  "~" + rule,
+		kind:  kindRule,
+		text:  rule,
 		Lexer: nil,
 	}
 }
@@ -106,85 +470,261 @@ func NewConcatLexer(name string, deps []*Lexeme) *Lexeme {
 	return &Lexeme{
 		Name:         name,
 		Dependencies: deps,
-		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
-			children := make([]*ParseTree, 0, len(deps))
-			offset := 0
-			for _, dep := range deps {
-				tree, err, l := dep.Lexer(s, pos+offset)
-				if err != nil {
-					return nil, err, 0
-				} else {
-					if tree != nil {
-						children = append(children, tree)
-					}
-					offset += l
+		kind:         kindConcat,
+		Lexer:        concatLexer(name, deps, nil),
+	}
+}
+
+// concatLexer matches deps in sequence. Where runs has an entry for a
+// dependency, the run of literals starting there is compared at once
+// before matching them one by one.
+func concatLexer(name string, deps []*Lexeme, runs map[int]*literalRun) LexFunc {
+	id := newNodeType(name)
+	return func(s *Source, pos int) (*ParseTree, error, int) {
+		b := treeBuilder{s: s, children: make([]*ParseTree, 0, len(deps))}
+		offset := 0
+		for i := 0; i < len(deps); i++ {
+			if run, ok := runs[i]; ok {
+				if n := run.match(s, pos+offset, &b); n > 0 {
+					offset += n
+					i += len(run.literals) - 1
+					continue
 				}
 			}
-			if len(children) == 1 {
-				return children[0], nil, offset
+			dep := deps[i]
+			tree, err, l := dep.Lexer(s, pos+offset)
+			if err != nil {
+				node, skip, missing, ok := s.recover(deps[i:], pos+offset, err)
+				if !ok {
+					return nil, err, 0
+				}
+				b.add(node, pos+offset, skip)
+				offset += skip
+				if missing {
+					part := missingPart{dep, pos + offset}
+					s.missing[part] = true
+					defer delete(s.missing, part)
+					continue
+				}
+				tree, err, l = dep.Lexer(s, pos+offset)
 			}
-			return &ParseTree{Type: name, Data: nil, Children: children}, nil, offset
-		},
+			b.add(tree, pos+offset, l)
+			offset += l
+		}
+		if s.tokenize {
+			return nil, nil, offset
+		}
+		children, trailing := b.done()
+		if len(children) == 1 && s.lang.collapses(name) {
+			return children[0], nil, offset
+		}
+		return s.node(&ParseTree{Type: name, ID: id.get(s), Data: nil, Children: children, Pos: pos, End: pos + offset, Trailing: trailing}), nil, offset
 	}
 }
 
 func NewPlusClosure(lex *Lexeme) *Lexeme {
-	return &Lexeme{
+	var typ lazyType
+	plus := &Lexeme{
 		Name:         lex.Name + "+",
 		Dependencies: []*Lexeme{lex},
-		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
-			start := pos
-			resp := &ParseTree{Type: lex.Name + "+"}
-			next, err, off := lex.Lexer(s, pos)
+		kind:         kindPlus,
+	}
+	plus.Lexer = func(s *Source, pos int) (*ParseTree, error, int) {
+		start := pos
+		resp := &ParseTree{}
+		resp.Type, resp.ID = typ.get(s, lex, "+")
+		b := treeBuilder{s: s}
+		pos, r := s.resume(plus, pos, &b)
+		off := pos - start
+		if off == 0 {
+			next, err, n := lex.Lexer(s, pos)
 			if err != nil {
+				r.end()
 				return nil, err, 0
-			} else {
-				resp.Children = append(resp.Children, next)
-				pos += off
-				for {
-					next, err, off = lex.Lexer(s, pos)
-					if err != nil {
-						break
-					}
-					resp.Children = append(resp.Children, next)
-					pos += off
+			}
+			b.add(next, pos, n)
+			pos += n
+			if off = n; off > 0 {
+				r.step(pos)
+			}
+		}
+		for off > 0 {
+			m := s.mark()
+			next, err, n := lex.Lexer(s, pos)
+			if err != nil {
+				s.reset(m)
+				node, skip, _, ok := s.recover([]*Lexeme{lex}, pos, err)
+				if !ok {
+					break
 				}
+				b.add(node, pos, skip)
+				pos += skip
+				off = skip
+				continue
 			}
+			b.add(next, pos, n)
+			pos += n
+			off = n
+			r.step(pos)
+		}
+		r.end()
 
-			return resp, nil, pos - start
-		},
+		if s.tokenize {
+			return nil, nil, pos - start
+		}
+		resp.Children, resp.Trailing = b.done()
+		resp.Pos, resp.End = start, pos
+		return s.node(resp), nil, pos - start
 	}
+	return plus
 }
 
 func NewStarClosure(lex *Lexeme) *Lexeme {
-	return &Lexeme{
+	var typ lazyType
+	star := &Lexeme{
 		Name:         lex.Name + "*",
 		Dependencies: []*Lexeme{lex},
-		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
-			start := pos
-			resp := &ParseTree{Type: lex.Name + "*"}
-			var next *ParseTree
-			var err error
-			var off int
-			for {
-				next, err, off = lex.Lexer(s, pos)
-				if err != nil {
+		kind:         kindStar,
+	}
+	star.Lexer = func(s *Source, pos int) (*ParseTree, error, int) {
+		start := pos
+		resp := &ParseTree{}
+		resp.Type, resp.ID = typ.get(s, lex, "*")
+		b := treeBuilder{s: s}
+		pos, r := s.resume(star, pos, &b)
+		var next *ParseTree
+		var err error
+		var off int
+		for {
+			m := s.mark()
+			next, err, off = lex.Lexer(s, pos)
+			if err != nil {
+				s.reset(m)
+				node, skip, _, ok := s.recover([]*Lexeme{lex}, pos, err)
+				if !ok {
 					break
 				}
-				resp.Children = append(resp.Children, next)
-				pos += off
+				b.add(node, pos, skip)
+				pos += skip
+				continue
 			}
-			return resp, nil, pos - start
-		},
+			b.add(next, pos, off)
+			pos += off
+			// A match that consumed nothing would match forever.
+			if off == 0 {
+				break
+			}
+			r.step(pos)
+		}
+		r.end()
+		if s.tokenize {
+			return nil, nil, pos - start
+		}
+		resp.Children, resp.Trailing = b.done()
+		resp.Pos, resp.End = start, pos
+		if s.dropsEmpty(resp) {
+			return nil, nil, 0
+		}
+		return s.node(resp), nil, pos - start
 	}
+	return star
+}
+
+// NewLazyClosure matches lex at least min times and then as few times as
+// it takes for the rest of the sequence to match, as x*? and x+? do in a
+// grammar. The parser sets what follows the closure in its sequence; at the
+// end of a rule, nothing does, and the closure stops after min matches. The
+// nodes it produces are those of NewStarClosure or NewPlusClosure, except
+// that repetitions of lexemes such as any form a single leaf, as they do in
+// NewRepeatLexer.
+func NewLazyClosure(lex *Lexeme, min int) *Lexeme {
+	suffix := "*"
+	if min > 0 {
+		suffix = "+"
+	}
+	var typ lazyType
+	lazy := &Lexeme{
+		Name:         lex.Name + suffix,
+		Dependencies: []*Lexeme{lex},
+		kind:         kindLazy,
+		text:         suffix + "?",
+	}
+	lazy.Lexer = func(s *Source, pos int) (*ParseTree, error, int) {
+		start := pos
+		resp := &ParseTree{}
+		resp.Type, resp.ID = typ.get(s, lex, suffix)
+		b := treeBuilder{s: s}
+		ntokens := s.ntokens
+		for count := 0; count < min || !s.lookahead(lazy.follow, pos); count++ {
+			m := s.mark()
+			next, err, off := lex.Lexer(s, pos)
+			if err != nil {
+				s.reset(m)
+				if count < min {
+					return nil, err, 0
+				}
+				// The rest of the sequence fails here, and says why.
+				break
+			}
+			if !lex.merge {
+				b.add(next, pos, off)
+			}
+			pos += off
+			if off == 0 {
+				break
+			}
+		}
+		if s.tokenize {
+			if lex.merge {
+				s.ntokens = ntokens
+				s.token(resp.Type, start, pos)
+			}
+			return nil, nil, pos - start
+		}
+		if lex.merge {
+			resp.Data = s.buf[start:pos]
+		} else {
+			resp.Children, resp.Trailing = b.done()
+		}
+		resp.Pos, resp.End = start, pos
+		if s.dropsEmpty(resp) {
+			return nil, nil, 0
+		}
+		return s.node(resp), nil, pos - start
+	}
+	return lazy
+}
+
+// lookahead reports whether the sequence seq matches at pos, leaving the
+// source as it was. An empty sequence matches anywhere, so that lazy
+// closures at the end of a rule stop as early as they can.
+func (s *Source) lookahead(seq []*Lexeme, pos int) bool {
+	if len(seq) == 0 {
+		return true
+	}
+	m := s.mark()
+	defer s.reset(m)
+	for _, lex := range seq {
+		_, err, n := lex.Lexer(s, pos)
+		if err != nil {
+			return false
+		}
+		pos += n
+	}
+	return true
 }
 
 func NewOptionClosure(lex *Lexeme) *Lexeme {
 	return &Lexeme{
 		Name:         lex.Name + "?",
 		Dependencies: []*Lexeme{lex},
+		kind:         kindOption,
 		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
-			tree, _, offset := lex.Lexer(s, pos)
+			m := s.mark()
+			tree, err, offset := lex.Lexer(s, pos)
+			if err != nil {
+				s.reset(m)
+			}
 			return tree, nil, offset
 		},
 	}
@@ -194,11 +734,14 @@ func NewAlternateLexer(name string, lhs, rhs *Lexeme) *Lexeme {
 	return &Lexeme{
 		Name:         name,
 		Dependencies: []*Lexeme{lhs, rhs},
+		kind:         kindAlternate,
 		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			m := s.mark()
 			tree, err, off := lhs.Lexer(s, pos)
 			if err == nil {
 				return tree, nil, off
 			} else {
+				s.reset(m)
 				tree, err, off = rhs.Lexer(s, pos)
 				if err != nil {
 					return nil, err, 0
@@ -209,12 +752,43 @@ func NewAlternateLexer(name string, lhs, rhs *Lexeme) *Lexeme {
 	}
 }
 
+// NewChoiceLexer tries each alternative in order and returns the first
+// match. If none matches, the expectations of those that got furthest are
+// merged into a single error.
+func NewChoiceLexer(name string, alts ...*Lexeme) *Lexeme {
+	return &Lexeme{
+		Name:         name,
+		Dependencies: alts,
+		kind:         kindChoice,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			var errs []error
+			for _, alt := range alts {
+				m := s.mark()
+				tree, err, off := alt.Lexer(s, pos)
+				if err == nil {
+					return tree, nil, off
+				}
+				s.reset(m)
+				errs = append(errs, err)
+			}
+			return nil, mergeErrors(errs), 0
+		},
+	}
+}
+
 func NewDiscardLexer(lex *Lexeme) *Lexeme {
 	return &Lexeme{
 		Name:         lex.Name + "^",
 		Dependencies: []*Lexeme{lex},
+		kind:         kindDiscard,
 		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
-			_, _, offset := lex.Lexer(s, pos)
+			m := s.mark()
+			_, err, offset := lex.Lexer(s, pos)
+			if err != nil {
+				s.reset(m)
+			}
+			// Discarded leaves are no tokens either.
+			s.ntokens = m.ntokens
 			return nil, nil, offset
 		},
 	}
@@ -1,6 +1,7 @@
 package peg
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -19,3 +20,184 @@ func TestSimpleLanguage(t *testing.T) {
 		t.Errorf("Incorrect type parsed: %s", tree.Type)
 	}
 }
+
+func TestParseBytes(t *testing.T) {
+	l, err := NewParser(strings.NewReader("prgm <- ~'[a-z]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := []byte("source")
+	tree, err := l.ParseBytes(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(tree.Data) != "source" {
+		t.Errorf("Incorrect data parsed: %q", tree.Data)
+	}
+	if &tree.Data[0] != &input[0] {
+		t.Errorf("ParseBytes copied the input")
+	}
+}
+
+func TestChoiceLexer(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("prgm <- 'a' / 'b' / ~'[0-9]' / 'b'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := lang.root.Dependencies[0]; body.kind != kindChoice || len(body.Dependencies) != 4 {
+		t.Errorf("expected a flat choice, got:\n%s", body)
+	}
+	for _, input := range []string{"a", "b", "7"} {
+		if _, err := lang.ParseString(input); err != nil {
+			t.Error(input, err)
+		}
+	}
+	_, err = lang.ParseString("x")
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected a ParseError, got %v", err)
+	}
+	if exp := "expected 'a', 'b' or ~`[0-9]` at offset 0: \"x\""; perr.Error() != exp {
+		t.Errorf("got %q, exp %q", perr.Error(), exp)
+	}
+}
+
+func TestChoiceFurthestError(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("prgm <- ab / 'c'\nab <- 'a' 'b'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lang.ParseString("ax")
+	if perr, ok := err.(*ParseError); !ok || perr.Pos != 1 || len(perr.Expected) != 1 {
+		t.Errorf("expected the error of the longest alternative, got %v", err)
+	}
+}
+
+func TestCollapseSingletons(t *testing.T) {
+	grammar := "prgm <- ' '^ a\na <- ' '^ 'a'"
+	for _, tc := range []struct {
+		opts []Option
+		exp  *ParseTree
+	}{
+		{nil, &ParseTree{Type: "a", Data: []byte("a")}},
+		{[]Option{CollapseSingletons(false)}, &ParseTree{
+			Type: "prgm",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a", Children: []*ParseTree{&ParseTree{Type: "a", Data: []byte("a")}}},
+			},
+		}},
+		{[]Option{CollapseSingletons(false, "prgm")}, &ParseTree{
+			Type:     "prgm",
+			Children: []*ParseTree{&ParseTree{Type: "a", Data: []byte("a")}},
+		}},
+		{[]Option{CollapseSingletons(false), CollapseSingletons(true, "a")}, &ParseTree{
+			Type:     "prgm",
+			Children: []*ParseTree{&ParseTree{Type: "a", Data: []byte("a")}},
+		}},
+	} {
+		lang, err := NewParser(strings.NewReader(grammar), tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := lang.ParseString("  a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := treeCompare(tree, tc.exp); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("list <- item+\nitem <- ~'[a-z]+' ' '^\nnumber <- ~'[0-9]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseRule("number", SourceFromBytes([]byte("42")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Type != "number" || string(tree.Data) != "42" {
+		t.Errorf("unexpected tree:\n%s", tree)
+	}
+	if _, err := lang.ParseRule("missing", SourceFromBytes([]byte("42"))); err == nil {
+		t.Errorf("expected an error for an undefined rule")
+	}
+}
+
+var lazyTestTable = []ParseTest{
+	ParseTest{
+		"comment <- '/*' any*? '*/'",
+		"/* a */ b */",
+		&ParseTree{
+			Type: "comment",
+			Children: []*ParseTree{
+				&ParseTree{Type: "comment", Data: []byte("/*")},
+				&ParseTree{Type: "any*", Data: []byte(" a ")},
+				&ParseTree{Type: "comment", Data: []byte("*/")},
+			},
+		},
+	},
+	ParseTest{
+		"list <- item+? 'b'^\nitem <- 'a' / 'b'",
+		"abbb",
+		&ParseTree{
+			Type: "item+",
+			Children: []*ParseTree{
+				&ParseTree{Type: "item", Data: []byte("a")},
+			},
+		},
+	},
+	ParseTest{
+		"list <- item+? 'b'^\nitem <- 'a' / 'b'",
+		"bbb",
+		&ParseTree{
+			Type: "item+",
+			Children: []*ParseTree{
+				&ParseTree{Type: "item", Data: []byte("b")},
+			},
+		},
+	},
+	ParseTest{
+		"pair <- item*?^ end\nitem <- 'a' / 'b'\nend <- 'b' 'b'",
+		"ababbb",
+		&ParseTree{
+			Type: "end",
+			Children: []*ParseTree{
+				&ParseTree{Type: "end", Data: []byte("b")},
+				&ParseTree{Type: "end", Data: []byte("b")},
+			},
+		},
+	},
+}
+
+func TestLazyClosure(t *testing.T) {
+	for _, tc := range lazyTestTable {
+		parser, err := NewParser(strings.NewReader(tc.language))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := parser.ParseString(tc.input)
+		if err != nil {
+			t.Error(tc.language, err)
+			continue
+		}
+		if err := treeCompare(tree, tc.exp); err != nil {
+			t.Error(tc.language, err)
+		}
+	}
+
+	parser, err := NewParser(strings.NewReader("comment <- '/*' any*? '*/'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseString("/* open"); err == nil {
+		t.Errorf("expected error without the end of the comment")
+	}
+	if g := parser.Grammar(); g != "comment <- '/*' any*? '*/'\n" {
+		t.Errorf("Grammar() = %q", g)
+	}
+}
@@ -3,6 +3,7 @@ package peg
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"strings"
@@ -11,9 +12,14 @@ import (
 )
 
 type item struct {
-	typ itemType
-	pos int
-	val string
+	typ  itemType
+	pos  int
+	val  string
+	line int // 1-based line of pos in the grammar.
+	col  int // 1-based byte column of pos in the grammar.
+	// The source text of the item, delimiters included, and its offset.
+	start int
+	text  string
 }
 
 func (i item) String() string {
@@ -43,6 +49,22 @@ const (
 	itemAlternate
 	itemOptional
 	itemDiscard
+	itemExternal
+	itemPredicate
+	itemDirective
+	itemCall
+	itemLParen
+	itemRParen
+	itemComma
+	itemLabel
+	itemBackref
+	itemByte
+	itemRepeat
+	itemRawLiteral
+	itemSkipUntil
+	itemLazy
+	itemDoc
+	itemComment
 	itemEOF
 )
 
@@ -76,6 +98,38 @@ func (i itemType) String() string {
 		return "itemOptional"
 	case itemDiscard:
 		return "itemDiscard"
+	case itemExternal:
+		return "itemExternal"
+	case itemPredicate:
+		return "itemPredicate"
+	case itemDirective:
+		return "itemDirective"
+	case itemCall:
+		return "itemCall"
+	case itemLParen:
+		return "itemLParen"
+	case itemRParen:
+		return "itemRParen"
+	case itemComma:
+		return "itemComma"
+	case itemLabel:
+		return "itemLabel"
+	case itemBackref:
+		return "itemBackref"
+	case itemByte:
+		return "itemByte"
+	case itemRepeat:
+		return "itemRepeat"
+	case itemRawLiteral:
+		return "itemRawLiteral"
+	case itemSkipUntil:
+		return "itemSkipUntil"
+	case itemLazy:
+		return "itemLazy"
+	case itemDoc:
+		return "itemDoc"
+	case itemComment:
+		return "itemComment"
 	}
 	return "UNKNOWN"
 }
@@ -85,12 +139,20 @@ const eof = -1
 type stateFn func(*lexer) stateFn
 
 type lexer struct {
-	input  *bufio.Reader
-	buffer bytes.Buffer
-	state  stateFn
-	pos    int
-	start  int
-	items  chan item
+	input     *bufio.Reader
+	buffer    bytes.Buffer
+	state     stateFn
+	pos       int
+	start     int
+	line      int // line of pos.
+	lineStart int // offset of the line containing pos.
+	startLine int // line of start.
+	startCol  int // column of start.
+	items     chan item
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopped   bool // whether ctx was done before an item could be sent.
+	comments  bool // whether comments are emitted rather than ignored.
 }
 
 func (l *lexer) nextItem() item {
@@ -99,27 +161,69 @@ func (l *lexer) nextItem() item {
 }
 
 func lex(input io.Reader) *lexer {
-	l := &lexer{
-		input: bufio.NewReader(input),
-		items: make(chan item, 1),
-	}
+	return lexContext(context.Background(), input)
+}
+
+// lexContext starts lexing input in a goroutine of its own, which stops
+// when ctx is done or the lexer is closed.
+func lexContext(ctx context.Context, input io.Reader) *lexer {
+	l := newLexer(ctx, input)
 	go l.run()
 	return l
 }
 
+// newLexer returns a lexer of input that is yet to be run.
+func newLexer(ctx context.Context, input io.Reader) *lexer {
+	ctx, cancel := context.WithCancel(ctx)
+	return &lexer{
+		input:     bufio.NewReader(input),
+		line:      1,
+		startLine: 1,
+		startCol:  1,
+		items:     make(chan item, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
 func (l *lexer) run() {
-	for l.state = lexPeg; l.state != nil; {
+	for l.state = lexPeg; l.state != nil && !l.stopped; {
 		l.state = l.state(l)
 	}
 	close(l.items)
 }
 
+// Close stops the lexer and drains the items it has yet to deliver, so
+// that its goroutine has returned when Close does. A consumer that stops
+// before the EOF item must close the lexer.
+func (l *lexer) Close() {
+	l.cancel()
+	for range l.items {
+	}
+}
+
+// send delivers i unless the lexer is stopped first.
+func (l *lexer) send(i item) {
+	if l.stopped {
+		return
+	}
+	select {
+	case l.items <- i:
+	case <-l.ctx.Done():
+		l.stopped = true
+	}
+}
+
 func (l *lexer) next() rune {
 	r, w, err := l.input.ReadRune()
 	if err == io.EOF {
 		return eof
 	}
 	l.pos += w
+	if r == '\n' {
+		l.line++
+		l.lineStart = l.pos
+	}
 	l.buffer.WriteRune(r)
 	return r
 }
@@ -165,8 +269,24 @@ func (l *lexer) emit(t itemType) {
 // and emits that.
 func (l *lexer) emitInner(t itemType, left, right int) {
 	token := l.buffer.String()
-	l.items <- item{t, l.start + left, token[left : len(token)-right]}
+	l.send(item{
+		typ:   t,
+		pos:   l.start + left,
+		val:   token[left : len(token)-right],
+		line:  l.startLine,
+		col:   l.startCol + left,
+		start: l.start,
+		text:  token,
+	})
+	l.start = l.pos
+	l.startLine, l.startCol = l.line, l.pos-l.lineStart+1
+	l.buffer.Truncate(0)
+}
+
+// ignore drops the text read since the last item.
+func (l *lexer) ignore() {
 	l.start = l.pos
+	l.startLine, l.startCol = l.line, l.pos-l.lineStart+1
 	l.buffer.Truncate(0)
 }
 
@@ -185,7 +305,15 @@ func (l *lexer) acceptRun(valid string) {
 }
 
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- item{itemError, l.start, fmt.Sprintf(format, args...)}
+	l.send(item{
+		typ:   itemError,
+		pos:   l.start,
+		val:   fmt.Sprintf(format, args...),
+		line:  l.startLine,
+		col:   l.startCol,
+		start: l.start,
+		text:  l.buffer.String(),
+	})
 	return nil
 }
 
@@ -211,6 +339,23 @@ func isIdentRune(r rune) bool {
 	return unicode.IsLetter(r) || r == '_'
 }
 
+// isIdentTailRune reports whether r may appear in an identifier after its
+// first rune.
+func isIdentTailRune(r rune) bool {
+	return isIdentRune(r) || unicode.IsDigit(r)
+}
+
+// qualified reports whether the input continues with a dot and a name,
+// which qualifies the name read before.
+func (l *lexer) qualified() bool {
+	buf, _ := l.input.Peek(1 + utf8.UTFMax)
+	if len(buf) < 2 || buf[0] != '.' {
+		return false
+	}
+	r, _ := utf8.DecodeRune(buf[1:])
+	return isIdentRune(r)
+}
+
 func lexPeg(l *lexer) stateFn {
 	switch r := l.peek(); {
 	case isIdentRune(r):
@@ -219,12 +364,14 @@ func lexPeg(l *lexer) stateFn {
 		return lexWhitespace
 	case r == '\n':
 		return lexNewline
-	case r == '<':
+	case r == '<' || r == ':':
 		return lexAssignment
-	case r == '\'':
+	case r == '\'' || r == '"' || r == '`':
 		return lexLiteral
 	case r == '~':
 		return lexRegex
+	case r == '.':
+		return lexSkipUntil
 	case r == '*':
 		return lexClosure
 	case r == '+':
@@ -235,16 +382,49 @@ func lexPeg(l *lexer) stateFn {
 		return lexOption
 	case r == '^':
 		return lexDiscard
+	case r == '@':
+		return lexExternal
+	case r == '&':
+		return lexPredicate
+	case r == '%':
+		return lexDirective
+	case r == '#':
+		return lexComment
+	case r == '(':
+		l.next()
+		l.emit(itemLParen)
+		return lexPeg
+	case r == ')':
+		l.next()
+		l.emit(itemRParen)
+		return lexPeg
+	case r == ',':
+		l.next()
+		l.emit(itemComma)
+		return lexPeg
+	case r == '=':
+		if buf, _ := l.input.Peek(1 + utf8.UTFMax); assignmentLen(buf) > 0 {
+			return lexAssignment
+		}
+		return lexBackref
+	case r == '\\':
+		return lexByte
+	case r == '{':
+		return lexRepeat
 	case r == eof:
 		l.emit(itemEOF)
 		return nil
 	}
 
-	return nil
+	return l.errorf("unexpected character %q", l.peek())
 }
 
 func lexPlus(l *lexer) stateFn {
 	l.next()
+	if l.accept("?") {
+		l.emit(itemLazy)
+		return lexPeg
+	}
 	l.emit(itemPlus)
 	return lexPeg
 }
@@ -269,15 +449,156 @@ func lexDiscard(l *lexer) stateFn {
 
 func lexClosure(l *lexer) stateFn {
 	l.next()
+	if l.accept("?") {
+		l.emit(itemLazy)
+		return lexPeg
+	}
 	l.emit(itemClosure)
 	return lexPeg
 }
 
 func lexIdentifier(l *lexer) stateFn {
-	for isIdentRune(l.peek()) {
+	for {
+		for isIdentTailRune(l.peek()) {
+			l.next()
+		}
+		// The rules of imported grammars are qualified by a namespace.
+		if !l.qualified() {
+			break
+		}
+		l.next() // consume .
+	}
+	// An identifier directly followed by '(' calls a built in matcher,
+	// one followed by ':' labels the next expression, unless it is defined
+	// with := or ::=.
+	switch l.peek() {
+	case '(':
+		l.emit(itemCall)
+	case ':':
+		if l.hasPrefix(":=") || l.hasPrefix("::=") {
+			l.emit(itemIdentifier)
+			break
+		}
+		l.next()
+		l.emitInner(itemLabel, 0, 1)
+	default:
+		l.emit(itemIdentifier)
+	}
+	return lexPeg
+}
+
+func isHexRune(r rune) bool {
+	return strings.IndexRune("0123456789abcdefABCDEF", r) >= 0
+}
+
+// lexByte scans a byte given in hex, such as \x7F.
+func lexByte(l *lexer) stateFn {
+	l.next() // consume \
+	if !l.accept("x") || !isHexRune(l.peek()) {
+		l.errorf("expected byte of the form \\xNN")
+		return nil
+	}
+	l.next()
+	if !isHexRune(l.peek()) {
+		l.errorf("expected byte of the form \\xNN")
+		return nil
+	}
+	l.next()
+	l.emitInner(itemByte, 2, 0)
+	return lexPeg
+}
+
+// lexRepeat scans a bounded repetition: {n}, {n,} or {n,m}.
+func lexRepeat(l *lexer) stateFn {
+	const digits = "0123456789"
+	l.next() // consume {
+	if strings.IndexRune(digits, l.peek()) < 0 {
+		l.errorf("expected repetition count after {")
+		return nil
+	}
+	l.acceptRun(digits)
+	if l.accept(",") {
+		l.acceptRun(digits)
+	}
+	if !l.accept("}") {
+		l.errorf("expected } after repetition count")
+		return nil
+	}
+	l.emitInner(itemRepeat, 1, 1)
+	return lexPeg
+}
+
+func lexBackref(l *lexer) stateFn {
+	l.next() // consume =
+	if !isIdentRune(l.peek()) {
+		l.errorf("expected label after =")
+		return nil
+	}
+	for isIdentTailRune(l.peek()) {
+		l.next()
+	}
+	l.emitInner(itemBackref, 1, 0)
+	return lexPeg
+}
+
+func lexExternal(l *lexer) stateFn {
+	l.next() // consume @
+	if !isIdentRune(l.peek()) {
+		l.errorf("expected matcher name after @")
+		return nil
+	}
+	for isIdentTailRune(l.peek()) {
+		l.next()
+	}
+	l.emitInner(itemExternal, 1, 0)
+	return lexPeg
+}
+
+func lexPredicate(l *lexer) stateFn {
+	l.next() // consume &
+	if !l.accept("{") {
+		l.errorf("expected '{' after &")
+		return nil
+	}
+	for isIdentTailRune(l.peek()) {
+		l.next()
+	}
+	if !l.accept("}") {
+		l.errorf("expected '}' after predicate name")
+		return nil
+	}
+	l.emitInner(itemPredicate, 2, 1)
+	return lexPeg
+}
+
+func lexDirective(l *lexer) stateFn {
+	l.next() // consume %
+	if !isIdentRune(l.peek()) {
+		l.errorf("expected directive name after %%")
+		return nil
+	}
+	for isIdentTailRune(l.peek()) {
 		l.next()
 	}
-	l.emit(itemIdentifier)
+	l.emitInner(itemDirective, 1, 0)
+	return lexPeg
+}
+
+// lexComment skips a comment running to the end of the line, unless the
+// lexer emits comments. Comments starting a line with ## document the rule
+// that follows and are emitted without the ##.
+func lexComment(l *lexer) stateFn {
+	doc := l.startCol == 1 && l.hasPrefix("##")
+	for r := l.peek(); r != '\n' && r != eof; r = l.peek() {
+		l.next()
+	}
+	if doc {
+		l.emitInner(itemDoc, 2, 0)
+	} else if l.comments {
+		l.emitInner(itemComment, 1, 0)
+	} else {
+		l.ignore()
+	}
 	return lexPeg
 }
 
@@ -296,57 +617,132 @@ func lexWhitespace(l *lexer) stateFn {
 
 func lexNewline(l *lexer) stateFn {
 	l.next()
-	l.emit(itemNewline)
+	if l.continuesRule() {
+		l.emit(itemWhitespace)
+	} else {
+		l.emit(itemNewline)
+	}
 	return lexPeg
 }
 
+// continuesRule reports whether the next non-blank line continues the
+// current rule body rather than starting a new definition. A line continues
+// the rule if it starts with '/', or if it is indented and does not define a
+// rule of its own.
+func (l *lexer) continuesRule() bool {
+	buf, _ := l.input.Peek(l.input.Size())
+	i := 0
+	for {
+		start := i
+		for i < len(buf) && (buf[i] == ' ' || buf[i] == '\t' || buf[i] == '\r') {
+			i++
+		}
+		if i == len(buf) {
+			return false
+		}
+		if buf[i] == '\n' {
+			i++
+			continue
+		}
+		if buf[i] == '/' {
+			return true
+		}
+		if i == start || buf[i] == '%' {
+			return false
+		}
+		j := i
+		for j < len(buf) && (buf[j] == '_' || buf[j] == '.' || buf[j] >= 0x80 || unicode.IsLetter(rune(buf[j])) || unicode.IsDigit(rune(buf[j]))) {
+			j++
+		}
+		for j < len(buf) && (buf[j] == ' ' || buf[j] == '\t') {
+			j++
+		}
+		return j == i || assignmentLen(buf[j:]) == 0
+	}
+}
+
+// assignmentLen returns the length of the operator defining a rule that
+// buf starts with, or 0 if it starts with none. Besides <-, rules may be
+// defined with =, := and ::= as in other PEG tools and in BNF; a = directly
+// followed by a label is a back reference.
+func assignmentLen(buf []byte) int {
+	for _, op := range []string{"<-", "::=", ":="} {
+		if bytes.HasPrefix(buf, []byte(op)) {
+			return len(op)
+		}
+	}
+	if len(buf) > 0 && buf[0] == '=' {
+		if r, _ := utf8.DecodeRune(buf[1:]); len(buf) == 1 || !isIdentRune(r) {
+			return 1
+		}
+	}
+	return 0
+}
+
 func lexAssignment(l *lexer) stateFn {
-	l.next()
-	if l.next() != '-' {
-		l.errorf("expected <-")
-		return nil
-	} else {
-		l.emit(itemAssignment)
+	buf, _ := l.input.Peek(3)
+	n := assignmentLen(buf)
+	if n == 0 {
+		return l.errorf("expected <-")
+	}
+	for i := 0; i < n; i++ {
+		l.next()
 	}
+	l.emit(itemAssignment)
 	return lexPeg
 }
 
+// lexLiteral scans a literal in single or double quotes, whose escapes are
+// decoded by the parser, or a raw literal in backticks.
 func lexLiteral(l *lexer) stateFn {
-	l.next() // consume '
-
-	for {
-		r := l.next()
-		if r == '\\' && l.peek() == '\'' {
-			l.next()
-		} else if r == '\'' {
-			l.emitInner(itemLiteral, 1, 1)
-			return lexPeg
-		} else if r == eof {
-			l.errorf("eof while parsing literal")
-			return nil
-		}
+	quote := l.next()
+	if quote == '`' {
+		return lexQuoted(l, quote, itemRawLiteral, 1, "literal")
 	}
+	return lexQuoted(l, quote, itemLiteral, 1, "literal")
 }
 
 func lexRegex(l *lexer) stateFn {
 	l.next() // consume ~
 
-	if l.peek() != '\'' {
-		l.errorf("Expected \"'\" after ~")
+	if r := l.peek(); r != '\'' && r != '"' && r != '`' {
+		l.errorf("Expected quote after ~")
 		return nil
-	} else {
-		l.next() // consume '
 	}
+	quote := l.next()
+	return lexQuoted(l, quote, itemRegexp, 2, "regexp")
+}
+
+// lexSkipUntil scans ..'delimiter'. The item keeps the opening quote, which
+// tells whether the delimiter has escapes.
+func lexSkipUntil(l *lexer) stateFn {
+	l.next() // consume the first .
+	if !l.accept(".") {
+		l.errorf("expected .. before delimiter")
+		return nil
+	}
+	if r := l.peek(); r != '\'' && r != '"' && r != '`' {
+		l.errorf("expected quoted delimiter after ..")
+		return nil
+	}
+	quote := l.next()
+	return lexQuoted(l, quote, itemSkipUntil, 2, "delimiter")
+}
 
+// lexQuoted scans up to the closing quote and emits the text in between.
+// A backslash escapes the next rune, except in raw strings.
+func lexQuoted(l *lexer, quote rune, typ itemType, left int, what string) stateFn {
 	for {
 		r := l.next()
-		if r == '\\' && l.peek() == '\'' {
-			l.next()
-		} else if r == '\'' {
-			l.emitInner(itemRegexp, 2, 1)
+		if r == '\\' && quote != '`' && l.peek() != eof {
+			if p := l.peek(); typ != itemRegexp || p == quote {
+				l.next()
+			}
+		} else if r == quote {
+			l.emitInner(typ, left, 1)
 			return lexPeg
 		} else if r == eof {
-			l.errorf("eof while parsing regexp")
+			l.errorf("eof while parsing %s", what)
 			return nil
 		}
 	}
@@ -1,8 +1,11 @@
 package peg
 
 import (
+	"context"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 type LexTest struct {
@@ -11,6 +14,28 @@ type LexTest struct {
 }
 
 var lexTestTable = []LexTest{
+	LexTest{
+		"a <- b\n  / c\nd <- e",
+		[]item{
+			item{typ: itemIdentifier, val: "a"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemAssignment, val: "<-"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemIdentifier, val: "b"},
+			item{typ: itemWhitespace, val: "\n"},
+			item{typ: itemWhitespace, val: "  "},
+			item{typ: itemAlternate, val: "/"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemIdentifier, val: "c"},
+			item{typ: itemNewline, val: "\n"},
+			item{typ: itemIdentifier, val: "d"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemAssignment, val: "<-"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemIdentifier, val: "e"},
+			item{typ: itemEOF, val: ""},
+		},
+	},
 	LexTest{
 		"prgm <- 'a'",
 		[]item{
@@ -104,6 +129,101 @@ var lexTestTable = []LexTest{
 			item{typ: itemEOF, val: ""},
 		},
 	},
+	LexTest{
+		"stmt <- @indent expr",
+		[]item{
+			item{typ: itemIdentifier, val: "stmt"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemAssignment, val: "<-"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemExternal, val: "indent"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemIdentifier, val: "expr"},
+			item{typ: itemEOF, val: ""},
+		},
+	},
+	LexTest{
+		"rule <- a &{checkVersion} b",
+		[]item{
+			item{typ: itemIdentifier, val: "rule"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemAssignment, val: "<-"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemIdentifier, val: "a"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemPredicate, val: "checkVersion"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemIdentifier, val: "b"},
+			item{typ: itemEOF, val: ""},
+		},
+	},
+	LexTest{
+		"b <- balanced('(', ')')",
+		[]item{
+			item{typ: itemIdentifier, val: "b"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemAssignment, val: "<-"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemCall, val: "balanced"},
+			item{typ: itemLParen, val: "("},
+			item{typ: itemLiteral, val: "("},
+			item{typ: itemComma, val: ","},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemLiteral, val: ")"},
+			item{typ: itemRParen, val: ")"},
+			item{typ: itemEOF, val: ""},
+		},
+	},
+	LexTest{
+		"e <- t:name =t",
+		[]item{
+			item{typ: itemIdentifier, val: "e"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemAssignment, val: "<-"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemLabel, val: "t"},
+			item{typ: itemIdentifier, val: "name"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemBackref, val: "t"},
+			item{typ: itemEOF, val: ""},
+		},
+	},
+	LexTest{
+		"h <- \\x7F 'ELF' byte{4} a{1,}",
+		[]item{
+			item{typ: itemIdentifier, val: "h"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemAssignment, val: "<-"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemByte, val: "7F"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemLiteral, val: "ELF"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemIdentifier, val: "byte"},
+			item{typ: itemRepeat, val: "4"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemIdentifier, val: "a"},
+			item{typ: itemRepeat, val: "1,"},
+			item{typ: itemEOF, val: ""},
+		},
+	},
+	LexTest{
+		"q <- \"it's\" `\\d` ~`\\d+` ~\"\\\"\"",
+		[]item{
+			item{typ: itemIdentifier, val: "q"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemAssignment, val: "<-"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemLiteral, val: "it's"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemRawLiteral, val: "\\d"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemRegexp, val: "\\d+"},
+			item{typ: itemWhitespace, val: " "},
+			item{typ: itemRegexp, val: "\\\""},
+			item{typ: itemEOF, val: ""},
+		},
+	},
 }
 
 func TestLexerTable(t *testing.T) {
@@ -132,3 +252,38 @@ func TestLexerTable(t *testing.T) {
 		}
 	}
 }
+
+func TestLexerClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		l := lex(strings.NewReader(strings.Repeat("a <- b\n", 100)))
+		<-l.items
+		l.Close()
+		if _, ok := <-l.items; ok {
+			t.Fatal("items after Close")
+		}
+	}
+	// Broken grammars stop being read at their first error.
+	for i := 0; i < 100; i++ {
+		if _, err := NewLanguage("a <- 'b\n" + strings.Repeat("c <- d\n", 100)); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	// Give the goroutines that build the languages time to return.
+	after := runtime.NumGoroutine()
+	for deadline := time.Now().Add(time.Second); after > before && time.Now().Before(deadline); after = runtime.NumGoroutine() {
+		time.Sleep(time.Millisecond)
+	}
+	if after > before {
+		t.Errorf("%d goroutines before, %d after", before, after)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewLanguageContext(ctx, "a <- b\nb <- 'c'"); err != context.Canceled {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+	if _, err := NewLanguageContext(context.Background(), "a <- b\nb <- 'c'"); err != nil {
+		t.Error(err)
+	}
+}
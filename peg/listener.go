@@ -0,0 +1,46 @@
+package peg
+
+// Listener is told about every rule a parse tries, which is what
+// profilers, debuggers and progress reports need. Results of %memo rules
+// that are served from the cache are not reported.
+type Listener interface {
+	// EnterRule is called when the rule name is tried at pos.
+	EnterRule(name string, pos int)
+	// ExitRule is called when the rule name returns. If ok, the rule
+	// matched and pos is where the match ended; otherwise pos is where the
+	// rule was tried.
+	ExitRule(name string, pos int, ok bool)
+}
+
+// Listen makes subsequent parses of s report the rules they try to l. A nil
+// Listener stops the reports.
+func (s *Source) Listen(l Listener) {
+	s.listener = l
+}
+
+// enterRule and exitRule report to the listener of s, failing the parse if
+// it panics.
+func (s *Source) enterRule(name string, pos int) {
+	defer s.guard("listener", pos)
+	s.listener.EnterRule(name, pos)
+}
+
+func (s *Source) exitRule(name string, pos int, ok bool) {
+	defer s.guard("listener", pos)
+	s.listener.ExitRule(name, pos, ok)
+}
+
+// listeners reports to each of its Listeners in turn.
+type listeners []Listener
+
+func (ls listeners) EnterRule(name string, pos int) {
+	for _, l := range ls {
+		l.EnterRule(name, pos)
+	}
+}
+
+func (ls listeners) ExitRule(name string, pos int, ok bool) {
+	for _, l := range ls {
+		l.ExitRule(name, pos, ok)
+	}
+}
@@ -0,0 +1,57 @@
+package peg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type recorder []string
+
+func (r *recorder) EnterRule(name string, pos int) {
+	*r = append(*r, fmt.Sprintf("> %s %d", name, pos))
+}
+
+func (r *recorder) ExitRule(name string, pos int, ok bool) {
+	*r = append(*r, fmt.Sprintf("< %s %d %v", name, pos, ok))
+}
+
+func TestListener(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("prgm <- item+\nitem <- num / word\nnum <- ~'[0-9]+'\nword <- ~'[a-z]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r recorder
+	s := SourceFromBytes([]byte("a1"))
+	s.Listen(&r)
+	if _, err := lang.ParseSource(s); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"> prgm 0",
+		"> item 0", "> num 0", "< num 0 false", "> word 0", "< word 1 true", "< item 1 true",
+		"> item 1", "> num 1", "< num 2 true", "< item 2 true",
+		"> item 2", "> num 2", "< num 2 false", "> word 2", "< word 2 false", "< item 2 false",
+		"< prgm 2 true",
+	}
+	if got := strings.Join(r, "\n"); got != strings.Join(exp, "\n") {
+		t.Errorf("got:\n%s\nexp:\n%s", got, strings.Join(exp, "\n"))
+	}
+
+	// Listeners and traces see the same rules.
+	r = nil
+	var trace Trace
+	s.Record(&trace)
+	if _, err := lang.ParseSource(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Events) != len(exp) || len(r) != len(exp) {
+		t.Errorf("got %d trace events and %d listener events, expected %d", len(trace.Events), len(r), len(exp))
+	}
+
+	r = nil
+	s.Listen(nil)
+	if _, err := lang.ParseSource(s); err != nil || len(r) != 0 {
+		t.Errorf("removed listener got %v", r)
+	}
+}
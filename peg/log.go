@@ -0,0 +1,74 @@
+package peg
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// WithLogger makes parses log to logger. The outcome of every parse is
+// logged at the Info level, or at Warn if it failed, with the fields rule,
+// bytes and duration, and error, pos, line and col for failures. If the
+// logger is enabled for the Debug level, each rule tried is logged too, as
+// "enter rule" with the fields rule, pos and depth when it is tried, and as
+// "exit rule" with matched and consumed, the bytes it matched, when it
+// returns. Like TraceWriter, rules are only logged for sources without a
+// Listener of their own. The context of the source is passed to the
+// logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(l *Language) {
+		l.logger = logger
+	}
+}
+
+// logContext returns the context to log the parses of s with.
+func (s *Source) logContext() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return context.Background()
+}
+
+// logParse logs the outcome of a parse of root in s started at start.
+func (l *Language) logParse(root *Lexeme, s *Source, start time.Time, err error) {
+	attrs := []slog.Attr{
+		slog.String("rule", ruleName(root)),
+		slog.Int("bytes", len(s.buf)),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err == nil {
+		l.logger.LogAttrs(s.logContext(), slog.LevelInfo, "parse", attrs...)
+		return
+	}
+	attrs = append(attrs, slog.String("error", err.Error()))
+	if d := Detail(err, s.buf); d.Pos >= 0 {
+		attrs = append(attrs, slog.Int("pos", d.Pos), slog.Int("line", d.Line), slog.Int("col", d.Col))
+	}
+	l.logger.LogAttrs(s.logContext(), slog.LevelWarn, "parse failed", attrs...)
+}
+
+// logListener logs the rules tried at the Debug level. starts holds the
+// offsets of the rules being matched.
+type logListener struct {
+	logger *slog.Logger
+	ctx    context.Context
+	starts []int
+}
+
+func (l *logListener) EnterRule(name string, pos int) {
+	l.logger.LogAttrs(l.ctx, slog.LevelDebug, "enter rule",
+		slog.String("rule", name), slog.Int("pos", pos), slog.Int("depth", len(l.starts)))
+	l.starts = append(l.starts, pos)
+}
+
+func (l *logListener) ExitRule(name string, pos int, ok bool) {
+	start := l.starts[len(l.starts)-1]
+	l.starts = l.starts[:len(l.starts)-1]
+	consumed := 0
+	if ok {
+		consumed = pos - start
+	}
+	l.logger.LogAttrs(l.ctx, slog.LevelDebug, "exit rule",
+		slog.String("rule", name), slog.Int("pos", start), slog.Int("depth", len(l.starts)),
+		slog.Bool("matched", ok), slog.Int("consumed", consumed))
+}
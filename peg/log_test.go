@@ -0,0 +1,46 @@
+package peg
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestWithLogger(t *testing.T) {
+	grammar := "list <- item+\nitem <- ~'[a-z]+' ' '^"
+	tests := []struct {
+		level slog.Level
+		input string
+		exp   string
+	}{
+		{slog.LevelInfo, "a", "level=INFO msg=parse rule=list bytes=1\n"},
+		{slog.LevelInfo, "1", "level=WARN msg=\"parse failed\" rule=list bytes=1 error=\"expected ~`[a-z]+` at offset 0: \\\"1\\\"\" pos=0 line=1 col=1\n"},
+		{slog.LevelDebug, "a", "level=DEBUG msg=\"enter rule\" rule=list pos=0 depth=0\n" +
+			"level=DEBUG msg=\"enter rule\" rule=item pos=0 depth=1\n" +
+			"level=DEBUG msg=\"exit rule\" rule=item pos=0 depth=1 matched=true consumed=1\n" +
+			"level=DEBUG msg=\"enter rule\" rule=item pos=1 depth=1\n" +
+			"level=DEBUG msg=\"exit rule\" rule=item pos=1 depth=1 matched=false consumed=0\n" +
+			"level=DEBUG msg=\"exit rule\" rule=list pos=0 depth=0 matched=true consumed=1\n" +
+			"level=INFO msg=parse rule=list bytes=1\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			Level: tt.level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey || a.Key == "duration" {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}))
+		lang, err := NewLanguage(grammar, WithLogger(logger))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lang.ParseString(tt.input)
+		if buf.String() != tt.exp {
+			t.Errorf("%v %q: got\n%s\nexp\n%s", tt.level, tt.input, buf.String(), tt.exp)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package peg
+
+import (
+	"bytes"
+)
+
+// Lossless makes parse trees keep the input that is matched without
+// producing a node, such as discarded lexemes, INDENT and the %whitespace
+// rule, as trivia of the neighbouring nodes. Text then reproduces the
+// matched input byte for byte.
+func Lossless(keep bool) Option {
+	return func(l *Language) {
+		l.lossless = keep
+	}
+}
+
+func (s *Source) lossless() bool {
+	return s.lang != nil && s.lang.lossless
+}
+
+// treeBuilder collects the children of a node. In lossless mode, input
+// consumed by lexemes that produce no tree becomes leading trivia of the
+// next child.
+type treeBuilder struct {
+	s        *Source
+	children []*ParseTree
+	pending  []byte
+	shared   bool // whether the progress of a stream parse holds the children too.
+}
+
+func (b *treeBuilder) add(tree *ParseTree, pos, n int) {
+	if tree == nil {
+		if n > 0 && b.s.lossless() {
+			b.pending = append(b.pending, b.s.buf[pos:pos+n]...)
+		}
+		return
+	}
+	if b.pending != nil {
+		// The tree may be shared with a memoized result, so copy it.
+		t := *tree
+		t.Leading = append(b.pending, tree.Leading...)
+		tree, b.pending = &t, nil
+	}
+	b.children = append(b.children, tree)
+}
+
+// done returns the children, with trivia left at the end attached to the
+// last one. Trivia that has no child to go to is returned separately.
+func (b *treeBuilder) done() (children []*ParseTree, trailing []byte) {
+	if b.pending == nil {
+		return b.children, nil
+	}
+	if len(b.children) == 0 {
+		return nil, b.pending
+	}
+	last := *b.children[len(b.children)-1]
+	last.Trailing = append(append([]byte(nil), last.Trailing...), b.pending...)
+	if b.shared {
+		b.children = append([]*ParseTree(nil), b.children...)
+	}
+	b.children[len(b.children)-1] = &last
+	return b.children, nil
+}
+
+// Text returns the input matched by the tree. Discarded input is only
+// included if the tree was parsed in Lossless mode.
+func (p *ParseTree) Text() []byte {
+	var buf bytes.Buffer
+	p.writeText(&buf)
+	return buf.Bytes()
+}
+
+func (p *ParseTree) writeText(buf *bytes.Buffer) {
+	buf.Write(p.Leading)
+	if len(p.Children) == 0 {
+		buf.Write(p.Data)
+	}
+	for _, child := range p.Children {
+		child.writeText(buf)
+	}
+	buf.Write(p.Trailing)
+}
@@ -0,0 +1,30 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLossless(t *testing.T) {
+	grammar := "%whitespace ws\nprgm <- stmt+\nstmt <- ~'[a-z]+' ';'^ comment^\ncomment <- ~'#[^\\n]*'\nws <- ~'[ \\t\\n]+'"
+	input := "  foo ;\n bar;# done"
+	for _, tc := range []struct {
+		lossless bool
+		exp      string
+	}{
+		{false, "foobar"},
+		{true, input},
+	} {
+		lang, err := NewParser(strings.NewReader(grammar), Lossless(tc.lossless))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := lang.ParseString(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text := string(tree.Text()); text != tc.exp {
+			t.Errorf("lossless %v: Text() = %q, exp %q", tc.lossless, text, tc.exp)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package lsp
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// Position is a zero-based line and character of a document, counting
+// UTF-16 code units as the protocol does.
+type Position struct {
+	Line      int
+	Character int
+}
+
+// Range is a span of a document, from Start up to End.
+type Range struct {
+	Start Position
+	End   Position
+}
+
+// FoldingRange is a region of lines an editor can collapse, as returned by
+// textDocument/foldingRange. EndLine is included in the region.
+type FoldingRange struct {
+	StartLine int
+	EndLine   int
+	Kind      string
+}
+
+// SymbolRule lists the nodes of a rule in an outline.
+type SymbolRule struct {
+	// Kind is the LSP SymbolKind of the symbols, such as 12 for a function.
+	Kind int
+	// Name is the rule of the descendant whose text names the symbol. If it
+	// is empty, or no descendant has that type, the first leaf does.
+	Name string
+}
+
+// Symbol is an entry of a document outline, as returned by
+// textDocument/documentSymbol. Range covers the node and SelectionRange
+// the leaf naming it.
+type Symbol struct {
+	Name           string
+	Kind           int
+	Range          Range
+	SelectionRange Range
+	Children       []Symbol
+}
+
+// Folds returns a folding range for each node of tree spanning several
+// lines whose type is one of the keys of rules, which hold the kinds of the
+// ranges, such as "comment" or "imports", or "" for none. Ranges are in
+// the order of their starts.
+func Folds(src []byte, tree *peg.ParseTree, rules map[string]string) []FoldingRange {
+	lines := lineStarts(src)
+	var folds []FoldingRange
+	var walk func(node *peg.ParseTree)
+	walk = func(node *peg.ParseTree) {
+		if kind, ok := rules[node.Type]; ok {
+			r := nodeRange(src, lines, node)
+			end := r.End.Line
+			if r.End.Character == 0 && end > r.Start.Line {
+				end-- // the node ends with a newline.
+			}
+			if end > r.Start.Line {
+				folds = append(folds, FoldingRange{r.Start.Line, end, kind})
+			}
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(tree)
+	return folds
+}
+
+// Outline returns the symbols of tree, one for each node whose type is one
+// of the keys of rules. The symbols of nodes within another node's are its
+// children.
+func Outline(src []byte, tree *peg.ParseTree, rules map[string]SymbolRule) []Symbol {
+	return outline(src, lineStarts(src), tree, rules)
+}
+
+func outline(src []byte, lines []int, node *peg.ParseTree, rules map[string]SymbolRule) []Symbol {
+	var children []Symbol
+	for _, child := range node.Children {
+		children = append(children, outline(src, lines, child, rules)...)
+	}
+	rule, ok := rules[node.Type]
+	if !ok {
+		return children
+	}
+	name := find(node, rule.Name)
+	if name == nil {
+		name = find(node, "")
+	}
+	sym := Symbol{Kind: rule.Kind, Range: nodeRange(src, lines, node), Children: children}
+	if name != nil {
+		sym.Name = string(name.Data)
+		sym.SelectionRange = Range{position(src, lines, name.Pos), position(src, lines, name.End)}
+	} else {
+		sym.SelectionRange = sym.Range
+	}
+	return []Symbol{sym}
+}
+
+// find returns the first leaf under node, in the order of the input, of
+// type typ, or of any type if typ is empty.
+func find(node *peg.ParseTree, typ string) *peg.ParseTree {
+	if len(node.Children) == 0 {
+		if node.Data != nil && (typ == "" || node.Type == typ) {
+			return node
+		}
+		return nil
+	}
+	for _, child := range node.Children {
+		if leaf := find(child, typ); leaf != nil {
+			return leaf
+		}
+	}
+	return nil
+}
+
+// nodeRange returns the range of node, leaving out the whitespace skipped
+// at its start.
+func nodeRange(src []byte, lines []int, node *peg.ParseTree) Range {
+	start := node.Pos
+	for start < node.End {
+		r, size := utf8.DecodeRune(src[start:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		start += size
+	}
+	return Range{position(src, lines, start), position(src, lines, node.End)}
+}
+
+// position converts a byte offset of src, whose lines start at lines, into
+// a Position.
+func position(src []byte, lines []int, offset int) Position {
+	line := sort.Search(len(lines), func(i int) bool { return lines[i] > offset }) - 1
+	return Position{line, utf16Len(src[lines[line]:offset])}
+}
@@ -0,0 +1,52 @@
+package lsp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+const outlineGrammar = `%whitespace ws
+prgm <- decl+
+decl <- var / func
+func <- 'func'^ name '{'^ body* '}'^
+body <- var / comment
+var <- 'var'^ name num
+num <- ~'[0-9]+'
+comment <- ~'#[^\n]*'
+name <- ~'[a-zé]+'
+ws <- ~'[ \t\n]+'`
+
+func TestOutline(t *testing.T) {
+	lang, err := peg.NewParser(strings.NewReader(outlineGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "var a 1\nfunc f {\n  # x\n  var éb 2\n}\n"
+	tree, err := lang.ParseString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folds := Folds([]byte(src), tree, map[string]string{"func": "", "comment": "comment", "var": ""})
+	if exp := []FoldingRange{{1, 4, ""}}; !reflect.DeepEqual(folds, exp) {
+		t.Errorf("got folds %v, exp %v", folds, exp)
+	}
+
+	symbols := Outline([]byte(src), tree, map[string]SymbolRule{
+		"func": {Kind: 12, Name: "name"},
+		"var":  {Kind: 13},
+	})
+	exp := []Symbol{
+		{Name: "a", Kind: 13, Range: Range{Position{0, 0}, Position{0, 7}}, SelectionRange: Range{Position{0, 4}, Position{0, 5}}},
+		{Name: "f", Kind: 12, Range: Range{Position{1, 0}, Position{4, 1}}, SelectionRange: Range{Position{1, 5}, Position{1, 6}}, Children: []Symbol{
+			// The selection counts UTF-16 code units.
+			{Name: "éb", Kind: 13, Range: Range{Position{3, 2}, Position{3, 10}}, SelectionRange: Range{Position{3, 6}, Position{3, 8}}},
+		}},
+	}
+	if !reflect.DeepEqual(symbols, exp) {
+		t.Errorf("got symbols %+v, exp %+v", symbols, exp)
+	}
+}
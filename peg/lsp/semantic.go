@@ -0,0 +1,110 @@
+// Package lsp helps language servers built on peg grammars speak the
+// Language Server Protocol.
+package lsp
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// Semantic is the LSP semantic token type and modifiers of a rule.
+type Semantic struct {
+	Type      string
+	Modifiers []string
+}
+
+// Legend maps the token types produced by a grammar to LSP semantic tokens.
+// Types and Modifiers are the legend advertised in the server capabilities;
+// Rules assigns each rule a type and modifiers from it. Tokens of rules that
+// are not mapped are left out.
+type Legend struct {
+	Types     []string
+	Modifiers []string
+	Rules     map[string]Semantic
+}
+
+// Encode converts tokens of src, as returned by Language.Tokenize, into the
+// delta encoded array of textDocument/semanticTokens. Positions count UTF-16
+// code units, as the protocol requires by default. Tokens spanning several
+// lines are split at the line ends.
+func (l *Legend) Encode(src []byte, tokens []peg.Token) []uint32 {
+	types := index(l.Types)
+	modifiers := index(l.Modifiers)
+	lines := lineStarts(src)
+
+	var data []uint32
+	prevLine, prevChar := 0, 0
+	for _, tok := range tokens {
+		sem, ok := l.Rules[tok.Type]
+		if !ok {
+			continue
+		}
+		typ, ok := types[sem.Type]
+		if !ok {
+			continue
+		}
+		var mods uint32
+		for _, m := range sem.Modifiers {
+			if bit, ok := modifiers[m]; ok {
+				mods |= 1 << uint(bit)
+			}
+		}
+		for start := tok.Start; start < tok.End; {
+			line := sort.Search(len(lines), func(i int) bool { return lines[i] > start }) - 1
+			end := tok.End
+			if line+1 < len(lines) && lines[line+1] <= end {
+				end = lines[line+1] - 1 // exclude the newline.
+			}
+			char := utf16Len(src[lines[line]:start])
+			length := utf16Len(src[start:end])
+			if length > 0 {
+				deltaChar := char
+				if line == prevLine {
+					deltaChar = char - prevChar
+				}
+				data = append(data, uint32(line-prevLine), uint32(deltaChar), uint32(length), uint32(typ), mods)
+				prevLine, prevChar = line, char
+			}
+			if line+1 == len(lines) {
+				break
+			}
+			start = lines[line+1]
+		}
+	}
+	return data
+}
+
+func index(names []string) map[string]int {
+	m := make(map[string]int, len(names))
+	for i, name := range names {
+		m[name] = i
+	}
+	return m
+}
+
+func lineStarts(src []byte) []int {
+	lines := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// utf16Len returns the number of UTF-16 code units encoding b.
+func utf16Len(b []byte) int {
+	n := 0
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+		b = b[size:]
+	}
+	return n
+}
@@ -0,0 +1,51 @@
+package lsp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+func TestEncode(t *testing.T) {
+	lang, err := peg.NewParser(strings.NewReader("prgm <- line+\nline <- kw ' '^ name '\\n'^\nkw <- 'let'\nname <- ~'[^\\n]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "let a\nlet 😀b\n"
+	tokens, err := lang.Tokenize(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legend := &Legend{
+		Types:     []string{"keyword", "variable"},
+		Modifiers: []string{"declaration", "readonly"},
+		Rules: map[string]Semantic{
+			"kw":   {Type: "keyword"},
+			"name": {Type: "variable", Modifiers: []string{"declaration"}},
+		},
+	}
+	exp := []uint32{
+		0, 0, 3, 0, 0,
+		0, 4, 1, 1, 1,
+		1, 0, 3, 0, 0,
+		0, 4, 3, 1, 1, // the emoji takes two code units.
+	}
+	if data := legend.Encode([]byte(src), tokens); !reflect.DeepEqual(data, exp) {
+		t.Errorf("got %v, exp %v", data, exp)
+	}
+}
+
+func TestEncodeMultiline(t *testing.T) {
+	legend := &Legend{
+		Types: []string{"comment"},
+		Rules: map[string]Semantic{"comment": {Type: "comment"}},
+	}
+	src := "x /* a\nbc */"
+	data := legend.Encode([]byte(src), []peg.Token{{Type: "x", Start: 0, End: 1}, {Type: "comment", Start: 2, End: len(src)}})
+	exp := []uint32{0, 2, 4, 0, 0, 1, 0, 5, 0, 0}
+	if !reflect.DeepEqual(data, exp) {
+		t.Errorf("got %v, exp %v", data, exp)
+	}
+}
@@ -0,0 +1,168 @@
+package peg
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Matcher recognizes the text of a leaf. Match returns the length of the
+// match at pos of src and whether there is one. src must not be modified or
+// retained.
+//
+// Matchers let hand-written recognizers stand in for literals and regexps
+// where those are too slow or can't express the token. The lexemes built
+// from grammar literals and regexps keep their own implementations, which
+// also take part in completion and streaming.
+type Matcher interface {
+	Match(src []byte, pos int) (n int, ok bool)
+}
+
+// MatcherFunc adapts an ordinary function to a Matcher.
+type MatcherFunc func(src []byte, pos int) (int, bool)
+
+func (f MatcherFunc) Match(src []byte, pos int) (int, bool) {
+	return f(src, pos)
+}
+
+// NewMatcherLexer matches m after skipping whitespace, producing leaves of
+// type typ. In a stream, a match that reaches the end of the input written
+// so far waits for more.
+func NewMatcherLexer(typ string, m Matcher) *Lexeme {
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: typ,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			skip := s.skipWhitespace(pos)
+			pos += skip
+			n, ok := s.match(typ, pos, m)
+			if pos+n == len(s.buf) {
+				s.Starve(1)
+			}
+			if !ok {
+				return nil, s.expected(pos, typ), 0
+			}
+			return s.leaf(&ParseTree{
+				Type:    typ,
+				ID:      id.get(s),
+				Data:    s.buf[pos : pos+n],
+				Pos:     pos,
+				End:     pos + n,
+				Leading: s.skipped(pos, skip),
+			}), nil, skip + n
+		},
+	}
+}
+
+// RegisterMatcher makes m available to the grammar as the external matcher
+// @name, which produces leaves of type name.
+func (l *Language) RegisterMatcher(name string, m Matcher) {
+	l.Register(name, NewMatcherLexer(name, m).Lexer)
+}
+
+// LiteralMatcher matches text.
+func LiteralMatcher(text string) Matcher {
+	return MatcherFunc(func(src []byte, pos int) (int, bool) {
+		if len(src)-pos < len(text) || string(src[pos:pos+len(text)]) != text {
+			return 0, false
+		}
+		return len(text), true
+	})
+}
+
+// RegexpMatcher matches re where it matches at pos.
+func RegexpMatcher(re *regexp.Regexp) Matcher {
+	return MatcherFunc(func(src []byte, pos int) (int, bool) {
+		loc := re.FindIndex(src[pos:])
+		if loc == nil || loc[0] != 0 {
+			return 0, false
+		}
+		return loc[1], true
+	})
+}
+
+// classMatcher matches runs of the runes of a class.
+type classMatcher struct {
+	ascii  [128]bool
+	ranges [][2]rune // the ranges of runes beyond ASCII.
+	negate bool
+}
+
+// ClassMatcher matches one or more runes of class, which is written like a
+// bracketed class of a regexp without the brackets: "a-zA-Z_" or "^,\n".
+// A backslash makes the next character stand for itself.
+func ClassMatcher(class string) (Matcher, error) {
+	m := &classMatcher{}
+	text := class
+	if len(text) > 0 && text[0] == '^' {
+		m.negate, text = true, text[1:]
+	}
+	if text == "" {
+		return nil, errors.New(fmt.Sprintf("class %q is empty", class))
+	}
+	next := func() rune {
+		r, n := utf8.DecodeRuneInString(text)
+		if r == '\\' && n < len(text) {
+			r, n = utf8.DecodeRuneInString(text[1:])
+			n++
+		}
+		text = text[n:]
+		return r
+	}
+	for text != "" {
+		lo := next()
+		hi := lo
+		if len(text) > 1 && text[0] == '-' {
+			text = text[1:]
+			hi = next()
+		}
+		if hi < lo {
+			return nil, errors.New(fmt.Sprintf("class %q: range %c-%c is reversed", class, lo, hi))
+		}
+		for r := lo; r <= hi && r < utf8.RuneSelf; r++ {
+			m.ascii[r] = true
+		}
+		if hi >= utf8.RuneSelf {
+			if lo < utf8.RuneSelf {
+				lo = utf8.RuneSelf
+			}
+			m.ranges = append(m.ranges, [2]rune{lo, hi})
+		}
+	}
+	return m, nil
+}
+
+func (m *classMatcher) Match(src []byte, pos int) (int, bool) {
+	i := pos
+	for i < len(src) {
+		r, n := rune(src[i]), 1
+		if r >= utf8.RuneSelf {
+			r, n = utf8.DecodeRune(src[i:])
+		}
+		if m.contains(r) == m.negate {
+			break
+		}
+		i += n
+	}
+	return i - pos, i > pos
+}
+
+func (m *classMatcher) contains(r rune) bool {
+	if r < utf8.RuneSelf {
+		return m.ascii[r]
+	}
+	for _, rg := range m.ranges {
+		if rg[0] <= r && r <= rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// match calls the Match method of the matcher of the leaf typ at pos,
+// stopping the parse if it panics.
+func (s *Source) match(typ string, pos int, m Matcher) (int, bool) {
+	defer s.guard(typ, pos)
+	return m.Match(s.buf, pos)
+}
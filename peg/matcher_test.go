@@ -0,0 +1,224 @@
+package peg
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMatchers(t *testing.T) {
+	class := func(c string) Matcher {
+		m, err := ClassMatcher(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	for _, tt := range []struct {
+		name  string
+		m     Matcher
+		input string
+		pos   int
+		n     int
+		ok    bool
+	}{
+		{"literal", LiteralMatcher("ab"), "xabc", 1, 2, true},
+		{"literal short", LiteralMatcher("ab"), "xa", 1, 0, false},
+		{"regexp", RegexpMatcher(regexp.MustCompile(`[0-9]+`)), "a12b", 1, 2, true},
+		{"regexp later", RegexpMatcher(regexp.MustCompile(`[0-9]+`)), "ab12", 1, 0, false},
+		{"class", class("a-z_"), "ab_c1", 0, 4, true},
+		{"class none", class("a-z"), "1ab", 0, 0, false},
+		{"class negated", class("^,\n"), "ab,c", 0, 2, true},
+		{"class escaped", class(`\-\^`), "-^a", 0, 2, true},
+		{"class unicode", class("a-zä-ö"), "aöü", 0, 3, true},
+		{"func", MatcherFunc(func(src []byte, pos int) (int, bool) { return 1, src[pos] == 'x' }), "x", 0, 1, true},
+	} {
+		n, ok := tt.m.Match([]byte(tt.input), tt.pos)
+		if ok != tt.ok || ok && n != tt.n {
+			t.Errorf("%s: got %d, %v, expected %d, %v", tt.name, n, ok, tt.n, tt.ok)
+		}
+	}
+
+	for _, c := range []string{"", "^", "z-a"} {
+		if _, err := ClassMatcher(c); err == nil {
+			t.Errorf("expected error for class %q", c)
+		}
+	}
+}
+
+func TestMatcherLexer(t *testing.T) {
+	ident, err := ClassMatcher("a-z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang, err := NewLanguage("%whitespace ws\nlist <- @ident+\nws <- ~' +'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang.RegisterMatcher("ident", ident)
+	tree, err := lang.ParseString("ab  cd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{Type: "@ident+", Children: []*ParseTree{
+		{Type: "ident", Data: []byte("ab")},
+		{Type: "ident", Data: []byte("cd")},
+	}}
+	if !tree.Equal(exp, IgnorePositions()) {
+		t.Errorf("got %s, expected %s", tree, exp)
+	}
+	if _, err := lang.ParseString("12"); err == nil || err.Error() != "expected ident at offset 0: \"12\"" {
+		t.Errorf("got error %v", err)
+	}
+
+	g := NewGrammar()
+	g.Rule("word").Is(g.Plus(g.Match(LiteralMatcher("ha"))))
+	lang, err = g.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err = lang.ParseString("haha")
+	if err != nil || len(tree.Children) != 2 || tree.Children[0].Type != "word" {
+		t.Errorf("got %s, %v", tree, err)
+	}
+
+	// In a stream, a match that reaches the end waits for more input.
+	p := lang.NewStreamParser()
+	p.Write([]byte("ha"))
+	if trees, need, err := p.Next(); len(trees) != 0 || need == 0 || err != nil {
+		t.Errorf("got %v, %d, %v before the end of the stream", trees, need, err)
+	}
+}
+
+func TestUserPanics(t *testing.T) {
+	lang, err := NewLanguage("list <- item+\nitem <- @ext / pee / @odd / @dec / 'q'\npee <- &{pred} 'p'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang.Register("ext", func(s *Source, pos int) (*ParseTree, error, int) {
+		if pos < len(s.Bytes()) && s.Bytes()[pos] == 'e' {
+			panic("matcher bug")
+		}
+		return nil, s.failed(pos, "no ext"), 0
+	})
+	lang.RegisterPredicate("pred", func(s *Source, pos int) bool {
+		if pos < len(s.Bytes()) && s.Bytes()[pos] == 'p' {
+			var seen map[string]bool
+			seen["p"] = true
+		}
+		return false
+	})
+	lang.RegisterMatcher("odd", MatcherFunc(func(src []byte, pos int) (int, bool) {
+		if pos < len(src) && src[pos] == 'x' {
+			panic(pos)
+		}
+		return 0, false
+	}))
+	lang.Register("dec", NewDecodingLexer("dec", "a d", regexp.MustCompile("d"), func(text []byte) (interface{}, error) {
+		panic("decoder bug")
+	}).Lexer)
+	for _, tt := range []struct {
+		input string
+		pos   int
+		rule  string
+		msg   string
+	}{
+		{"qe", 1, "@ext", "@ext panicked: matcher bug"},
+		{"p", 0, "&{pred}", "&{pred} panicked: assignment to entry in nil map"},
+		{"qqx", 2, "odd", "odd panicked: 2"},
+		{"d", 0, "dec", "dec panicked: decoder bug"},
+	} {
+		_, err := lang.ParseString(tt.input)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%q: got %v, expected a *ParseError", tt.input, err)
+			continue
+		}
+		if perr.Pos != tt.pos || perr.Rule != tt.rule || perr.Msg != tt.msg {
+			t.Errorf("%q: got %d, %q, %q, expected %d, %q, %q", tt.input, perr.Pos, perr.Rule, perr.Msg, tt.pos, tt.rule, tt.msg)
+		}
+	}
+	if tree, err := lang.ParseString("qq"); err != nil || len(tree.Children) != 2 {
+		t.Errorf("got %v, %v after panics", tree, err)
+	}
+}
+
+// panicky returns a lexeme defined in Go that panics at the letter p.
+func panicky() *Lexeme {
+	return &Lexeme{Name: "panicky", Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+		if pos < len(s.Bytes()) && s.Bytes()[pos] == 'p' {
+			panic("rule bug")
+		}
+		return nil, s.failed(pos, "no p"), 0
+	}}
+}
+
+// panickyListener panics when told about the rule item.
+type panickyListener struct{}
+
+func (panickyListener) EnterRule(name string, pos int) {
+	if name == "item" {
+		panic("listener bug")
+	}
+}
+
+func (panickyListener) ExitRule(name string, pos int, ok bool) {}
+
+func TestGoCodePanics(t *testing.T) {
+	grammar := "list <- item+\nitem <- ext / 'q'"
+	bound, err := NewLanguage(grammar, WithRule("ext", panicky()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := NewLanguage("list <- item+\nitem <- 'q'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := base.AddRule("ext", panicky())
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err = added.ReplaceRule("item", NewChoiceLexer("item", NewRuleLexer("ext"), NewLiteralLexer("item", "q")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := NewLanguage(grammar, WithRule("ext", WrapLexeme(NewLiteralLexer("ext", "x"), func(next LexFunc) LexFunc {
+		return func(s *Source, pos int) (*ParseTree, error, int) {
+			if pos < len(s.Bytes()) && s.Bytes()[pos] == 'p' {
+				panic("middleware bug")
+			}
+			return next(s, pos)
+		}
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listened, err := NewLanguage("list <- item+\nitem <- 'q' / 'p'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		lang      *Language
+		listen    Listener
+		rule, msg string
+	}{
+		{"WithRule", bound, nil, "ext", "ext panicked: rule bug"},
+		{"AddRule", added, nil, "ext", "ext panicked: rule bug"},
+		{"WrapLexeme", wrapped, nil, "wrapper of ext", "wrapper of ext panicked: middleware bug"},
+		{"Listener", listened, panickyListener{}, "listener", "listener panicked: listener bug"},
+	} {
+		s := SourceFromBytes([]byte("qp"))
+		if tc.listen != nil {
+			s.Listen(tc.listen)
+		}
+		_, err := tc.lang.ParseSource(s)
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%s: got %v, expected a *ParseError", tc.name, err)
+			continue
+		}
+		if perr.Rule != tc.rule || perr.Msg != tc.msg {
+			t.Errorf("%s: got %q, %q, expected %q, %q", tc.name, perr.Rule, perr.Msg, tc.rule, tc.msg)
+		}
+	}
+}
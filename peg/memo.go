@@ -0,0 +1,85 @@
+package peg
+
+// memoKey identifies an attempt to match a lexeme. The mark it starts from
+// is part of the key, since rules may match differently inside another
+// indentation block or capture scope.
+type memoKey struct {
+	lex  *Lexeme
+	pos  int
+	mark Mark
+}
+
+// memoKey returns the key of an attempt to match lex at pos. Tokens and
+// warnings recorded before don't affect the match, so they are left out.
+func (s *Source) memoKey(lex *Lexeme, pos int) memoKey {
+	m := s.Mark()
+	m.state.ntokens, m.state.nwarnings = 0, 0
+	return memoKey{lex, pos, m}
+}
+
+type memoEntry struct {
+	tree     *ParseTree
+	err      error
+	n        int
+	after    Mark      // the mark after the match.
+	tokens   []Token   // the tokens recorded by the match.
+	warnings []Warning // the warnings recorded by the match.
+}
+
+// NewMemoLexer caches the result of lex at each position of a source, so
+// that backtracking over it does not repeat the work. Results that depend
+// on predicates or external matchers with their own state must not be
+// cached.
+func NewMemoLexer(lex *Lexeme) *Lexeme {
+	return &Lexeme{
+		Name:         lex.Name,
+		Dependencies: []*Lexeme{lex},
+		kind:         kindMemo,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			return s.memoized(lex, pos)
+		},
+	}
+}
+
+// memoized matches lex at pos, or returns what it matched there before.
+// While streaming, results that looked for input past what was written may
+// still change, so only the others are kept.
+func (s *Source) memoized(lex *Lexeme, pos int) (*ParseTree, error, int) {
+	if s.completions != nil {
+		// Every terminal must be tried to find completions.
+		return lex.Lexer(s, pos)
+	}
+	key := s.memoKey(lex, pos)
+	if e, ok := s.memo[key]; ok {
+		ntokens, nwarnings := s.ntokens, s.nwarnings
+		s.Reset(e.after)
+		s.ntokens, s.nwarnings = ntokens, nwarnings
+		for _, t := range e.tokens {
+			s.token(t.Type, t.Start, t.End)
+		}
+		for _, w := range e.warnings {
+			s.Warn(w.Pos, w.Msg)
+		}
+		return e.tree, e.err, e.n
+	}
+	before, warned, starved := s.ntokens, s.nwarnings, s.starved
+	s.starved = false
+	tree, err, n := lex.Lexer(s, pos)
+	if s.starved {
+		return tree, err, n
+	}
+	s.starved = starved
+	if s.memo == nil {
+		s.memo = make(map[memoKey]memoEntry)
+	}
+	var tokens []Token
+	if err == nil && s.ntokens > before {
+		tokens = append(tokens, s.tokens[before:s.ntokens]...)
+	}
+	var warnings []Warning
+	if err == nil && s.nwarnings > warned {
+		warnings = append(warnings, s.warnings[warned:s.nwarnings]...)
+	}
+	s.memo[key] = memoEntry{tree, err, n, s.Mark(), tokens, warnings}
+	return tree, err, n
+}
@@ -0,0 +1,42 @@
+package peg
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMemoLexer(t *testing.T) {
+	// Both alternatives start with expr, which is matched only once per
+	// position when memoized.
+	grammar := "%memo expr\nprgm <- call / index\ncall <- expr '()'\nindex <- expr '[]'\nexpr <- @count"
+	for _, memo := range []bool{false, true} {
+		g := grammar
+		if !memo {
+			g = g[strings.IndexByte(g, '\n')+1:]
+		}
+		lang, err := NewParser(strings.NewReader(g))
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls := 0
+		lang.Register("count", func(s *Source, pos int) (*ParseTree, error, int) {
+			calls++
+			return NewRegexpLexer("expr", regexp.MustCompile("[a-z]+")).Lexer(s, pos)
+		})
+		tree, err := lang.ParseString("a[]")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tree.Children) != 2 {
+			t.Errorf("unexpected tree %s", tree)
+		}
+		exp := 2
+		if memo {
+			exp = 1
+		}
+		if calls != exp {
+			t.Errorf("memo %v: expr matched %d times, exp %d", memo, calls, exp)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package peg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of the parse duration histogram of a
+// Collector made without buckets of its own.
+var DefaultBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+// Collector gathers metrics of parses: how many there were, how many
+// failed, how long they took, and how often each rule was called. It learns
+// about parses as a Listener does, so it needs no metrics library; Metrics
+// returns the figures and WritePrometheus exposes them to a scraper.
+//
+// A Collector is safe for concurrent use. Each parse reports to a Listener
+// of its own, returned by Listener or installed by the Collect option, and
+// adds its counts to the collector when it ends.
+type Collector struct {
+	buckets []time.Duration
+	mu      sync.Mutex
+	m       Metrics
+}
+
+// Metrics are the figures gathered by a Collector.
+type Metrics struct {
+	Parses   int
+	Failures int
+	Duration time.Duration // the total of all parses.
+	// Buckets counts the parses that took at most the duration of Bounds
+	// with the same index, cumulatively as Prometheus does. Parses over
+	// the largest bound are only counted by Parses.
+	Bounds  []time.Duration
+	Buckets []int
+	Calls   map[string]int // how often each rule was called.
+	Failed  map[string]int // how often each rule failed.
+}
+
+// NewCollector returns a Collector whose duration histogram has the upper
+// bounds buckets, which must be ascending, or DefaultBuckets if none are
+// given.
+func NewCollector(buckets ...time.Duration) *Collector {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	return &Collector{buckets: buckets, m: Metrics{
+		Bounds:  buckets,
+		Buckets: make([]int, len(buckets)),
+		Calls:   make(map[string]int),
+		Failed:  make(map[string]int),
+	}}
+}
+
+// Collect makes the parses of the language report to c, unless their
+// source has a Listener of its own.
+func Collect(c *Collector) Option {
+	return func(l *Language) {
+		l.collector = c
+	}
+}
+
+// Listener returns a Listener that reports a parse to c, for sources that
+// are listened to directly. A parse begins when the listener is told about
+// its first rule, and ends when that rule returns. The listener can be
+// used for one parse at a time.
+func (c *Collector) Listener() Listener {
+	return &collectorListener{c: c}
+}
+
+// Metrics returns a copy of the figures gathered so far.
+func (c *Collector) Metrics() Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.m
+	m.Buckets = append([]int(nil), m.Buckets...)
+	m.Calls = make(map[string]int, len(c.m.Calls))
+	for rule, n := range c.m.Calls {
+		m.Calls[rule] = n
+	}
+	m.Failed = make(map[string]int, len(c.m.Failed))
+	for rule, n := range c.m.Failed {
+		m.Failed[rule] = n
+	}
+	return m
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text format:
+// the counters peg_parses_total and peg_parse_failures_total, the
+// histogram peg_parse_duration_seconds, and the counters
+// peg_rule_calls_total and peg_rule_failures_total with a rule label.
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	m := c.Metrics()
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "# HELP peg_parses_total Parses started.\n# TYPE peg_parses_total counter\npeg_parses_total %d\n", m.Parses)
+	fmt.Fprintf(b, "# HELP peg_parse_failures_total Parses that failed.\n# TYPE peg_parse_failures_total counter\npeg_parse_failures_total %d\n", m.Failures)
+	fmt.Fprintf(b, "# HELP peg_parse_duration_seconds How long parses took.\n# TYPE peg_parse_duration_seconds histogram\n")
+	for i, bound := range m.Bounds {
+		fmt.Fprintf(b, "peg_parse_duration_seconds_bucket{le=\"%s\"} %d\n", seconds(bound), m.Buckets[i])
+	}
+	fmt.Fprintf(b, "peg_parse_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.Parses)
+	fmt.Fprintf(b, "peg_parse_duration_seconds_sum %s\npeg_parse_duration_seconds_count %d\n", seconds(m.Duration), m.Parses)
+	writeRuleCounters(b, "peg_rule_calls_total", "Calls of each rule.", m.Calls)
+	writeRuleCounters(b, "peg_rule_failures_total", "Failed calls of each rule.", m.Failed)
+	return b.Flush()
+}
+
+func writeRuleCounters(w io.Writer, name, help string, counts map[string]int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		// Rule names are identifiers, which need no escaping.
+		fmt.Fprintf(w, "%s{rule=\"%s\"} %d\n", name, rule, counts[rule])
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// add counts a parse that took d and called the rules as in calls and
+// failed.
+func (c *Collector) add(ok bool, d time.Duration, calls, failed map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m.Parses++
+	if !ok {
+		c.m.Failures++
+	}
+	c.m.Duration += d
+	for i, bound := range c.buckets {
+		if d <= bound {
+			c.m.Buckets[i]++
+		}
+	}
+	for rule, n := range calls {
+		c.m.Calls[rule] += n
+	}
+	for rule, n := range failed {
+		c.m.Failed[rule] += n
+	}
+}
+
+// collectorListener counts the rules of a parse until it ends, so that
+// the Collector is locked once per parse.
+type collectorListener struct {
+	c      *Collector
+	depth  int // the rules being matched.
+	start  time.Time
+	calls  map[string]int
+	failed map[string]int
+}
+
+func (l *collectorListener) EnterRule(name string, pos int) {
+	if l.depth == 0 {
+		l.start = time.Now()
+		l.calls, l.failed = make(map[string]int), make(map[string]int)
+	}
+	l.depth++
+	l.calls[name]++
+}
+
+func (l *collectorListener) ExitRule(name string, pos int, ok bool) {
+	if !ok {
+		l.failed[name]++
+	}
+	if l.depth--; l.depth == 0 {
+		l.c.add(ok, time.Since(l.start), l.calls, l.failed)
+	}
+}
+
+// abort ends a parse that stopped without its first rule returning, such
+// as one over its budget, as a failure.
+func (l *collectorListener) abort() {
+	if l.depth > 0 {
+		l.depth = 0
+		l.c.add(false, time.Since(l.start), l.calls, l.failed)
+	}
+}
@@ -0,0 +1,67 @@
+package peg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector(time.Hour)
+	lang, err := NewLanguage("list <- item+\nitem <- ~'[a-z]+' ' '^", Collect(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{"a b", "c", "1"} {
+		lang.ParseString(input)
+	}
+	// A source listened to directly reports to the listener.
+	s := SourceFromBytes([]byte("d"))
+	s.Listen(c.Listener())
+	lang.ParseSource(s)
+
+	m := c.Metrics()
+	if m.Parses != 4 || m.Failures != 1 || !reflect.DeepEqual(m.Buckets, []int{4}) {
+		t.Errorf("got %d parses, %d failures, buckets %v", m.Parses, m.Failures, m.Buckets)
+	}
+	// item fails at the end of every list, and list fails on 1.
+	if exp := map[string]int{"list": 4, "item": 8}; !reflect.DeepEqual(m.Calls, exp) {
+		t.Errorf("got calls %v, exp %v", m.Calls, exp)
+	}
+	if exp := map[string]int{"list": 1, "item": 4}; !reflect.DeepEqual(m.Failed, exp) {
+		t.Errorf("got failures %v, exp %v", m.Failed, exp)
+	}
+
+	// Parses over their budget end without their rules returning. The
+	// item that goes over it is never entered.
+	deep, err := NewLanguage("list <- item+\nitem <- ~'[a-z]+' ' '^", Collect(c), MaxDepth(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deep.ParseString("a"); err == nil {
+		t.Fatal("parse within budget")
+	}
+	if m := c.Metrics(); m.Parses != 5 || m.Failures != 2 {
+		t.Errorf("got %d parses, %d failures after the budget", m.Parses, m.Failures)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{
+		"# TYPE peg_parses_total counter\npeg_parses_total 5\n",
+		"peg_parse_failures_total 2\n",
+		"# TYPE peg_parse_duration_seconds histogram\n",
+		"peg_parse_duration_seconds_bucket{le=\"3600\"} 5\npeg_parse_duration_seconds_bucket{le=\"+Inf\"} 5\n",
+		"peg_parse_duration_seconds_count 5\n",
+		"peg_rule_calls_total{rule=\"item\"} 8\npeg_rule_calls_total{rule=\"list\"} 5\n",
+		"peg_rule_failures_total{rule=\"item\"} 4\npeg_rule_failures_total{rule=\"list\"} 1\n",
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("metrics lack %q:\n%s", exp, buf.String())
+		}
+	}
+}
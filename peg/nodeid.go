@@ -0,0 +1,98 @@
+package peg
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// NodeID identifies a node of a parse tree, so that editor annotations and
+// caches can refer to nodes across parses of the same input, or of an
+// edited one.
+type NodeID uint64
+
+// NodeIDs holds the NodeIDs of the nodes of a tree.
+type NodeIDs map[*ParseTree]NodeID
+
+// IDs returns NodeIDs for the nodes of the tree derived from their types,
+// the spans of input they cover and their contents, the types and Data of
+// the nodes below them. The IDs are deterministic: parsing the same input
+// again, in any process, gives the nodes the same IDs. Nodes that agree in
+// all of that, such as an empty closure and the empty node it holds, still
+// get distinct IDs.
+func (p *ParseTree) IDs() NodeIDs {
+	ids := make(NodeIDs)
+	used := make(map[NodeID]bool)
+	var walk func(node *ParseTree) uint64
+	walk = func(node *ParseTree) uint64 {
+		h := fnv.New64a()
+		var n [binary.MaxVarintLen64]byte
+		write := func(b []byte) {
+			h.Write(n[:binary.PutUvarint(n[:], uint64(len(b)))])
+			h.Write(b)
+		}
+		write([]byte(node.Type))
+		write(node.Data)
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(node.Children)))])
+		for _, child := range node.Children {
+			h.Write(n[:binary.PutUvarint(n[:], walk(child))])
+		}
+		content := h.Sum64()
+		h.Write(n[:binary.PutUvarint(n[:], uint64(node.Pos))])
+		h.Write(n[:binary.PutUvarint(n[:], uint64(node.End))])
+		ids[node] = unique(NodeID(h.Sum64()), used)
+		return content
+	}
+	if p != nil {
+		walk(p)
+	}
+	return ids
+}
+
+// Carry returns NodeIDs for the nodes of tree, a parse of an edit of the
+// input that old, whose nodes ids are of, was parsed from. The nodes that
+// Diff matches with nodes of old keep their IDs, even if what they contain
+// or where they are changed; the others get the IDs that tree.IDs gives
+// them, unless one of the kept IDs is among those.
+func (ids NodeIDs) Carry(old, tree *ParseTree) NodeIDs {
+	carried := make(NodeIDs)
+	used := make(map[NodeID]bool)
+	var match func(a, b *ParseTree)
+	match = func(a, b *ParseTree) {
+		if id, ok := ids[a]; ok && !used[id] {
+			carried[b] = id
+			used[id] = true
+		}
+		alignChildren(a.Children, b.Children, func(i, j int) {
+			if i >= 0 && j >= 0 {
+				match(a.Children[i], b.Children[j])
+			}
+		})
+	}
+	if old != nil && tree != nil && old.Type == tree.Type {
+		match(old, tree)
+	}
+	fresh := tree.IDs()
+	var walk func(node *ParseTree)
+	walk = func(node *ParseTree) {
+		if _, ok := carried[node]; !ok {
+			carried[node] = unique(fresh[node], used)
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	if tree != nil {
+		walk(tree)
+	}
+	return carried
+}
+
+// unique returns id, or the first ID after it that is not yet used, and
+// marks it used.
+func unique(id NodeID, used map[NodeID]bool) NodeID {
+	for used[id] {
+		id++
+	}
+	used[id] = true
+	return id
+}
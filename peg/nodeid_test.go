@@ -0,0 +1,56 @@
+package peg
+
+import "testing"
+
+func TestNodeIDs(t *testing.T) {
+	lang, err := NewLanguage("list <- item more*\nmore <- ','^ item\nitem <- ~'[a-z]+'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(input string) *ParseTree {
+		tree, err := lang.ParseString(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+	old := parse("a,b,c")
+	ids := old.IDs()
+	reparsed := parse("a,b,c")
+	again := reparsed.IDs()
+	seen := make(map[NodeID]bool)
+	for node, id := range ids {
+		if seen[id] {
+			t.Errorf("%s: id %d given twice", node.SExpr(), id)
+		}
+		seen[id] = true
+	}
+	if len(ids) != len(again) || ids[old] != again[reparsed] || ids[old.Children[1]] != again[reparsed.Children[1]] {
+		t.Errorf("reparsing gave other ids")
+	}
+
+	// Inserting an item keeps the ids of the items around it, although the
+	// one after it moved.
+	tree := parse("a,x,b,c")
+	carried := ids.Carry(old, tree)
+	for _, tc := range []struct {
+		name     string
+		old, new *ParseTree
+	}{
+		{"root", old, tree},
+		{"first", old.Children[0], tree.Children[0]},
+		{"moved", old.Children[1].Children[0], tree.Children[1].Children[1]},
+		{"last", old.Children[1].Children[1], tree.Children[1].Children[2]},
+	} {
+		if carried[tc.new] != ids[tc.old] {
+			t.Errorf("%s: %s has id %d, exp %d of %s", tc.name, tc.new.SExpr(), carried[tc.new], ids[tc.old], tc.old.SExpr())
+		}
+	}
+	inserted := tree.Children[1].Children[0]
+	if id, ok := carried[inserted]; !ok || seen[id] {
+		t.Errorf("inserted %s has id %d, exp a new one", inserted.SExpr(), id)
+	}
+	if len(carried) != len(tree.IDs()) {
+		t.Errorf("carried ids for %d nodes, exp %d", len(carried), len(tree.IDs()))
+	}
+}
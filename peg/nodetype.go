@@ -0,0 +1,129 @@
+package peg
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// NodeType is the interned form of the type of a node. The parser sets the
+// ID of every node it builds, so that consumers can compare and switch on
+// small integers rather than strings:
+//
+//	sum := lang.RuleType("sum")
+//	if tree.ID == sum {
+//		...
+//	}
+//
+// Types are interned per language, so IDs only compare between trees of
+// the same language, and the table goes with the language when it is no
+// longer used. The zero NodeType is the type of nodes built without one,
+// such as those of external matchers that only set Type.
+type NodeType uint32
+
+// typeTable is the name table of the types interned by a language, indexed
+// by their ID. The zero table is empty.
+type typeTable struct {
+	sync.RWMutex
+	ids   map[string]NodeType
+	names []string
+}
+
+func (t *typeTable) intern(name string) NodeType {
+	t.RLock()
+	id, ok := t.ids[name]
+	t.RUnlock()
+	if ok || name == "" {
+		return id
+	}
+	t.Lock()
+	defer t.Unlock()
+	if id, ok := t.ids[name]; ok {
+		return id
+	}
+	if t.ids == nil {
+		t.ids, t.names = make(map[string]NodeType), []string{""}
+	}
+	id = NodeType(len(t.names))
+	t.ids[name] = id
+	t.names = append(t.names, name)
+	return id
+}
+
+// InternType returns the NodeType of name in l, assigning it on first use.
+// External matchers can use it to set the ID of the nodes they build.
+func (l *Language) InternType(name string) NodeType {
+	return l.types.intern(name)
+}
+
+// TypeName returns the name t was interned for in l, or "" if l has not
+// interned t.
+func (l *Language) TypeName(t NodeType) string {
+	l.types.RLock()
+	defer l.types.RUnlock()
+	if int(t) >= len(l.types.names) {
+		return ""
+	}
+	return l.types.names[t]
+}
+
+// RuleType returns the NodeType of the nodes of rule, or the zero NodeType
+// if the language has no such rule.
+func (l *Language) RuleType(rule string) NodeType {
+	if !l.hasRule(rule) {
+		return 0
+	}
+	return l.InternType(rule)
+}
+
+// nodeType is the type of the nodes a lexer builds. The lexer can serve
+// several languages, so it keeps the ID for the table it saw last rather
+// than interning the name for every node.
+type nodeType struct {
+	name string
+	last atomic.Value // the typeID of name in the last table.
+}
+
+type typeID struct {
+	table *typeTable
+	id    NodeType
+}
+
+func newNodeType(name string) *nodeType {
+	return &nodeType{name: name}
+}
+
+// get returns the ID of the type in the language parsing s.
+func (t *nodeType) get(s *Source) NodeType {
+	if s.lang == nil {
+		return 0
+	}
+	table := &s.lang.types
+	if last, ok := t.last.Load().(typeID); ok && last.table == table {
+		return last.id
+	}
+	id := table.intern(t.name)
+	t.last.Store(typeID{table, id})
+	return id
+}
+
+// lazyType holds the type of the nodes of a closure, which are named after
+// its operand once the grammar is resolved.
+type lazyType struct {
+	once sync.Once
+	typ  nodeType
+}
+
+func (t *lazyType) get(s *Source, lex *Lexeme, suffix string) (string, NodeType) {
+	t.once.Do(func() { t.typ.name = operandName(lex) + suffix })
+	return t.typ.name, t.typ.get(s)
+}
+
+// operandName returns the name closures of lex are named after. A label
+// names the matches of the closure, as in rest:term*, and is no part of
+// their type.
+func operandName(lex *Lexeme) string {
+	for lex.kind == kindCapture {
+		lex = lex.Dependencies[0]
+	}
+	return lex.Name
+}
@@ -0,0 +1,87 @@
+package peg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNodeType(t *testing.T) {
+	for _, tt := range []struct {
+		grammar string
+		input   string
+		opts    []Option
+	}{
+		{"sum <- num more*\nmore <- '+' num\nnum <- ~'[0-9]+'", "1+2+3", nil},
+		{"list <- item{2,}\nitem <- 'a' / 'b' / 'c'", "abca", nil},
+		{"word <- 'a' 'b' 'c' tail+\ntail <- 'x'", "abcxx", nil},
+		{"call <- 'f' balanced('(', ')') string('\"', '\\\\')", "f(a(b))\"s\"", nil},
+		{"%token num\nsum <- num '+'^ num\nnum <- ~'[0-9]+'", "1+2", nil},
+		{"pair <- x:~'[a-z]' '-' =x", "a-a", nil},
+		{"list <- '[' num* ']'\nnum <- ~'[0-9]+'", "[1x]", []Option{Tolerant(true)}},
+		{"word <- 'ab'", "AB", []Option{CaseInsensitive()}},
+	} {
+		lang, err := NewLanguage(tt.grammar, tt.opts...)
+		if err != nil {
+			t.Errorf("%q: %s", tt.grammar, err)
+			continue
+		}
+		// Tolerant parses return a tree along with the error.
+		tree, err := lang.ParseString(tt.input)
+		if tree == nil {
+			t.Errorf("%q: %s", tt.input, err)
+			continue
+		}
+		var check func(node *ParseTree)
+		check = func(node *ParseTree) {
+			if node.ID != lang.InternType(node.Type) || lang.TypeName(node.ID) != node.Type {
+				t.Errorf("%q: node %s has ID %d (%s)", tt.input, node.Type, node.ID, lang.TypeName(node.ID))
+			}
+			for _, child := range node.Children {
+				check(child)
+			}
+		}
+		check(tree)
+	}
+}
+
+func TestInternType(t *testing.T) {
+	lang, err := NewLanguage("sum <- num\nnum <- 'x'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := lang.InternType("intern_a"), lang.InternType("intern_b")
+	if a == b || a == 0 || lang.InternType("intern_a") != a || lang.InternType("") != 0 {
+		t.Errorf("got IDs %d and %d", a, b)
+	}
+	if lang.TypeName(a) != "intern_a" || lang.TypeName(0) != "" || lang.TypeName(1<<30) != "" {
+		t.Errorf("got names %q, %q and %q", lang.TypeName(a), lang.TypeName(0), lang.TypeName(1<<30))
+	}
+	if lang.RuleType("num") != lang.InternType("num") || lang.RuleType("missing") != 0 {
+		t.Errorf("got rule types %d and %d", lang.RuleType("num"), lang.RuleType("missing"))
+	}
+}
+
+// TestNodeTypePerLanguage checks that a language interns only the types of
+// its own trees, whatever other languages have parsed.
+func TestNodeTypePerLanguage(t *testing.T) {
+	var langs []*Language
+	for _, grammar := range []string{"list <- word+\nword <- ~'[a-z]'", "pair <- a b\na <- 'a'\nb <- 'b'"} {
+		lang, err := NewLanguage(grammar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		langs = append(langs, lang)
+	}
+	for i := 0; i < 2; i++ {
+		for _, lang := range langs {
+			if _, err := lang.ParseString("ab"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i, exp := range [][]string{{"", "word+", "word"}, {"", "a", "b", "pair"}} {
+		if got := langs[i].types.names; !reflect.DeepEqual(got, exp) {
+			t.Errorf("language %d interned %q, exp %q", i, got, exp)
+		}
+	}
+}
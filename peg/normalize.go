@@ -0,0 +1,34 @@
+package peg
+
+// Normalizer rewrites text into a normal form. The forms of
+// golang.org/x/text/unicode/norm, such as norm.NFC, are Normalizers.
+type Normalizer interface {
+	Bytes(b []byte) []byte
+	String(s string) string
+}
+
+// WithNormalization normalizes the literals of the grammar and the input
+// of every parse with n, so that text composed differently but meaning the
+// same, like "é" as one code point or as "e" and a combining accent,
+// matches alike:
+//
+//	lang, err := peg.NewLanguage(grammar, peg.WithNormalization(norm.NFC))
+//
+// The input is normalized before it is parsed, so the offsets and leaves of
+// the tree refer to the normalized text, which may differ in length from
+// the original. Regexps and external matchers see the normalized text but
+// are not rewritten themselves. StreamParsers don't normalize, as a
+// combining mark completing a character may not have been written yet.
+func WithNormalization(n Normalizer) Option {
+	return func(l *Language) {
+		l.config.normalizer = n
+	}
+}
+
+// normalize rewrites the buffer of s into the normal form of l, once.
+func (l *Language) normalize(s *Source) {
+	if l.config.normalizer == nil || s.normalized {
+		return
+	}
+	s.buf, s.lines, s.normalized = l.config.normalizer.Bytes(s.buf), nil, true
+}
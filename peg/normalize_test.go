@@ -0,0 +1,63 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+// composer composes "e" and a combining acute accent into "\u00e9", as a
+// stand in for norm.NFC.
+var composer = strings.NewReplacer("e\u0301", "\u00e9")
+
+type composeForm struct{}
+
+func (composeForm) Bytes(b []byte) []byte  { return []byte(composer.Replace(string(b))) }
+func (composeForm) String(s string) string { return composer.Replace(s) }
+
+func TestNormalization(t *testing.T) {
+	for _, tt := range []struct {
+		grammar string
+		input   string
+		leaves  []string
+	}{
+		{"word <- 'caf\u00e9'", "caf\u00e9", []string{"caf\u00e9"}},
+		{"word <- 'caf\u00e9'", "cafe\u0301", []string{"caf\u00e9"}},
+		{"word <- 'cafe\u0301'", "caf\u00e9", []string{"caf\u00e9"}},
+		{"word <- 'cafe\u0301'", "cafe\u0301", []string{"caf\u00e9"}},
+		{"%case_insensitive\nword <- 'CAFe\u0301'", "caf\u00e9", []string{"caf\u00e9"}},
+		{"list <- name+\nname <- ~'[a-z\u00e9]+' ' '^", "cafe\u0301 the\u0301 ", []string{"caf\u00e9", "th\u00e9"}},
+	} {
+		lang, err := NewLanguage(tt.grammar, WithNormalization(composeForm{}))
+		if err != nil {
+			t.Errorf("%q: %s", tt.grammar, err)
+			continue
+		}
+		tree, err := lang.ParseString(tt.input)
+		if err != nil {
+			t.Errorf("%q on %q: %s", tt.grammar, tt.input, err)
+			continue
+		}
+		var leaves []string
+		var walk func(node *ParseTree)
+		walk = func(node *ParseTree) {
+			if node.Data != nil {
+				leaves = append(leaves, string(node.Data))
+			}
+			for _, child := range node.Children {
+				walk(child)
+			}
+		}
+		walk(tree)
+		if strings.Join(leaves, ",") != strings.Join(tt.leaves, ",") {
+			t.Errorf("%q on %q: got leaves %q, want %q", tt.grammar, tt.input, leaves, tt.leaves)
+		}
+	}
+
+	lang, err := NewLanguage("word <- 'caf\u00e9'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lang.ParseString("cafe\u0301"); err == nil {
+		t.Errorf("decomposed input matched without normalization")
+	}
+}
@@ -0,0 +1,155 @@
+package peg
+
+import (
+	"bytes"
+)
+
+// optimize replaces the lexers reachable from roots with faster ones that
+// produce the same trees, errors and tokens:
+//
+//   - a choice between literals looks up the alternatives that start with
+//     the next input byte, so a choice of single characters matches like a
+//     character class and alternatives that share a prefix aren't tried one
+//     after another;
+//   - a run of adjacent literals in a sequence is compared at once, and only
+//     matched literal by literal if that fails.
+//
+// BenchmarkOptimize compares both with the lexers they replace. Literals
+// that fold case and runs of literals in a grammar with a %whitespace rule,
+// which may separate them, are left alone. Rules are not inlined and the
+// common prefixes of other alternatives are not hoisted.
+func optimize(roots []*Lexeme, d *directives) {
+	seen := make(map[*Lexeme]bool)
+	var walk func(lex *Lexeme)
+	walk = func(lex *Lexeme) {
+		if lex == nil || seen[lex] {
+			return
+		}
+		seen[lex] = true
+		for _, dep := range lex.Dependencies {
+			walk(dep)
+		}
+		if d.caseInsensitive {
+			return
+		}
+		switch lex.kind {
+		case kindChoice:
+			if set := newLiteralSet(lex.Dependencies); set != nil {
+				lex.Lexer = set.lexer(lex.Lexer)
+			}
+		case kindConcat:
+			if d.whitespace != "" || d.autoWhitespace {
+				return
+			}
+			if runs := literalRuns(lex.Dependencies); len(runs) > 0 {
+				lex.Lexer = concatLexer(lex.Name, lex.Dependencies, runs)
+			}
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+}
+
+// literalSet is a choice between literals indexed by their first byte.
+type literalSet struct {
+	literals []*Lexeme
+	ids      []*nodeType // the type of each literal.
+	text     [][]byte    // the text of each literal.
+	expected []string    // the expectations of the choice, in order.
+	first    [256][]int  // the literals starting with each byte, in order.
+}
+
+// newLiteralSet indexes alts, or returns nil if they aren't all non-empty
+// literals.
+func newLiteralSet(alts []*Lexeme) *literalSet {
+	set := &literalSet{literals: alts}
+	for i, alt := range alts {
+		if alt.kind != kindLiteral || alt.text == "" {
+			return nil
+		}
+		c := alt.text[0]
+		set.first[c] = append(set.first[c], i)
+		set.ids = append(set.ids, newNodeType(alt.Name))
+		set.text = append(set.text, []byte(alt.text))
+		if q := quoteLiteral(alt.text); !contains(set.expected, q) {
+			set.expected = append(set.expected, q)
+		}
+	}
+	return set
+}
+
+// lexer returns the indexed matcher, which leaves completion to choice.
+func (set *literalSet) lexer(choice LexFunc) LexFunc {
+	return func(s *Source, pos int) (*ParseTree, error, int) {
+		if s.completions != nil || s.stream {
+			return choice(s, pos)
+		}
+		skip := s.skipWhitespace(pos)
+		at := pos + skip
+		if at < len(s.buf) {
+			for _, i := range set.first[s.buf[at]] {
+				if text := set.text[i]; bytes.HasPrefix(s.buf[at:], text) {
+					return s.leaf(&ParseTree{
+						Type:    set.literals[i].Name,
+						ID:      set.ids[i].get(s),
+						Data:    text,
+						Pos:     at,
+						End:     at + len(text),
+						Leading: s.skipped(at, skip),
+					}), nil, skip + len(text)
+				}
+			}
+		}
+		err := s.expected(at, set.expected[0]).(*ParseError)
+		err.Expected = append([]string(nil), set.expected...)
+		return nil, err, 0
+	}
+}
+
+// literalRun is a sequence of adjacent literals and their concatenation.
+type literalRun struct {
+	literals []*Lexeme
+	ids      []*nodeType
+	text     []byte
+}
+
+// literalRuns finds the runs of two or more literals in deps, keyed by the
+// index of their first literal.
+func literalRuns(deps []*Lexeme) map[int]*literalRun {
+	runs := make(map[int]*literalRun)
+	for i := 0; i < len(deps); {
+		j := i
+		for j < len(deps) && deps[j].kind == kindLiteral && deps[j].text != "" {
+			j++
+		}
+		if j-i >= 2 {
+			run := &literalRun{literals: deps[i:j]}
+			for _, lit := range run.literals {
+				run.ids = append(run.ids, newNodeType(lit.Name))
+				run.text = append(run.text, lit.text...)
+			}
+			runs[i] = run
+		}
+		if j == i {
+			j++
+		}
+		i = j
+	}
+	return runs
+}
+
+// match adds the leaves of the run to b and returns its length if the input
+// at pos starts with it, and returns 0 otherwise.
+func (run *literalRun) match(s *Source, pos int, b *treeBuilder) int {
+	if !bytes.HasPrefix(s.buf[pos:], run.text) {
+		return 0
+	}
+	at := pos
+	for i, lit := range run.literals {
+		n := len(lit.text)
+		b.add(s.leaf(&ParseTree{Type: lit.Name, ID: run.ids[i].get(s), Data: s.buf[at : at+n], Pos: at, End: at + n}), at, n)
+		at += n
+	}
+	return at - pos
+}
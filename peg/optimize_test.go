@@ -0,0 +1,90 @@
+package peg
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	// Each call builds a fresh copy, so that one copy can be optimized and
+	// compared against the other.
+	languages := []struct {
+		name  string
+		build func() *Lexeme
+	}{
+		{"class", func() *Lexeme {
+			return NewPlusClosure(NewChoiceLexer("digit", NewLiteralLexer("digit", "0"), NewLiteralLexer("digit", "1"), NewLiteralLexer("digit", "2")))
+		}},
+		{"prefixes", func() *Lexeme {
+			return NewChoiceLexer("kw", NewLiteralLexer("kw", "for"), NewLiteralLexer("kw", "foreach"), NewLiteralLexer("kw", "func"))
+		}},
+		{"run", func() *Lexeme {
+			return NewConcatLexer("call", []*Lexeme{
+				NewLiteralLexer("call", "f"), NewLiteralLexer("call", "("), NewRegexpLexer("call", regexp.MustCompile("[a-z]*")), NewLiteralLexer("call", ")"), NewLiteralLexer("call", ";"),
+			})
+		}},
+	}
+	inputs := []string{"", "0", "2101", "3", "for", "foreach", "func", "fun", "f(x);", "f();", "f(x)", "f[x];"}
+	for _, l := range languages {
+		plain := &Language{root: l.build()}
+		optimized := &Language{root: l.build()}
+		optimize([]*Lexeme{optimized.root}, &directives{})
+		for _, input := range inputs {
+			want, wantErr := plain.ParseString(input)
+			got, gotErr := optimized.ParseString(input)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %q: expected %v, got %v", l.name, input, want, got)
+			}
+			if !reflect.DeepEqual(gotErr, wantErr) {
+				t.Errorf("%s %q: expected error %v, got %v", l.name, input, wantErr, gotErr)
+			}
+		}
+	}
+}
+
+func BenchmarkOptimize(b *testing.B) {
+	literals := func(typ string, texts ...string) []*Lexeme {
+		lexes := make([]*Lexeme, len(texts))
+		for i, text := range texts {
+			lexes[i] = NewLiteralLexer(typ, text)
+		}
+		return lexes
+	}
+	for _, bench := range []struct {
+		name  string
+		build func() *Lexeme
+		input string
+	}{
+		{"class", func() *Lexeme {
+			return NewPlusClosure(NewChoiceLexer("digit", literals("digit", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9")...))
+		}, strings.Repeat("9876543210", 1000)},
+		{"keywords", func() *Lexeme {
+			return NewPlusClosure(NewChoiceLexer("kw", literals("kw", "break", "case", "const", "continue", "default", "else", "for", "func", "go", "if", "return", "switch", "type", "var")...))
+		}, strings.Repeat("varswitchreturnforfunc", 500)},
+		{"run", func() *Lexeme {
+			return NewPlusClosure(NewConcatLexer("call", literals("call", "f", "(", ")", ";")))
+		}, strings.Repeat("f();", 2500)},
+	} {
+		for _, optimized := range []bool{false, true} {
+			lang := &Language{root: bench.build()}
+			if optimized {
+				optimize([]*Lexeme{lang.root}, &directives{})
+			}
+			name := bench.name + "/plain"
+			if optimized {
+				name = bench.name + "/optimized"
+			}
+			b.Run(name, func(b *testing.B) {
+				b.SetBytes(int64(len(bench.input)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if tree, err := lang.ParseString(bench.input); err != nil || tree.End != len(bench.input) {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package peg
+
+import (
+	"context"
+	"sync"
+)
+
+// NewLanguage compiles the grammar src. It is NewParser for grammars held
+// in a string, and takes the same options.
+func NewLanguage(src string, opts ...Option) (*Language, error) {
+	return compile(context.Background(), src, nil, opts)
+}
+
+// MustNewLanguage is like NewLanguage but panics if src does not compile.
+// It simplifies the initialization of package variables holding
+// languages, and of tests.
+func MustNewLanguage(src string, opts ...Option) *Language {
+	lang, err := NewLanguage(src, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return lang
+}
+
+// Lazy returns a function that compiles src with MustNewLanguage the first
+// time it is called, and returns the same language to every call. Package
+// variables of grammars held in constants then cost nothing until used:
+//
+//	var exprLang = peg.Lazy(exprGrammar)
+//
+//	tree, err := exprLang().ParseString(input)
+//
+// A grammar that fails to compile panics in every call.
+func Lazy(src string, opts ...Option) func() *Language {
+	return sync.OnceValue(func() *Language {
+		return MustNewLanguage(src, opts...)
+	})
+}
+
+// NewLanguageContext is NewLanguage, but gives up compiling src with the
+// error of ctx once ctx is done.
+func NewLanguageContext(ctx context.Context, src string, opts ...Option) (*Language, error) {
+	return compile(ctx, src, nil, opts)
+}
+
+// Start makes rule the root rule, as %start does. It takes precedence over
+// a %start directive of the grammar.
+func Start(rule string) Option {
+	return func(l *Language) {
+		l.config.start = rule
+	}
+}
+
+// Whitespace makes rule the rule skipped before every literal and regexp,
+// as %whitespace does. It takes precedence over a %whitespace directive of
+// the grammar.
+func Whitespace(rule string) Option {
+	return func(l *Language) {
+		l.config.whitespace = rule
+	}
+}
+
+// AutoWhitespace skips whitespace and the given comments before every
+// literal and regexp, as %auto_whitespace does, so that the elements of
+// sequences may be separated by them without a %whitespace rule. A comment
+// is the text that starts a line comment, such as "//" or "#", or the
+// texts that start and end a block comment separated by a space, such as
+// "/* */".
+func AutoWhitespace(comments ...string) Option {
+	return func(l *Language) {
+		l.config.autoWhitespace, l.config.comments = true, comments
+	}
+}
+
+// Memo caches the results of rules at each input position, as %memo does,
+// in addition to the rules the grammar memoizes.
+func Memo(rules ...string) Option {
+	return func(l *Language) {
+		l.config.memo = append(l.config.memo, rules...)
+	}
+}
+
+// Inline replaces the nodes of rules by their children, as %inline does,
+// in addition to the rules the grammar inlines.
+func Inline(rules ...string) Option {
+	return func(l *Language) {
+		l.config.inline = append(l.config.inline, rules...)
+	}
+}
+
+// CaseInsensitive makes literals match regardless of case, as if the
+// grammar started with %case_insensitive.
+func CaseInsensitive() Option {
+	return func(l *Language) {
+		l.config.caseInsensitive = true
+	}
+}
@@ -0,0 +1,74 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDirectiveOptions(t *testing.T) {
+	grammar := "%start a\na <- 'x' b\nb <- 'Y' c\nc <- ~'[0-9]'\nws <- ~' +'"
+	lang, err := NewLanguage(grammar, Start("b"), Whitespace("ws"), Memo("c"), CaseInsensitive())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString(" y 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Type != "b" || len(tree.Children) != 2 {
+		t.Errorf("unexpected tree %s", tree.SExpr())
+	}
+	exp := "%case_insensitive\n%start b\n%whitespace ws\n%memo c\n"
+	if out := lang.Grammar(); !strings.HasPrefix(out, exp) {
+		t.Errorf("Grammar() = %q, expected it to start with %q", out, exp)
+	}
+
+	// Options and directives of the grammar combine.
+	lang, err = NewLanguage("%memo b\n"+grammar, Memo("b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := lang.Grammar(); !strings.HasPrefix(out, "%start a\n%memo b c\n") {
+		t.Errorf("unexpected directives in %q", out)
+	}
+
+	for _, opt := range []Option{Start("nope"), Whitespace("nope"), Memo("nope")} {
+		if _, err := NewLanguage(grammar, opt); err == nil {
+			t.Errorf("expected an error for an undefined rule")
+		}
+	}
+}
+
+func TestMust(t *testing.T) {
+	lang := MustNewLanguage("num <- ~'[0-9]+'")
+	if got := lang.MustParseString("42").SExpr(); got != `(num "42")` {
+		t.Errorf("got %s", got)
+	}
+	for _, tc := range []struct {
+		name string
+		fn   func()
+	}{
+		{"grammar", func() { MustNewLanguage("num <- ") }},
+		{"input", func() { lang.MustParseString("x") }},
+		{"lazy", func() { Lazy("num <- ")() }},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: did not panic", tc.name)
+				}
+			}()
+			tc.fn()
+		}()
+	}
+}
+
+func TestLazy(t *testing.T) {
+	lang := Lazy("num <- ~'[0-9]+'", Start("num"))
+	if lang() != lang() {
+		t.Errorf("Lazy compiled the grammar twice")
+	}
+	if got := lang().MustParseString("7").SExpr(); got != `(num "7")` {
+		t.Errorf("got %s", got)
+	}
+}
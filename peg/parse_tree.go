@@ -1,13 +1,25 @@
 package peg
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"reflect"
+	"sort"
 )
 
 type ParseTree struct {
 	Type     string
+	ID       NodeType // interned Type, set if the parser built the node.
 	Data     []byte
 	Children []*ParseTree
+	Value    interface{} // decoded value of typed leaves.
+	Pos      int         // offset of the first byte matched.
+	End      int         // offset just past the last byte matched.
+	Leading  []byte      // trivia before the node, kept in Lossless mode.
+	Trailing []byte      // trivia after the node that precedes no sibling.
 }
 
 func (p *ParseTree) prettyPrint(indent string) string {
@@ -22,3 +34,124 @@ func (p *ParseTree) prettyPrint(indent string) string {
 func (p *ParseTree) String() string {
 	return p.prettyPrint("")
 }
+
+// EqualOption relaxes the comparison of Equal.
+type EqualOption func(*equalConfig)
+
+type equalConfig struct {
+	positions bool
+	data      bool
+	trivia    bool
+}
+
+// IgnorePositions makes Equal skip Pos and End.
+func IgnorePositions() EqualOption {
+	return func(c *equalConfig) {
+		c.positions = false
+	}
+}
+
+// IgnoreData makes Equal skip Data and Value.
+func IgnoreData() EqualOption {
+	return func(c *equalConfig) {
+		c.data = false
+	}
+}
+
+// IgnoreTrivia makes Equal skip Leading and Trailing.
+func IgnoreTrivia() EqualOption {
+	return func(c *equalConfig) {
+		c.trivia = false
+	}
+}
+
+// TypesOnly makes Equal compare only the types and the shape of the trees.
+func TypesOnly() EqualOption {
+	return func(c *equalConfig) {
+		*c = equalConfig{}
+	}
+}
+
+// Equal reports whether p and other are the same tree: nodes of the same
+// types, with equal Data, Value, positions and trivia, and equal children
+// in the same order. Options leave some of the fields out.
+func (p *ParseTree) Equal(other *ParseTree, opts ...EqualOption) bool {
+	c := equalConfig{positions: true, data: true, trivia: true}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c.equal(p, other)
+}
+
+func (c *equalConfig) equal(a, b *ParseTree) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || len(a.Children) != len(b.Children) {
+		return false
+	}
+	if c.positions && (a.Pos != b.Pos || a.End != b.End) {
+		return false
+	}
+	if c.data && (!bytes.Equal(a.Data, b.Data) || !reflect.DeepEqual(a.Value, b.Value)) {
+		return false
+	}
+	if c.trivia && (!bytes.Equal(a.Leading, b.Leading) || !bytes.Equal(a.Trailing, b.Trailing)) {
+		return false
+	}
+	for i, child := range a.Children {
+		if !c.equal(child, b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash returns a fingerprint of the type, Data and children of the tree.
+// It leaves out positions, values and trivia, so equal subtrees at
+// different places of the input, or of different inputs, hash the same.
+// The hash is the 64-bit FNV-1a of a length prefixed encoding of the tree,
+// which is stable across processes and versions of Go.
+func (p *ParseTree) Hash() uint64 {
+	h := fnv.New64a()
+	p.writeHash(h)
+	return h.Sum64()
+}
+
+func (p *ParseTree) writeHash(h hash.Hash64) {
+	var n [binary.MaxVarintLen64]byte
+	write := func(b []byte) {
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(b)))])
+		h.Write(b)
+	}
+	write([]byte(p.Type))
+	write(p.Data)
+	h.Write(n[:binary.PutUvarint(n[:], uint64(len(p.Children)))])
+	for _, child := range p.Children {
+		child.writeHash(h)
+	}
+}
+
+// NodeAt returns the deepest node of the tree covering the byte at offset,
+// along with its ancestors from the root down to its parent, which is
+// where hovering, going to a definition and expanding a selection start.
+// A node covers the bytes from Pos up to End, so an offset between the
+// children of a node, such as in skipped whitespace, returns the node
+// itself. It returns nil if the tree doesn't cover offset. Children are
+// expected in the order of their positions, as the parser builds them.
+func (p *ParseTree) NodeAt(offset int) (*ParseTree, []*ParseTree) {
+	if p == nil || offset < p.Pos || offset >= p.End {
+		return nil, nil
+	}
+	node := p
+	var ancestors []*ParseTree
+	for {
+		children := node.Children
+		i := sort.Search(len(children), func(i int) bool { return children[i].End > offset })
+		if i == len(children) || children[i].Pos > offset {
+			return node, ancestors
+		}
+		ancestors = append(ancestors, node)
+		node = children[i]
+	}
+}
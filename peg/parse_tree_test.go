@@ -1 +1,131 @@
 package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("%whitespace ws\nprgm <- num+\nnum <- ~'[0-9]+'\nws <- ' '"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(src string) *ParseTree {
+		tree, err := lang.ParseString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+	a := parse("1 2")
+	tests := []struct {
+		b    *ParseTree
+		opts []EqualOption
+		exp  bool
+	}{
+		{parse("1 2"), nil, true},
+		{parse("1  2"), nil, false},
+		{parse("1  2"), []EqualOption{IgnorePositions()}, true},
+		{parse("1 3"), []EqualOption{IgnorePositions()}, false},
+		{parse("1 3"), []EqualOption{IgnoreData()}, true},
+		{parse("10  3"), []EqualOption{IgnoreData()}, false},
+		{parse("10  3"), []EqualOption{TypesOnly()}, true},
+		{parse("10 3 4"), []EqualOption{TypesOnly()}, false},
+		{nil, nil, false},
+	}
+	for i, test := range tests {
+		if got := a.Equal(test.b, test.opts...); got != test.exp {
+			t.Errorf("%d: Equal = %v, exp %v", i, got, test.exp)
+		}
+	}
+
+	lossless, err := NewParser(strings.NewReader("%whitespace ws\nprgm <- num+\nnum <- ~'[0-9]+'\nws <- ' '"), Lossless(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := lossless.ParseString("1 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Equal(b) || !a.Equal(b, IgnoreTrivia()) {
+		t.Errorf("trivia was not compared as expected")
+	}
+	if !(*ParseTree)(nil).Equal(nil) {
+		t.Errorf("nil trees differ")
+	}
+}
+
+func TestHash(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("%whitespace ws\nprgm <- pair+\npair <- num num\nnum <- ~'[0-9]+'\nws <- ' '"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(src string) *ParseTree {
+		tree, err := lang.ParseString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+	a, b := parse("1 2 3 4"), parse("3 4  1 2")
+	if a.Hash() != parse("1 2 3 4").Hash() {
+		t.Errorf("hash is not deterministic")
+	}
+	if a.Hash() == b.Hash() {
+		t.Errorf("reordered trees hash the same")
+	}
+	if a.Children[0].Hash() != b.Children[1].Hash() || a.Children[1].Hash() != b.Children[0].Hash() {
+		t.Errorf("moved subtrees hash differently")
+	}
+	// The encoding keeps the boundaries between fields, and is stable.
+	x := &ParseTree{Type: "ab", Data: []byte("c")}
+	y := &ParseTree{Type: "a", Data: []byte("bc")}
+	if x.Hash() == y.Hash() {
+		t.Errorf("ambiguous encoding")
+	}
+	if got := x.Hash(); got != 0x36eaf70156ae9922 {
+		t.Errorf("hash changed: %#x", got)
+	}
+}
+
+func TestNodeAt(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("%whitespace ws\nprgm <- call+\ncall <- name '('^ args ')'^\nargs <- name*\nname <- ~'[a-z]+'\nws <- ' '"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "f(ab c) g( )"
+	tree, err := lang.ParseString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		offset int
+		node   string // the text of the node, or "" for none.
+		path   []string
+	}{
+		{0, "f", []string{"call+", "call"}},
+		{1, "f(ab c)", []string{"call+"}},
+		{3, "ab", []string{"call+", "call", "name*"}},
+		{4, "ab c", []string{"call+", "call"}},
+		{5, "c", []string{"call+", "call", "name*"}},
+		// Nodes start before the whitespace skipped for their first leaf.
+		{7, " g( )", []string{"call+"}},
+		{8, "g", []string{"call+", "call"}},
+		{10, " g( )", []string{"call+"}},
+		{12, "", nil},
+		{-1, "", nil},
+	} {
+		node, ancestors := tree.NodeAt(tt.offset)
+		var got string
+		if node != nil {
+			got = src[node.Pos:node.End]
+		}
+		var path []string
+		for _, a := range ancestors {
+			path = append(path, a.Type)
+		}
+		if got != tt.node || strings.Join(path, " ") != strings.Join(tt.path, " ") {
+			t.Errorf("NodeAt(%d) = %q in %v, want %q in %v", tt.offset, got, path, tt.node, tt.path)
+		}
+	}
+}
@@ -1,47 +1,257 @@
 package peg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type parseStateFn func(*parser) parseStateFn
 
 type parser struct {
-	lex     *lexer
-	state   parseStateFn
-	parts   chan *Lexeme
-	lastErr error
+	lex        *lexer
+	state      parseStateFn
+	parts      chan rule
+	errs       GrammarErrors
+	item       item // the last item read.
+	closed     bool // whether the items are exhausted.
+	rule       string
+	defined    map[string]bool
+	refs       []reference
+	directives directives
+	scoped     bool   // whether the current rule captures labeled text.
+	added      []rule // rules defined in Go rather than by the grammar.
+	// config holds the directives set by options, which take precedence.
+	config directives
+
+	templates    map[string]*template
+	params       []string // the parameters of the template being defined.
+	instances    []instance
+	instantiated map[string]bool // the names of the instances.
+	instancing   bool            // whether an instance is being defined.
+	replay       []item          // items to read again before the lexer's.
+	doc          []string        // the ## lines before the next rule.
+}
+
+// reference is a use of a rule, checked once all rules are defined.
+type reference struct {
+	name, rule string
+	at         item
+}
+
+// directives holds the language level settings declared with %pragmas.
+type directives struct {
+	indent          bool          // provide INDENT, SAMEDENT and DEDENT.
+	caseInsensitive bool          // match literals regardless of case.
+	start           string        // the root rule, if not the first one.
+	whitespace      string        // the rule skipped before literals and regexps.
+	autoWhitespace  bool          // skip spaces and comments without a rule.
+	comments        []string      // the comments skipped by autoWhitespace.
+	memo            []string      // the rules whose results are cached.
+	tokens          []string      // the rules matched as a single leaf.
+	inline          []string      // the rules whose nodes are replaced by their children.
+	keywords        []string      // the literals matched as keywords.
+	identifiers     []string      // the rules whose matches are never keywords.
+	deprecated      []deprecation // the rules that warn when matched.
+	normalizer      Normalizer    // applied to literals and input, if set.
+	tests           []GrammarTest
+}
+
+// override applies the directives set in o, replacing the start rule and
+// the whitespace, by rule or automatic, of d and adding to its memoized and
+// inline rules.
+func (d *directives) override(o directives) {
+	d.caseInsensitive = d.caseInsensitive || o.caseInsensitive
+	if o.start != "" {
+		d.start = o.start
+	}
+	if o.whitespace != "" {
+		d.whitespace, d.autoWhitespace, d.comments = o.whitespace, false, nil
+	}
+	if o.autoWhitespace {
+		d.whitespace, d.autoWhitespace, d.comments = "", true, o.comments
+	}
+	for _, name := range o.memo {
+		if !contains(d.memo, name) {
+			d.memo = append(d.memo, name)
+		}
+	}
+	for _, name := range o.inline {
+		if !contains(d.inline, name) {
+			d.inline = append(d.inline, name)
+		}
+	}
+}
+
+// NewParser compiles the grammar read from input.
+func NewParser(input io.Reader, opts ...Option) (*Language, error) {
+	source, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return compile(context.Background(), string(source), nil, opts)
 }
 
-func NewParser(input io.Reader) (*Language, error) {
-	l := lex(input)
-	p := &parser{lex: l}
-	return p.prepare()
+// compile builds the language of the grammar source, unless ctx is done
+// first. The rules bound with WithRule and then the added rules are defined
+// after those of the grammar, replacing any with the same name.
+func compile(ctx context.Context, source string, added []rule, opts []Option) (*Language, error) {
+	// The parser needs the rules and directives set by options before the
+	// language exists, so collect them from the options first.
+	var pre Language
+	for _, opt := range opts {
+		opt(&pre)
+	}
+	p := &parser{
+		lex:          lexContext(ctx, strings.NewReader(source)),
+		defined:      make(map[string]bool),
+		added:        append(append([]rule(nil), pre.bound...), added...),
+		config:       pre.config,
+		templates:    make(map[string]*template),
+		instantiated: make(map[string]bool),
+	}
+	lang, err := p.prepare()
+	// The grammar may be broken off where the lexer stopped.
+	p.lex.Close()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	lang.source, lang.added, lang.opts = source, added, opts
+	for _, opt := range opts {
+		opt(lang)
+	}
+	return lang, nil
 }
 
+// Errorf records a problem at the last item read.
 func (p *parser) Errorf(format string, args ...interface{}) {
-	s := fmt.Sprintf(format, args...)
-	p.lastErr = errors.New(s)
+	p.errs = append(p.errs, &GrammarError{
+		Rule: p.rule,
+		Line: p.item.line,
+		Col:  p.item.col,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (p *parser) next() (item, bool) {
+	if len(p.replay) > 0 {
+		p.item, p.replay = p.replay[0], p.replay[1:]
+		return p.item, true
+	}
+	next, ok := <-p.lex.items
+	if !ok {
+		p.closed = true
+		return next, false
+	}
+	p.item = next
+	return next, true
+}
+
+// primary is like the function primary, but remembers rule references
+// and applies %keywords, %case_insensitive and normalization to literals.
+func (p *parser) primary(name string, next item) (*Lexeme, error) {
+	if next.typ == itemIdentifier && !p.isParam(next.val) {
+		p.refs = append(p.refs, reference{next.val, p.rule, next})
+	}
+	lex, err := primary(name, next)
+	if lex != nil && len(p.directives.keywords) > 0 {
+		switch {
+		case lex.kind == kindLiteral && contains(p.directives.keywords, lex.text):
+			word := lex.text
+			lex = NewKeywordLexer(lex.Name, word)
+			// Printed as it was written.
+			lex.text = quoteLiteral(word)
+			return lex, err
+		case lex.kind == kindRegexp:
+			if reserved := p.directives.reservedIn(p.rule); reserved != nil {
+				lex = newRegexpLexer(lex.Name, regexp.MustCompile(lex.text), reserved)
+			}
+		}
+	}
+	if lex != nil && lex.kind == kindLiteral && p.config.normalizer != nil {
+		if text := p.config.normalizer.String(lex.text); text != lex.text {
+			lex = NewLiteralLexer(lex.Name, text)
+		}
+	}
+	if lex != nil && lex.kind == kindLiteral && (p.directives.caseInsensitive || p.config.caseInsensitive) {
+		lex = NewFoldLiteralLexer(lex.Name, lex.text)
+	}
+	return lex, err
+}
+
+// checkReferences reports references to rules that are never defined.
+func (p *parser) checkReferences() {
+	provided := make(map[string]bool)
+	for name := range builtinRules {
+		provided[name] = true
+	}
+	if p.directives.indent {
+		for _, builtin := range indentBuiltins() {
+			provided[builtin.Name] = true
+		}
+	}
+	for _, r := range p.added {
+		provided[r.name] = true
+	}
+	for _, ref := range p.refs {
+		if !p.defined[ref.name] && !provided[ref.name] {
+			p.errs = append(p.errs, &GrammarError{
+				Rule: ref.rule,
+				Line: ref.at.line,
+				Col:  ref.at.col,
+				Msg:  fmt.Sprintf("undefined rule %s", ref.name),
+			})
+		}
+	}
+	for _, r := range p.added {
+		for _, name := range r.refs {
+			if !p.defined[name] && !provided[name] {
+				p.errs = append(p.errs, &GrammarError{Rule: r.name, Msg: fmt.Sprintf("undefined rule %s", name)})
+			}
+		}
+	}
 }
 
 func (p *parser) prepare() (*Language, error) {
-	p.parts = make(chan *Lexeme)
+	p.parts = make(chan rule)
 	in := make(chan *Language, 1)
 	err := make(chan error, 1)
-	go constructLanguage(p.parts, in, err)
+	go constructLanguage(p.parts, &p.directives, in, err)
 
 	for p.state = parseLexeme; p.state != nil; {
+		errs := len(p.errs)
 		p.state = p.state(p)
+		// Skip the rest of a broken rule to look for further problems.
+		if p.state == nil && len(p.errs) > errs && !p.closed {
+			p.state = skipRule
+		}
 	}
 
+	p.instantiate()
+	p.directives.override(p.config)
+	for _, r := range p.added {
+		r.lex = guarded(r.name, r.lex)
+		p.parts <- r
+	}
 	close(p.parts)
 
-	if p.lastErr != nil {
-		return nil, p.lastErr
+	p.checkReferences()
+	if len(p.errs) > 0 {
+		sort.SliceStable(p.errs, func(i, j int) bool {
+			a, b := p.errs[i], p.errs[j]
+			return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+		})
+		return nil, p.errs
 	}
 
 	select {
@@ -52,28 +262,130 @@ func (p *parser) prepare() (*Language, error) {
 	}
 }
 
-func constructLanguage(parts chan *Lexeme, success chan *Language, failure chan error) {
+func constructLanguage(parts chan rule, d *directives, success chan *Language, failure chan error) {
 	var lexemes = make(map[string]*Lexeme)
+	var rules []rule
 	first, ok := <-parts
 	if !ok {
 		failure <- errors.New("Parts channel was empty.")
 		return
 	}
-	lexemes[first.Name] = first
-	for part := range parts {
-		lexemes[part.Name] = part
+	index := make(map[string]int)
+	start := ""
+	for part, ok := first, true; ok; part, ok = <-parts {
+		// Templates are only printed, their instances are rules.
+		if part.params != nil {
+			rules = append(rules, part)
+			continue
+		}
+		if start == "" {
+			start = part.name
+		}
+		// The placeholder is overwritten once resolved, so remember what
+		// an alias of another rule refers to.
+		if part.lex.Lexer == nil {
+			part.alias = part.lex.text
+		}
+		if i, ok := index[part.name]; ok {
+			rules[i] = part
+		} else {
+			index[part.name] = len(rules)
+			rules = append(rules, part)
+		}
+	}
+	for i, r := range rules {
+		if r.params == nil {
+			rules[i].lex = definition(r.name, r.lex)
+			lexemes[r.name] = rules[i].lex
+		}
+	}
+	for name, builtin := range builtinRules {
+		if _, ok := lexemes[name]; !ok {
+			lexemes[name] = builtin()
+		}
+	}
+	// The directives are complete once parts is closed.
+	if d.indent {
+		for _, builtin := range indentBuiltins() {
+			if _, ok := lexemes[builtin.Name]; !ok {
+				lexemes[builtin.Name] = builtin
+			}
+		}
 	}
 
-	lex, err := resolveDependencies(first, lexemes)
+	if d.start != "" {
+		start = d.start
+	}
+	if start == "" {
+		failure <- errors.New("grammar defines no rules")
+		return
+	}
+	checked := append([]string{start, d.whitespace}, d.memo...)
+	for _, dep := range d.deprecated {
+		checked = append(checked, dep.rule)
+	}
+	checked = append(append(checked, d.inline...), d.identifiers...)
+	for _, name := range append(checked, d.tokens...) {
+		if _, ok := lexemes[name]; !ok && name != "" {
+			failure <- errors.New(fmt.Sprintf("undefined rule %s", name))
+			return
+		}
+	}
+	for _, dep := range d.deprecated {
+		lexemes[dep.rule] = NewDeprecatedLexer(dep.rule, dep.msg, lexemes[dep.rule])
+	}
+	for _, name := range d.memo {
+		lexemes[name] = NewMemoLexer(lexemes[name])
+	}
+
+	lex, err := resolveDependencies(lexemes[start], lexemes)
 	if err != nil {
 		failure <- err
 		return
-	} else {
-		success <- &Language{
-			root: lex,
-		}
+	}
+	lang := &Language{
+		root:       lex,
+		rules:      rules,
+		directives: *d,
+		inline:     inlineRules(rules, d.inline),
+	}
+	switch {
+	case d.autoWhitespace && d.whitespace != "":
+		failure <- errors.New("%auto_whitespace and %whitespace rule " + d.whitespace + " exclude each other")
 		return
+	case d.autoWhitespace:
+		if lang.whitespace, err = newSpaceLexer(d.comments); err != nil {
+			failure <- err
+			return
+		}
+	case d.whitespace != "":
+		if lang.whitespace, err = resolveDependencies(lexemes[d.whitespace], lexemes); err != nil {
+			failure <- err
+			return
+		}
 	}
+	// Resolve the rules the root doesn't use too, so that any rule can
+	// start a parse.
+	roots := []*Lexeme{lang.root, lang.whitespace}
+	for _, r := range rules {
+		if r.params != nil {
+			continue
+		}
+		if _, err := resolveDependencies(r.lex, lexemes); err != nil {
+			failure <- err
+			return
+		}
+		roots = append(roots, r.lex)
+	}
+	optimize(roots, d)
+	for _, name := range d.tokens {
+		if err := compileToken(name, rules[index[name]].lex, d.caseInsensitive, d.reservedIn(name)); err != nil {
+			failure <- err
+			return
+		}
+	}
+	compileRegulars(lang, roots, d)
+	success <- lang
 }
 
 func resolveDependencies(lex *Lexeme, env map[string]*Lexeme) (*Lexeme, error) {
@@ -81,21 +393,29 @@ func resolveDependencies(lex *Lexeme, env map[string]*Lexeme) (*Lexeme, error) {
 		return lex, nil
 	}
 	old := lex
-	if lex.Lexer == nil {
-		p, ok := env[lex.Name[1:]]
+	// Follow rules that are defined as another rule.
+	for seen := 0; lex.Lexer == nil; seen++ {
+		if seen > len(env) {
+			return nil, errors.New(fmt.Sprintf("rule %s does not define anything", old.text))
+		}
+		p, ok := env[lex.text]
 		if !ok {
-			return nil, errors.New(fmt.Sprintf("Cannot resolve dependency %s\n Available are: %v", lex.Name[1:], env))
+			return nil, errors.New(fmt.Sprintf("Cannot resolve dependency %s\n Available are: %v", lex.text, env))
 		} else {
 			lex = p
 		}
 	}
-	lex.isResolved = true
+	// A resolved rule may still be in the middle of resolving its own
+	// dependencies if it is part of a cycle, so don't descend into it again.
+	if !lex.isResolved {
+		lex.isResolved = true
 
-	for i, dep := range lex.Dependencies {
-		var err error
-		lex.Dependencies[i], err = resolveDependencies(dep, env)
-		if err != nil {
-			return nil, err
+		for i, dep := range lex.Dependencies {
+			var err error
+			lex.Dependencies[i], err = resolveDependencies(dep, env)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -104,27 +424,207 @@ func resolveDependencies(lex *Lexeme, env map[string]*Lexeme) (*Lexeme, error) {
 	return lex, nil
 }
 
+// skipRule discards the items up to the end of the current rule.
+func skipRule(p *parser) parseStateFn {
+	for next := p.item; next.typ != itemNewline; {
+		// The lexer stops at its first error.
+		if next.typ == itemEOF || next.typ == itemError {
+			return nil
+		}
+		var ok bool
+		if next, ok = p.next(); !ok {
+			return nil
+		}
+		if next.typ == itemError {
+			p.Errorf("lex error: %s", next.String())
+		}
+	}
+	return parseLexeme
+}
+
 func parseLexeme(p *parser) parseStateFn {
-	next, ok := <-p.lex.items
+	p.rule, p.params = "", nil
+	next, ok := p.next()
 	if !ok {
 		return nil
 	}
 	switch next.typ {
 	case itemIdentifier:
 		return parseRule(next.val)
-	case itemWhitespace:
+	case itemWhitespace, itemNewline:
 		return parseLexeme
+	case itemDoc:
+		p.doc = append(p.doc, strings.TrimPrefix(next.val, " "))
+		return parseLexeme
+	case itemDirective:
+		p.rule, p.doc = "", nil
+		return parseDirective(next.val, nil)
+	case itemCall:
+		return parseTemplate(next.val, []string{})
+	case itemEOF:
+		return nil
 	case itemError:
 		p.Errorf("lex error: %s", next.String())
 	default:
-		panic(next.typ.String())
+		p.Errorf("expected rule definition, found %v", next)
 	}
 	return nil
 }
 
+func parseDirective(name string, args []item) parseStateFn {
+	return func(p *parser) parseStateFn {
+		next, ok := p.next()
+		if !ok {
+			p.Errorf("item channel drained unexpectedly in parseDirective")
+			return nil
+		}
+		switch next.typ {
+		case itemWhitespace:
+			return parseDirective(name, args)
+		case itemIdentifier:
+			return parseDirective(name, append(args, next))
+		case itemLiteral:
+			if name != "test" && name != "deprecated" && name != "import" && name != "auto_whitespace" {
+				p.Errorf("unexpected token in %%%s: %v", name, next)
+				return nil
+			}
+			return parseDirective(name, append(args, next))
+		case itemNewline, itemEOF:
+		case itemError:
+			p.Errorf("lex error: %s", next.String())
+			return nil
+		default:
+			p.Errorf("unexpected token in %%%s: %v", name, next)
+			return nil
+		}
+
+		if name != "test" && (len(p.defined) > 0 || len(p.templates) > 0) {
+			p.Errorf("%%%s must precede the rules", name)
+			return nil
+		}
+		switch name {
+		case "indent", "case_insensitive":
+			if len(args) != 0 {
+				p.Errorf("%%%s takes no arguments", name)
+				return nil
+			}
+			if name == "indent" {
+				p.directives.indent = true
+			} else {
+				p.directives.caseInsensitive = true
+			}
+		case "start", "whitespace":
+			if len(args) != 1 {
+				p.Errorf("%%%s takes a single rule", name)
+				return nil
+			}
+			p.refs = append(p.refs, reference{args[0].val, "", args[0]})
+			if name == "start" {
+				p.directives.start = args[0].val
+			} else {
+				p.directives.whitespace = args[0].val
+			}
+		case "auto_whitespace":
+			var comments []string
+			for _, arg := range args {
+				if arg.typ != itemLiteral {
+					p.Errorf("%%auto_whitespace takes the comments to skip")
+					return nil
+				}
+				comment, err := unquote(arg.val)
+				if err != nil {
+					p.Errorf("%%auto_whitespace: %s", err)
+					return nil
+				}
+				comments = append(comments, comment)
+			}
+			p.directives.autoWhitespace, p.directives.comments = true, comments
+		case "keywords":
+			if len(args) == 0 {
+				p.Errorf("%%keywords takes at least one word")
+				return nil
+			}
+			for _, arg := range args {
+				p.directives.keywords = append(p.directives.keywords, arg.val)
+			}
+		case "memo", "token", "inline", "identifier":
+			if len(args) == 0 {
+				p.Errorf("%%%s takes at least one rule", name)
+				return nil
+			}
+			for _, arg := range args {
+				p.refs = append(p.refs, reference{arg.val, "", arg})
+				switch name {
+				case "memo":
+					p.directives.memo = append(p.directives.memo, arg.val)
+				case "token":
+					p.directives.tokens = append(p.directives.tokens, arg.val)
+				case "identifier":
+					p.directives.identifiers = append(p.directives.identifiers, arg.val)
+				default:
+					p.directives.inline = append(p.directives.inline, arg.val)
+				}
+			}
+		case "deprecated":
+			if len(args) != 2 || args[0].typ != itemIdentifier || args[1].typ != itemLiteral {
+				p.Errorf("%%deprecated takes a rule and a message")
+				return nil
+			}
+			msg, err := unquote(args[1].val)
+			if err != nil {
+				p.Errorf("%%deprecated: %s", err)
+				return nil
+			}
+			p.refs = append(p.refs, reference{args[0].val, "", args[0]})
+			p.directives.deprecated = append(p.directives.deprecated, deprecation{args[0].val, msg})
+		case "test":
+			test, ok := p.parseTest(args)
+			if !ok {
+				return nil
+			}
+			p.directives.tests = append(p.directives.tests, test)
+		case "import":
+			p.Errorf("%%import is only supported by grammars loaded with NewLanguageFS")
+			return nil
+		default:
+			p.Errorf("unknown directive %%%s", name)
+			return nil
+		}
+		if next.typ == itemEOF {
+			return nil
+		}
+		return parseLexeme
+	}
+}
+
+// parseTest reads the arguments of a %test directive: a literal input,
+// matches or fails, and optionally the rule to parse it with.
+func (p *parser) parseTest(args []item) (GrammarTest, bool) {
+	if len(args) < 2 || len(args) > 3 || args[0].typ != itemLiteral ||
+		args[1].typ != itemIdentifier || args[1].val != "matches" && args[1].val != "fails" {
+		p.Errorf("%%test takes an input, matches or fails, and an optional rule")
+		return GrammarTest{}, false
+	}
+	input, err := unquote(args[0].val)
+	if err != nil {
+		p.Errorf("%%test: %s", err)
+		return GrammarTest{}, false
+	}
+	test := GrammarTest{Input: input, Fails: args[1].val == "fails", Line: args[0].line}
+	if len(args) == 3 {
+		if args[2].typ != itemIdentifier {
+			p.Errorf("%%test takes an input, matches or fails, and an optional rule")
+			return GrammarTest{}, false
+		}
+		test.Rule = args[2].val
+		p.refs = append(p.refs, reference{test.Rule, "", args[2]})
+	}
+	return test, true
+}
+
 func parseRule(name string) parseStateFn {
 	return func(p *parser) parseStateFn {
-		next, ok := <-p.lex.items
+		next, ok := p.next()
 		if !ok {
 			p.Errorf("item channel drained unexpectedly in parseRule")
 			return nil
@@ -133,107 +633,366 @@ func parseRule(name string) parseStateFn {
 		case itemWhitespace:
 			return parseRule(name)
 		case itemAssignment:
+			p.scoped = false
+			p.rule = name
+			p.defined[name] = true
 			return parseRuleBody(name, nil)
 		}
+		p.Errorf("expected '<-', '=', ':=' or '::=' after rule name %s, found %v", name, next)
 		return nil
 	}
 }
 
+// primary builds the lexeme for an item that forms an expression on its
+// own. It returns nil if next does not.
+func primary(name string, next item) (*Lexeme, error) {
+	switch next.typ {
+	case itemLiteral:
+		literal, err := unquote(next.val)
+		if err != nil {
+			return nil, err
+		}
+		return NewLiteralLexer(name, literal), nil
+	case itemRawLiteral:
+		return NewLiteralLexer(name, next.val), nil
+	case itemRegexp:
+		re, err := regexp.Compile(next.val)
+		if err != nil {
+			return nil, err
+		}
+		return NewRegexpLexer(name, re), nil
+	case itemSkipUntil:
+		delimiter := next.val[1:]
+		if next.val[0] != '`' {
+			var err error
+			if delimiter, err = unquote(delimiter); err != nil {
+				return nil, err
+			}
+		}
+		if delimiter == "" {
+			return nil, errors.New("empty delimiter after ..")
+		}
+		return NewSkipUntilLexer(name, delimiter), nil
+	case itemIdentifier:
+		return NewRuleLexer(next.val), nil
+	case itemExternal:
+		return NewExternalLexer(next.val), nil
+	case itemPredicate:
+		return NewPredicateLexer(next.val), nil
+	case itemByte:
+		b, err := strconv.ParseUint(next.val, 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		return NewLiteralLexer(name, string([]byte{byte(b)})), nil
+	}
+	return nil, nil
+}
+
+// unquote decodes the escape sequences of a literal: \n, \t, \r, \\, \',
+// \", \xNN and \uNNNN.
+func unquote(literal string) (string, error) {
+	if strings.IndexByte(literal, '\\') < 0 {
+		return literal, nil
+	}
+	buf := make([]byte, 0, len(literal))
+	for i := 0; i < len(literal); i++ {
+		c := literal[i]
+		if c != '\\' {
+			buf = append(buf, c)
+			continue
+		}
+		if i++; i == len(literal) {
+			return "", errors.New("literal ends in an incomplete escape")
+		}
+		switch c = literal[i]; c {
+		case 'n':
+			buf = append(buf, '\n')
+		case 't':
+			buf = append(buf, '\t')
+		case 'r':
+			buf = append(buf, '\r')
+		case '\\', '\'', '"':
+			buf = append(buf, c)
+		case 'x', 'u':
+			width := 2
+			if c == 'u' {
+				width = 4
+			}
+			if i+width >= len(literal) {
+				return "", errors.New(fmt.Sprintf("incomplete escape \\%s", literal[i:]))
+			}
+			v, err := strconv.ParseUint(literal[i+1:i+1+width], 16, 32)
+			if err != nil {
+				return "", errors.New(fmt.Sprintf("invalid escape \\%s", literal[i:i+1+width]))
+			}
+			if c == 'x' {
+				buf = append(buf, byte(v))
+			} else {
+				buf = utf8.AppendRune(buf, rune(v))
+			}
+			i += width
+		default:
+			return "", errors.New(fmt.Sprintf("unknown escape \\%c", c))
+		}
+	}
+	return string(buf), nil
+}
+
+// postfix applies the operator op to lex.
+func postfix(lex *Lexeme, op item) (*Lexeme, error) {
+	switch op.typ {
+	case itemPlus:
+		return NewPlusClosure(lex), nil
+	case itemClosure:
+		return NewStarClosure(lex), nil
+	case itemLazy:
+		if op.val == "+?" {
+			return NewLazyClosure(lex, 1), nil
+		}
+		return NewLazyClosure(lex, 0), nil
+	case itemOptional:
+		return NewOptionClosure(lex), nil
+	case itemDiscard:
+		return NewDiscardLexer(lex), nil
+	case itemRepeat:
+		min, max, err := parseRepeat(op.val)
+		if err != nil {
+			return nil, err
+		}
+		return NewRepeatLexer(lex, min, max), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unexpected operator %v", op))
+}
+
+// linkLazy tells the lazy closures of the sequence parts what follows them,
+// looking through discards.
+func linkLazy(parts []*Lexeme) {
+	for i, part := range parts {
+		for part.kind == kindDiscard {
+			part = part.Dependencies[0]
+		}
+		if part.kind == kindLazy {
+			part.follow = parts[i+1:]
+		}
+	}
+}
+
+// parseRepeat parses the bounds of {n}, {n,} and {n,m}. An unbounded
+// maximum is returned as -1.
+func parseRepeat(bounds string) (min, max int, err error) {
+	lo, hi := bounds, bounds
+	if i := strings.IndexRune(bounds, ','); i >= 0 {
+		lo, hi = bounds[:i], bounds[i+1:]
+	}
+	if min, err = strconv.Atoi(lo); err != nil {
+		return 0, 0, err
+	}
+	if hi == "" {
+		return min, -1, nil
+	}
+	if max, err = strconv.Atoi(hi); err != nil {
+		return 0, 0, err
+	}
+	if max < min {
+		return 0, 0, errors.New(fmt.Sprintf("invalid repetition {%s}", bounds))
+	}
+	return min, max, nil
+}
+
 func parseRuleBody(name string, parts []*Lexeme) parseStateFn {
-	quoteResolver := strings.NewReplacer("\\'", "'")
 	return func(p *parser) parseStateFn {
-		next, ok := <-p.lex.items
+		next, ok := p.next()
 		if !ok {
 			p.Errorf("item channel drained unexpectedly in parseRuleBody")
 			return nil
 		}
+		if lex, err := p.primary(name, next); err != nil {
+			p.Errorf("%s: %s", next, err)
+			return nil
+		} else if lex != nil {
+			return parseRuleBody(name, append(parts, lex))
+		}
 		switch next.typ {
 		case itemWhitespace:
 			return parseRuleBody(name, parts)
-		case itemLiteral:
-			next.val = quoteResolver.Replace(next.val)
-			return parseRuleBody(name, append(parts, NewLiteralLexer(name, next.val)))
-		case itemRegexp:
-			return parseRuleBody(name, append(parts, NewRegexpLexer(name, regexp.MustCompile(next.val))))
-		case itemIdentifier:
-			return parseRuleBody(name, append(parts, NewRuleLexer(next.val)))
-		case itemPlus:
-			if len(parts) == 0 {
-				p.Errorf("expected lexeme definition before '+'")
-				return nil
-			}
-			lex := parts[len(parts)-1]
-			parts := parts[:len(parts)-1]
-			return parseRuleBody(name, append(parts, NewPlusClosure(lex)))
-		case itemClosure:
+		case itemCall:
+			return parseCall(name, next.val, nil, func(lex *Lexeme) parseStateFn {
+				return parseRuleBody(name, append(parts, lex))
+			})
+		case itemLabel:
+			p.scoped = true
+			return parseLabel(name, next.val, parts)
+		case itemBackref:
+			return parseRuleBody(name, append(parts, NewBackrefLexer(name, next.val)))
+		case itemPlus, itemClosure, itemLazy, itemOptional, itemDiscard, itemRepeat:
 			if len(parts) == 0 {
-				p.Errorf("expected lexeme definition before '*'")
+				p.Errorf("expected lexeme definition before '%s'", next.val)
 				return nil
 			}
-			lex := parts[len(parts)-1]
-			parts := parts[:len(parts)-1]
-			return parseRuleBody(name, append(parts, NewStarClosure(lex)))
-		case itemOptional:
-			if len(parts) == 0 {
-				p.Errorf("expected lexeme definition before '?'")
+			lex, err := postfix(parts[len(parts)-1], next)
+			if err != nil {
+				p.Errorf("%s", err)
 				return nil
 			}
-			lex := parts[len(parts)-1]
 			parts := parts[:len(parts)-1]
-			return parseRuleBody(name, append(parts, NewOptionClosure(lex)))
-		case itemDiscard:
-			if len(parts) == 0 {
-				p.Errorf("expected lexeme definition before '^'")
-				return nil
-			}
-			lex := parts[len(parts)-1]
-			parts := parts[:len(parts)-1]
-			return parseRuleBody(name, append(parts, NewDiscardLexer(lex)))
+			return parseRuleBody(name, append(parts, lex))
 		case itemAlternate:
 			return parseAlternateRHS(name, parts)
 
 		case itemNewline, itemEOF:
+			linkLazy(parts)
+			var lex *Lexeme
 			if len(parts) == 0 {
+				p.Errorf("empty rule body")
 				return nil
 			} else if len(parts) == 1 { // Prevent single literals from being stuck in an array.
-				p.parts <- parts[0]
+				lex = parts[0]
 			} else {
-				p.parts <- NewConcatLexer(name, parts)
+				lex = NewConcatLexer(name, parts)
+			}
+			if p.scoped {
+				lex = NewCaptureScope(lex)
 			}
+			r := rule{name: name, lex: lex, params: p.params, instance: p.instancing}
+			if !p.instancing {
+				r.doc, p.doc = strings.Join(p.doc, "\n"), nil
+			}
+			p.parts <- r
 			return parseLexeme
+		case itemLParen:
+			p.Errorf("grammars have no parentheses, define the group as a rule or template of its own")
+			return nil
 		default:
 			p.Errorf("unexpected token : %v", next)
 			return nil
 		}
-		return nil
 	}
 }
 
 func parseAlternateRHS(name string, parts []*Lexeme) parseStateFn {
 	return func(p *parser) parseStateFn {
-		next, ok := <-p.lex.items
+		if len(parts) == 0 {
+			p.Errorf("expected expression before '/'")
+			return nil
+		}
+		next, ok := p.next()
 		if !ok {
 			p.Errorf("expected lexeme after '/'")
 			return nil
 		}
-		var rhs *Lexeme
+		if rhs, err := p.primary(name, next); err != nil {
+			p.Errorf("%s: %s", next, err)
+			return nil
+		} else if rhs != nil {
+			return parseAlternate(name, parts, rhs)
+		}
 		switch next.typ {
 		case itemWhitespace:
 			return parseAlternateRHS(name, parts)
-		case itemLiteral:
-			rhs = NewLiteralLexer(name, next.val)
-		case itemRegexp:
-			rhs = NewRegexpLexer(name, regexp.MustCompile(next.val))
-		case itemIdentifier:
-			rhs = NewRuleLexer(next.val)
+		case itemCall:
+			return parseCall(name, next.val, nil, func(rhs *Lexeme) parseStateFn {
+				return parseAlternate(name, parts, rhs)
+			})
+		case itemLParen:
+			p.Errorf("grammars have no parentheses, define the group as a rule or template of its own")
+			return nil
 		default:
 			p.Errorf("unexpected token : %v", next)
 			return nil
 		}
+	}
+}
+
+// parseLabel captures the text matched by the next expression under label.
+func parseLabel(name, label string, parts []*Lexeme) parseStateFn {
+	return func(p *parser) parseStateFn {
+		next, ok := p.next()
+		if !ok {
+			p.Errorf("expected expression after label %s:", label)
+			return nil
+		}
+		if lex, err := p.primary(name, next); err != nil {
+			p.Errorf("%s: %s", next, err)
+			return nil
+		} else if lex != nil {
+			return parseRuleBody(name, append(parts, NewCaptureLexer(label, lex)))
+		}
+		switch next.typ {
+		case itemWhitespace:
+			return parseLabel(name, label, parts)
+		case itemCall:
+			return parseCall(name, next.val, nil, func(lex *Lexeme) parseStateFn {
+				return parseRuleBody(name, append(parts, NewCaptureLexer(label, lex)))
+			})
+		default:
+			p.Errorf("unexpected token after label %s: %v", label, next)
+			return nil
+		}
+	}
+}
+
+func parseAlternate(name string, parts []*Lexeme, rhs *Lexeme) parseStateFn {
+	lhs := parts[len(parts)-1]
+	parts = parts[:len(parts)-1]
 
-		lhs := parts[len(parts)-1]
-		parts := parts[:len(parts)-1]
+	// Extend a chain of alternatives rather than nesting them.
+	alts := []*Lexeme{lhs, rhs}
+	if lhs.kind == kindChoice {
+		alts = append(append([]*Lexeme(nil), lhs.Dependencies...), rhs)
+	}
+	return parseRuleBody(name, append(parts, NewChoiceLexer(name, alts...)))
+}
 
-		return parseRuleBody(name, append(parts, NewAlternateLexer(name, lhs, rhs)))
+// parseCall collects the arguments of a call to the built in matcher or
+// template fn and hands the constructed lexeme to done.
+func parseCall(name, fn string, args []item, done func(*Lexeme) parseStateFn) parseStateFn {
+	return func(p *parser) parseStateFn {
+		next, ok := p.next()
+		if !ok {
+			p.Errorf("item channel drained unexpectedly in call to %s", fn)
+			return nil
+		}
+		switch next.typ {
+		case itemWhitespace, itemLParen, itemComma:
+			return parseCall(name, fn, args, done)
+		case itemLiteral, itemRawLiteral:
+			if _, err := unquote(next.val); next.typ == itemLiteral && err != nil {
+				p.Errorf("%s: %s", next, err)
+				return nil
+			}
+			return parseCall(name, fn, append(args, next), done)
+		case itemIdentifier:
+			if !p.isParam(next.val) {
+				p.refs = append(p.refs, reference{next.val, p.rule, next})
+			}
+			return parseCall(name, fn, append(args, next), done)
+		case itemRParen:
+			builtin, ok := builtinCalls[fn]
+			if !ok {
+				return done(p.instance(fn, args))
+			}
+			literals := make([]string, len(args))
+			for i, arg := range args {
+				switch arg.typ {
+				case itemLiteral:
+					literals[i], _ = unquote(arg.val)
+				case itemRawLiteral:
+					literals[i] = arg.val
+				default:
+					p.Errorf("%s() takes literal arguments, found %v", fn, arg)
+					return nil
+				}
+			}
+			lex, err := builtin(name, literals)
+			if err != nil {
+				p.Errorf("%s(): %s", fn, err)
+				return nil
+			}
+			return done(lex)
+		default:
+			p.Errorf("unexpected token in call to %s: %v", fn, next)
+			return nil
+		}
 	}
 }
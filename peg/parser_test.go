@@ -18,23 +18,22 @@ var parseTestTable = []ParseTest{
 	ParseTest{
 		"prgm <- 'a'",
 		"a",
-		&ParseTree{"prgm", []byte("a"), nil},
+		&ParseTree{Type: "prgm", Data: []byte("a")},
 	},
 	ParseTest{
 		"prgm <- ~'\\d+'",
 		"74538",
-		&ParseTree{"prgm", []byte("74538"), nil},
+		&ParseTree{Type: "prgm", Data: []byte("74538")},
 	},
 	ParseTest{
 		"prgm <- 'a'_'b' \n _ <- ~'\\s+'",
 		"a b",
 		&ParseTree{
-			"prgm",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"prgm", []byte("a"), nil},
-				&ParseTree{"_", []byte(" "), nil},
-				&ParseTree{"prgm", []byte("b"), nil},
+			Type: "prgm",
+			Children: []*ParseTree{
+				&ParseTree{Type: "prgm", Data: []byte("a")},
+				&ParseTree{Type: "_", Data: []byte(" ")},
+				&ParseTree{Type: "prgm", Data: []byte("b")},
 			},
 		},
 	},
@@ -42,12 +41,11 @@ var parseTestTable = []ParseTest{
 		"prgm <- name '=' number \n name <- ~'[a-zA-Z]+' \n number <- ~'\\d+'",
 		"variableName=432",
 		&ParseTree{
-			"prgm",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"name", []byte("variableName"), nil},
-				&ParseTree{"prgm", []byte("="), nil},
-				&ParseTree{"number", []byte("432"), nil},
+			Type: "prgm",
+			Children: []*ParseTree{
+				&ParseTree{Type: "name", Data: []byte("variableName")},
+				&ParseTree{Type: "prgm", Data: []byte("=")},
+				&ParseTree{Type: "number", Data: []byte("432")},
 			},
 		},
 	},
@@ -55,12 +53,11 @@ var parseTestTable = []ParseTest{
 		"prgm <- a+\na <- 'a'",
 		"aaa",
 		&ParseTree{
-			"a+",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"a", []byte("a"), nil},
-				&ParseTree{"a", []byte("a"), nil},
-				&ParseTree{"a", []byte("a"), nil},
+			Type: "a+",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "a", Data: []byte("a")},
 			},
 		},
 	},
@@ -68,15 +65,14 @@ var parseTestTable = []ParseTest{
 		"prgm <- a+\na <- 'a' _?\n_ <- ~'\\s'",
 		"aa a",
 		&ParseTree{
-			"a+",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"a", []byte("a"), nil},
-				&ParseTree{"a", nil, []*ParseTree{
-					&ParseTree{"a", []byte("a"), nil},
-					&ParseTree{"_", []byte(" "), nil},
+			Type: "a+",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "a", Children: []*ParseTree{
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "_", Data: []byte(" ")},
 				}},
-				&ParseTree{"a", []byte("a"), nil},
+				&ParseTree{Type: "a", Data: []byte("a")},
 			},
 		},
 	},
@@ -84,12 +80,11 @@ var parseTestTable = []ParseTest{
 		"prgm <- a*\na <- 'a' _?^\n_ <- ~'\\s+'",
 		"aa \ta",
 		&ParseTree{
-			"a*",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"a", []byte("a"), nil},
-				&ParseTree{"a", []byte("a"), nil},
-				&ParseTree{"a", []byte("a"), nil},
+			Type: "a*",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "a", Data: []byte("a")},
 			},
 		},
 	},
@@ -97,20 +92,19 @@ var parseTestTable = []ParseTest{
 		"prgm <- a*\na <- 'a' _?^ '\\''\n_ <- ~'\\s+'",
 		"a'a \t'a'",
 		&ParseTree{
-			"a*",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"a", nil, []*ParseTree{
-					&ParseTree{"a", []byte("a"), nil},
-					&ParseTree{"a", []byte("'"), nil},
+			Type: "a*",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a", Children: []*ParseTree{
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "a", Data: []byte("'")},
 				}},
-				&ParseTree{"a", nil, []*ParseTree{
-					&ParseTree{"a", []byte("a"), nil},
-					&ParseTree{"a", []byte("'"), nil},
+				&ParseTree{Type: "a", Children: []*ParseTree{
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "a", Data: []byte("'")},
 				}},
-				&ParseTree{"a", nil, []*ParseTree{
-					&ParseTree{"a", []byte("a"), nil},
-					&ParseTree{"a", []byte("'"), nil},
+				&ParseTree{Type: "a", Children: []*ParseTree{
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "a", Data: []byte("'")},
 				}},
 			},
 		},
@@ -119,15 +113,14 @@ var parseTestTable = []ParseTest{
 		"prgm <- a*\na <- 'a' _?\n_ <- ~'\\s+'",
 		"aa \ta",
 		&ParseTree{
-			"a*",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"a", []byte("a"), nil},
-				&ParseTree{"a", nil, []*ParseTree{
-					&ParseTree{"a", []byte("a"), nil},
-					&ParseTree{"_", []byte(" \t"), nil},
+			Type: "a*",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "a", Children: []*ParseTree{
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "_", Data: []byte(" \t")},
 				}},
-				&ParseTree{"a", []byte("a"), nil},
+				&ParseTree{Type: "a", Data: []byte("a")},
 			},
 		},
 	},
@@ -135,15 +128,14 @@ var parseTestTable = []ParseTest{
 		"prgm <- a* b\na <- 'a'\nb <- 'b'",
 		"aaab",
 		&ParseTree{
-			"prgm",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"a*", nil, []*ParseTree{
-					&ParseTree{"a", []byte("a"), nil},
-					&ParseTree{"a", []byte("a"), nil},
-					&ParseTree{"a", []byte("a"), nil},
+			Type: "prgm",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a*", Children: []*ParseTree{
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "a", Data: []byte("a")},
 				}},
-				&ParseTree{"b", []byte("b"), nil},
+				&ParseTree{Type: "b", Data: []byte("b")},
 			},
 		},
 	},
@@ -151,15 +143,14 @@ var parseTestTable = []ParseTest{
 		"prgm <- a+ b\na <- 'a'\nb <- 'b'",
 		"aaab",
 		&ParseTree{
-			"prgm",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"a+", nil, []*ParseTree{
-					&ParseTree{"a", []byte("a"), nil},
-					&ParseTree{"a", []byte("a"), nil},
-					&ParseTree{"a", []byte("a"), nil},
+			Type: "prgm",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a+", Children: []*ParseTree{
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "a", Data: []byte("a")},
+					&ParseTree{Type: "a", Data: []byte("a")},
 				}},
-				&ParseTree{"b", []byte("b"), nil},
+				&ParseTree{Type: "b", Data: []byte("b")},
 			},
 		},
 	},
@@ -167,16 +158,15 @@ var parseTestTable = []ParseTest{
 		"prgm <- item+\nitem <- a/ b\na <- 'a'\n b <- 'b'",
 		"abaabba",
 		&ParseTree{
-			"item+",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"a", []byte("a"), nil},
-				&ParseTree{"b", []byte("b"), nil},
-				&ParseTree{"a", []byte("a"), nil},
-				&ParseTree{"a", []byte("a"), nil},
-				&ParseTree{"b", []byte("b"), nil},
-				&ParseTree{"b", []byte("b"), nil},
-				&ParseTree{"a", []byte("a"), nil},
+			Type: "item+",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "b", Data: []byte("b")},
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "b", Data: []byte("b")},
+				&ParseTree{Type: "b", Data: []byte("b")},
+				&ParseTree{Type: "a", Data: []byte("a")},
 			},
 		},
 	},
@@ -184,32 +174,31 @@ var parseTestTable = []ParseTest{
 		"prgm <- list+\nlist <- 'c' a+ 'd'\na <- 'a' / list",
 		"cacaaacaaddd",
 		&ParseTree{
-			"list+",
-			nil,
-			[]*ParseTree{
-				&ParseTree{"list", nil, []*ParseTree{
-					&ParseTree{"list", []byte("c"), nil},
-					&ParseTree{"a+", nil, []*ParseTree{
-						&ParseTree{"a", []byte("a"), nil},
-						&ParseTree{"list", nil, []*ParseTree{
-							&ParseTree{"list", []byte("c"), nil},
-							&ParseTree{"a+", nil, []*ParseTree{
-								&ParseTree{"a", []byte("a"), nil},
-								&ParseTree{"a", []byte("a"), nil},
-								&ParseTree{"a", []byte("a"), nil},
-								&ParseTree{"list", nil, []*ParseTree{
-									&ParseTree{"list", []byte("c"), nil},
-									&ParseTree{"a+", nil, []*ParseTree{
-										&ParseTree{"a", []byte("a"), nil},
-										&ParseTree{"a", []byte("a"), nil},
+			Type: "list+",
+			Children: []*ParseTree{
+				&ParseTree{Type: "list", Children: []*ParseTree{
+					&ParseTree{Type: "list", Data: []byte("c")},
+					&ParseTree{Type: "a+", Children: []*ParseTree{
+						&ParseTree{Type: "a", Data: []byte("a")},
+						&ParseTree{Type: "list", Children: []*ParseTree{
+							&ParseTree{Type: "list", Data: []byte("c")},
+							&ParseTree{Type: "a+", Children: []*ParseTree{
+								&ParseTree{Type: "a", Data: []byte("a")},
+								&ParseTree{Type: "a", Data: []byte("a")},
+								&ParseTree{Type: "a", Data: []byte("a")},
+								&ParseTree{Type: "list", Children: []*ParseTree{
+									&ParseTree{Type: "list", Data: []byte("c")},
+									&ParseTree{Type: "a+", Children: []*ParseTree{
+										&ParseTree{Type: "a", Data: []byte("a")},
+										&ParseTree{Type: "a", Data: []byte("a")},
 									}},
-									&ParseTree{"list", []byte("d"), nil},
+									&ParseTree{Type: "list", Data: []byte("d")},
 								}},
 							}},
-							&ParseTree{"list", []byte("d"), nil},
+							&ParseTree{Type: "list", Data: []byte("d")},
 						}},
 					}},
-					&ParseTree{"list", []byte("d"), nil},
+					&ParseTree{Type: "list", Data: []byte("d")},
 				}},
 			},
 		},
@@ -281,3 +270,334 @@ func dumpTree(tree *ParseTree, indent string) {
 		}
 	}
 }
+
+func TestParsePositions(t *testing.T) {
+	parser, err := NewParser(strings.NewReader("prgm <- name '=' number \n name <- ~'[a-zA-Z]+' \n number <- ~'\\d+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := parser.ParseString("abc=12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := [][2]int{{0, 3}, {3, 4}, {4, 6}}
+	if tree.Pos != 0 || tree.End != 6 {
+		t.Errorf("incorrect root span: [%d, %d)", tree.Pos, tree.End)
+	}
+	for i, child := range tree.Children {
+		if child.Pos != spans[i][0] || child.End != spans[i][1] {
+			t.Errorf("incorrect span for %s: [%d, %d) exp: %v", child.Type, child.Pos, child.End, spans[i])
+		}
+	}
+}
+
+func TestExternalMatcher(t *testing.T) {
+	parser, err := NewParser(strings.NewReader("prgm <- 'x' @count 'x'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parser.ParseString("x3abcx")
+	if err == nil {
+		t.Errorf("expected error for unregistered matcher")
+	}
+
+	// count consumes a single digit n followed by n bytes.
+	parser.Register("count", func(s *Source, pos int) (*ParseTree, error, int) {
+		buf := s.Bytes()
+		if pos >= len(buf) || buf[pos] < '0' || buf[pos] > '9' {
+			return nil, errors.New("expected count"), 0
+		}
+		n := int(buf[pos]-'0') + 1
+		if pos+n > len(buf) {
+			return nil, errors.New("short field"), 0
+		}
+		return &ParseTree{Type: "field", Data: buf[pos+1 : pos+n], Pos: pos, End: pos + n}, nil, n
+	})
+
+	tree, err := parser.ParseString("x3abcx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "prgm",
+		Children: []*ParseTree{
+			&ParseTree{Type: "prgm", Data: []byte("x")},
+			&ParseTree{Type: "field", Data: []byte("abc")},
+			&ParseTree{Type: "prgm", Data: []byte("x")},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSemanticPredicate(t *testing.T) {
+	parser, err := NewParser(strings.NewReader("prgm <- 'a' &{modern} 'b'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.RegisterPredicate("modern", func(s *Source, pos int) bool {
+		version, _ := s.State().(int)
+		return version >= 2
+	})
+
+	for version, ok := range []bool{false, false, true} {
+		s := SourceFromBytes([]byte("ab"))
+		s.SetState(version)
+		tree, err := parser.ParseSource(s)
+		if ok && err != nil {
+			t.Errorf("version %d: %s", version, err)
+		} else if !ok && err == nil {
+			t.Errorf("version %d: expected predicate failure", version)
+		} else if ok && len(tree.Children) != 2 {
+			t.Errorf("version %d: predicate produced a node: %v", version, tree)
+		}
+	}
+}
+
+func TestParseWithState(t *testing.T) {
+	parser, err := NewParser(strings.NewReader("prgm <- @name &{declared} ';'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser.Register("name", func(s *Source, pos int) (*ParseTree, error, int) {
+		n := 0
+		for pos+n < len(s.Bytes()) && s.Bytes()[pos+n] != ';' {
+			n++
+		}
+		s.State().(map[string]bool)["last"] = true
+		return &ParseTree{Type: "name", Data: s.Bytes()[pos : pos+n], Pos: pos, End: pos + n}, nil, n
+	})
+	parser.RegisterPredicate("declared", func(s *Source, pos int) bool {
+		return s.State().(map[string]bool)[string(s.Bytes()[:pos])]
+	})
+
+	symbols := map[string]bool{"x": true}
+	if _, err := parser.ParseWithState(strings.NewReader("x;"), symbols); err != nil {
+		t.Error(err)
+	}
+	if _, err := parser.ParseWithState(strings.NewReader("y;"), symbols); err == nil {
+		t.Errorf("expected an undeclared name to fail")
+	}
+	if !symbols["last"] {
+		t.Errorf("matcher did not see the state")
+	}
+}
+
+type UnquoteTest struct {
+	literal string
+	exp     string
+}
+
+var unquoteTestTable = []UnquoteTest{
+	UnquoteTest{"plain", "plain"},
+	UnquoteTest{"a\\nb", "a\nb"},
+	UnquoteTest{"\\t\\r\\\\\\'", "\t\r\\'"},
+	UnquoteTest{"\\x41\\x7f", "A\x7f"},
+	UnquoteTest{"\\u00e9!", "é!"},
+}
+
+func TestUnquote(t *testing.T) {
+	for _, tc := range unquoteTestTable {
+		got, err := unquote(tc.literal)
+		if err != nil {
+			t.Errorf("%q: %s", tc.literal, err)
+		} else if got != tc.exp {
+			t.Errorf("incorrect unquote of %q: %q exp: %q", tc.literal, got, tc.exp)
+		}
+	}
+	for _, bad := range []string{"\\q", "\\x4", "\\uzzzz", "\\"} {
+		if _, err := unquote(bad); err == nil {
+			t.Errorf("expected error unquoting %q", bad)
+		}
+	}
+}
+
+func TestEscapedLiterals(t *testing.T) {
+	parser, err := NewParser(strings.NewReader("prgm <- cell '\\t' cell '\\n'\ncell <- ~'[a-z]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseString("ab\tcd\n"); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewParser(strings.NewReader("prgm <- '\\z'")); err == nil {
+		t.Errorf("expected error for unknown escape")
+	}
+}
+
+func TestQuotedLiterals(t *testing.T) {
+	parser, err := NewParser(strings.NewReader("prgm <- \"'\" `\\d` ~`\\d+` \"\\\"\""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := parser.ParseString("'\\d42\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "prgm",
+		Children: []*ParseTree{
+			&ParseTree{Type: "prgm", Data: []byte("'")},
+			&ParseTree{Type: "prgm", Data: []byte("\\d")},
+			&ParseTree{Type: "prgm", Data: []byte("42")},
+			&ParseTree{Type: "prgm", Data: []byte("\"")},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		t.Error(err)
+	}
+}
+
+var multilineTestTable = []ParseTest{
+	ParseTest{
+		"prgm <- item+\nitem <- a\n      / b\n\n      / c\na <- 'a'\nb <- 'b'\nc <- 'c'",
+		"cab",
+		&ParseTree{
+			Type: "item+",
+			Children: []*ParseTree{
+				&ParseTree{Type: "c", Data: []byte("c")},
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "b", Data: []byte("b")},
+			},
+		},
+	},
+	ParseTest{
+		"prgm <- a\n  b\n b <- 'b'\na <- 'a'",
+		"ab",
+		&ParseTree{
+			Type: "prgm",
+			Children: []*ParseTree{
+				&ParseTree{Type: "a", Data: []byte("a")},
+				&ParseTree{Type: "b", Data: []byte("b")},
+			},
+		},
+	},
+}
+
+func TestMultilineRules(t *testing.T) {
+	for _, tc := range multilineTestTable {
+		parser, err := NewParser(strings.NewReader(tc.language))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := parser.ParseString(tc.input)
+		if err != nil {
+			t.Error(tc.language, err)
+			continue
+		}
+		if err := treeCompare(tree, tc.exp); err != nil {
+			t.Error(tc.language, err)
+		}
+	}
+}
+
+func TestAssignmentOperators(t *testing.T) {
+	const exp = "elem <- '<'^ t:name '>'^ =t\nname <- ~`[a-z]+`\nrest <- name / ' '\n"
+	for _, grammar := range []string{
+		"elem <- '<'^ t:name '>'^ =t\nname <- ~'[a-z]+'\nrest <- name\n  / ' '",
+		"elem = '<'^ t:name '>'^ =t\nname = ~'[a-z]+'\nrest= name\n  / ' '",
+		"elem := '<'^ t:name '>'^ =t\nname:=~'[a-z]+'\nrest := name\n  / ' '",
+		"elem ::= '<'^ t:name '>'^ =t\nname::= ~'[a-z]+'\n  rest ::= name\n  / ' '",
+	} {
+		lang, err := NewLanguage(grammar)
+		if err != nil {
+			t.Errorf("%q: %s", grammar, err)
+			continue
+		}
+		if got := lang.Grammar(); got != exp {
+			t.Errorf("%q: got %q, expected %q", grammar, got, exp)
+		}
+		if _, err := lang.ParseString("<ab>ab"); err != nil {
+			t.Errorf("%q: %s", grammar, err)
+		}
+	}
+}
+
+func TestGrammarErrors(t *testing.T) {
+	grammar := "prgm <- a b\na <- ~'('\nb <- 'b' /\nc <- d\ne 'e'\n"
+	_, err := NewParser(strings.NewReader(grammar))
+	errs, ok := err.(GrammarErrors)
+	if !ok {
+		t.Fatalf("expected GrammarErrors, got %v", err)
+	}
+	exp := []GrammarError{
+		{Rule: "a", Line: 2, Col: 8},
+		{Rule: "b", Line: 3, Col: 11},
+		{Rule: "c", Line: 4, Col: 6},
+		{Line: 5, Col: 4},
+	}
+	if len(errs) != len(exp) {
+		t.Fatalf("expected %d errors, got:\n%s", len(exp), err)
+	}
+	for i, e := range exp {
+		if errs[i].Rule != e.Rule || errs[i].Line != e.Line || errs[i].Col != e.Col {
+			t.Errorf("error %d: %s, exp %d:%d in %q", i, errs[i], e.Line, e.Col, e.Rule)
+		}
+	}
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	for _, tc := range []struct {
+		grammar, exp string
+	}{
+		{"prgm <- e\ne 'e'", "expected '<-', '=', ':=' or '::=' after rule name e"},
+		{"prgm <- list('a')\nlist(a) a", "expected '<-', '=', ':=' or '::=' after template list"},
+	} {
+		_, err := NewLanguage(tc.grammar)
+		if err == nil || !strings.Contains(err.Error(), tc.exp) {
+			t.Errorf("%q: got %v, exp %q", tc.grammar, err, tc.exp)
+		}
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	grammar := "%case_insensitive\n%start list\n%whitespace ws\nws <- ~'[ \\t]+'\nlist <- 'select' item+\nitem <- ~'[a-z]+'"
+	lang, err := NewParser(strings.NewReader(grammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("  SeLeCt a  bc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "list",
+		Children: []*ParseTree{
+			&ParseTree{Type: "list", Data: []byte("SeLeCt")},
+			&ParseTree{
+				Type: "item+",
+				Children: []*ParseTree{
+					&ParseTree{Type: "item", Data: []byte("a")},
+					&ParseTree{Type: "item", Data: []byte("bc")},
+				},
+			},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		t.Error(err)
+	}
+	if pos := tree.Children[0].Pos; pos != 2 {
+		t.Errorf("literal starts at %d, exp 2", pos)
+	}
+	if out := lang.Grammar(); !strings.HasPrefix(out, "%case_insensitive\n%start list\n%whitespace ws\n") {
+		t.Errorf("directives missing from grammar:\n%s", out)
+	}
+}
+
+func TestDirectiveErrors(t *testing.T) {
+	for _, grammar := range []string{
+		"%start\na <- 'a'",
+		"%start b\na <- 'a'",
+		"%memo\na <- 'a'",
+		"%whitespace a b\na <- 'a'",
+		"%case_insensitive a\na <- 'a'",
+		"a <- 'a'\n%indent",
+		"%unknown\na <- 'a'",
+	} {
+		if _, err := NewParser(strings.NewReader(grammar)); err == nil {
+			t.Errorf("expected error for %q", grammar)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package pegfuzz builds fuzz targets for languages. A grammar is fuzzed
+// with
+//
+//	func FuzzGrammar(f *testing.F) {
+//		pegfuzz.Fuzz(f, lang, "1+2*3")
+//	}
+//
+// which seeds the corpus with the given inputs and the %test inputs of the
+// grammar, and checks the invariants of Check on every input go test tries.
+package pegfuzz
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// Fuzz adds seeds and the inputs of the %test directives of lang to the
+// corpus of f and fuzzes lang with Target.
+func Fuzz(f *testing.F, lang *peg.Language, seeds ...string) {
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+	for _, test := range lang.Tests() {
+		f.Add([]byte(test.Input))
+	}
+	f.Fuzz(Target(lang))
+}
+
+// Target returns a fuzz target, for f.Fuzz, that fails when parsing its
+// input with lang breaks an invariant of Check.
+func Target(lang *peg.Language) func(*testing.T, []byte) {
+	return func(t *testing.T, data []byte) {
+		if err := Check(lang, data); err != nil {
+			t.Fatalf("%s, input %q", err, data)
+		}
+	}
+}
+
+// Check parses data with lang and reports the first invariant the parse
+// breaks:
+//
+//   - parsing does not panic;
+//   - it returns a tree, an error or both, never neither;
+//   - every node lies within the input and ends after it starts;
+//   - children lie within their parent, in the order of the input;
+//   - parse errors are at an offset within the input.
+//
+// Input that fails to parse is not a failure in itself.
+func Check(lang *peg.Language, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("parse panicked: %v", r))
+		}
+	}()
+	// ParseBytes keeps the slice, and fuzzers reuse theirs.
+	src := append([]byte(nil), data...)
+	tree, perr := lang.ParseBytes(src)
+	if tree == nil && perr == nil {
+		return errors.New("parse returned neither a tree nor an error")
+	}
+	if e, ok := perr.(*peg.ParseError); ok && (e.Pos < 0 || e.Pos > len(src)) {
+		return errors.New(fmt.Sprintf("error at offset %d outside of the input of %d bytes", e.Pos, len(src)))
+	}
+	if tree != nil {
+		return checkTree(tree, 0, len(src))
+	}
+	return nil
+}
+
+// checkTree reports a node of tree that is not within start and end, or
+// whose children are out of order.
+func checkTree(tree *peg.ParseTree, start, end int) error {
+	if tree.Pos < start || tree.End > end || tree.End < tree.Pos {
+		return errors.New(fmt.Sprintf("%s spans %d to %d, outside of %d to %d", tree.Type, tree.Pos, tree.End, start, end))
+	}
+	pos := tree.Pos
+	for _, child := range tree.Children {
+		if err := checkTree(child, pos, tree.End); err != nil {
+			return err
+		}
+		pos = child.End
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package pegfuzz
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Logiraptor/chicken/peg"
+	"github.com/Logiraptor/chicken/peg/grammars"
+)
+
+func TestCheck(t *testing.T) {
+	inputs := []string{"", "{", `{"a": [1, 2.5e3, "xé"], "b": null}`, `[1,]`, "\x00\xff", `"\u12`}
+	for _, opts := range [][]peg.Option{nil, {peg.Tolerant(true)}, {peg.Lossless(true)}} {
+		lang, err := grammars.NewJSON(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, input := range inputs {
+			if err := Check(lang, []byte(input)); err != nil {
+				t.Errorf("%q: %s", input, err)
+			}
+		}
+	}
+}
+
+// panicTracer is user code that runs unguarded during parses.
+type panicTracer struct{}
+
+func (panicTracer) Start(ctx context.Context, name string) (context.Context, peg.Span) {
+	panic("boom")
+}
+
+func TestCheckPanic(t *testing.T) {
+	lang, err := peg.NewParser(strings.NewReader("prgm <- 'a' @boom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang.Register("boom", func(s *peg.Source, pos int) (*peg.ParseTree, error, int) {
+		panic("boom")
+	})
+	// Panics of registered matchers are parse errors.
+	for _, input := range []string{"a", "b"} {
+		if err := Check(lang, []byte(input)); err != nil {
+			t.Errorf("%q: unexpected error %s", input, err)
+		}
+	}
+	lang, err = peg.NewParser(strings.NewReader("prgm <- 'a'"), peg.WithTracer(panicTracer{}, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(lang, []byte("a")); err == nil || err.Error() != "parse panicked: boom" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestCheckTree(t *testing.T) {
+	lang, err := peg.NewParser(strings.NewReader("prgm <- @bad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang.Register("bad", func(s *peg.Source, pos int) (*peg.ParseTree, error, int) {
+		return &peg.ParseTree{Type: "bad", Pos: 0, End: 5}, nil, 5
+	})
+	exp := "bad spans 0 to 5, outside of 0 to 2"
+	if err := Check(lang, []byte("ab")); err == nil || err.Error() != exp {
+		t.Errorf("Check() = %v, expected %s", err, exp)
+	}
+}
+
+func FuzzArith(f *testing.F) {
+	lang, err := grammars.NewArith()
+	if err != nil {
+		f.Fatal(err)
+	}
+	Fuzz(f, lang, "1+2*3", "-(4-1)/2", "1+")
+}
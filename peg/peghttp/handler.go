@@ -0,0 +1,198 @@
+// Package peghttp serves the parses of a peg language over HTTP.
+package peghttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// The limits of a Handler unless set with MaxBytes and Timeout.
+const (
+	DefaultMaxBytes = 1 << 20
+	DefaultTimeout  = 10 * time.Second
+)
+
+// The media types a Handler writes trees in.
+const (
+	JSON  = "application/json"
+	SExpr = "text/x-sexpr"
+)
+
+// Option configures a Handler.
+type Option func(*handler)
+
+// MaxBytes rejects inputs longer than n bytes with 413 Request Entity Too
+// Large. Zero removes the limit.
+func MaxBytes(n int64) Option {
+	return func(h *handler) {
+		h.maxBytes = n
+	}
+}
+
+// Timeout stops parses that take longer than d with 503 Service
+// Unavailable. Zero removes the limit.
+func Timeout(d time.Duration) Option {
+	return func(h *handler) {
+		h.timeout = d
+	}
+}
+
+// Error is the body of the responses of a Handler to requests it fails,
+// always written as JSON. Detail has the message and, for an input that
+// doesn't parse, where it fails.
+type Error struct {
+	Status int             `json:"status"`
+	Detail peg.ErrorDetail `json:"error"`
+}
+
+type handler struct {
+	lang     *peg.Language
+	maxBytes int64
+	timeout  time.Duration
+}
+
+// Handler returns a handler that parses the bodies of the requests posted
+// to it with lang, using the rule given by the rule query parameter, or
+// the root rule. It answers with the tree in the type of the Accept header
+// of the request, JSON or SExpr, JSON being the default.
+//
+// Requests it cannot serve are answered with an Error: 405 for methods
+// other than POST, 406 for Accept headers it cannot satisfy, 400 for
+// rules the grammar lacks, 413 for inputs over MaxBytes, 422 for inputs
+// that don't parse or exceed the budgets of lang, and 503 for parses over
+// the Timeout.
+func Handler(lang *peg.Language, opts ...Option) http.Handler {
+	h := &handler{lang: lang, maxBytes: DefaultMaxBytes, timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "inputs must be posted")
+		return
+	}
+	typ := negotiate(r.Header.Get("Accept"))
+	if typ == "" {
+		writeError(w, http.StatusNotAcceptable, fmt.Sprintf("trees are written as %s or %s", JSON, SExpr))
+		return
+	}
+	rule := r.URL.Query().Get("rule")
+	if rule != "" && !contains(h.lang.Rules(), rule) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("undefined rule %s", rule))
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if h.maxBytes > 0 {
+		body = io.LimitReader(body, h.maxBytes+1)
+	}
+	input, err := ioutil.ReadAll(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if h.maxBytes > 0 && int64(len(input)) > h.maxBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("inputs are limited to %d bytes", h.maxBytes))
+		return
+	}
+
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	src := peg.SourceFromBytes(input)
+	src.SetContext(ctx)
+	var tree *peg.ParseTree
+	if rule == "" {
+		tree, err = h.lang.ParseSource(src)
+	} else {
+		tree, err = h.lang.ParseRule(rule, src)
+	}
+	switch {
+	case err == context.DeadlineExceeded:
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("parse took over %s", h.timeout))
+		return
+	case err == context.Canceled:
+		return // the client is gone.
+	case err != nil:
+		writeJSON(w, http.StatusUnprocessableEntity, Error{http.StatusUnprocessableEntity, peg.Detail(err, input)})
+		return
+	}
+
+	w.Header().Set("Content-Type", typ)
+	if typ == SExpr {
+		io.WriteString(w, tree.SExpr()+"\n")
+		return
+	}
+	json.NewEncoder(w).Encode(tree)
+}
+
+// negotiate returns the type to write trees in for the Accept header
+// accept: the supported type it gives the highest quality, the first if
+// several do. It returns "" if accept rules out both.
+func negotiate(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return JSON
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		typ, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var match string
+		switch typ {
+		case JSON, SExpr:
+			match = typ
+		case "*/*", "application/*":
+			match = JSON
+		case "text/*":
+			match = SExpr
+		}
+		if match != "" && q > bestQ {
+			best, bestQ = match, q
+		}
+	}
+	return best
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, Error{status, peg.ErrorDetail{Pos: -1, Msg: msg}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", JSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package peghttp
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+func TestHandler(t *testing.T) {
+	lang, err := peg.NewLanguage("list <- item+\nitem <- ~'[a-z]+' ' '^\nslow <- late+\nlate <- &{late} item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first item takes long enough for the parse to time out when the
+	// context is next checked.
+	lang.RegisterPredicate("late", func(s *peg.Source, pos int) bool {
+		if pos == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return true
+	})
+	h := Handler(lang, MaxBytes(4096), Timeout(10*time.Millisecond))
+	tests := []struct {
+		method, target, accept, body string
+		status                       int
+		typ, exp                     string
+	}{
+		{"POST", "/", "", "a b", 200, JSON,
+			`{"type":"item+","pos":0,"end":3,"children":[{"type":"item","text":"a","pos":0,"end":1},{"type":"item","text":"b","pos":2,"end":3}]}`},
+		{"POST", "/?rule=item", "text/x-sexpr", "a b", 200, SExpr, `(item "a")`},
+		{"POST", "/", "application/json;q=0.5, text/*", "a", 200, SExpr, `(item+ (item "a"))`},
+		{"POST", "/", "*/*", "a", 200, JSON, `{"type":"item+","pos":0,"end":1,"children":[{"type":"item","text":"a","pos":0,"end":1}]}`},
+		{"POST", "/", "text/html", "a", 406, JSON,
+			`{"status":406,"error":{"pos":-1,"message":"trees are written as application/json or text/x-sexpr"}}`},
+		{"GET", "/", "", "", 405, JSON, `{"status":405,"error":{"pos":-1,"message":"inputs must be posted"}}`},
+		{"POST", "/?rule=nope", "", "a", 400, JSON, `{"status":400,"error":{"pos":-1,"message":"undefined rule nope"}}`},
+		{"POST", "/", "", strings.Repeat("a ", 2049), 413, JSON, `{"status":413,"error":{"pos":-1,"message":"inputs are limited to 4096 bytes"}}`},
+		{"POST", "/", "", "1", 422, JSON,
+			`{"status":422,"error":{"pos":0,"line":1,"col":1,"message":"expected ~` + "`[a-z]+`" + `","expected":["~` + "`[a-z]+`" + `"],"found":"1"}}`},
+		{"POST", "/?rule=slow", "", strings.Repeat("a ", 1000), 503, JSON, `{"status":503,"error":{"pos":-1,"message":"parse took over 10ms"}}`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		body := strings.TrimSuffix(rec.Body.String(), "\n")
+		if rec.Code != tt.status || rec.Header().Get("Content-Type") != tt.typ || body != tt.exp {
+			t.Errorf("%s %s %q: got %d %s %s, exp %d %s %s", tt.method, tt.target, tt.body, rec.Code, rec.Header().Get("Content-Type"), body, tt.status, tt.typ, tt.exp)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package pegtest checks the trees a language produces against golden
+// files. Given inputs named testdata/*.input,
+//
+//	func TestGrammar(t *testing.T) {
+//		pegtest.Run(t, lang, "testdata/*.input")
+//	}
+//
+// parses each one and compares the printed tree with the file of the same
+// name ending in .golden instead. Running the tests with -update writes the
+// golden files from the current trees instead of comparing them.
+package pegtest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of pegtest.Run")
+
+// Run parses every file matching pattern with lang, in a subtest named
+// after the file, and compares the result with its golden file. The
+// result is the tree as printed by its String method, or the error for
+// inputs that fail to parse or are not parsed completely, so golden files
+// can pin down errors too.
+func Run(t *testing.T, lang *peg.Language, pattern string) {
+	inputs, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatalf("no inputs match %s", pattern)
+	}
+	for _, input := range inputs {
+		input := input
+		t.Run(filepath.Base(input), func(t *testing.T) {
+			check(t, lang, input)
+		})
+	}
+}
+
+func check(t *testing.T, lang *peg.Language, input string) {
+	src, err := ioutil.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseBytes(src)
+	if err == nil && tree.End < len(src) {
+		err = errors.New(fmt.Sprintf("unparsed input at offset %d", tree.End))
+	}
+	got := Dump(tree, err)
+	golden := Golden(input)
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%s; run with -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s does not match %s\ngot:\n%s\nwant:\n%s", input, golden, got, want)
+	}
+}
+
+// Golden returns the name of the golden file of input: input with its
+// extension replaced by .golden.
+func Golden(input string) string {
+	return strings.TrimSuffix(input, filepath.Ext(input)) + ".golden"
+}
+
+// Dump returns the contents of a golden file for the result of a parse.
+func Dump(tree *peg.ParseTree, err error) []byte {
+	if err != nil {
+		return []byte("error: " + err.Error() + "\n")
+	}
+	return []byte(tree.String())
+}
@@ -0,0 +1,56 @@
+package pegtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+const grammar = `list <- word more*
+more <- ','^ word
+word <- ~' *[a-z]+'`
+
+func TestRun(t *testing.T) {
+	lang, err := peg.NewParser(strings.NewReader(grammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Run(t, lang, "testdata/*.input")
+}
+
+func TestUpdate(t *testing.T) {
+	lang, err := peg.NewParser(strings.NewReader(grammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "pegtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "one.input")
+	if err := ioutil.WriteFile(input, []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	*update = true
+	defer func() { *update = false }()
+	Run(t, lang, filepath.Join(dir, "*.input"))
+	got, err := ioutil.ReadFile(filepath.Join(dir, "one.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := Dump(lang.ParseString("x")); string(got) != string(exp) {
+		t.Errorf("golden file holds %q, expected %q", got, exp)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	if got := Golden("testdata/a.b.input"); got != "testdata/a.b.golden" {
+		t.Errorf("Golden() = %s", got)
+	}
+}
@@ -0,0 +1,303 @@
+package peg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// NewLanguageFS compiles the grammar in the file entry of fsys, such as an
+// embed.FS, together with the grammars it imports. A grammar imports
+// another, named by its path relative to its own, into a namespace:
+//
+//	%import lex "lexical.peg"
+//
+// after which its rules refer to the rules of the other as lex.ident and
+// so on. The rules of an imported grammar are named by the namespace it
+// was first imported into, such as lex.ident, or json.lex.ident for a
+// grammar imported by json, and a grammar imported by several others is
+// loaded once.
+//
+// The entry grammar configures the whole language: the others may not use
+// %start, %whitespace, %auto_whitespace, %indent or %case_insensitive, and
+// their %test directives must name the rule to test. The GrammarErrors returned have
+// the File of each problem.
+func NewLanguageFS(fsys fs.FS, entry string, opts ...Option) (*Language, error) {
+	pr := &project{fsys: fsys, prefixes: make(map[string]string)}
+	entry = path.Clean(entry)
+	data, err := fs.ReadFile(fsys, entry)
+	if err != nil {
+		return nil, err
+	}
+	pr.prefixes[entry] = ""
+	pr.add(entry, "", data)
+	for len(pr.queue) > 0 {
+		imp := pr.queue[0]
+		pr.queue = pr.queue[1:]
+		data, err := fs.ReadFile(fsys, imp.file)
+		if err != nil {
+			pr.errorf(imp.from, imp.at, "%s", err)
+			continue
+		}
+		pr.add(imp.file, imp.prefix, data)
+	}
+	if len(pr.errs) > 0 {
+		return nil, pr.errs
+	}
+	lang, err := compile(context.Background(), pr.directives.text.String()+pr.rules.text.String(), nil, opts)
+	if err != nil {
+		return nil, pr.locate(err)
+	}
+	lines := pr.lines()
+	for i, test := range lang.directives.tests {
+		if test.Line >= 1 && test.Line <= len(lines) {
+			lang.directives.tests[i].File, lang.directives.tests[i].Line = lines[test.Line-1].file, lines[test.Line-1].line
+		}
+	}
+	return lang, nil
+}
+
+// project joins the grammars of NewLanguageFS into one, the directives
+// before the rules so that they precede the rules of every grammar.
+type project struct {
+	fsys              fs.FS
+	prefixes          map[string]string // qualifies the rules of each file.
+	queue             []imported        // the files yet to be added.
+	directives, rules grammarText
+	errs              GrammarErrors
+}
+
+// imported is a file imported by the file from, at the token at.
+type imported struct {
+	file, prefix string
+	from         string
+	at           GrammarToken
+}
+
+// grammarText is grammar text joined from several files, which remembers
+// where its lines come from.
+type grammarText struct {
+	text  strings.Builder
+	lines []lineOrigin
+	col   int // the column the text ends at, or 0 at the start of a line.
+}
+
+// lineOrigin is the line of a file that a line of grammarText comes from,
+// and the columns its tokens have there.
+type lineOrigin struct {
+	file string
+	line int
+	cols []colOrigin
+}
+
+type colOrigin struct{ col, orig int }
+
+// write adds text, the token tok of file or what it is qualified to.
+func (g *grammarText) write(file string, tok GrammarToken, text string) {
+	line := tok.Line
+	for i, part := range strings.Split(text, "\n") {
+		if i > 0 {
+			if g.col == 0 {
+				g.lines = append(g.lines, lineOrigin{file: file, line: line})
+			}
+			g.text.WriteByte('\n')
+			g.col = 0
+			line++
+		}
+		if part == "" {
+			continue
+		}
+		if g.col == 0 {
+			g.lines = append(g.lines, lineOrigin{file: file, line: line})
+			g.col = 1
+		}
+		origin := &g.lines[len(g.lines)-1]
+		col := 1
+		if i == 0 {
+			col = tok.Col
+		}
+		origin.cols = append(origin.cols, colOrigin{g.col, col})
+		g.text.WriteString(part)
+		g.col += len(part)
+	}
+}
+
+// endLine ends the line of the text unless it is already ended.
+func (g *grammarText) endLine() {
+	if g.col != 0 {
+		g.text.WriteByte('\n')
+		g.col = 0
+	}
+}
+
+func (pr *project) errorf(file string, at GrammarToken, format string, args ...interface{}) {
+	pr.errs = append(pr.errs, &GrammarError{File: file, Line: at.Line, Col: at.Col, Msg: fmt.Sprintf(format, args...)})
+}
+
+// add adds the grammar data of file, whose rules are qualified by prefix,
+// and queues the files it imports.
+func (pr *project) add(file, prefix string, data []byte) {
+	var stmts [][]GrammarToken
+	var stmt []GrammarToken
+	for tok := range TokenizeGrammar(bytes.NewReader(data)) {
+		stmt = append(stmt, tok)
+		if tok.Kind == "newline" {
+			stmts = append(stmts, stmt)
+			stmt = nil
+		}
+	}
+	if len(stmt) > 0 {
+		stmts = append(stmts, stmt)
+	}
+
+	// The rules and templates the file defines, and the namespaces it
+	// imports.
+	defined := make(map[string]bool)
+	aliases := make(map[string]string)
+	for _, stmt := range stmts {
+		words := significant(stmt)
+		switch {
+		case len(words) == 0:
+		case words[0].Kind == "call":
+			defined[words[0].Text] = true
+		case words[0].Kind == "identifier" && len(words) > 1 && words[1].Kind == "assignment":
+			defined[words[0].Text] = true
+		case words[0].Kind == "directive" && words[0].Value == "import":
+			if len(words) != 3 || words[1].Kind != "identifier" || strings.Contains(words[1].Text, ".") || words[2].Kind != "literal" {
+				pr.errorf(file, words[0], "%%import takes a namespace and a file")
+				continue
+			}
+			alias := words[1].Text
+			if _, ok := aliases[alias]; ok {
+				pr.errorf(file, words[1], "namespace %s is imported twice", alias)
+				continue
+			}
+			name, err := unquote(words[2].Value)
+			if err != nil {
+				pr.errorf(file, words[2], "%%import: %s", err)
+				continue
+			}
+			target := path.Join(path.Dir(file), name)
+			if _, ok := pr.prefixes[target]; !ok {
+				pr.prefixes[target] = prefix + alias + "."
+				pr.queue = append(pr.queue, imported{target, prefix + alias + ".", file, words[2]})
+			}
+			aliases[alias] = pr.prefixes[target]
+		}
+	}
+
+	for _, stmt := range stmts {
+		words := significant(stmt)
+		out, qualify, test := &pr.rules, true, false
+		params := make(map[string]bool)
+		if len(words) > 0 && words[0].Kind == "directive" {
+			out = &pr.directives
+			switch name := words[0].Value; name {
+			case "import":
+				continue
+			case "start", "whitespace", "auto_whitespace", "indent", "case_insensitive":
+				if prefix != "" {
+					pr.errorf(file, words[0], "%%%s may only be given by the entry grammar", name)
+					continue
+				}
+			case "test":
+				// Of its words, only the rule names a rule.
+				test = true
+				if prefix != "" && len(words) < 4 {
+					pr.errorf(file, words[0], "%%test of an imported grammar must name a rule")
+					continue
+				}
+			case "keywords":
+				qualify = false
+			}
+		} else if len(words) > 0 && words[0].Kind == "call" {
+			// The parameters of a template are not rules.
+			for _, word := range words[1:] {
+				if word.Kind == "assignment" {
+					break
+				}
+				if word.Kind == "identifier" {
+					params[word.Text] = true
+				}
+			}
+		}
+		for i, tok := range stmt {
+			text := tok.Text
+			if qualify && (tok.Kind == "identifier" || tok.Kind == "call") && !params[text] && (!test || isLastWord(stmt, i)) {
+				text = resolve(text, prefix, defined, aliases)
+			}
+			out.write(file, tok, text)
+		}
+		out.endLine()
+	}
+}
+
+// resolve returns the name of the rule that the file with the rules
+// defined, qualified by prefix, calls name.
+func resolve(name, prefix string, defined map[string]bool, aliases map[string]string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		if p, ok := aliases[name[:i]]; ok {
+			return p + name[i+1:]
+		}
+		return name
+	}
+	if defined[name] {
+		return prefix + name
+	}
+	return name
+}
+
+// significant returns the tokens of stmt other than spaces and comments.
+func significant(stmt []GrammarToken) []GrammarToken {
+	var words []GrammarToken
+	for _, tok := range stmt {
+		switch tok.Kind {
+		case "space", "newline", "comment":
+		default:
+			words = append(words, tok)
+		}
+	}
+	return words
+}
+
+// isLastWord reports whether stmt has no more words after its ith token.
+func isLastWord(stmt []GrammarToken, i int) bool {
+	return len(significant(stmt[i+1:])) == 0
+}
+
+// lines returns where the lines of the joined grammar come from.
+func (pr *project) lines() []lineOrigin {
+	return append(append([]lineOrigin(nil), pr.directives.lines...), pr.rules.lines...)
+}
+
+// locate moves the positions of the grammar errors of err from the joined
+// grammar to the files they come from.
+func (pr *project) locate(err error) error {
+	var errs GrammarErrors
+	switch e := err.(type) {
+	case GrammarErrors:
+		errs = e
+	case *GrammarError:
+		errs = GrammarErrors{e}
+	default:
+		return err
+	}
+	lines := pr.lines()
+	for _, e := range errs {
+		if e.Line < 1 || e.Line > len(lines) {
+			continue
+		}
+		origin := lines[e.Line-1]
+		e.File, e.Line = origin.file, origin.line
+		for i := len(origin.cols) - 1; i >= 0; i-- {
+			if c := origin.cols[i]; c.col <= e.Col {
+				e.Col = c.orig + e.Col - c.col
+				break
+			}
+		}
+	}
+	return err
+}
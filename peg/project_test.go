@@ -0,0 +1,79 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var projectFS = fstest.MapFS{
+	"main.peg": {Data: []byte(`%import json "formats/json.peg"
+%import lex "lex/lex.peg"
+%whitespace lex.ws
+
+## A document.
+doc <- json.value+
+%test '[1, 2]' matches doc
+`)},
+	"formats/json.peg": {Data: []byte(`%import lex "../lex/lex.peg"
+%memo value
+value <- list / lex.number
+list <- '[' items ']'
+items <- sep(value, ',')
+sep(x, s) <- x more(x, s)*
+more(x, s) <- s^ x
+%test '[]' fails value
+`)},
+	"lex/lex.peg": {Data: []byte(`## Numbers.
+number <- ~'[0-9]+'
+ws <- ~'[ ]+'
+`)},
+}
+
+func TestNewLanguageFS(t *testing.T) {
+	lang, err := NewLanguageFS(projectFS, "main.peg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := strings.Join(lang.Rules(), " ")
+	if exp := "doc json.value json.list json.items"; !strings.HasPrefix(rules, exp) {
+		t.Errorf("got rules %s, expected %s", rules, exp)
+	}
+	if doc := lang.RuleDoc("lex.number"); doc != "Numbers." {
+		t.Errorf("got doc %q", doc)
+	}
+	tree, err := lang.ParseString("[1, [2, 3]] 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `(json.value+ (json.list (json.list "[") (json.sep(json.value, ',') (lex.number "1")`; !strings.HasPrefix(tree.SExpr(), exp) {
+		t.Errorf("got %s, expected it to start with %s", tree.SExpr(), exp)
+	}
+	if err := lang.RunTests(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewLanguageFSErrors(t *testing.T) {
+	for _, tt := range []struct {
+		files map[string]string
+		exp   string
+	}{
+		{map[string]string{"a.peg": "%import b \"b.peg\"\na <- b.x"}, "a.peg:1:11: open b.peg: file does not exist"},
+		{map[string]string{"a.peg": "%import b \"b.peg\"\na <- b.x", "b.peg": "x <- 'x'\ny <- z"}, "b.peg:2:6: b.y: undefined rule z"},
+		{map[string]string{"a.peg": "%import b \"b.peg\"\na <- b.y", "b.peg": "x <- 'x'"}, "a.peg:2:6: a: undefined rule b.y"},
+		{map[string]string{"a.peg": "%import b \"b.peg\"\na <- b.x", "b.peg": "%start x\nx <- 'x'"}, "b.peg:1:1: %start may only be given by the entry grammar"},
+		{map[string]string{"a.peg": "%import b\na <- 'a'"}, "a.peg:1:1: %import takes a namespace and a file"},
+	} {
+		fsys := fstest.MapFS{}
+		for name, text := range tt.files {
+			fsys[name] = &fstest.MapFile{Data: []byte(text)}
+		}
+		if _, err := NewLanguageFS(fsys, "a.peg"); err == nil || err.Error() != tt.exp {
+			t.Errorf("%v: got error %v, expected %s", tt.files, err, tt.exp)
+		}
+	}
+	if _, err := NewLanguage("%import b \"b.peg\"\na <- 'a'"); err == nil || !strings.Contains(err.Error(), "NewLanguageFS") {
+		t.Errorf("got error %v", err)
+	}
+}
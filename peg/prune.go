@@ -0,0 +1,78 @@
+package peg
+
+// Prune returns a copy of the tree without the nodes of the given types,
+// such as ws*, and the nodes below them. It returns nil if the root is of
+// one of the types. The tree itself is left unchanged.
+func (p *ParseTree) Prune(types ...string) *ParseTree {
+	drop := typeSet(types)
+	var prune func(node *ParseTree) *ParseTree
+	prune = func(node *ParseTree) *ParseTree {
+		if drop[node.Type] {
+			return nil
+		}
+		copied := *node
+		copied.Children = nil
+		for _, child := range node.Children {
+			if child := prune(child); child != nil {
+				copied.Children = append(copied.Children, child)
+			}
+		}
+		return &copied
+	}
+	if p == nil {
+		return nil
+	}
+	return prune(p)
+}
+
+// Keep returns a copy of the tree with only the root and the nodes of the
+// given types. The nodes of other types are replaced by the nodes they
+// keep, so that a kept node becomes the child of its nearest kept
+// ancestor. The tree itself is left unchanged.
+func (p *ParseTree) Keep(types ...string) *ParseTree {
+	keep := typeSet(types)
+	var kept func(node *ParseTree) []*ParseTree
+	kept = func(node *ParseTree) []*ParseTree {
+		var nodes []*ParseTree
+		for _, child := range node.Children {
+			if !keep[child.Type] {
+				nodes = append(nodes, kept(child)...)
+				continue
+			}
+			copied := *child
+			copied.Children = kept(child)
+			nodes = append(nodes, &copied)
+		}
+		return nodes
+	}
+	if p == nil {
+		return nil
+	}
+	root := *p
+	root.Children = kept(p)
+	return &root
+}
+
+func typeSet(types []string) map[string]bool {
+	set := make(map[string]bool, len(types))
+	for _, typ := range types {
+		set[typ] = true
+	}
+	return set
+}
+
+// DropEmpty sets whether closures that match nothing, such as a ws* where
+// there is no whitespace, are left out of the tree rather than producing a
+// node without children. Options that don't match produce no node either
+// way.
+func DropEmpty(drop bool) Option {
+	return func(l *Language) {
+		l.dropEmpty = drop
+	}
+}
+
+// dropsEmpty reports whether the node of a closure is left out of the tree
+// because it matched nothing.
+func (s *Source) dropsEmpty(node *ParseTree) bool {
+	return node.Pos == node.End && len(node.Children) == 0 && s.lang != nil && s.lang.dropEmpty
+}
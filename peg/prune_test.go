@@ -0,0 +1,65 @@
+package peg
+
+import "testing"
+
+func TestPrune(t *testing.T) {
+	lang, err := NewLanguage("list <- item more*\nmore <- sep item\nsep <- ','\nitem <- ~'[a-z]+'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("a,b,c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := tree.SExpr()
+	for _, tc := range []struct {
+		name string
+		got  *ParseTree
+		exp  string
+	}{
+		{"prune", tree.Prune("sep"), `(list (item "a") (more* (more (item "b")) (more (item "c"))))`},
+		{"prune subtree", tree.Prune("more*"), `(list (item "a"))`},
+		{"prune nothing", tree.Prune(), before},
+		{"keep", tree.Keep("item"), `(list (item "a") (item "b") (item "c"))`},
+		{"keep nested", tree.Keep("more", "item"), `(list (item "a") (more (item "b")) (more (item "c")))`},
+		{"keep none", tree.Keep(), `(list "")`},
+	} {
+		if got := tc.got.SExpr(); got != tc.exp {
+			t.Errorf("%s: got %s, exp %s", tc.name, got, tc.exp)
+		}
+	}
+	if tree.Prune("list") != nil {
+		t.Errorf("pruning the root: exp nil")
+	}
+	if tree.SExpr() != before {
+		t.Errorf("tree changed to %s, exp %s", tree.SExpr(), before)
+	}
+}
+
+func TestDropEmpty(t *testing.T) {
+	grammar := "list <- item more* end?\nmore <- ','^ item\nend <- ';'\nitem <- ~'[a-z]+'"
+	for _, tc := range []struct {
+		name  string
+		drop  bool
+		input string
+		exp   string
+	}{
+		{"kept", false, "a;", `(list (item "a") (more* "") (end ";"))`},
+		{"dropped", true, "a;", `(list (item "a") (end ";"))`},
+		{"matched", true, "a,b;", `(list (item "a") (more* (item "b")) (end ";"))`},
+		{"option", true, "a,b", `(list (item "a") (more* (item "b")))`},
+	} {
+		lang, err := NewLanguage(grammar, DropEmpty(tc.drop))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := lang.ParseString(tc.input)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := tree.SExpr(); got != tc.exp {
+			t.Errorf("%s: got %s, exp %s", tc.name, got, tc.exp)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package peg
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// recognizer reports the length of the match at pos, or -1 if there is
+// none. Recognizers build no trees.
+type recognizer func(s *Source, pos int) int
+
+// compileToken turns the body of the %token rule name into a single leaf
+// matched by a recognizer, one regexp if exactRecognizer takes it. The rule
+// must be regular: built from literals, regexps, sequences, choices,
+// closures, repetitions and discards, and references to other such rules
+// that don't lead back to it. A token that is one of the reserved words
+// fails.
+func compileToken(name string, def *Lexeme, fold bool, reserved map[string]bool) error {
+	body := def.Dependencies[0]
+	match, err := compileRegular(body, fold, map[string]bool{name: true})
+	if err != nil {
+		return errors.New(fmt.Sprintf("%%token %s: %s", name, err))
+	}
+	id := newNodeType(name)
+	body.Lexer = func(s *Source, pos int) (*ParseTree, error, int) {
+		skip := s.skipWhitespace(pos)
+		pos += skip
+		n := match(s, pos)
+		if n < 0 {
+			s.complete(name, pos, name, "", false)
+			return nil, s.expected(pos, name), 0
+		} else if reserved[string(s.buf[pos:pos+n])] {
+			return nil, s.failed(pos, fmt.Sprintf("%s is a keyword", s.buf[pos:pos+n])), 0
+		}
+		return s.leaf(&ParseTree{
+			Type:    name,
+			ID:      id.get(s),
+			Data:    s.buf[pos : pos+n],
+			Pos:     pos,
+			End:     pos + n,
+			Leading: s.skipped(pos, skip),
+		}), nil, skip + n
+	}
+	return nil
+}
+
+// compileRegulars finds the regular rules whose trees are never kept, the
+// %whitespace rule and the rules of discarded lexemes, and matches each of
+// them with a single regexp where one matches the same text. Like %token
+// rules, they then try no rules of their own, so parses that watch the
+// rules tried match them as written.
+func compileRegulars(lang *Language, roots []*Lexeme, d *directives) {
+	if len(d.keywords) > 0 || d.autoWhitespace {
+		return
+	}
+	tokens := make(map[string]bool)
+	for _, name := range d.tokens {
+		tokens[name] = true
+	}
+	space := ""
+	if d.whitespace != "" {
+		expr, err := regularPattern(lang.whitespace, d.caseInsensitive, "", tokens, map[string]bool{})
+		if err != nil {
+			return
+		}
+		space = "(?:" + expr + ")*"
+		if lang.space = exactRecognizer(space); lang.space == nil {
+			return
+		}
+	}
+
+	seen := make(map[*Lexeme]bool)
+	var walk func(lex *Lexeme)
+	walk = func(lex *Lexeme) {
+		if lex == nil || seen[lex] {
+			return
+		}
+		seen[lex] = true
+		for _, dep := range lex.Dependencies {
+			walk(dep)
+		}
+		if lex.kind != kindDiscard {
+			return
+		}
+		// A lone terminal is a single match already.
+		switch lex.Dependencies[0].kind {
+		case kindLiteral, kindRegexp:
+			return
+		}
+		expr, err := regularPattern(lex.Dependencies[0], d.caseInsensitive, space, tokens, map[string]bool{})
+		if err != nil {
+			return
+		}
+		if match := exactRecognizer(expr); match != nil {
+			lex.Lexer = compiledDiscard(lex.Lexer, match)
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+}
+
+// compiledDiscard matches a discarded lexeme with match instead of lexer,
+// which still matches it where the source is watched or skips whitespace.
+func compiledDiscard(lexer LexFunc, match recognizer) LexFunc {
+	return func(s *Source, pos int) (*ParseTree, error, int) {
+		if s.inspected() || s.skipping {
+			return lexer(s, pos)
+		}
+		n := match(s, pos)
+		if n < 0 {
+			n = 0
+		}
+		return nil, nil, n
+	}
+}
+
+// inspected reports whether the rules tried on s are watched: traced,
+// listened to, profiled, counted against a budget, completed or recovered
+// from. Compiled matchers try no rules, so they make way for the lexemes.
+func (s *Source) inspected() bool {
+	return s.trace != nil || s.listener != nil || s.stats != nil || s.budget != nil ||
+		s.completions != nil || s.missing != nil || s.resyncing
+}
+
+// compileRegular builds the recognizer of lex: a single regexp where one
+// matches the same text, or else a recognizer for each of its lexemes. The
+// rules in active are being compiled further up, so referring to them
+// again would recurse.
+func compileRegular(lex *Lexeme, fold bool, active map[string]bool) (recognizer, error) {
+	if expr, err := regularPattern(lex, fold, "", nil, copyActive(active)); err == nil {
+		if match := exactRecognizer(expr); match != nil {
+			return match, nil
+		}
+	}
+	return recognizeRegular(lex, fold, active)
+}
+
+func copyActive(active map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(active))
+	for name := range active {
+		c[name] = true
+	}
+	return c
+}
+
+// regularPattern writes the regular lex in the syntax of package regexp.
+// space is the pattern of the whitespace skipped before each literal and
+// regexp, if any, and tokens are the %token rules, which skip it before
+// their text only.
+func regularPattern(lex *Lexeme, fold bool, space string, tokens, active map[string]bool) (string, error) {
+	operands := func(space string) ([]string, error) {
+		exprs := make([]string, len(lex.Dependencies))
+		for i, dep := range lex.Dependencies {
+			expr, err := regularPattern(dep, fold, space, tokens, active)
+			if err != nil {
+				return nil, err
+			}
+			exprs[i] = "(?:" + expr + ")"
+		}
+		return exprs, nil
+	}
+
+	switch lex.kind {
+	case kindLiteral:
+		expr, err := literalPattern(lex.text, fold)
+		return space + expr, err
+	case kindRegexp:
+		return space + "(?:" + lex.text + ")", nil
+	case kindDefinition:
+		if active[lex.text] {
+			return "", errors.New(fmt.Sprintf("rule %s is recursive", lex.text))
+		}
+		active[lex.text] = true
+		defer delete(active, lex.text)
+		if tokens[lex.text] {
+			exprs, err := operands("")
+			if err != nil {
+				return "", err
+			}
+			return space + exprs[0], nil
+		}
+	}
+
+	exprs, err := operands(space)
+	if err != nil {
+		return "", err
+	}
+	switch lex.kind {
+	case kindDefinition, kindMemo:
+		return exprs[0], nil
+	case kindConcat:
+		return strings.Join(exprs, ""), nil
+	case kindChoice, kindAlternate:
+		return strings.Join(exprs, "|"), nil
+	case kindOption, kindDiscard:
+		return exprs[0] + "?", nil
+	case kindPlus:
+		return exprs[0] + "+", nil
+	case kindStar:
+		return exprs[0] + "*", nil
+	case kindRepeat:
+		return exprs[0] + lex.text, nil
+	}
+	return "", errors.New(fmt.Sprintf("%s is not regular", lex.Name))
+}
+
+// literalPattern matches the text of a literal. Folded literals only fold
+// ASCII letters the way ConsumeLiteralFold does.
+func literalPattern(text string, fold bool) (string, error) {
+	// The replacement character would stand for any invalid input.
+	if !utf8.ValidString(text) || strings.ContainsRune(text, utf8.RuneError) {
+		return "", errors.New(fmt.Sprintf("literal %q is no valid UTF-8", text))
+	}
+	if !fold {
+		return regexp.QuoteMeta(text), nil
+	}
+	var buf strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch lower := lowerASCII(c); {
+		case c >= utf8.RuneSelf:
+			return "", errors.New(fmt.Sprintf("literal %q folds more than ASCII", text))
+		case 'a' <= lower && lower <= 'z':
+			fmt.Fprintf(&buf, "[%c%c]", lower, lower-'a'+'A')
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return buf.String(), nil
+}
+
+// exactRecognizer matches the regexp expr, or returns nil if it might match
+// other text than the lexemes it was written from. Regexps backtrack into
+// the choices and closures that matched, which grammars never do, so expr
+// must be settled by its next byte at every choice, closure and option.
+func exactRecognizer(expr string) recognizer {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	if _, _, ok := settled(re.Simplify(), byteSet{}); !ok {
+		return nil
+	}
+	valid, err := regexp.Compile("^(?:" + expr + ")")
+	if err != nil {
+		return nil
+	}
+	var once sync.Once
+	var prog *syntax.Prog
+	compiled := func() *syntax.Prog {
+		once.Do(func() { prog = compileProg(expr) })
+		return prog
+	}
+	return func(s *Source, pos int) int {
+		loc := valid.FindIndex(s.buf[pos:])
+		if s.stream && alive(compiled(), s.buf[pos:]) {
+			s.Starve(1)
+		}
+		if loc == nil {
+			return -1
+		}
+		return loc[1]
+	}
+}
+
+// byteSet holds the bytes that may start a match.
+type byteSet [4]uint64
+
+func (b *byteSet) add(c byte) {
+	b[c/64] |= 1 << (c % 64)
+}
+
+// addRunes adds the first bytes of the runes from lo to hi.
+func (b *byteSet) addRunes(lo, hi rune) {
+	var buf [utf8.UTFMax]byte
+	for ; lo <= hi && lo < utf8.RuneSelf; lo++ {
+		b.add(byte(lo))
+	}
+	if lo > hi {
+		return
+	}
+	if hi > unicode.MaxRune {
+		hi = unicode.MaxRune
+	}
+	// Invalid input reads as the replacement character.
+	if lo <= utf8.RuneError && utf8.RuneError <= hi {
+		for c := utf8.RuneSelf; c < 256; c++ {
+			b.add(byte(c))
+		}
+	}
+	utf8.EncodeRune(buf[:], lo)
+	first := buf[0]
+	utf8.EncodeRune(buf[:], hi)
+	for c := int(first); c <= int(buf[0]); c++ {
+		b.add(byte(c))
+	}
+}
+
+func (b byteSet) union(o byteSet) byteSet {
+	for i := range b {
+		b[i] |= o[i]
+	}
+	return b
+}
+
+func (b byteSet) meets(o byteSet) bool {
+	for i := range b {
+		if b[i]&o[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// settled returns the bytes that may start a match of re and whether it
+// matches the empty string. ok reports whether each choice, closure and
+// option of re is settled by the byte after it, given the bytes that may
+// follow re, so that a regexp matching re backtracks into none of them.
+func settled(re *syntax.Regexp, follow byteSet) (first byteSet, nullable, ok bool) {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return first, false, true
+	case syntax.OpEmptyMatch:
+		return first, true, true
+	case syntax.OpLiteral:
+		if len(re.Rune) == 0 {
+			return first, true, true
+		}
+		r := re.Rune[0]
+		first.addRunes(r, r)
+		if re.Flags&syntax.FoldCase != 0 {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				first.addRunes(f, f)
+			}
+		}
+		return first, false, true
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			first.addRunes(re.Rune[i], re.Rune[i+1])
+		}
+		return first, false, true
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		first.addRunes(0, unicode.MaxRune)
+		return first, false, true
+	case syntax.OpCapture:
+		return settled(re.Sub[0], follow)
+	case syntax.OpConcat:
+		nullable = true
+		for i := len(re.Sub) - 1; i >= 0; i-- {
+			f, n, ok := settled(re.Sub[i], follow)
+			if !ok {
+				return first, false, false
+			}
+			if n {
+				first, follow = first.union(f), follow.union(f)
+			} else {
+				first, follow, nullable = f, f, false
+			}
+		}
+		return first, nullable, true
+	case syntax.OpAlternate:
+		for i, sub := range re.Sub {
+			f, n, ok := settled(sub, follow)
+			// An empty match is only tried after the others.
+			if !ok || first.meets(f) || n && i < len(re.Sub)-1 {
+				return first, false, false
+			}
+			if n && first.meets(follow) {
+				return first, false, false
+			}
+			first, nullable = first.union(f), n
+		}
+		return first, nullable, true
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		if re.Flags&syntax.NonGreedy != 0 {
+			return first, false, false
+		}
+		f, n, ok := settled(re.Sub[0], follow)
+		if !ok || n || f.meets(follow) {
+			return first, false, false
+		}
+		// Repetitions are followed by the next one too.
+		if re.Op != syntax.OpQuest {
+			if _, _, ok := settled(re.Sub[0], follow.union(f)); !ok {
+				return first, false, false
+			}
+		}
+		return f, re.Op != syntax.OpPlus, true
+	}
+	// Assertions such as ^ and \b depend on more than the next byte.
+	return first, false, false
+}
+
+// recognizeRegular builds a recognizer for each lexeme of lex, for those
+// that no single regexp matches exactly.
+func recognizeRegular(lex *Lexeme, fold bool, active map[string]bool) (recognizer, error) {
+	operands := func() ([]recognizer, error) {
+		ms := make([]recognizer, len(lex.Dependencies))
+		for i, dep := range lex.Dependencies {
+			m, err := recognizeRegular(dep, fold, active)
+			if err != nil {
+				return nil, err
+			}
+			ms[i] = m
+		}
+		return ms, nil
+	}
+
+	switch lex.kind {
+	case kindLiteral:
+		valid := []byte(lex.text)
+		return func(s *Source, pos int) int {
+			var match []byte
+			if fold {
+				match = s.ConsumeLiteralFold(valid, pos)
+			} else {
+				match = s.ConsumeLiteral(valid, pos)
+			}
+			if match == nil {
+				s.starveLiteral(valid, pos, fold)
+				return -1
+			}
+			return len(match)
+		}, nil
+	case kindRegexp:
+		re, err := regexp.Compile("^(?:" + lex.text + ")")
+		if err != nil {
+			return nil, err
+		}
+		var once sync.Once
+		var prog *syntax.Prog
+		compiled := func() *syntax.Prog {
+			once.Do(func() { prog = compileProg(lex.text) })
+			return prog
+		}
+		return func(s *Source, pos int) int {
+			match := s.consumeRegexp(re, compiled, pos)
+			if match == nil {
+				return -1
+			}
+			return len(match)
+		}, nil
+	case kindDefinition:
+		// References are copies of the definition, so go by name.
+		if active[lex.text] {
+			return nil, errors.New(fmt.Sprintf("rule %s is recursive", lex.text))
+		}
+		active[lex.text] = true
+		defer delete(active, lex.text)
+	}
+
+	ms, err := operands()
+	if err != nil {
+		return nil, err
+	}
+	switch lex.kind {
+	case kindDefinition, kindMemo:
+		return ms[0], nil
+	case kindConcat:
+		return func(s *Source, pos int) int {
+			start := pos
+			for _, m := range ms {
+				n := m(s, pos)
+				if n < 0 {
+					return -1
+				}
+				pos += n
+			}
+			return pos - start
+		}, nil
+	case kindChoice, kindAlternate:
+		return func(s *Source, pos int) int {
+			for _, m := range ms {
+				if n := m(s, pos); n >= 0 {
+					return n
+				}
+			}
+			return -1
+		}, nil
+	case kindOption, kindDiscard:
+		return func(s *Source, pos int) int {
+			if n := ms[0](s, pos); n >= 0 {
+				return n
+			}
+			return 0
+		}, nil
+	case kindPlus:
+		return repeatRecognizer(ms[0], 1, -1), nil
+	case kindStar:
+		return repeatRecognizer(ms[0], 0, -1), nil
+	case kindRepeat:
+		min, max, err := parseRepeat(lex.text[1 : len(lex.text)-1])
+		if err != nil {
+			return nil, err
+		}
+		return repeatRecognizer(ms[0], min, max), nil
+	}
+	return nil, errors.New(fmt.Sprintf("%s is not regular", lex.Name))
+}
+
+// repeatRecognizer matches m at least min and at most max times, or without
+// an upper bound if max is negative. Like the closures, it never gives back
+// a repetition it matched.
+func repeatRecognizer(m recognizer, min, max int) recognizer {
+	return func(s *Source, pos int) int {
+		start := pos
+		for count := 0; max < 0 || count < max; count++ {
+			n := m(s, pos)
+			if n < 0 {
+				if count < min {
+					return -1
+				}
+				break
+			}
+			pos += n
+			if n == 0 {
+				break
+			}
+		}
+		return pos - start
+	}
+}
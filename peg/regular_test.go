@@ -0,0 +1,257 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenRules(t *testing.T) {
+	grammar := `%whitespace ws
+%token ident number
+list <- item+
+item <- ident / number
+ident <- alpha alnum*
+alnum <- alpha / digit
+number <- digit+ frac?
+frac <- '.' digit+
+alpha <- ~'[a-z_]'
+digit <- ~'[0-9]'
+ws <- ' '`
+	lang, err := NewParser(strings.NewReader(grammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("x1 3.25  _a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "item+",
+		Children: []*ParseTree{
+			&ParseTree{Type: "ident", Data: []byte("x1")},
+			&ParseTree{Type: "number", Data: []byte("3.25")},
+			&ParseTree{Type: "ident", Data: []byte("_a")},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		t.Error(err)
+	}
+
+	_, err = lang.ParseString("?")
+	if err == nil || !strings.Contains(err.Error(), "expected ident or number") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if out := lang.Grammar(); !strings.Contains(out, "%token ident number\n") {
+		t.Errorf("directive missing from grammar:\n%s", out)
+	}
+}
+
+func TestTokenRulesArePossessive(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("%token as\nprgm <- as\nas <- 'a'* 'a'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lang.ParseString("aaa"); err == nil {
+		t.Errorf("a closure gave back a repetition")
+	}
+}
+
+func TestTokenErrors(t *testing.T) {
+	for _, grammar := range []string{
+		"%token\na <- 'a'",
+		"%token b\na <- 'a'",
+		"%token a\na <- 'a' a?",
+		"%token a\na <- b\nb <- 'b' a",
+		"%token a\na <- @ext",
+		"%token a\na <- x:'a' =x",
+	} {
+		if _, err := NewParser(strings.NewReader(grammar)); err == nil {
+			t.Errorf("expected error for %q", grammar)
+		}
+	}
+}
+
+func TestRegularPatterns(t *testing.T) {
+	lang, err := NewLanguage(`ident <- alpha alnum*
+alnum <- alpha / digit
+number <- digit+ frac?
+frac <- '.' digit+
+alpha <- ~'[a-z]'
+digit <- ~'[0-9]'
+words <- ~'[a-z]+' ' ' ~'[a-z]+'
+sums <- number more*
+more <- ','^ ' ' number
+as <- 'a'* 'a'
+cut <- 'a'? 'a'
+greedy <- ~'[a-z]+' ~'[a-z]'
+prefix <- 'ab' / 'a'
+overlap <- 'a' / 'ab'
+first <- maybe / 'b'
+maybe <- 'a'?
+late <- first 'c'
+reps <- digit{2,3} '.'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []string{""}
+	for n, last := 0, []string{""}; n < 5; n++ {
+		var next []string
+		for _, in := range last {
+			for _, c := range "ab1. c" {
+				next = append(next, in+string(c))
+			}
+		}
+		inputs, last = append(inputs, next...), next
+	}
+	for _, test := range []struct {
+		rule  string
+		exact bool
+	}{
+		{"ident", true},
+		{"number", true},
+		{"words", true},
+		{"sums", true},
+		{"reps", true},
+		{"prefix", true},
+		{"as", false},
+		{"cut", false},
+		{"greedy", false},
+		{"overlap", false},
+		{"late", false},
+	} {
+		r, _ := lang.rule(test.rule)
+		expr, err := regularPattern(r.lex, false, "", nil, map[string]bool{})
+		if err != nil {
+			t.Errorf("%s: %s", test.rule, err)
+			continue
+		}
+		match := exactRecognizer(expr)
+		if (match != nil) != test.exact {
+			t.Errorf("%s: %s is exact: %v, expected %v", test.rule, expr, match != nil, test.exact)
+		}
+		if match == nil {
+			continue
+		}
+		recognize, err := recognizeRegular(r.lex, false, map[string]bool{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, in := range inputs {
+			s := SourceFromBytes([]byte(in))
+			if got, exp := match(s, 0), recognize(s, 0); got != exp {
+				t.Errorf("%s on %q: %s matched %d, the lexemes %d", test.rule, in, expr, got, exp)
+			}
+		}
+	}
+}
+
+func TestCompiledWhitespace(t *testing.T) {
+	for _, test := range []struct {
+		ws, in   string
+		compiled bool
+	}{
+		{"ws <- ' ' / note\nnote <- '#' ~'[^\\n]*' ~'\\n'", " a #c\n b", true},
+		{"ws <- ' '+", " a  b", false},
+		{"ws <- ' ' ' '?", " a   b", false},
+	} {
+		lang, err := NewLanguage("%whitespace ws\nlist <- word+\nword <- ~'[a-z]+'\n" + test.ws)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (lang.space != nil) != test.compiled {
+			t.Errorf("%s compiled: %v, expected %v", test.ws, lang.space != nil, test.compiled)
+		}
+		// Listeners see the whitespace rule all the same.
+		for _, listen := range []bool{false, true} {
+			var r recorder
+			s := SourceFromBytes([]byte(test.in))
+			if listen {
+				s.Listen(&r)
+			}
+			tree, err := lang.ParseSource(s)
+			if err != nil {
+				t.Errorf("%s: %s", test.ws, err)
+				continue
+			}
+			if got := tree.SExpr(); got != `(word+ (word "a") (word "b"))` {
+				t.Errorf("%s: unexpected tree %s", test.ws, got)
+			}
+			if listen && !strings.Contains(strings.Join(r, "\n"), "> ws 0") {
+				t.Errorf("%s: the listener missed the whitespace", test.ws)
+			}
+		}
+	}
+}
+
+func TestCompiledDiscard(t *testing.T) {
+	lang, err := NewLanguage(`%whitespace ws
+pair <- key sep^ key
+sep <- ':' '='?
+key <- ~'[a-z]+'
+ws <- ' '`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in, exp := range map[string]string{
+		"a:b":      `(pair (key "a") (key "b"))`,
+		"a := b":   `(pair (key "a") (key "b"))`,
+		"a : = b ": `(pair (key "a") (key "b"))`,
+		"a b":      `(pair (key "a") (key "b"))`,
+	} {
+		tree, err := lang.ParseString(in)
+		if err != nil {
+			t.Errorf("%q: %s", in, err)
+			continue
+		}
+		if got := tree.SExpr(); got != exp {
+			t.Errorf("%q: got %s, expected %s", in, got, exp)
+		}
+	}
+}
+
+func BenchmarkRegular(b *testing.B) {
+	lang, err := NewLanguage(`ident <- alpha alnum*
+alnum <- alpha / digit
+alpha <- ~'[a-z]'
+digit <- ~'[0-9]'
+number <- digit+ frac?
+frac <- '.' digit+
+space <- white*
+white <- ~'[ \t\n]' / note
+note <- '#' ~'[^\n]*' ~'\n'`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bench := range []struct{ rule, input string }{
+		{"ident", strings.Repeat("ab1", 100)},
+		{"number", strings.Repeat("1", 150) + "." + strings.Repeat("2", 150)},
+		{"space", strings.Repeat(" \t# note\n", 30)},
+	} {
+		r, _ := lang.rule(bench.rule)
+		expr, err := regularPattern(r.lex, false, "", nil, map[string]bool{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		recognize, err := recognizeRegular(r.lex, false, map[string]bool{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		s := SourceFromBytes([]byte(bench.input))
+		for _, m := range []struct {
+			name  string
+			match recognizer
+		}{
+			{"lexemes", recognize},
+			{"regexp", exactRecognizer(expr)},
+		} {
+			b.Run(bench.rule+"/"+m.name, func(b *testing.B) {
+				b.SetBytes(int64(len(bench.input)))
+				for i := 0; i < b.N; i++ {
+					if m.match(s, 0) != len(bench.input) {
+						b.Fatal("no match")
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package peg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// RewriteFunc computes a node of the result of a rewrite from the nodes
+// bound to its arguments.
+type RewriteFunc func(args []*ParseTree) (*ParseTree, error)
+
+// Rewriter rewrites parse trees with rules written as patterns over node
+// types, such as for constant folding or desugaring:
+//
+//	(add (num a) (num b)) -> (num @sum(a, b))
+//	(paren x) -> x
+//
+// A rule replaces a node matching its pattern, on the left of the arrow,
+// with the tree its template on the right builds. In patterns,
+//
+//   - (type p...) matches a node of the type whose children match p, in
+//     order, and a leaf matches (type p) as though it were its own child;
+//   - a name matches any node and binds the name to it; a name used twice
+//     matches equal nodes, regardless of their positions;
+//   - name... at the end of a list matches the remaining children;
+//   - _ matches any node, and 'text' a leaf holding text.
+//
+// In templates, names stand for the nodes bound to them, (type t...)
+// builds a node of the type with those children, 'text' builds a leaf of
+// the type of the enclosing list, and @fn(args...) calls the RewriteFunc
+// fn with the nodes of its arguments. A list of a single literal or of a
+// call returning a leaf, such as (num @sum(a, b)), builds a leaf of its
+// type holding that text. Built nodes span the node they replace, and have
+// no ID, as the rewriter serves no language of its own.
+type Rewriter struct {
+	rules []rewriteRule
+	funcs map[string]RewriteFunc
+}
+
+// maxRewrites bounds the rewrites of a single Rewrite, so that rules that
+// undo each other fail rather than run forever.
+const maxRewrites = 1 << 20
+
+type rewriteRule struct {
+	line     int
+	pattern  *rwTerm
+	template *rwTerm
+}
+
+// rwTerm is a pattern or template.
+type rwTerm struct {
+	kind int
+	text string    // the type, variable, literal text or function name.
+	args []*rwTerm // the children of a list or the arguments of a call.
+}
+
+const (
+	rwList = iota
+	rwVar
+	rwRest
+	rwAny
+	rwText
+	rwCall
+)
+
+// NewRewriter compiles rules, one to a line, calling funcs for @fn in the
+// templates. Empty lines and lines starting with # are skipped.
+func NewRewriter(rules string, funcs map[string]RewriteFunc) (*Rewriter, error) {
+	r := &Rewriter{funcs: funcs}
+	for i, line := range strings.Split(rules, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule, err := r.compile(line)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: %s", i+1, err))
+		}
+		rule.line = i + 1
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+func (r *Rewriter) compile(line string) (rewriteRule, error) {
+	arrow := strings.Index(line, "->")
+	if arrow < 0 {
+		return rewriteRule{}, errors.New("expected pattern -> template")
+	}
+	pattern, err := parseTerm(line[:arrow], false)
+	if err != nil {
+		return rewriteRule{}, err
+	}
+	if pattern.kind != rwList {
+		return rewriteRule{}, errors.New("a pattern must be a (type ...) list")
+	}
+	template, err := parseTerm(line[arrow+2:], true)
+	if err != nil {
+		return rewriteRule{}, err
+	}
+	if template.kind == rwText || template.kind == rwRest {
+		return rewriteRule{}, errors.New("a template must build a single node")
+	}
+	bound := make(map[string]bool)
+	pattern.vars(bound)
+	if err := r.check(template, bound); err != nil {
+		return rewriteRule{}, err
+	}
+	return rewriteRule{pattern: pattern, template: template}, nil
+}
+
+// vars adds the variables t binds to bound.
+func (t *rwTerm) vars(bound map[string]bool) {
+	if t.kind == rwVar || t.kind == rwRest {
+		bound[t.text] = true
+	}
+	for _, arg := range t.args {
+		arg.vars(bound)
+	}
+}
+
+// check reports variables and functions of a template that are undefined.
+func (r *Rewriter) check(t *rwTerm, bound map[string]bool) error {
+	switch t.kind {
+	case rwVar, rwRest:
+		if !bound[t.text] {
+			return errors.New(fmt.Sprintf("%s is not bound by the pattern", t.text))
+		}
+	case rwCall:
+		if r.funcs[t.text] == nil {
+			return errors.New(fmt.Sprintf("no function %s", t.text))
+		}
+	}
+	for _, arg := range t.args {
+		if err := r.check(arg, bound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// termParser reads the terms of one side of a rule.
+type termParser struct {
+	text     string
+	template bool
+}
+
+func parseTerm(text string, template bool) (*rwTerm, error) {
+	p := &termParser{text: text, template: template}
+	t, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	if p.skip(); p.text != "" {
+		return nil, errors.New(fmt.Sprintf("unexpected %q", p.text))
+	}
+	return t, nil
+}
+
+func (p *termParser) skip() {
+	p.text = strings.TrimLeftFunc(p.text, unicode.IsSpace)
+}
+
+// name reads a type, variable or function name, which may contain any
+// characters but spaces, parentheses, commas and quotes.
+func (p *termParser) name() string {
+	i := strings.IndexFunc(p.text, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("(),'\"", r)
+	})
+	if i < 0 {
+		i = len(p.text)
+	}
+	name := p.text[:i]
+	p.text = p.text[i:]
+	return name
+}
+
+func (p *termParser) term() (*rwTerm, error) {
+	p.skip()
+	if p.text == "" {
+		return nil, errors.New("unexpected end of rule")
+	}
+	switch c := p.text[0]; {
+	case c == '(':
+		p.text = p.text[1:]
+		p.skip()
+		typ := p.name()
+		if typ == "" {
+			return nil, errors.New("expected a node type after (")
+		}
+		t := &rwTerm{kind: rwList, text: typ}
+		for {
+			if p.skip(); strings.HasPrefix(p.text, ")") {
+				p.text = p.text[1:]
+				return t, nil
+			}
+			if n := len(t.args); n > 0 && t.args[n-1].kind == rwRest && !p.template {
+				return nil, errors.New(fmt.Sprintf("%s... must come last", t.args[n-1].text))
+			}
+			arg, err := p.term()
+			if err != nil {
+				return nil, err
+			}
+			t.args = append(t.args, arg)
+		}
+	case c == '\'' || c == '"':
+		end := 1
+		for end < len(p.text) && p.text[end] != c {
+			if p.text[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.text) {
+			return nil, errors.New("unterminated literal")
+		}
+		text, err := unquote(p.text[1:end])
+		if err != nil {
+			return nil, err
+		}
+		p.text = p.text[end+1:]
+		return &rwTerm{kind: rwText, text: text}, nil
+	case c == '@' && p.template:
+		p.text = p.text[1:]
+		t := &rwTerm{kind: rwCall, text: p.name()}
+		if p.skip(); !strings.HasPrefix(p.text, "(") {
+			return nil, errors.New(fmt.Sprintf("expected ( after @%s", t.text))
+		}
+		p.text = p.text[1:]
+		for {
+			if p.skip(); strings.HasPrefix(p.text, ")") {
+				p.text = p.text[1:]
+				return t, nil
+			}
+			if len(t.args) > 0 {
+				if !strings.HasPrefix(p.text, ",") {
+					return nil, errors.New(fmt.Sprintf("expected , or ) in @%s", t.text))
+				}
+				p.text = p.text[1:]
+			}
+			arg, err := p.term()
+			if err != nil {
+				return nil, err
+			}
+			t.args = append(t.args, arg)
+		}
+	}
+	name := p.name()
+	switch {
+	case name == "":
+		return nil, errors.New(fmt.Sprintf("unexpected %q", p.text[:1]))
+	case name == "_" && !p.template:
+		return &rwTerm{kind: rwAny}, nil
+	case strings.HasSuffix(name, "...") && len(name) > 3:
+		return &rwTerm{kind: rwRest, text: strings.TrimSuffix(name, "...")}, nil
+	}
+	return &rwTerm{kind: rwVar, text: name}, nil
+}
+
+// bindings maps the variables of a pattern to the nodes they matched.
+type bindings map[string][]*ParseTree
+
+// match reports whether node matches t, adding the variables it binds.
+func (t *rwTerm) match(node *ParseTree, b bindings) bool {
+	switch t.kind {
+	case rwAny:
+		return true
+	case rwText:
+		return len(node.Children) == 0 && string(node.Data) == t.text
+	case rwVar:
+		if prev, ok := b[t.text]; ok {
+			return len(prev) == 1 && prev[0].Equal(node, IgnorePositions(), IgnoreTrivia())
+		}
+		b[t.text] = []*ParseTree{node}
+		return true
+	}
+	if node.Type != t.text {
+		return false
+	}
+	children := node.Children
+	if len(children) == 0 && node.Data != nil && len(t.args) == 1 {
+		// A leaf is its own child.
+		children = []*ParseTree{node}
+	}
+	for i, arg := range t.args {
+		if arg.kind == rwRest {
+			if i > len(children) {
+				return false
+			}
+			b[arg.text] = children[i:]
+			return true
+		}
+		if i >= len(children) || !arg.match(children[i], b) {
+			return false
+		}
+	}
+	return len(children) == len(t.args)
+}
+
+// build instantiates the template t for the node at, which matched with
+// the bindings b. Literals become leaves of the type typ of the enclosing
+// list, like the literals of a grammar rule.
+func (r *Rewriter) build(t *rwTerm, typ string, at *ParseTree, b bindings) ([]*ParseTree, error) {
+	switch t.kind {
+	case rwVar, rwRest:
+		return b[t.text], nil
+	case rwText:
+		return []*ParseTree{{Type: typ, Data: []byte(t.text), Pos: at.Pos, End: at.End}}, nil
+	case rwCall:
+		var args []*ParseTree
+		for _, arg := range t.args {
+			nodes, err := r.build(arg, typ, at, b)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, nodes...)
+		}
+		node, err := r.funcs[t.text](args)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("@%s: %s", t.text, err))
+		}
+		return []*ParseTree{node}, nil
+	}
+	var children []*ParseTree
+	for _, arg := range t.args {
+		nodes, err := r.build(arg, t.text, at, b)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, nodes...)
+	}
+	node := &ParseTree{Type: t.text, Pos: at.Pos, End: at.End}
+	if len(t.args) == 1 && (t.args[0].kind == rwText || t.args[0].kind == rwCall) &&
+		len(children[0].Children) == 0 && children[0].Data != nil {
+		// A leaf of the type holding the text of the only child.
+		node.Data, node.Value = children[0].Data, children[0].Value
+	} else {
+		node.Children = children
+	}
+	return []*ParseTree{node}, nil
+}
+
+// Rewrite returns tree with the rules applied until none matches. The
+// children of a node are rewritten before the node, and the first rule
+// matching a node replaces it, after which its replacement is rewritten in
+// turn. Nodes that no rule changes are shared with tree, which is not
+// modified.
+func (r *Rewriter) Rewrite(tree *ParseTree) (*ParseTree, error) {
+	steps := 0
+	return r.rewrite(tree, &steps)
+}
+
+func (r *Rewriter) rewrite(node *ParseTree, steps *int) (*ParseTree, error) {
+	for {
+		var err error
+		if node, err = r.rewriteChildren(node, steps); err != nil {
+			return nil, err
+		}
+		built, err := r.apply(node, steps)
+		if err != nil || built == nil {
+			return node, err
+		}
+		node = built
+	}
+}
+
+// rewriteChildren returns node with its children rewritten.
+func (r *Rewriter) rewriteChildren(node *ParseTree, steps *int) (*ParseTree, error) {
+	var children []*ParseTree
+	for i, child := range node.Children {
+		rewritten, err := r.rewrite(child, steps)
+		if err != nil {
+			return nil, err
+		}
+		if rewritten != child && children == nil {
+			children = make([]*ParseTree, i, len(node.Children))
+			copy(children, node.Children)
+		}
+		if children != nil {
+			children = append(children, rewritten)
+		}
+	}
+	if children == nil {
+		return node, nil
+	}
+	copied := *node
+	copied.Children = children
+	return &copied, nil
+}
+
+// apply returns the replacement of node built by the first rule matching
+// it, or nil if none does.
+func (r *Rewriter) apply(node *ParseTree, steps *int) (*ParseTree, error) {
+	for _, rule := range r.rules {
+		b := make(bindings)
+		if !rule.pattern.match(node, b) {
+			continue
+		}
+		if *steps++; *steps > maxRewrites {
+			return nil, errors.New(fmt.Sprintf("line %d: rewriting does not terminate", rule.line))
+		}
+		built, err := r.build(rule.template, node.Type, node, b)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: %s", rule.line, err))
+		}
+		if len(built) != 1 || built[0] == nil {
+			return nil, errors.New(fmt.Sprintf("line %d: template built %d nodes", rule.line, len(built)))
+		}
+		return built[0], nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,130 @@
+package peg
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func leaf(typ, text string) *ParseTree {
+	return &ParseTree{Type: typ, Data: []byte(text)}
+}
+
+func node(typ string, children ...*ParseTree) *ParseTree {
+	return &ParseTree{Type: typ, Children: children}
+}
+
+func TestRewrite(t *testing.T) {
+	funcs := map[string]RewriteFunc{
+		"sum": func(args []*ParseTree) (*ParseTree, error) {
+			total := 0
+			for _, arg := range args {
+				n, err := strconv.Atoi(string(arg.Data))
+				if err != nil {
+					return nil, err
+				}
+				total += n
+			}
+			return leaf("", strconv.Itoa(total)), nil
+		},
+	}
+	const rules = `
+# Constant folding.
+(add (num a) (num b)) -> (num @sum(a, b))
+(paren x) -> x
+(neg (neg x)) -> x
+(list first rest...) -> (cons first (list rest...))
+(list) -> (nil)
+(eq x x) -> (bool 'true')
+(not '!' x) -> (neg x)`
+	r, err := NewRewriter(rules, funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		in, want *ParseTree
+	}{
+		{
+			node("add", leaf("num", "1"), leaf("num", "2")),
+			leaf("num", "3"),
+		},
+		{
+			node("add", node("paren", node("add", leaf("num", "1"), leaf("num", "2"))), leaf("num", "4")),
+			leaf("num", "7"),
+		},
+		{
+			node("add", leaf("var", "x"), leaf("num", "2")),
+			node("add", leaf("var", "x"), leaf("num", "2")),
+		},
+		{
+			node("neg", node("neg", node("neg", leaf("var", "x")))),
+			node("neg", leaf("var", "x")),
+		},
+		{
+			node("list", leaf("num", "1"), leaf("num", "2")),
+			node("cons", leaf("num", "1"), node("cons", leaf("num", "2"), node("nil"))),
+		},
+		{
+			node("eq", leaf("var", "x"), leaf("var", "x")),
+			leaf("bool", "true"),
+		},
+		{
+			node("eq", leaf("var", "x"), leaf("var", "y")),
+			node("eq", leaf("var", "x"), leaf("var", "y")),
+		},
+		{
+			node("not", leaf("not", "!"), node("not", leaf("not", "!"), leaf("var", "x"))),
+			leaf("var", "x"),
+		},
+	} {
+		got, err := r.Rewrite(tt.in)
+		if err != nil {
+			t.Errorf("%s: %s", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want, IgnorePositions()) {
+			t.Errorf("rewrote %s to %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	// Rewriting leaves the input alone.
+	in := node("add", node("paren", leaf("num", "1")), leaf("num", "2"))
+	if _, err := r.Rewrite(in); err != nil || in.Children[0].Type != "paren" {
+		t.Errorf("input modified: %s, %v", in, err)
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+	fail := map[string]RewriteFunc{
+		"fail": func(args []*ParseTree) (*ParseTree, error) { return nil, errors.New("failed") },
+	}
+	for _, rules := range []string{
+		"(a x)",
+		"x -> x",
+		"(a x) -> y",
+		"(a x) -> @missing(x)",
+		"(a rest... x) -> x",
+		"(a x) -> 'text'",
+		"(a 'x) -> x",
+		"(a x) -> (b x",
+	} {
+		if _, err := NewRewriter(rules, fail); err == nil {
+			t.Errorf("expected an error for %q", rules)
+		}
+	}
+
+	r, err := NewRewriter("(a x) -> (b x)\n(b x) -> (a x)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Rewrite(node("a", leaf("c", "1"))); err == nil {
+		t.Errorf("expected an error for rules that don't terminate")
+	}
+	r, err = NewRewriter("(a x) -> (b @fail(x))", fail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Rewrite(node("a", node("c"))); err == nil || err.Error() != "line 1: @fail: failed" {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -2,13 +2,102 @@ package peg
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
+	"os"
 	"regexp"
+	"sort"
+	"unicode/utf8"
 )
 
 type Source struct {
-	buf []byte
+	buf     []byte
+	release func() error // unmaps file backed buffers.
+	lines   []int        // offsets of line starts, built on demand.
+	lang    *Language    // language currently parsing this source.
+	state   interface{}  // user state for predicates and matchers.
+	memo    map[memoKey]memoEntry
+	// skipping is set while the %whitespace rule is matched, so that its
+	// own literals don't skip whitespace.
+	skipping bool
+	tokenize bool    // whether leaves are recorded as tokens instead.
+	tokens   []Token // the first ntokens are valid.
+	// completions collects the terminals expected at the end of the
+	// input while completing.
+	completions map[Completion]bool
+	farthest    int                  // the furthest offset at which a terminal failed.
+	recoverAt   map[int]bool         // offsets where Tolerant parses recover.
+	resyncing   bool                 // set while looking for a place to recover.
+	missing     map[missingPart]bool // parts Tolerant parses treat as missing.
+	trace       *Trace               // records the rules tried, if set.
+	listener    Listener             // is told about the rules tried, if set.
+	stats       map[string]*RuleStats
+	visited     map[memoKey]bool // where profiled rules were matched.
+	budget      *budget          // what the parse has spent, if it is limited.
+	ctx         context.Context  // stops the parse once done, if set.
+	warnings    []Warning        // the first nwarnings are valid.
+	// stream is set while a StreamParser parses the input written so far.
+	// starved is then set by terminals that looked past its end, and need
+	// is the least number of bytes they asked for. progress keeps how far
+	// closures got for the next parse of the same document.
+	stream   bool
+	starved  bool
+	need     int
+	progress map[memoKey]progress
+	// normalized is set once the buffer is in the normal form of a
+	// language parsed WithNormalization.
+	normalized bool
+	// decoded is set once the buffer is transcoded to UTF-8 for a language
+	// parsed with DecodeInput.
+	decoded bool
+	parseState
+}
+
+// parseState is the part of a Source that is modified while parsing and
+// restored when the parser backtracks.
+type parseState struct {
+	indent    *indentLevel
+	captures  *capture
+	symbols   *symbol
+	ntokens   int // number of tokens recorded so far.
+	nwarnings int // number of warnings recorded so far.
+}
+
+// mark records the parse state before attempting a match that may fail.
+func (s *Source) mark() parseState {
+	return s.parseState
+}
+
+// reset restores the parse state recorded by mark.
+func (s *Source) reset(m parseState) {
+	s.parseState = m
+}
+
+// Mark is a backtrack point of a Source.
+type Mark struct {
+	state parseState
+}
+
+// Mark records a backtrack point before an attempt to match that may fail,
+// for matchers and lexemes that try alternatives of their own. It holds
+// what a parse builds up besides the position: the indentation blocks
+// entered, the text captured for backreferences, and the tokens and
+// warnings recorded so far. Positions are passed to lexers rather than kept
+// by the Source, so they need no restoring.
+//
+// Marks are also what memoization keys results by, so a lexeme that leaves
+// the source as it found its mark can be memoized.
+func (s *Source) Mark() Mark {
+	return Mark{s.mark()}
+}
+
+// Reset returns the source to the backtrack point m after a failed attempt,
+// so that the attempt leaves no trace in the tree, the tokens or the
+// warnings. What a stream parser learned about missing input is kept, as a
+// failed attempt looked at the input all the same.
+func (s *Source) Reset(m Mark) {
+	s.reset(m.state)
 }
 
 func NewSource(in io.Reader) (*Source, error) {
@@ -16,9 +105,51 @@ func NewSource(in io.Reader) (*Source, error) {
 	if err != nil {
 		return nil, err
 	}
+	return SourceFromBytes(buf), nil
+}
+
+// SourceFromBytes wraps buf without copying it. Leaves of the resulting
+// parse tree share memory with buf.
+func SourceFromBytes(buf []byte) *Source {
 	return &Source{
 		buf: buf,
-	}, nil
+	}
+}
+
+// SetState attaches a user supplied value to the source. Predicates and
+// external matchers can retrieve it with State while the source is parsed.
+func (s *Source) SetState(state interface{}) {
+	s.state = state
+}
+
+// State returns the value attached with SetState.
+func (s *Source) State() interface{} {
+	return s.state
+}
+
+// Bytes returns the complete input. The slice must not be modified.
+func (s *Source) Bytes() []byte {
+	return s.buf
+}
+
+func readFileSource(f *os.File) (*Source, error) {
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return SourceFromBytes(buf), nil
+}
+
+// Close releases any resources held by the source. Trees parsed from a
+// file backed source must not be used after Close.
+func (s *Source) Close() error {
+	if s.release == nil {
+		return nil
+	}
+	release := s.release
+	s.release = nil
+	s.buf = nil
+	return release()
 }
 
 // Consume tries to consume text matching the specified regex
@@ -40,11 +171,97 @@ func (s *Source) Consume(regex *regexp.Regexp, pos int) []byte {
 // Consume literal attempts to consume a literal string.
 // Returns the consumed text, or nil if there was no match.
 func (s *Source) ConsumeLiteral(valid []byte, pos int) []byte {
-	if pos == len(s.buf) {
+	// The length and the first byte rule out most mismatches before the
+	// bytes are compared.
+	if pos == len(s.buf) || len(s.buf)-pos < len(valid) {
 		return nil
 	}
-	if bytes.HasPrefix(s.buf[pos:], valid) {
+	if len(valid) > 0 && s.buf[pos] != valid[0] {
+		return nil
+	}
+	if bytes.Equal(s.buf[pos:pos+len(valid)], valid) {
 		return valid
 	}
 	return nil
 }
+
+// ConsumeLiteralFold is like ConsumeLiteral, but compares runes under
+// Unicode case folding. It returns the consumed input.
+func (s *Source) ConsumeLiteralFold(valid []byte, pos int) []byte {
+	if pos == len(s.buf) {
+		return nil
+	}
+	// Input in the case of the literal needs no folding.
+	if bytes.HasPrefix(s.buf[pos:], valid) {
+		return s.buf[pos : pos+len(valid)]
+	}
+	i := pos
+	for len(valid) > 0 {
+		if i == len(s.buf) {
+			return nil
+		}
+		// ASCII bytes only fold into each other by case.
+		if b, v := s.buf[i], valid[0]; b < utf8.RuneSelf && v < utf8.RuneSelf {
+			if lowerASCII(b) != lowerASCII(v) {
+				return nil
+			}
+			valid = valid[1:]
+			i++
+			continue
+		}
+		vr, vn := utf8.DecodeRune(valid)
+		r, n := utf8.DecodeRune(s.buf[i:])
+		if !bytes.EqualFold(valid[:vn], s.buf[i:i+n]) && vr != r {
+			return nil
+		}
+		valid = valid[vn:]
+		i += n
+	}
+	return s.buf[pos:i]
+}
+
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+func (s *Source) lineStarts() []int {
+	if s.lines == nil {
+		s.lines = []int{0}
+		for i, b := range s.buf {
+			if b == '\n' {
+				s.lines = append(s.lines, i+1)
+			}
+		}
+	}
+	return s.lines
+}
+
+// Position converts a byte offset into a 1-based line and column. Columns
+// count bytes, not runes. Offsets past the end of the input are clamped.
+func (s *Source) Position(offset int) (line, col int) {
+	if offset < 0 {
+		offset = 0
+	} else if offset > len(s.buf) {
+		offset = len(s.buf)
+	}
+	lines := s.lineStarts()
+	line = sort.Search(len(lines), func(i int) bool { return lines[i] > offset })
+	return line, offset - lines[line-1] + 1
+}
+
+// Line returns the contents of the 1-based line n without its line
+// terminator, or nil if there is no such line.
+func (s *Source) Line(n int) []byte {
+	lines := s.lineStarts()
+	if n < 1 || n > len(lines) {
+		return nil
+	}
+	start, end := lines[n-1], len(s.buf)
+	if n < len(lines) {
+		end = lines[n] - 1
+	}
+	return bytes.TrimSuffix(s.buf[start:end], []byte("\r"))
+}
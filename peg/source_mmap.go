@@ -0,0 +1,40 @@
+//go:build unix
+
+package peg
+
+import (
+	"os"
+	"syscall"
+)
+
+// NewFileSource maps the file at path into memory and returns a Source
+// backed by the mapping. Leaves of the parse tree point directly into the
+// mapped pages, so the Source must not be closed while the tree is in use.
+//
+// Files that cannot be mapped are read into memory instead.
+func NewFileSource(path string) (*Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 || !info.Mode().IsRegular() || int64(int(size)) != size {
+		return readFileSource(f)
+	}
+
+	buf, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return readFileSource(f)
+	}
+	s := SourceFromBytes(buf)
+	s.release = func() error {
+		return syscall.Munmap(buf)
+	}
+	return s, nil
+}
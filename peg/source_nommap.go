@@ -0,0 +1,18 @@
+//go:build !unix
+
+package peg
+
+import (
+	"os"
+)
+
+// NewFileSource reads the file at path into memory. On platforms with
+// mmap support the file is mapped instead of read.
+func NewFileSource(path string) (*Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readFileSource(f)
+}
@@ -1,6 +1,9 @@
 package peg
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
 	"regexp"
 	"strings"
 	"testing"
@@ -48,3 +51,174 @@ func TestSourceConsume(t *testing.T) {
 		}
 	}
 }
+
+func TestFileSource(t *testing.T) {
+	f, err := ioutil.TempFile("", "peg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("123.43")
+	f.Close()
+
+	s, err := NewFileSource(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	match := s.Consume(regexp.MustCompile("\\d+"), 0)
+	if string(match) != "123" {
+		t.Errorf("File source failed to consume input: %q", match)
+	}
+}
+
+type PositionTest struct {
+	offset int
+	line   int
+	col    int
+}
+
+var sourcePositionTests = []PositionTest{
+	PositionTest{0, 1, 1},
+	PositionTest{3, 1, 4},
+	PositionTest{4, 2, 1},
+	PositionTest{5, 3, 1},
+	PositionTest{7, 3, 3},
+	PositionTest{100, 3, 4},
+}
+
+func TestSourcePosition(t *testing.T) {
+	s := SourceFromBytes([]byte("one\n\nabc"))
+	for _, pt := range sourcePositionTests {
+		line, col := s.Position(pt.offset)
+		if line != pt.line || col != pt.col {
+			t.Errorf("incorrect position for %d: %d:%d exp: %d:%d", pt.offset, line, col, pt.line, pt.col)
+		}
+	}
+
+	lines := []string{"", "one", "", "abc", ""}
+	for n, exp := range lines {
+		if line := s.Line(n); string(line) != exp || (line == nil) != (n == 0 || n == 4) {
+			t.Errorf("incorrect line %d: %q exp: %q", n, line, exp)
+		}
+	}
+}
+
+func TestMark(t *testing.T) {
+	lang, err := NewLanguage("prgm <- @try 'b'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// try warns about an a, and takes the warning back if there is none.
+	lang.Register("try", func(s *Source, pos int) (*ParseTree, error, int) {
+		m := s.Mark()
+		s.Warn(pos, "a")
+		if !bytes.HasPrefix(s.Bytes()[pos:], []byte("a")) {
+			s.Reset(m)
+			return nil, nil, 0
+		}
+		return nil, nil, 1
+	})
+	for _, tt := range []struct {
+		input    string
+		warnings int
+	}{
+		{"ab", 1},
+		{"b", 0},
+	} {
+		s := SourceFromBytes([]byte(tt.input))
+		if _, err := lang.ParseSource(s); err != nil {
+			t.Errorf("%q: %s", tt.input, err)
+		}
+		if got := len(s.Warnings()); got != tt.warnings {
+			t.Errorf("%q: got %d warnings, expected %d", tt.input, got, tt.warnings)
+		}
+	}
+}
+
+func TestConsumeLiteral(t *testing.T) {
+	for _, tt := range []struct {
+		input, literal string
+		pos            int
+		fold           bool
+		exp            string
+		ok             bool
+	}{
+		{"GET /", "GET", 0, false, "GET", true},
+		{"GET /", "GET", 1, false, "", false},
+		{"GE", "GET", 0, false, "", false},
+		{"PUT /", "GET", 0, false, "", false},
+		{"get /", "GET", 0, true, "get", true},
+		{"gEt", "GET", 0, true, "gEt", true},
+		{"\u212a", "k", 0, true, "\u212a", true},
+		{"x", "k", 0, true, "", false},
+		{"ge", "GET", 0, true, "", false},
+		{"a", "a", 1, false, "", false},
+	} {
+		s := SourceFromBytes([]byte(tt.input))
+		var match []byte
+		if tt.fold {
+			match = s.ConsumeLiteralFold([]byte(tt.literal), tt.pos)
+		} else {
+			match = s.ConsumeLiteral([]byte(tt.literal), tt.pos)
+		}
+		if (match != nil) != tt.ok || string(match) != tt.exp {
+			t.Errorf("%q at %d of %q (fold %v): got %q, exp %q", tt.literal, tt.pos, tt.input, tt.fold, match, tt.exp)
+		}
+	}
+}
+
+// logLines is a log for benchmarks, whose lines start with one of several
+// literals.
+var logLines = bytes.Repeat([]byte("INFO request served\nWARN slow request\nERROR request failed\nDEBUG cache hit\n"), 1000)
+
+func BenchmarkConsumeLiteral(b *testing.B) {
+	s := SourceFromBytes(logLines)
+	literals := [][]byte{[]byte("TRACE"), []byte("DEBUG"), []byte("INFO"), []byte("WARN"), []byte("ERROR")}
+	b.SetBytes(int64(len(logLines)))
+	for i := 0; i < b.N; i++ {
+		for pos := 0; pos < len(logLines); pos += 16 {
+			for _, lit := range literals {
+				s.ConsumeLiteral(lit, pos)
+			}
+		}
+	}
+}
+
+func BenchmarkConsumeLiteralFold(b *testing.B) {
+	s := SourceFromBytes(logLines)
+	literals := [][]byte{[]byte("trace"), []byte("debug"), []byte("info"), []byte("warn"), []byte("error")}
+	b.SetBytes(int64(len(logLines)))
+	for i := 0; i < b.N; i++ {
+		for pos := 0; pos < len(logLines); pos += 16 {
+			for _, lit := range literals {
+				s.ConsumeLiteralFold(lit, pos)
+			}
+		}
+	}
+}
+
+func BenchmarkLiterals(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		opts []Option
+	}{
+		{"exact", nil},
+		{"fold", []Option{CaseInsensitive()}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			lang, err := NewLanguage("log <- line*\nline <- level ' ' ..'\\n' '\\n'\nlevel <- trace / debug / info / warn / error\ntrace <- 'TRACE'\ndebug <- 'DEBUG'\ninfo <- 'INFO'\nwarn <- 'WARN'\nerror <- 'ERROR'", bb.opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(logLines)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := lang.ParseBytes(logLines); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
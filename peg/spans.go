@@ -0,0 +1,130 @@
+package peg
+
+import "context"
+
+// Tracer starts the spans of traced parses. It is the part of a tracing
+// library, such as an OpenTelemetry trace.Tracer, that parses use, so that
+// an adapter of a few lines connects them:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, peg.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	// Start starts the span name as a child of the span of ctx, and
+	// returns a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute of the span. Values are ints,
+	// bools or strings.
+	SetAttribute(key string, value interface{})
+	// RecordError records that the operation of the span failed with err.
+	RecordError(err error)
+	End()
+}
+
+// WithTracer makes parses start a span named peg.parse with t, as a child
+// of the span in the context of the source, if it has one. The span has
+// the attributes peg.rule, the rule parsed, peg.input.bytes, the size of
+// the input, and peg.rule_calls, how often rules were called; a failed
+// parse records its error and where it is, as peg.error.pos,
+// peg.error.line and peg.error.col.
+//
+// If depth is positive, rules nested up to depth deep get spans of their
+// own, named after the rule, as children of the span of the rule that
+// called them: 1 traces the rule parsed, 2 the rules it calls too, and so
+// on. Their attributes are peg.pos, where the rule was tried, and
+// peg.matched with, if it matched, peg.end. Like the rules written by
+// TraceWriter, they are only traced for sources without a Listener of
+// their own.
+func WithTracer(t Tracer, depth int) Option {
+	return func(l *Language) {
+		l.tracer, l.spanDepth = t, depth
+	}
+}
+
+// startParseSpan starts the span of a parse of root in s, returning the
+// context of the span and a function that ends it with the outcome of the
+// parse.
+func (l *Language) startParseSpan(root *Lexeme, s *Source) (context.Context, func(err error)) {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := l.tracer.Start(ctx, "peg.parse")
+	span.SetAttribute("peg.rule", ruleName(root))
+	span.SetAttribute("peg.input.bytes", len(s.buf))
+	return ctx, func(err error) {
+		if s.budget != nil {
+			span.SetAttribute("peg.rule_calls", s.budget.calls)
+		}
+		if err != nil {
+			span.RecordError(err)
+			if d := Detail(err, s.buf); d.Pos >= 0 {
+				span.SetAttribute("peg.error.pos", d.Pos)
+				span.SetAttribute("peg.error.line", d.Line)
+				span.SetAttribute("peg.error.col", d.Col)
+			}
+		}
+		span.End()
+	}
+}
+
+// spanListener starts a span for each rule tried up to depth deep. ctxs
+// holds the contexts of the spans of the rules being matched, the first
+// being that of the parse.
+type spanListener struct {
+	tracer Tracer
+	depth  int
+	nested int // the rules being matched.
+	ctxs   []context.Context
+	spans  []Span
+}
+
+func (l *spanListener) EnterRule(name string, pos int) {
+	l.nested++
+	if l.nested > l.depth {
+		return
+	}
+	ctx, span := l.tracer.Start(l.ctxs[len(l.ctxs)-1], name)
+	span.SetAttribute("peg.pos", pos)
+	l.ctxs = append(l.ctxs, ctx)
+	l.spans = append(l.spans, span)
+}
+
+func (l *spanListener) ExitRule(name string, pos int, ok bool) {
+	l.nested--
+	if l.nested >= l.depth {
+		return
+	}
+	span := l.spans[len(l.spans)-1]
+	span.SetAttribute("peg.matched", ok)
+	if ok {
+		span.SetAttribute("peg.end", pos)
+	}
+	span.End()
+	l.ctxs = l.ctxs[:len(l.ctxs)-1]
+	l.spans = l.spans[:len(l.spans)-1]
+}
+
+// abort ends the spans of the rules a parse stopped in, such as one over
+// its budget.
+func (l *spanListener) abort() {
+	for i := len(l.spans) - 1; i >= 0; i-- {
+		l.spans[i].End()
+	}
+	l.spans = nil
+}
+
+// ruleName returns the name of the rule root, which a parse starts with.
+func ruleName(root *Lexeme) string {
+	if root.kind == kindDefinition {
+		return root.text
+	}
+	return root.Name
+}
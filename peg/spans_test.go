@@ -0,0 +1,107 @@
+package peg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordingTracer records the spans it starts as lines of text, children
+// indented under their parents.
+type recordingTracer struct {
+	lines []string
+}
+
+type spanKey struct{}
+
+type recordingSpan struct {
+	t     *recordingTracer
+	line  int
+	depth int
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	depth := 0
+	if parent, ok := ctx.Value(spanKey{}).(*recordingSpan); ok {
+		depth = parent.depth + 1
+	}
+	span := &recordingSpan{t, len(t.lines), depth}
+	t.lines = append(t.lines, strings.Repeat("  ", depth)+name)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.t.lines[s.line] += fmt.Sprintf(" %s=%v", key, value)
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.t.lines[s.line] += " error"
+}
+
+func (s *recordingSpan) End() {
+	s.t.lines[s.line] += " end"
+}
+
+func TestWithTracer(t *testing.T) {
+	grammar := "list <- item+\nitem <- word ' '^\nword <- ~'[a-z]+'"
+	tests := []struct {
+		name  string
+		depth int
+		input string
+		exp   []string
+	}{
+		{"parse", 0, "a b", []string{
+			"peg.parse peg.rule=list peg.input.bytes=3 peg.rule_calls=7 end",
+		}},
+		{"rules", 2, "a b", []string{
+			"peg.parse peg.rule=list peg.input.bytes=3 peg.rule_calls=7 end",
+			"  list peg.pos=0 peg.matched=true peg.end=3 end",
+			"    item peg.pos=0 peg.matched=true peg.end=2 end",
+			"    item peg.pos=2 peg.matched=true peg.end=3 end",
+			"    item peg.pos=3 peg.matched=false end",
+		}},
+		{"error", 1, "1", []string{
+			"peg.parse peg.rule=list peg.input.bytes=1 peg.rule_calls=3 error peg.error.pos=0 peg.error.line=1 peg.error.col=1 end",
+			"  list peg.pos=0 peg.matched=false end",
+		}},
+		// The spans of the rules the parse stopped in end with it.
+		{"budget", 2, "a", []string{
+			"peg.parse peg.rule=list peg.input.bytes=1 peg.rule_calls=3 error peg.error.pos=0 peg.error.line=1 peg.error.col=1 end",
+			"  list peg.pos=0 end",
+			"    item peg.pos=0 end",
+		}},
+	}
+	for _, tt := range tests {
+		tracer := &recordingTracer{}
+		opts := []Option{WithTracer(tracer, tt.depth)}
+		if tt.name == "budget" {
+			opts = append(opts, MaxDepth(2))
+		}
+		lang, err := NewLanguage(grammar, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lang.ParseString(tt.input)
+		if !reflect.DeepEqual(tracer.lines, tt.exp) {
+			t.Errorf("%s: got spans\n%s\nexp\n%s", tt.name, strings.Join(tracer.lines, "\n"), strings.Join(tt.exp, "\n"))
+		}
+	}
+
+	// Spans are children of the span in the context of the source.
+	tracer := &recordingTracer{}
+	lang, err := NewLanguage(grammar, WithTracer(tracer, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := tracer.Start(context.Background(), "request")
+	s := SourceFromBytes([]byte("a"))
+	s.SetContext(ctx)
+	lang.ParseSource(s)
+	span.End()
+	exp := []string{"request end", "  peg.parse peg.rule=list peg.input.bytes=1 peg.rule_calls=5 end"}
+	if !reflect.DeepEqual(tracer.lines, exp) {
+		t.Errorf("got spans %q, exp %q", tracer.lines, exp)
+	}
+}
@@ -0,0 +1,199 @@
+package peg
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// RuleStats are the counters of a rule collected by profiled parses.
+type RuleStats struct {
+	Rule     string
+	Calls    int
+	Failures int
+	// Time is spent in the rule, including the rules it calls. Time spent
+	// in recursive calls is counted once for every level.
+	Time time.Duration
+	// MaxBacktrack is the largest number of input bytes a failed attempt
+	// looked at before the parser had to backtrack over them.
+	MaxBacktrack int
+	// Repeats counts the calls at positions where the rule was called
+	// before in the same parse, which a memoized rule answers from its
+	// cache.
+	Repeats int
+}
+
+// Profile makes parses collect RuleStats, which accumulate until
+// ResetStats is called.
+func Profile(profile bool) Option {
+	return func(l *Language) {
+		l.profile = profile
+	}
+}
+
+// Stats returns the statistics of the profiled parses so far, with the
+// rules that took the most time first.
+func (l *Language) Stats() []RuleStats {
+	l.statsMu.Lock()
+	defer l.statsMu.Unlock()
+	stats := make([]RuleStats, 0, len(l.stats))
+	for _, st := range l.stats {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Time != stats[j].Time {
+			return stats[i].Time > stats[j].Time
+		}
+		return stats[i].Rule < stats[j].Rule
+	})
+	return stats
+}
+
+// memoCost estimates the time a memoized rule spends on its cache for each
+// call.
+const memoCost = 200 * time.Nanosecond
+
+// SuggestMemo returns the rules that the statistics of the profiled parses
+// so far say are worth memoizing, the ones memoizing saves the most time
+// first. A rule is worth it if its Repeats, at the average time of its
+// calls, take longer than the cache costs for all of its calls. Rules with
+// few repeated calls are left out, so that memoizing them doesn't slow
+// parses down as memoizing every rule would. The names can be listed by
+// %memo or given to the Memo option.
+func (l *Language) SuggestMemo() []string {
+	type saving struct {
+		rule  string
+		saved time.Duration
+	}
+	var savings []saving
+	for _, st := range l.Stats() {
+		if st.Repeats == 0 || !l.hasRule(st.Rule) {
+			continue
+		}
+		saved := st.Time/time.Duration(st.Calls)*time.Duration(st.Repeats) - memoCost*time.Duration(st.Calls)
+		if saved > 0 {
+			savings = append(savings, saving{st.Rule, saved})
+		}
+	}
+	sort.SliceStable(savings, func(i, j int) bool {
+		return savings[i].saved > savings[j].saved
+	})
+	rules := make([]string, len(savings))
+	for i, s := range savings {
+		rules[i] = s.rule
+	}
+	return rules
+}
+
+// TuneMemo parses the files of corpus, typical inputs of the language, with
+// a profiled copy of l, and returns a copy of l that also memoizes the
+// rules SuggestMemo names, along with those rules. Whether the inputs parse
+// doesn't matter. l is left unchanged and must have been compiled from a
+// grammar.
+func (l *Language) TuneMemo(corpus fs.FS) (*Language, []string, error) {
+	if l.rules == nil {
+		return nil, nil, errors.New("language was not compiled from a grammar")
+	}
+	opts := append(append([]Option(nil), l.opts...), Profile(true))
+	profiled, err := l.recompile(l.added, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = walkCorpus(corpus, func(path string, input []byte) {
+		profiled.ParseBytes(input)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	rules := profiled.SuggestMemo()
+	tuned, err := l.recompile(l.added, append(append([]Option(nil), l.opts...), Memo(rules...)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return tuned, rules, nil
+}
+
+// ResetStats discards the statistics collected so far.
+func (l *Language) ResetStats() {
+	l.statsMu.Lock()
+	l.stats = nil
+	l.statsMu.Unlock()
+}
+
+func (l *Language) addStats(stats map[string]*RuleStats) {
+	l.statsMu.Lock()
+	defer l.statsMu.Unlock()
+	if l.stats == nil {
+		l.stats = make(map[string]*RuleStats)
+	}
+	for name, st := range stats {
+		total, ok := l.stats[name]
+		if !ok {
+			total = &RuleStats{Rule: name}
+			l.stats[name] = total
+		}
+		total.Calls += st.Calls
+		total.Failures += st.Failures
+		total.Time += st.Time
+		total.Repeats += st.Repeats
+		if st.MaxBacktrack > total.MaxBacktrack {
+			total.MaxBacktrack = st.MaxBacktrack
+		}
+	}
+}
+
+// measureRule runs the rule name, updating its statistics and the budget
+// of the parse.
+func (s *Source) measureRule(name string, lex *Lexeme, pos int) (*ParseTree, error, int) {
+	var st *RuleStats
+	if s.stats != nil {
+		var ok bool
+		if st, ok = s.stats[name]; !ok {
+			st = &RuleStats{Rule: name}
+			s.stats[name] = st
+		}
+		// As NewMemoLexer keys its cache.
+		key := s.memoKey(lex, pos)
+		if s.visited[key] {
+			st.Repeats++
+		}
+		s.visited[key] = true
+	}
+	if s.budget != nil {
+		s.spendCall(name, pos)
+		defer s.leaveRule()
+	}
+	// Track how far this attempt got, keeping the overall furthest failure.
+	outer := s.farthest
+	s.farthest = -1
+	start := time.Now()
+
+	var tree *ParseTree
+	var err error
+	var n int
+	if s.trace != nil || s.listener != nil {
+		tree, err, n = s.traceRule(name, lex, pos)
+	} else {
+		tree, err, n = lex.Lexer(s, pos)
+	}
+
+	depth := s.farthest - pos
+	if st != nil {
+		st.Time += time.Since(start)
+		st.Calls++
+		if err != nil {
+			st.Failures++
+			if depth > st.MaxBacktrack {
+				st.MaxBacktrack = depth
+			}
+		}
+	}
+	if outer > s.farthest {
+		s.farthest = outer
+	}
+	if err != nil && s.budget != nil {
+		s.spendBacktrack(name, pos, depth)
+	}
+	return tree, err, n
+}
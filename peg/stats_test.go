@@ -0,0 +1,86 @@
+package peg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStats(t *testing.T) {
+	grammar := "prgm <- long / short\nlong <- 'a' 'b' 'c' 'd'\nshort <- 'a'"
+	lang, err := NewParser(strings.NewReader(grammar), Profile(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := lang.ParseString("abx"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := make(map[string]RuleStats)
+	for _, st := range lang.Stats() {
+		stats[st.Rule] = st
+	}
+	if st := stats["prgm"]; st.Calls != 2 || st.Failures != 0 {
+		t.Errorf("prgm: %+v", st)
+	}
+	if st := stats["long"]; st.Calls != 2 || st.Failures != 2 || st.MaxBacktrack != 2 {
+		t.Errorf("long: %+v", st)
+	}
+	if st := stats["short"]; st.Calls != 2 || st.Failures != 0 {
+		t.Errorf("short: %+v", st)
+	}
+	if stats["prgm"].Time < stats["long"].Time {
+		t.Errorf("time of prgm does not include the rules it calls")
+	}
+
+	lang.ResetStats()
+	if len(lang.Stats()) != 0 {
+		t.Errorf("expected no stats after reset")
+	}
+}
+
+func TestRepeats(t *testing.T) {
+	grammar := "prgm <- x / y / num\nx <- num 'x'\ny <- num 'y'\nnum <- ~'[0-9]+'"
+	lang, err := NewLanguage(grammar, Profile(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := lang.ParseString("12y"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, st := range lang.Stats() {
+		if exp := map[string]int{"num": 2}[st.Rule]; st.Repeats != exp {
+			t.Errorf("%s: %d repeats, exp %d", st.Rule, st.Repeats, exp)
+		}
+	}
+}
+
+func TestTuneMemo(t *testing.T) {
+	// Each alternative matches the long number again.
+	grammar := "prgm <- a / b / c\na <- num 'a'\nb <- num 'b'\nc <- num 'c'\nnum <- ~'[0-9]+'"
+	lang, err := NewLanguage(grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus := fstest.MapFS{"c.txt": {Data: []byte(strings.Repeat("1", 100000) + "c")}}
+	tuned, rules, err := lang.TuneMemo(corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rules, []string{"num"}) {
+		t.Errorf("got %q, exp [num]", rules)
+	}
+	if !strings.Contains(tuned.Grammar(), "%memo num\n") {
+		t.Errorf("the tuned grammar doesn't memoize num:\n%s", tuned.Grammar())
+	}
+	if strings.Contains(lang.Grammar(), "%memo") {
+		t.Errorf("TuneMemo changed the language")
+	}
+	if _, err := tuned.ParseBytes(corpus["c.txt"].Data); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,54 @@
+// Package std provides the tokens most languages share, both as patterns
+// to use in grammars and as lexemes to add to a language with AddRule.
+//
+// The patterns match what C and Go accept. Where one pattern matches a
+// prefix of another, as Decimal does for Hex and Float, the grammar must
+// try the longer one first.
+package std
+
+import (
+	"regexp"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+// Patterns of the tokens, in the syntax of the regexp package. None of them
+// contains a backquote, so they can be used as ~`pattern` in a grammar.
+const (
+	// Ident is a C identifier, such as _tmp1.
+	Ident = `[A-Za-z_][A-Za-z0-9_]*`
+	// Decimal is an unsigned decimal integer.
+	Decimal = `[0-9]+`
+	// Hex is a hexadecimal integer with a 0x or 0X prefix.
+	Hex = `0[xX][0-9A-Fa-f]+`
+	// Float is a decimal number with a fraction, an exponent or both, such
+	// as 1.5, .5, 1. or 1e-9.
+	Float = `(?:[0-9]+\.[0-9]*|\.[0-9]+)(?:[eE][+-]?[0-9]+)?|[0-9]+[eE][+-]?[0-9]+`
+	// DoubleQuoted is a string between double quotes on a single line, in
+	// which a backslash escapes the next character.
+	DoubleQuoted = `"(?:[^"\\\n]|\\.)*"`
+	// SingleQuoted is like DoubleQuoted, between single quotes.
+	SingleQuoted = `'(?:[^'\\\n]|\\.)*'`
+	// LineComment is a comment from // to the end of the line, which it
+	// leaves unmatched.
+	LineComment = `//[^\n]*`
+	// BlockComment is a comment from /* to the first */. Block comments do
+	// not nest.
+	BlockComment = `/\*(?s:.*?)\*/`
+	// Date is an ISO 8601 calendar date, such as 2006-01-02. Days are only
+	// checked to be between 01 and 31.
+	Date = `[0-9]{4}-(?:0[1-9]|1[0-2])-(?:0[1-9]|[12][0-9]|3[01])`
+)
+
+// Rule returns the grammar rule name <- ~`pattern`, to be added to the text
+// of a grammar.
+func Rule(name, pattern string) string {
+	return name + " <- ~`" + pattern + "`"
+}
+
+// Lexeme returns a lexeme producing leaves of type typ for the text matched
+// by pattern, which must be one of the patterns of this package or
+// otherwise compile.
+func Lexeme(typ, pattern string) *peg.Lexeme {
+	return peg.NewRegexpLexer(typ, regexp.MustCompile(pattern))
+}
@@ -0,0 +1,67 @@
+package std
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Logiraptor/chicken/peg"
+)
+
+func TestPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		match   []string
+		reject  []string
+	}{
+		{Ident, []string{"a", "_tmp1", "Foo_Bar"}, []string{"1a", "-a", ""}},
+		{Decimal, []string{"0", "123"}, []string{"", "-1", "a"}},
+		{Hex, []string{"0x0", "0XdeadBEEF"}, []string{"0x", "x1", "12"}},
+		{Float, []string{"1.5", ".5", "1.", "1e9", "2.5E-3"}, []string{"1", ".", "e9", "1e"}},
+		{DoubleQuoted, []string{`""`, `"a b"`, `"say \"hi\"\n"`}, []string{`"a`, `'a'`, "\"a\nb\""}},
+		{SingleQuoted, []string{`''`, `'it\'s'`}, []string{`'a`, `"a"`}},
+		{LineComment, []string{"//", "// note"}, []string{"/ x", "# x"}},
+		{BlockComment, []string{"/**/", "/* a\n * b */"}, []string{"/* a", "/ * a */"}},
+		{Date, []string{"2006-01-02", "1999-12-31"}, []string{"2006-13-01", "2006-1-2", "2006-01-32"}},
+	}
+	for _, test := range tests {
+		lang, err := peg.NewParser(strings.NewReader(Rule("tok", test.pattern)))
+		if err != nil {
+			t.Fatalf("%s: %s", test.pattern, err)
+		}
+		for _, in := range test.match {
+			tree, err := lang.ParseString(in)
+			if err != nil || tree.End != len(in) {
+				t.Errorf("%s did not match %q", test.pattern, in)
+			}
+		}
+		for _, in := range test.reject {
+			tree, err := lang.ParseString(in)
+			if err == nil && tree.End == len(in) {
+				t.Errorf("%s matched %q", test.pattern, in)
+			}
+		}
+	}
+}
+
+func TestLexeme(t *testing.T) {
+	lang, err := peg.NewParser(strings.NewReader("prgm <- item+\nitem <- num ' '^\nnum <- hex / float / int\nhex <- 'hex'\nfloat <- 'float'\nint <- 'int'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pattern := range map[string]string{"hex": Hex, "float": Float, "int": Decimal} {
+		if lang, err = lang.ReplaceRule(name, Lexeme(name, pattern)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := lang.ParseString("0x1f 1.5e3 7 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, child := range tree.Children {
+		types = append(types, child.Type+":"+string(child.Data))
+	}
+	if got := strings.Join(types, " "); got != "hex:0x1f float:1.5e3 int:7" {
+		t.Errorf("unexpected tokens %s", got)
+	}
+}
@@ -0,0 +1,322 @@
+package peg
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// StreamParser parses documents pushed to it in chunks, such as messages
+// read off a network connection. Every document is a match of the root
+// rule, as in ParseAll.
+//
+// A document is only returned once more input can no longer change it:
+// whenever a terminal of the grammar looked for input beyond what has been
+// written, parsing waits for more. An external matcher that looks ahead
+// must call Source.Starve to take part in this.
+//
+// What more input can't change is kept until the document is complete: the
+// results of the rules and the repetitions of the closures that looked no
+// further than the input written. A document written a byte at a time is
+// then parsed about once, not once for every byte, as long as no predicate
+// could make a rule match differently at the same place.
+type StreamParser struct {
+	lang   *Language
+	buf    []byte  // the input not returned as documents yet.
+	off    int     // the offset of buf in the stream.
+	src    *Source // the parse of buf, kept between calls to Next.
+	closed bool
+	err    error
+}
+
+// NewStreamParser returns a parser for a stream of documents.
+func (l *Language) NewStreamParser() *StreamParser {
+	return &StreamParser{lang: l}
+}
+
+// Write appends data to the input. The data is copied.
+func (p *StreamParser) Write(data []byte) (int, error) {
+	if p.closed {
+		return 0, errors.New("write to closed stream parser")
+	}
+	p.buf = append(p.buf, data...)
+	return len(data), nil
+}
+
+// Close marks the end of the input, so that Next parses what is left.
+func (p *StreamParser) Close() error {
+	p.closed = true
+	return nil
+}
+
+// Next returns the documents completed by the input written so far, with
+// the positions of their trees as offsets into the stream. need is the
+// least number of bytes that must be written before Next can return the
+// next document, or 0 if no document is partially written. Once the parser
+// fails it keeps returning the same error, and once it is closed a partial
+// document is an error.
+func (p *StreamParser) Next() (trees []*ParseTree, need int, err error) {
+	if p.err != nil {
+		return nil, 0, p.err
+	}
+	if p.src == nil {
+		p.src = SourceFromBytes(nil)
+	}
+	s := p.src
+	s.buf, s.lines, s.parseState = p.buf, nil, parseState{}
+	s.lang, s.stream = p.lang, !p.closed
+	pos := 0
+	defer func() {
+		// Leaves refer to buf, so the rest is moved to a new array, and
+		// what was kept of the parse starts over with it.
+		if pos > 0 {
+			p.buf = append([]byte(nil), p.buf[pos:]...)
+			p.off += pos
+			p.src = nil
+		}
+	}()
+	for {
+		s.starved, s.need = false, 0
+		start := pos
+		if pos += s.skipWhitespace(pos); pos == len(s.buf) {
+			return trees, 0, nil
+		}
+		tree, err, n := p.lang.parseAt(p.lang.root, s, pos, false)
+		if s.starved {
+			pos = start
+			return trees, s.need, nil
+		}
+		if err == nil && n == 0 {
+			err = s.failed(pos, "empty document")
+		}
+		if err != nil {
+			if perr, ok := err.(*ParseError); ok {
+				perr.Pos += p.off
+			}
+			p.err = err
+			return trees, 0, err
+		}
+		shift(tree, p.off)
+		trees = append(trees, tree)
+		pos += n
+		// The next document shares no results with this one, whose
+		// positions just moved.
+		s.memo, s.progress = nil, nil
+	}
+}
+
+// progress is how far a closure got through input that more of the stream
+// won't change: the repetitions matched so far and what they left behind.
+type progress struct {
+	children []*ParseTree
+	pending  []byte
+	pos      int
+	after    Mark
+	warnings []Warning
+}
+
+// resumer keeps the progress of a closure while streaming.
+type resumer struct {
+	s       *Source
+	key     memoKey
+	b       *treeBuilder
+	warned  int  // the warnings recorded before the closure.
+	starved bool // whether the parse was starved before the closure.
+	settled bool // whether no repetition looked past the input so far.
+}
+
+// resume starts the closure of lex at pos where a parse of less of the
+// stream left it settled, and returns the position to go on from. The
+// resumer, nil unless streaming a grammar without predicates, records the
+// progress after each repetition until one looks for input past what was
+// written.
+func (s *Source) resume(lex *Lexeme, pos int, b *treeBuilder) (int, *resumer) {
+	if !s.stream || len(s.lang.predicates) > 0 {
+		return pos, nil
+	}
+	r := &resumer{s: s, key: s.memoKey(lex, pos), b: b, warned: s.nwarnings, starved: s.starved, settled: true}
+	if p, ok := s.progress[r.key]; ok {
+		b.children, b.pending, b.shared = p.children, p.pending, true
+		nwarnings := s.nwarnings
+		s.Reset(p.after)
+		s.nwarnings = nwarnings
+		for _, w := range p.warnings {
+			s.Warn(w.Pos, w.Msg)
+		}
+		pos = p.pos
+	}
+	s.starved = false
+	return pos, r
+}
+
+// step records that the closure repeated up to pos.
+func (r *resumer) step(pos int) {
+	if r == nil || !r.settled {
+		return
+	}
+	s := r.s
+	if s.starved {
+		r.settled = false
+		return
+	}
+	if s.progress == nil {
+		s.progress = make(map[memoKey]progress)
+	}
+	p := s.progress[r.key]
+	p.children, p.pending, p.pos, p.after = r.b.children, r.b.pending, pos, s.Mark()
+	r.b.shared = true
+	p.warnings = append(p.warnings[:0], s.warnings[r.warned:s.nwarnings]...)
+	s.progress[r.key] = p
+}
+
+// end restores what the parse knew about starvation before the closure.
+func (r *resumer) end() {
+	if r != nil {
+		r.s.starved = r.s.starved || r.starved
+	}
+}
+
+// shift moves the positions of tree by off.
+func shift(tree *ParseTree, off int) {
+	if tree == nil {
+		return
+	}
+	tree.Pos += off
+	tree.End += off
+	for _, child := range tree.Children {
+		shift(child, off)
+	}
+}
+
+// Starve records that the match at the end of the input could change with
+// n more bytes of it. It only matters to StreamParsers, which wait for more
+// input before returning the document.
+func (s *Source) Starve(n int) {
+	if !s.stream {
+		return
+	}
+	if n < 1 {
+		n = 1
+	}
+	if !s.starved || n < s.need {
+		s.need = n
+	}
+	s.starved = true
+}
+
+// starveLiteral records starvation when the input at pos is a proper
+// prefix of valid.
+func (s *Source) starveLiteral(valid []byte, pos int, fold bool) {
+	rest := s.buf[pos:]
+	if !s.stream || len(rest) >= len(valid) {
+		return
+	}
+	if fold || bytes.HasPrefix(valid, rest) {
+		s.Starve(len(valid) - len(rest))
+	}
+}
+
+// consumeRegexp matches re at pos like Consume. While streaming, prog is
+// the compiled form of re, used to find out whether more input could still
+// change the match.
+func (s *Source) consumeRegexp(re *regexp.Regexp, prog func() *syntax.Prog, pos int) []byte {
+	match := s.Consume(re, pos)
+	if s.stream && alive(prog(), s.buf[pos:]) {
+		s.Starve(1)
+	}
+	return match
+}
+
+// compileProg compiles the regexp expr, which is known to be valid.
+func compileProg(expr string) *syntax.Prog {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		panic(err)
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		panic(err)
+	}
+	return prog
+}
+
+// alive reports whether prog, run from the start of text, could still
+// consume input once all of text has been read. The matching threads are
+// not ranked as the regexp package ranks them, so this may report that a
+// match could go on where the regexp would have settled for a shorter one.
+func alive(prog *syntax.Prog, text []byte) bool {
+	var threads, next []uint32
+	seen := make(map[uint32]bool)
+	starved := false
+	// add follows the empty transitions from pc, with before and after the
+	// runes around the position, or -1 at the ends of the text.
+	var add func(list []uint32, pc uint32, before, after rune, end bool) []uint32
+	add = func(list []uint32, pc uint32, before, after rune, end bool) []uint32 {
+		if seen[pc] {
+			return list
+		}
+		seen[pc] = true
+		inst := &prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			list = add(list, inst.Out, before, after, end)
+			return add(list, inst.Arg, before, after, end)
+		case syntax.InstCapture, syntax.InstNop:
+			return add(list, inst.Out, before, after, end)
+		case syntax.InstEmptyWidth:
+			op := syntax.EmptyOp(inst.Arg)
+			if end && op&(syntax.EmptyEndLine|syntax.EmptyEndText|syntax.EmptyWordBoundary|syntax.EmptyNoWordBoundary) != 0 {
+				// Whether it holds depends on the input to come.
+				starved = true
+				return list
+			}
+			if op&^syntax.EmptyOpContext(before, after) == 0 {
+				return add(list, inst.Out, before, after, end)
+			}
+			return list
+		case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny, syntax.InstRuneAnyNotNL:
+			return append(list, pc)
+		}
+		return list
+	}
+	before := rune(-1)
+	first, _ := utf8.DecodeRune(text)
+	if len(text) == 0 {
+		first = -1
+	}
+	threads = add(threads, uint32(prog.Start), before, first, len(text) == 0)
+	for i := 0; i < len(text) && len(threads) > 0; {
+		r, n := utf8.DecodeRune(text[i:])
+		i += n
+		after := rune(-1)
+		if i < len(text) {
+			after, _ = utf8.DecodeRune(text[i:])
+		}
+		for pc := range seen {
+			delete(seen, pc)
+		}
+		next = next[:0]
+		for _, pc := range threads {
+			if matchRune(&prog.Inst[pc], r) {
+				next = add(next, prog.Inst[pc].Out, r, after, i == len(text))
+			}
+		}
+		threads, next = next, threads
+		before = r
+	}
+	return starved || len(threads) > 0
+}
+
+func matchRune(inst *syntax.Inst, r rune) bool {
+	switch inst.Op {
+	case syntax.InstRune1:
+		return r == inst.Rune[0]
+	case syntax.InstRuneAny:
+		return true
+	case syntax.InstRuneAnyNotNL:
+		return r != '\n'
+	}
+	return inst.MatchRune(r)
+}
@@ -0,0 +1,173 @@
+package peg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStreamParser(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("msg <- cmd arg? '\\n'\ncmd <- ~'[A-Z]+'\narg <- ' ' ~'[^\\n]*'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := lang.NewStreamParser()
+	steps := []struct {
+		write string
+		trees []string
+		need  int
+	}{
+		{"PI", nil, 1},
+		{"NG\nSET a", []string{`0-5 (msg (cmd "PING") (msg "\n"))`}, 1},
+		{" 1\n", []string{`5-13 (msg (cmd "SET") (arg (arg " ") (arg "a 1")) (msg "\n"))`}, 0},
+		{"", nil, 0},
+	}
+	for _, step := range steps {
+		p.Write([]byte(step.write))
+		trees, need, err := p.Next()
+		if err != nil {
+			t.Fatalf("%q: %s", step.write, err)
+		}
+		var got []string
+		for _, tree := range trees {
+			got = append(got, fmt.Sprintf("%d-%d %s", tree.Pos, tree.End, tree.SExpr()))
+		}
+		if strings.Join(got, "\n") != strings.Join(step.trees, "\n") || need != step.need {
+			t.Errorf("%q: got %v needing %d, exp %v needing %d", step.write, got, need, step.trees, step.need)
+		}
+	}
+	p.Write([]byte("bad\n"))
+	if _, _, err := p.Next(); err == nil || !strings.Contains(err.Error(), "offset 13") {
+		t.Errorf("expected an error at offset 13, got %v", err)
+	}
+	if _, _, err2 := p.Next(); err2 == nil {
+		t.Errorf("the error was not kept")
+	}
+}
+
+func TestStreamByteByByte(t *testing.T) {
+	tests := []struct {
+		language, input string
+	}{
+		{"doc <- field* ';'\nfield <- key '=' @num\nkey <- ~'[a-z]+'", strings.Repeat("ab=12 ", 200) + ";"},
+		{"doc <- @num+ ';'", strings.Repeat("12 ", 300) + ";"},
+	}
+	for _, test := range tests {
+		lang, err := NewLanguage(test.language)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// num matches digits and the space after them.
+		calls := 0
+		lang.Register("num", func(s *Source, pos int) (*ParseTree, error, int) {
+			calls++
+			n := 0
+			for pos+n < len(s.buf) && '0' <= s.buf[pos+n] && s.buf[pos+n] <= '9' {
+				n++
+			}
+			if pos+n == len(s.buf) {
+				s.Starve(1)
+			}
+			if n == 0 || pos+n == len(s.buf) || s.buf[pos+n] != ' ' {
+				return nil, s.expected(pos, "num"), 0
+			}
+			return &ParseTree{Type: "num", Data: s.buf[pos : pos+n], Pos: pos, End: pos + n}, nil, n + 1
+		})
+		exp, err := lang.ParseString(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		calls = 0
+		p := lang.NewStreamParser()
+		var trees []*ParseTree
+		for i := range test.input {
+			p.Write([]byte{test.input[i]})
+			got, _, err := p.Next()
+			if err != nil {
+				t.Fatalf("%s at %d: %s", test.language, i, err)
+			}
+			trees = append(trees, got...)
+		}
+		if len(trees) != 1 || trees[0].SExpr() != exp.SExpr() || trees[0].End != len(test.input) {
+			t.Errorf("%s: got %v, expected %s", test.language, trees, exp.SExpr())
+		}
+		// A number is matched again only while it is being written.
+		if calls > 2*len(test.input) {
+			t.Errorf("%s: numbers were matched %d times for %d bytes", test.language, calls, len(test.input))
+		}
+	}
+}
+
+func TestStreamNeed(t *testing.T) {
+	tests := []struct {
+		language string
+		input    string
+		need     int
+	}{
+		{"msg <- 'HELLO'", "HE", 3},
+		{"msg <- u16be u16be", "\x00\x01\x02", 1},
+		{"msg <- string('\"', '\\\\')", `"a\u00`, 3},
+		{"msg <- balanced('(', ')')", "(a(b)", 1},
+	}
+	for _, test := range tests {
+		lang, err := NewParser(strings.NewReader(test.language))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := lang.NewStreamParser()
+		p.Write([]byte(test.input))
+		trees, need, err := p.Next()
+		if err != nil || len(trees) != 0 || need != test.need {
+			t.Errorf("%s: got %d trees needing %d, exp %d: %v", test.language, len(trees), need, test.need, err)
+		}
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("%whitespace ws\n%token num\nprgm <- num\nnum <- ~'[0-9]+'\nws <- ' '"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := lang.NewStreamParser()
+	p.Write([]byte("12"))
+	if trees, need, err := p.Next(); err != nil || len(trees) != 0 || need != 1 {
+		t.Errorf("a number at the end was returned: %v, %d, %v", trees, need, err)
+	}
+	p.Write([]byte(" 3"))
+	if trees, _, err := p.Next(); err != nil || len(trees) != 1 || string(trees[0].Data) != "12" {
+		t.Errorf("unexpected documents %v, %v", trees, err)
+	}
+	p.Close()
+	trees, need, err := p.Next()
+	if err != nil || len(trees) != 1 || string(trees[0].Data) != "3" || trees[0].Pos != 3 || need != 0 {
+		t.Errorf("unexpected documents after close %v, %d, %v", trees, need, err)
+	}
+	if _, err := p.Write([]byte("4")); err == nil {
+		t.Errorf("expected an error writing to a closed parser")
+	}
+}
+
+func TestAlive(t *testing.T) {
+	tests := []struct {
+		expr, text string
+		exp        bool
+	}{
+		{`[a-z]+`, "abc", true},
+		{`[a-z]+`, "ab;", false},
+		{`abc`, "ab", true},
+		{`abc`, "abc", false},
+		{`abc`, "abx", false},
+		{`a$`, "a", true},
+		{`a\b`, "a", true},
+		{`^a`, "", true},
+		{`(?i)é`, "", true},
+		{`[^\n]*`, "a\n", false},
+		{`x*`, "y", false},
+	}
+	for _, test := range tests {
+		if got := alive(compileProg(test.expr), []byte(test.text)); got != test.exp {
+			t.Errorf("alive(%s, %q) = %v, exp %v", test.expr, test.text, got, test.exp)
+		}
+	}
+}
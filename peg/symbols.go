@@ -0,0 +1,76 @@
+package peg
+
+// symbol is an immutable list of the definitions made with Define, most
+// recent first, in which the start of every scope is marked. Like the
+// captures, it is part of the parse state, so definitions made by
+// alternatives that fail are undone when the parser backtracks.
+type symbol struct {
+	name  string
+	value interface{}
+	scope bool // whether the entry opens a scope rather than defines a name.
+	prev  *symbol
+}
+
+// PushScope opens a scope, whose definitions shadow those of the enclosing
+// scopes until it is closed with PopScope.
+func (s *Source) PushScope() {
+	s.symbols = &symbol{scope: true, prev: s.symbols}
+}
+
+// PopScope closes the innermost scope, dropping its definitions. Without
+// an open scope it drops all definitions.
+func (s *Source) PopScope() {
+	sym := s.symbols
+	for sym != nil && !sym.scope {
+		sym = sym.prev
+	}
+	if sym != nil {
+		sym = sym.prev
+	}
+	s.symbols = sym
+}
+
+// Define binds name to value in the innermost scope, shadowing earlier
+// definitions of name.
+func (s *Source) Define(name string, value interface{}) {
+	s.symbols = &symbol{name: name, value: value, prev: s.symbols}
+}
+
+// Lookup returns the value of the innermost definition of name that is in
+// scope.
+func (s *Source) Lookup(name string) (interface{}, bool) {
+	for sym := s.symbols; sym != nil; sym = sym.prev {
+		if !sym.scope && sym.name == name {
+			return sym.value, true
+		}
+	}
+	return nil, false
+}
+
+// LookupLocal is like Lookup, but only looks at the innermost scope, such
+// as to reject a name declared twice in the same block.
+func (s *Source) LookupLocal(name string) (interface{}, bool) {
+	for sym := s.symbols; sym != nil && !sym.scope; sym = sym.prev {
+		if sym.name == name {
+			return sym.value, true
+		}
+	}
+	return nil, false
+}
+
+// NewSymbolScope matches lex in a scope of its own, so that the names it
+// defines are only visible inside of it. It suits the rules of blocks and
+// function bodies:
+//
+//	scoped := peg.NewSymbolScope(peg.NewRuleLexer("body"))
+//	lang, err := peg.NewLanguage(grammar, peg.WithRule("block", scoped))
+func NewSymbolScope(lex *Lexeme) *Lexeme {
+	return WrapLexeme(lex, func(next LexFunc) LexFunc {
+		return func(s *Source, pos int) (*ParseTree, error, int) {
+			outer := s.symbols
+			defer func() { s.symbols = outer }()
+			s.PushScope()
+			return next(s, pos)
+		}
+	})
+}
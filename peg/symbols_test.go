@@ -0,0 +1,92 @@
+package peg
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSymbols(t *testing.T) {
+	const grammar = `%whitespace ws
+prgm <- stmt+
+stmt <- block / decl / use
+decl <- 'let' @define ';'^
+use <- @used ';'^
+block <- body
+body <- '{' stmt* '}'
+ws <- ~'[ \n]+'`
+	name := regexp.MustCompile(`^[a-z]+`)
+	lang, err := NewLanguage(grammar, WithRule("block", NewSymbolScope(NewRuleLexer("body"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang.Register("define", func(s *Source, pos int) (*ParseTree, error, int) {
+		pos += s.skipWhitespace(pos)
+		match := s.Consume(name, pos)
+		if match == nil {
+			return nil, s.expected(pos, "name"), 0
+		}
+		if _, ok := s.LookupLocal(string(match)); ok {
+			return nil, s.failed(pos, string(match)+" is already declared"), 0
+		}
+		s.Define(string(match), pos)
+		return &ParseTree{Type: "define", Data: match, Pos: pos, End: pos + len(match)}, nil, len(match)
+	})
+	lang.Register("used", func(s *Source, pos int) (*ParseTree, error, int) {
+		pos += s.skipWhitespace(pos)
+		match := s.Consume(name, pos)
+		if match == nil {
+			return nil, s.expected(pos, "name"), 0
+		}
+		if _, ok := s.Lookup(string(match)); !ok {
+			return nil, s.failed(pos, string(match)+" is not declared"), 0
+		}
+		return &ParseTree{Type: "used", Data: match, Pos: pos, End: pos + len(match)}, nil, len(match)
+	})
+	for _, tt := range []struct {
+		input string
+		ok    bool
+	}{
+		{"let a; a;", true},
+		{"a; let a;", false},
+		{"let a; { a; let b; b; }", true},
+		{"{ let b; } b;", false},
+		{"let a; { let a; a; }", true},
+		{"let a; let a;", false},
+	} {
+		tree, err := lang.ParseString(tt.input)
+		if err == nil && tree.End != len(tt.input) {
+			err = &ParseError{Pos: tree.End, Msg: "unparsed input"}
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: got error %v", tt.input, err)
+		}
+	}
+}
+
+func TestSymbolsBacktrack(t *testing.T) {
+	s := SourceFromBytes(nil)
+	s.Define("a", 1)
+	m := s.Mark()
+	s.PushScope()
+	s.Define("a", 2)
+	s.Define("b", 3)
+	if v, _ := s.Lookup("a"); v != 2 {
+		t.Errorf("got a = %v in the inner scope", v)
+	}
+	if _, ok := s.LookupLocal("a"); !ok {
+		t.Errorf("a is not local to the inner scope")
+	}
+	s.PopScope()
+	if v, _ := s.Lookup("a"); v != 1 {
+		t.Errorf("got a = %v after closing the scope", v)
+	}
+	s.Define("c", 4)
+	s.Reset(m)
+	if _, ok := s.Lookup("c"); ok {
+		t.Errorf("c is defined after a reset")
+	}
+	s.PopScope()
+	if _, ok := s.Lookup("a"); ok {
+		t.Errorf("a is defined after closing every scope")
+	}
+}
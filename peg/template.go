@@ -0,0 +1,166 @@
+package peg
+
+import "strings"
+
+// template is a parameterized rule, such as list(item, sep) <- item tail*.
+// Calling it with rules or literals as arguments defines an instance of
+// the template, a rule whose body has the arguments in place of the
+// parameters.
+type template struct {
+	params []string
+	body   []item // the items of the body, up to and including its end.
+}
+
+// instance is a call of a template, instantiated once the grammar has
+// been read, since the template may be defined after it is called.
+type instance struct {
+	template string
+	name     string // the call with its arguments, as in list(expr, ',').
+	args     []item
+	rule     string // the rule containing the call.
+	at       item
+}
+
+// isParam reports whether name is a parameter of the template being
+// defined.
+func (p *parser) isParam(name string) bool {
+	for _, param := range p.params {
+		if param == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTemplate collects the parameters of the template name.
+func parseTemplate(name string, params []string) parseStateFn {
+	return func(p *parser) parseStateFn {
+		next, ok := p.next()
+		if !ok {
+			p.Errorf("item channel drained unexpectedly in template %s", name)
+			return nil
+		}
+		switch next.typ {
+		case itemWhitespace, itemLParen, itemComma:
+			return parseTemplate(name, params)
+		case itemIdentifier:
+			return parseTemplate(name, append(params, next.val))
+		case itemRParen:
+			return parseTemplateBody(name, params)
+		}
+		p.Errorf("expected parameter of template %s, found %v", name, next)
+		return nil
+	}
+}
+
+// parseTemplateBody records the items of the body of the template name,
+// then parses them like a rule to check them and to print the template.
+func parseTemplateBody(name string, params []string) parseStateFn {
+	return func(p *parser) parseStateFn {
+		next, ok := p.next()
+		if !ok {
+			p.Errorf("item channel drained unexpectedly in template %s", name)
+			return nil
+		}
+		switch next.typ {
+		case itemWhitespace:
+			return parseTemplateBody(name, params)
+		case itemAssignment:
+		default:
+			p.Errorf("expected '<-', '=', ':=' or '::=' after template %s, found %v", name, next)
+			return nil
+		}
+		if _, ok := p.templates[name]; ok {
+			p.Errorf("template %s is already defined", name)
+			return nil
+		}
+		var body []item
+		for next.typ != itemNewline && next.typ != itemEOF {
+			if next, ok = p.next(); !ok {
+				p.Errorf("item channel drained unexpectedly in template %s", name)
+				return nil
+			}
+			if next.typ == itemError {
+				p.Errorf("lex error: %s", next.String())
+				return nil
+			}
+			body = append(body, next)
+		}
+		p.templates[name] = &template{params, body}
+		p.rule, p.params, p.scoped = name, params, false
+		p.replay = append([]item(nil), body...)
+		return parseRuleBody(name, nil)
+	}
+}
+
+// instance returns a reference to the instance of the template fn called
+// with args. Calls in the definition of a template are only instantiated
+// with the template.
+func (p *parser) instance(fn string, args []item) *Lexeme {
+	names := make([]string, len(args))
+	for i, arg := range args {
+		switch arg.typ {
+		case itemIdentifier:
+			names[i] = arg.val
+		case itemLiteral:
+			literal, err := unquote(arg.val)
+			if err != nil {
+				literal = arg.val
+			}
+			names[i] = quoteLiteral(literal)
+		default:
+			names[i] = quoteLiteral(arg.val)
+		}
+	}
+	name := fn + "(" + strings.Join(names, ", ") + ")"
+	if p.params == nil && !p.instantiated[name] {
+		p.instantiated[name] = true
+		p.instances = append(p.instances, instance{fn, name, args, p.rule, p.item})
+	}
+	return NewRuleLexer(name)
+}
+
+// instantiate defines the rules of the template instances called by the
+// grammar, including those called by other instances. The instances of a
+// template whose body has errors are left undefined, since the errors are
+// reported at the template already.
+func (p *parser) instantiate() {
+	broken := make(map[string]bool)
+	for _, err := range p.errs {
+		if _, ok := p.templates[err.Rule]; ok {
+			broken[err.Rule] = true
+		}
+	}
+	for i := 0; i < len(p.instances); i++ {
+		inst := p.instances[i]
+		p.rule, p.item = inst.rule, inst.at
+		t, ok := p.templates[inst.template]
+		if !ok {
+			p.Errorf("unknown built in matcher or template %s()", inst.template)
+			continue
+		}
+		if len(inst.args) != len(t.params) {
+			p.Errorf("template %s takes %d arguments, got %d", inst.template, len(t.params), len(inst.args))
+			continue
+		}
+		if broken[inst.template] {
+			p.defined[inst.name] = true
+			continue
+		}
+		p.replay = p.replay[:0]
+		for _, next := range t.body {
+			for j, param := range t.params {
+				if next.typ == itemIdentifier && next.val == param {
+					next = inst.args[j]
+				}
+			}
+			p.replay = append(p.replay, next)
+		}
+		p.rule, p.scoped, p.instancing = inst.name, false, true
+		p.defined[inst.name] = true
+		for p.state = parseRuleBody(inst.name, nil); p.state != nil; {
+			p.state = p.state(p)
+		}
+		p.instancing = false
+	}
+}
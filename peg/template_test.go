@@ -0,0 +1,90 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	grammar := `prgm <- list(word, ',') ';'^ list(num, '|')
+list(item, sep) <- item more(item, sep)*
+more(item, sep) <- sep^ item
+word <- ~'[a-z]+'
+num <- ~'[0-9]+'`
+	lang, err := NewParser(strings.NewReader(grammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("a,b;1|2|3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &ParseTree{
+		Type: "prgm",
+		Children: []*ParseTree{
+			&ParseTree{Type: "list(word, ',')", Children: []*ParseTree{
+				&ParseTree{Type: "word", Data: []byte("a")},
+				&ParseTree{Type: "more(word, ',')*", Children: []*ParseTree{
+					&ParseTree{Type: "word", Data: []byte("b")},
+				}},
+			}},
+			&ParseTree{Type: "list(num, '|')", Children: []*ParseTree{
+				&ParseTree{Type: "num", Data: []byte("1")},
+				&ParseTree{Type: "more(num, '|')*", Children: []*ParseTree{
+					&ParseTree{Type: "num", Data: []byte("2")},
+					&ParseTree{Type: "num", Data: []byte("3")},
+				}},
+			}},
+		},
+	}
+	if err := treeCompare(tree, exp); err != nil {
+		t.Error(err)
+	}
+
+	if rules := strings.Join(lang.Rules(), " "); rules != "prgm word num" {
+		t.Errorf("unexpected rules %s", rules)
+	}
+	out := lang.Grammar()
+	if exp := strings.Replace(strings.Replace(grammar, "'[a-z]+'", "`[a-z]+`", 1), "'[0-9]+'", "`[0-9]+`", 1) + "\n"; out != exp {
+		t.Errorf("Grammar() = %q, expected %q", out, exp)
+	}
+	if _, err := NewParser(strings.NewReader(out)); err != nil {
+		t.Errorf("printed grammar does not compile: %s", err)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	for _, grammar := range []string{
+		"prgm <- list('a')\nlist(a, b) <- a b",
+		"prgm <- nope('a')",
+		"prgm <- list(x)\nlist(a) <- a",
+		"prgm <- 'a'\nlist(a) <- a\nlist(b) <- b",
+		"prgm <- 'a'\nlist(a) <- a b",
+		"prgm <- balanced(x, 'a')\nx <- 'x'",
+	} {
+		if _, err := NewParser(strings.NewReader(grammar)); err == nil {
+			t.Errorf("expected error for %q", grammar)
+		}
+	}
+}
+
+func TestTemplateErrorsReportedOnce(t *testing.T) {
+	grammar := "prgm <- list(b, ',') list(c, ';')\nlist(item, sep) <- item (sep item)*\nb <- 'b'\nc <- 'c'"
+	_, err := NewLanguage(grammar)
+	errs, ok := err.(GrammarErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("expected one error, got %v", err)
+	}
+	if errs[0].Rule != "list" || errs[0].Line != 2 || !strings.Contains(errs[0].Msg, "no parentheses") {
+		t.Errorf("got %s, expected the parentheses of template list at line 2", errs[0])
+	}
+
+	// The group goes into a template of its own.
+	lang, err := NewLanguage("prgm <- list(b, ',')\nlist(item, sep) <- item more(item, sep)*\nmore(item, sep) <- sep^ item\nb <- 'b'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree, err := lang.ParseString("b,b,b"); err != nil || len(tree.Children) != 2 {
+		t.Errorf("got %v, %v", tree, err)
+	}
+}
@@ -0,0 +1,63 @@
+package peg
+
+import (
+	"strings"
+	"time"
+)
+
+// NewTimestampLexer matches a time written in layout, in the notation of
+// the time package, after skipping whitespace. The leaf's Value holds the
+// time.Time it denotes. Times that the layout describes but the calendar
+// doesn't have, such as the 30th of February, don't match.
+func NewTimestampLexer(typ, layout string) *Lexeme {
+	// Names of months and days and fractions of seconds may be longer in
+	// the input than in the layout, but not by much.
+	max := len(layout) + 32
+	id := newNodeType(typ)
+	return &Lexeme{
+		Name: typ,
+		kind: kindCall,
+		text: "timestamp(" + quoteLiteral(layout) + ")",
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			skip := s.skipWhitespace(pos)
+			start := pos + skip
+			end := start + max
+			if end >= len(s.buf) {
+				end = len(s.buf)
+				s.Starve(1)
+			}
+			n, t, ok := parseTimePrefix(layout, string(s.buf[start:end]))
+			if !ok {
+				return nil, s.expected(start, "time like "+layout), 0
+			}
+			return s.leaf(&ParseTree{
+				Type:    typ,
+				ID:      id.get(s),
+				Data:    s.buf[start : start+n],
+				Value:   t,
+				Pos:     start,
+				End:     start + n,
+				Leading: s.skipped(start, skip),
+			}), nil, skip + n
+		},
+	}
+}
+
+// parseTimePrefix parses the time in layout at the start of text, returning
+// its length. time.Parse only takes a whole string, but tells how much is
+// left over when the time is followed by more text.
+func parseTimePrefix(layout, text string) (int, time.Time, bool) {
+	t, err := time.Parse(layout, text)
+	if err == nil {
+		return len(text), t, true
+	}
+	perr, ok := err.(*time.ParseError)
+	if !ok || !strings.HasPrefix(perr.Message, ": extra text") {
+		return 0, time.Time{}, false
+	}
+	n := len(text) - len(perr.ValueElem)
+	if t, err = time.Parse(layout, text[:n]); err != nil || n == 0 {
+		return 0, time.Time{}, false
+	}
+	return n, t, true
+}
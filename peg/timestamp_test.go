@@ -0,0 +1,53 @@
+package peg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestamp(t *testing.T) {
+	for _, tt := range []struct {
+		grammar string
+		input   string
+		want    string // the time as RFC 3339, or "" if the parse fails.
+	}{
+		{"ts <- timestamp('2006-01-02')", "2024-02-29", "2024-02-29T00:00:00Z"},
+		{"ts <- timestamp('2006-01-02')", "2023-02-29", ""},
+		{"ts <- timestamp('2006-01-02')", "2023-13-01", ""},
+		{"ts <- timestamp('2006-01-02T15:04:05Z07:00')", "2024-05-01T12:30:00+02:00", "2024-05-01T12:30:00+02:00"},
+		{"ts <- timestamp('2006-01-02T15:04:05Z07:00')", "2024-05-01T12:30:00Z", "2024-05-01T12:30:00Z"},
+		{"ts <- timestamp('Jan _2 15:04:05')", "Sep  3 08:01:02", "0000-09-03T08:01:02Z"},
+		{"ts <- timestamp('January 2, 2006')", "September 30, 2020", "2020-09-30T00:00:00Z"},
+		{"line <- timestamp('2006-01-02 15:04:05') msg\nmsg <- ~'.*'", "2024-05-01 12:30:00 started", "2024-05-01T12:30:00Z"},
+		{"line <- timestamp('2006-01-02 15:04:05.000') msg\nmsg <- ~'.*'", "2024-05-01 12:30:00.250 ok", "2024-05-01T12:30:00.25Z"},
+		{"ts <- timestamp('2006-01-02')", "yesterday", ""},
+	} {
+		lang, err := NewLanguage(tt.grammar)
+		if err != nil {
+			t.Errorf("%q: %s", tt.grammar, err)
+			continue
+		}
+		tree, err := lang.ParseString(tt.input)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q on %q: expected an error", tt.grammar, tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q on %q: %s", tt.grammar, tt.input, err)
+			continue
+		}
+		if len(tree.Children) > 0 {
+			tree = tree.Children[0]
+		}
+		got, ok := tree.Value.(time.Time)
+		if !ok || got.Format(time.RFC3339Nano) != tt.want {
+			t.Errorf("%q on %q: got %v, want %s", tt.grammar, tt.input, tree.Value, tt.want)
+		}
+	}
+
+	if _, err := NewLanguage("ts <- timestamp()"); err == nil {
+		t.Errorf("expected an error for a missing layout")
+	}
+}
@@ -0,0 +1,56 @@
+package peg
+
+import (
+	"io"
+)
+
+// Token is a leaf match, located by the offsets of its first byte and the
+// byte following it.
+type Token struct {
+	Type       string
+	Start, End int
+}
+
+// Tokenize parses the input and returns its leaves in input order, without
+// building the tree. Leaves that are discarded from the tree produce no
+// tokens either.
+func (l *Language) Tokenize(r io.Reader) ([]Token, error) {
+	s, err := NewSource(r)
+	if err != nil {
+		return nil, err
+	}
+	s.tokenize = true
+	if _, err := l.parse(s); err != nil {
+		return nil, err
+	}
+	return s.tokens[:s.ntokens], nil
+}
+
+// leaf returns t, or records it as a token and returns nil when the source
+// is tokenized.
+func (s *Source) leaf(t *ParseTree) *ParseTree {
+	if !s.tokenize {
+		return s.node(t)
+	}
+	s.token(t.Type, t.Pos, t.End)
+	return nil
+}
+
+// token records a token, dropping those left behind by backtracking.
+func (s *Source) token(typ string, start, end int) {
+	if start == end {
+		return
+	}
+	s.tokens = append(s.tokens[:s.ntokens], Token{typ, start, end})
+	s.ntokens++
+}
+
+// leaves records the leaves of a tree built by an external matcher.
+func (s *Source) leaves(t *ParseTree) {
+	if len(t.Children) == 0 {
+		s.token(t.Type, t.Pos, t.End)
+	}
+	for _, child := range t.Children {
+		s.leaves(child)
+	}
+}
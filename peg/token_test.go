@@ -0,0 +1,37 @@
+package peg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	grammar := "%whitespace ws\n%memo value\nprgm <- assign / value\nassign <- value '='^ value\nvalue <- name / number\nname <- ~'[a-z]+'\nnumber <- ~'[0-9]+'\nws <- ~' +'"
+	lang, err := NewParser(strings.NewReader(grammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := lang.Tokenize(strings.NewReader("x = 42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []Token{{"name", 0, 1}, {"number", 4, 6}}
+	if !reflect.DeepEqual(tokens, exp) {
+		t.Errorf("got %v, exp %v", tokens, exp)
+	}
+
+	// The first alternative fails after matching a value, whose token must
+	// not be reported twice.
+	tokens, err = lang.Tokenize(strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []Token{{"name", 0, 1}}; !reflect.DeepEqual(tokens, exp) {
+		t.Errorf("got %v, exp %v", tokens, exp)
+	}
+
+	if _, err := lang.Tokenize(strings.NewReader("=")); err == nil {
+		t.Errorf("expected error for invalid input")
+	}
+}
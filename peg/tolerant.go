@@ -0,0 +1,184 @@
+package peg
+
+import "errors"
+
+// ErrorType is the type of the nodes that cover input skipped by a
+// Tolerant parse.
+const ErrorType = "Error"
+
+// Tolerant makes parsing always produce a tree. Where the input fails to
+// match, the parser skips ahead to the next position where the failing part
+// of a sequence or repetition matches, or treats the part as missing if the
+// rest of the sequence matches instead, and records the skipped input as an
+// ErrorType node whose Value is the parse error. Input left over at the end
+// becomes an error node too. A parse with errors returns the tree together
+// with its error or, if it recovered from several, all of them in the order
+// of the input, joined with errors.Join. Each of them is a *ParseError, so
+// that errors.As finds the first one.
+//
+// Recovery is only attempted at the offsets where a parse without it got
+// furthest, so that alternatives which would match are not cut short.
+func Tolerant(tolerant bool) Option {
+	return func(l *Language) {
+		l.tolerant = tolerant
+	}
+}
+
+func (l *Language) parseTolerant(root *Lexeme, s *Source) (*ParseTree, error) {
+	var first error
+	s.recoverAt, s.missing = make(map[int]bool), make(map[missingPart]bool)
+	for {
+		s.parseState, s.memo, s.farthest = parseState{}, nil, -1
+		tree, err, n := root.Lexer(s, 0)
+		if err == nil && n == len(s.buf) {
+			return tree, treeErrors(tree, l.InternType(ErrorType), first)
+		}
+		at := s.farthest
+		if perr, ok := err.(*ParseError); ok && perr.Pos > at {
+			at = perr.Pos
+		}
+		if first == nil {
+			if first = err; err == nil {
+				first = s.failed(n, "unexpected input")
+			}
+		}
+		if at >= 0 && !s.recoverAt[at] {
+			s.recoverAt[at] = true
+			continue
+		}
+
+		// Recovery made no progress, so cover what's left with an error.
+		if err != nil {
+			tree, n = nil, 0
+		}
+		start := n + s.skipWhitespace(n)
+		if err == nil {
+			err = s.failed(start, "unexpected input")
+		}
+		rest := s.errorNode(n, start, len(s.buf), err)
+		switch {
+		case s.tokenize:
+		case tree == nil:
+			typ := rootType(root)
+			tree = &ParseTree{Type: typ, ID: l.InternType(typ), Children: []*ParseTree{rest}, End: len(s.buf)}
+		case len(tree.Children) == 0:
+			typ := rootType(root)
+			tree = &ParseTree{Type: typ, ID: l.InternType(typ), Children: []*ParseTree{tree, rest}, End: len(s.buf)}
+		default:
+			t := *tree
+			t.Children = append(append([]*ParseTree(nil), tree.Children...), rest)
+			t.End = len(s.buf)
+			tree = &t
+		}
+		return tree, treeErrors(tree, l.InternType(ErrorType), first)
+	}
+}
+
+// rootType returns the type of the nodes that matches of root produce, as
+// the root of a tree whose parse failed: that of the closure a rule is, as
+// in item+, or else the name of the innermost rule root refers to, rather
+// than the names of the lexemes, such as ~item+.
+func rootType(root *Lexeme) string {
+	typ := root.Name
+	for {
+		switch root.kind {
+		case kindDefinition:
+			typ = root.text
+		case kindMemo, kindScope, kindWrap:
+		case kindPlus, kindStar, kindRepeat, kindLazy:
+			return closureType(root)
+		default:
+			return typ
+		}
+		root = root.Dependencies[0]
+	}
+}
+
+// treeErrors returns the errors of the error nodes of tree, those of type
+// errorType, joined if there are several, or first if it has none, such as when only tokens were
+// recorded.
+func treeErrors(tree *ParseTree, errorType NodeType, first error) error {
+	var errs []error
+	var walk func(node *ParseTree)
+	walk = func(node *ParseTree) {
+		if node.ID == errorType {
+			err, _ := node.Value.(error)
+			if _, ok := err.(*ParseError); !ok && err != nil {
+				err = &ParseError{Pos: node.Pos, Msg: err.Error()}
+			}
+			if err != nil && (len(errs) == 0 || errs[len(errs)-1] != err) {
+				errs = append(errs, err)
+			}
+			return
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	if tree != nil {
+		walk(tree)
+	}
+	switch len(errs) {
+	case 0:
+		return first
+	case 1:
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// errorNode covers the input from start to end, after whitespace skipped
+// from pos.
+func (s *Source) errorNode(pos, start, end int, err error) *ParseTree {
+	return s.leaf(&ParseTree{
+		Type:    ErrorType,
+		ID:      s.lang.InternType(ErrorType),
+		Data:    s.buf[start:end],
+		Value:   err,
+		Pos:     start,
+		End:     end,
+		Leading: s.skipped(start, start-pos),
+	})
+}
+
+// missingPart is a part of a sequence treated as missing at pos.
+type missingPart struct {
+	lex *Lexeme
+	pos int
+}
+
+// recover is called when parts[0] of a sequence or repetition fails with
+// err at pos. If recovery is enabled at the offset of the error, it returns
+// an error node and the length of the input to skip before parts[0]
+// matches, or reports parts[0] as missing if parts[1] matches at pos. A
+// part is not reported as missing at pos again while the sequence that
+// treats it so is still being matched, which would recurse without
+// consuming input.
+func (s *Source) recover(parts []*Lexeme, pos int, err error) (node *ParseTree, skip int, missing, ok bool) {
+	perr, isParse := err.(*ParseError)
+	if s.resyncing || !isParse || !s.recoverAt[perr.Pos] {
+		return nil, 0, false, false
+	}
+	// The error starts after any whitespace skipped before it.
+	start := pos + s.skipWhitespace(pos)
+	if len(parts) > 1 && !s.missing[missingPart{parts[0], pos}] && s.matches(parts[1], pos) {
+		return s.errorNode(pos, start, start, err), start - pos, true, true
+	}
+	for q := start + 1; q <= len(s.buf); q++ {
+		if s.matches(parts[0], q) {
+			return s.errorNode(pos, start, q, err), q - pos, false, true
+		}
+	}
+	return nil, 0, false, false
+}
+
+// matches reports whether lex matches at pos, without recovering from
+// errors and without changing the parse state.
+func (s *Source) matches(lex *Lexeme, pos int) bool {
+	m := s.mark()
+	s.resyncing = true
+	_, err, _ := lex.Lexer(s, pos)
+	s.resyncing = false
+	s.reset(m)
+	return err == nil
+}
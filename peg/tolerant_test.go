@@ -0,0 +1,131 @@
+package peg
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// errorSpans lists the text covered by the error nodes of tree.
+func errorSpans(tree *ParseTree) []string {
+	if tree.Type == ErrorType {
+		return []string{string(tree.Data)}
+	}
+	var spans []string
+	for _, child := range tree.Children {
+		spans = append(spans, errorSpans(child)...)
+	}
+	return spans
+}
+
+func TestTolerant(t *testing.T) {
+	grammar := "%whitespace ws\nprgm <- stmt*\nstmt <- name '=' value ';'\nname <- ~'[a-z]+'\nvalue <- ~'[0-9]+'\nws <- ~'[ \\n]+'"
+	lang, err := NewParser(strings.NewReader(grammar), Tolerant(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		input string
+		spans []string
+	}{
+		{"a = 1; b = 2;", nil},
+		{"a = 1; ?? b = 2;", []string{"??"}},
+		{"a = = 1; b = 2;", []string{"="}},
+		{"a 1; b = 2;", []string{""}},
+		{"a = 1; b = 2", []string{"b = 2"}},
+		{"???", []string{"???"}},
+	} {
+		tree, err := lang.ParseString(tc.input)
+		if tree == nil {
+			t.Errorf("%q: no tree", tc.input)
+			continue
+		}
+		if (err != nil) != (tc.spans != nil) {
+			t.Errorf("%q: unexpected error %v", tc.input, err)
+		}
+		if tree.Type != "stmt*" {
+			t.Errorf("%q: root %s, exp stmt*", tc.input, tree.Type)
+		}
+		if spans := errorSpans(tree); strings.Join(spans, "|") != strings.Join(tc.spans, "|") || len(spans) != len(tc.spans) {
+			t.Errorf("%q: error spans %q, exp %q", tc.input, spans, tc.spans)
+		}
+	}
+}
+
+func TestTolerantRootType(t *testing.T) {
+	for _, tc := range []struct {
+		grammar, input, exp string
+	}{
+		{"list <- item+\nitem <- ~'[a-z]'", "1", `(item+ (Error "1"))`},
+		{"list <- item{2}\nitem <- ~'[a-z]'", "1", `(item{2} (Error "1"))`},
+		{"list <- item ' ' item\nitem <- ~'[a-z]'", "1", `(list (Error "1"))`},
+		{"list <- item\nitem <- ~'[a-z]'", "ab", `(item (item "a") (Error "b"))`},
+		{"%memo list\nlist <- item*\nitem <- ~'[a-z]'", "1", `(item* (item* "") (Error "1"))`},
+	} {
+		lang, err := NewLanguage(tc.grammar, Tolerant(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := lang.ParseString(tc.input)
+		if err == nil {
+			t.Errorf("%q: expected an error", tc.grammar)
+		}
+		if got := tree.SExpr(); got != tc.exp {
+			t.Errorf("%q: got %s, exp %s", tc.grammar, got, tc.exp)
+		}
+	}
+}
+
+func TestTolerantRecursion(t *testing.T) {
+	// Treating '[' as missing used to recurse into value at the same
+	// offset forever.
+	lang, err := NewParser(strings.NewReader("value <- array / num\narray <- '[' value? ']'\nnum <- ~'[0-9]+'"), Tolerant(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{"", "[", "[1", "x]"} {
+		tree, err := lang.ParseString(input)
+		if tree == nil || err == nil {
+			t.Errorf("%q: expected a tree and an error, got %v, %v", input, tree, err)
+		}
+	}
+}
+
+func TestTolerantErrors(t *testing.T) {
+	grammar := "%whitespace ws\nprgm <- stmt*\nstmt <- name '=' value ';'\nname <- ~'[a-z]+'\nvalue <- ~'[0-9]+'\nws <- ~'[ \\n]+'"
+	lang, err := NewParser(strings.NewReader(grammar), Tolerant(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		input string
+		pos   []int
+	}{
+		{"a = 1;", nil},
+		{"a = 1; ?? b = 2;", []int{7}},
+		{"a = 1; ?? b = ; c = 3", []int{7, 14, 16}},
+	} {
+		_, err := lang.ParseString(tc.input)
+		var pos []int
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range joined.Unwrap() {
+				perr, ok := err.(*ParseError)
+				if !ok {
+					t.Errorf("%q: %v is no *ParseError", tc.input, err)
+					continue
+				}
+				pos = append(pos, perr.Pos)
+			}
+		} else if err != nil {
+			pos = []int{err.(*ParseError).Pos}
+		}
+		if !reflect.DeepEqual(pos, tc.pos) {
+			t.Errorf("%q: errors at %v, exp %v: %v", tc.input, pos, tc.pos, err)
+		}
+		var perr *ParseError
+		if err != nil && (!errors.As(err, &perr) || perr.Pos != tc.pos[0]) {
+			t.Errorf("%q: errors.As found %v", tc.input, perr)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package peg
+
+// ToMap converts the tree to nested maps and slices, for templating engines
+// and scripts that go through a tree without types for its nodes. Each node
+// is a map with the keys type, pos and end, text for leaves and children
+// for nodes with children, as a []any of the maps of the children, and
+// value for the decoded values of typed leaves, as in MarshalJSON.
+func (p *ParseTree) ToMap() map[string]any {
+	return toMap(p, nil)
+}
+
+// ToMap is like the ToMap of tree, but also sets the labeled fields of the
+// nodes of rules, as Fields lists them, to the maps of the children they
+// refer to. In a tree of
+//
+//	assign <- name:ident '=' value:expr
+//
+// the map of an assign node has name and value besides its children. A
+// field referring to a closure holds the []any of its matches, and one
+// that refers to several children holds the []any of them. Fields named
+// like the keys of every node are left out.
+func (l *Language) ToMap(tree *ParseTree) map[string]any {
+	fields := make(map[string][]Field)
+	return toMap(tree, func(node *ParseTree) []Field {
+		fs, ok := fields[node.Type]
+		if !ok {
+			fs = l.Fields(node.Type)
+			fields[node.Type] = fs
+		}
+		return fs
+	})
+}
+
+func toMap(node *ParseTree, fields func(*ParseTree) []Field) map[string]any {
+	if node == nil {
+		return nil
+	}
+	m := map[string]any{"type": node.Type, "pos": node.Pos, "end": node.End}
+	if node.Value != nil {
+		m["value"] = node.Value
+	}
+	if len(node.Children) == 0 {
+		m["text"] = string(node.Data)
+		return m
+	}
+	children := make([]any, len(node.Children))
+	for i, child := range node.Children {
+		children[i] = toMap(child, fields)
+	}
+	m["children"] = children
+	if fields == nil {
+		return m
+	}
+	// Children are matched to the fields in order, skipping the fields of
+	// options and alternatives that produced nothing.
+	fs, next := fields(node), 0
+	lists := make(map[string]bool) // the fields holding a []any of matches.
+	for i, child := range node.Children {
+		for j := next; j < len(fs); j++ {
+			f := fs[j]
+			closure := f.Closure != "" && child.Type == f.Closure
+			if child.Type != f.Rule && !closure {
+				continue
+			}
+			next = j + 1
+			if reserved[f.Name] {
+				break
+			}
+			matches := []any{children[i]}
+			if closure {
+				matches, _ = children[i].(map[string]any)["children"].([]any)
+			}
+			prev, ok := m[f.Name]
+			switch {
+			case !ok && !closure:
+				m[f.Name] = children[i]
+			case !ok:
+				m[f.Name], lists[f.Name] = append([]any{}, matches...), true
+			case lists[f.Name]:
+				m[f.Name] = append(prev.([]any), matches...)
+			default:
+				m[f.Name], lists[f.Name] = append([]any{prev}, matches...), true
+			}
+			break
+		}
+	}
+	return m
+}
+
+// reserved holds the keys of the maps of every node.
+var reserved = map[string]bool{"type": true, "pos": true, "end": true, "value": true, "text": true, "children": true}
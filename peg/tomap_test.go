@@ -0,0 +1,90 @@
+package peg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestToMap(t *testing.T) {
+	lang, err := NewLanguage("assign <- name:ident '='^ val:num args*\nargs <- ','^ num\nident <- ~'[a-z]+'\nnum <- ~'[0-9]+'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("x=1,2,3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := func(typ, text string, pos int) map[string]any {
+		return map[string]any{"type": typ, "text": text, "pos": pos, "end": pos + len(text)}
+	}
+	x, one, two, three := leaf("ident", "x", 0), leaf("num", "1", 2), leaf("num", "2", 4), leaf("num", "3", 6)
+	args := map[string]any{"type": "args*", "pos": 3, "end": 7, "children": []any{two, three}}
+	exp := map[string]any{"type": "assign", "pos": 0, "end": 7, "children": []any{x, one, args}}
+	if got := tree.ToMap(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("tree.ToMap() = %v, exp %v", got, exp)
+	}
+
+	exp["name"], exp["val"], exp["args"] = x, one, []any{two, three}
+	if got := lang.ToMap(tree); !reflect.DeepEqual(got, exp) {
+		t.Errorf("lang.ToMap() = %v, exp %v", got, exp)
+	}
+}
+
+func TestToMapFields(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		grammar string
+		input   string
+		exp     map[string][]string // the types of the fields set.
+	}{
+		{
+			name:    "option",
+			grammar: "pair <- key:word sep? val:word\nsep <- ':'\nword <- ~'[a-z]'",
+			input:   "ab",
+			exp:     map[string][]string{"key": {"word"}, "val": {"word"}},
+		},
+		{
+			name:    "repeated",
+			grammar: "pair <- word ' ' word\nword <- ~'[a-z]+'",
+			input:   "a b",
+			exp:     map[string][]string{"word": {"word", "word"}},
+		},
+		{
+			name:    "reserved",
+			grammar: "pair <- type:word ' ' text:word\nword <- ~'[a-z]+'",
+			input:   "a b",
+			exp:     map[string][]string{},
+		},
+	} {
+		lang, err := NewLanguage(tc.grammar)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		tree, err := lang.ParseString(tc.input)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		m := lang.ToMap(tree)
+		got := make(map[string][]string)
+		for key, v := range m {
+			if reserved[key] {
+				continue
+			}
+			list, ok := v.([]any)
+			if !ok {
+				list = []any{v}
+			}
+			for _, node := range list {
+				got[key] = append(got[key], node.(map[string]any)["type"].(string))
+			}
+		}
+		if !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%s: fields %v, exp %v", tc.name, got, tc.exp)
+		}
+		if m["type"] != "pair" {
+			t.Errorf("%s: type %v, exp pair", tc.name, m["type"])
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package peg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TraceKind tells whether a trace event starts or ends an attempt.
+type TraceKind byte
+
+const (
+	TraceEnter TraceKind = iota // a rule is tried at Pos.
+	TraceMatch                  // the rule matched from Pos to End.
+	TraceFail                   // the rule failed at Pos.
+)
+
+func (k TraceKind) String() string {
+	switch k {
+	case TraceEnter:
+		return "enter"
+	case TraceMatch:
+		return "match"
+	case TraceFail:
+		return "fail"
+	}
+	return "unknown"
+}
+
+// TraceEvent is a single decision of the parser.
+type TraceEvent struct {
+	Kind TraceKind
+	Rule int // index into Trace.Rules.
+	Pos  int
+	End  int
+}
+
+// Trace records the rules tried while parsing a source, in order.
+type Trace struct {
+	Rules  []string
+	Events []TraceEvent
+	ids    map[string]int
+}
+
+// Record appends the decisions of subsequent parses of s to t.
+func (s *Source) Record(t *Trace) {
+	s.trace = t
+}
+
+// TraceWriter makes every parse write the rules it tries to w, one line
+// per attempt and one per outcome, indented by how deeply the rules are
+// nested:
+//
+//	expr at 0
+//	  term at 0
+//	  term matched 0-1
+//	  more at 1
+//	  more failed
+//	expr matched 0-1
+//
+// Sources with a Listener of their own are not traced. Parses running at
+// the same time write to w concurrently.
+func TraceWriter(w io.Writer) Option {
+	return func(l *Language) {
+		l.traceOut = w
+	}
+}
+
+// traceWriter is the Listener installed by TraceWriter. starts holds the
+// offsets of the rules being matched.
+type traceWriter struct {
+	w      io.Writer
+	starts []int
+}
+
+func (t *traceWriter) EnterRule(name string, pos int) {
+	fmt.Fprintf(t.w, "%s%s at %d\n", strings.Repeat("  ", len(t.starts)), name, pos)
+	t.starts = append(t.starts, pos)
+}
+
+func (t *traceWriter) ExitRule(name string, pos int, ok bool) {
+	start := t.starts[len(t.starts)-1]
+	t.starts = t.starts[:len(t.starts)-1]
+	indent := strings.Repeat("  ", len(t.starts))
+	if ok {
+		fmt.Fprintf(t.w, "%s%s matched %d-%d\n", indent, name, start, pos)
+	} else {
+		fmt.Fprintf(t.w, "%s%s failed\n", indent, name)
+	}
+}
+
+func (t *Trace) rule(name string) int {
+	if t.ids == nil {
+		t.ids = make(map[string]int)
+		for i, rule := range t.Rules {
+			t.ids[rule] = i
+		}
+	}
+	id, ok := t.ids[name]
+	if !ok {
+		id = len(t.Rules)
+		t.ids[name] = id
+		t.Rules = append(t.Rules, name)
+	}
+	return id
+}
+
+// traceRule matches the rule name, reporting it to the trace and the
+// listener of s, whichever are set.
+func (s *Source) traceRule(name string, lex *Lexeme, pos int) (*ParseTree, error, int) {
+	id := -1
+	if s.trace != nil {
+		id = s.trace.rule(name)
+		s.trace.Events = append(s.trace.Events, TraceEvent{TraceEnter, id, pos, pos})
+	}
+	if s.listener != nil {
+		s.enterRule(name, pos)
+	}
+	tree, err, n := lex.Lexer(s, pos)
+	if s.listener != nil {
+		if err != nil {
+			s.exitRule(name, pos, false)
+		} else {
+			s.exitRule(name, pos+n, true)
+		}
+	}
+	if s.trace != nil {
+		if err != nil {
+			s.trace.Events = append(s.trace.Events, TraceEvent{TraceFail, id, pos, pos})
+		} else {
+			s.trace.Events = append(s.trace.Events, TraceEvent{TraceMatch, id, pos, pos + n})
+		}
+	}
+	return tree, err, n
+}
+
+// traceMagic starts every serialized trace.
+const traceMagic = "pegtrace1"
+
+// WriteTo serializes the trace in a compact binary form.
+func (t *Trace) WriteTo(w io.Writer) (int64, error) {
+	buf := []byte(traceMagic)
+	buf = binary.AppendUvarint(buf, uint64(len(t.Rules)))
+	for _, rule := range t.Rules {
+		buf = binary.AppendUvarint(buf, uint64(len(rule)))
+		buf = append(buf, rule...)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(t.Events)))
+	for _, e := range t.Events {
+		buf = append(buf, byte(e.Kind))
+		buf = binary.AppendUvarint(buf, uint64(e.Rule))
+		buf = binary.AppendUvarint(buf, uint64(e.Pos))
+		buf = binary.AppendUvarint(buf, uint64(e.End-e.Pos))
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// ReadTrace reads a trace serialized with WriteTo.
+func ReadTrace(r io.Reader) (*Trace, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != traceMagic {
+		return nil, errors.New("not a parse trace")
+	}
+	t := &Trace{}
+	nrules, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nrules; i++ {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, size)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, err
+		}
+		t.Rules = append(t.Rules, string(name))
+	}
+	nevents, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nevents; i++ {
+		kind, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		var v [3]uint64
+		for j := range v {
+			if v[j], err = binary.ReadUvarint(br); err != nil {
+				return nil, err
+			}
+		}
+		if kind > byte(TraceFail) || v[0] >= uint64(len(t.Rules)) {
+			return nil, errors.New("corrupt parse trace")
+		}
+		t.Events = append(t.Events, TraceEvent{TraceKind(kind), int(v[0]), int(v[1]), int(v[1] + v[2])})
+	}
+	return t, nil
+}
@@ -0,0 +1,70 @@
+package peg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	lang, err := NewParser(strings.NewReader("prgm <- a b?\na <- 'a'\nb <- 'b'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := SourceFromBytes([]byte("a"))
+	trace := &Trace{}
+	s.Record(trace)
+	if _, err := lang.ParseSource(s); err != nil {
+		t.Fatal(err)
+	}
+	exp := []TraceEvent{
+		{TraceEnter, 0, 0, 0},
+		{TraceEnter, 1, 0, 0},
+		{TraceMatch, 1, 0, 1},
+		{TraceEnter, 2, 1, 1},
+		{TraceFail, 2, 1, 1},
+		{TraceMatch, 0, 0, 1},
+	}
+	if !reflect.DeepEqual(trace.Events, exp) || !reflect.DeepEqual(trace.Rules, []string{"prgm", "a", "b"}) {
+		t.Errorf("unexpected trace %v %v", trace.Rules, trace.Events)
+	}
+
+	var buf bytes.Buffer
+	trace.WriteTo(&buf)
+	read, err := ReadTrace(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read.Events, trace.Events) || !reflect.DeepEqual(read.Rules, trace.Rules) {
+		t.Errorf("round trip changed the trace")
+	}
+	if _, err := ReadTrace(strings.NewReader("garbage")); err == nil {
+		t.Errorf("expected error for invalid trace")
+	}
+}
+
+func TestTraceWriter(t *testing.T) {
+	var out bytes.Buffer
+	lang, err := NewLanguage("expr <- term more*\nmore <- '+' term\nterm <- ~'[0-9]+'", TraceWriter(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lang.ParseString("1+2"); err != nil {
+		t.Fatal(err)
+	}
+	exp := `expr at 0
+  term at 0
+  term matched 0-1
+  more at 1
+    term at 2
+    term matched 2-3
+  more matched 1-3
+  more at 3
+  more failed
+expr matched 0-3
+`
+	if out.String() != exp {
+		t.Errorf("got:\n%s\nexp:\n%s", out.String(), exp)
+	}
+}
@@ -0,0 +1,113 @@
+package peg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Constructors build values of type T from the nodes of the rules they are
+// keyed by. A constructor receives the node and the values built from its
+// children, in order.
+type Constructors[T any] map[string]func(node *ParseTree, children []T) (T, error)
+
+// TypedLanguage parses input straight into values of type T, such as the
+// nodes of an AST, rather than into parse trees.
+type TypedLanguage[T any] struct {
+	lang  *Language
+	build Constructors[T]
+}
+
+// Typed returns a TypedLanguage that parses with lang and converts the
+// trees with build:
+//
+//	calc, err := peg.Typed(lang, peg.Constructors[int]{
+//		"num": func(node *peg.ParseTree, _ []int) (int, error) {
+//			return strconv.Atoi(string(node.Data))
+//		},
+//		"sum": func(node *peg.ParseTree, terms []int) (int, error) {
+//			return terms[0] + terms[1], nil
+//		},
+//	})
+//
+// Nodes without a constructor, such as those of closures, pass the values
+// of their children on to their parent; leaves without one produce none.
+// It is an error for build to name a rule lang does not have.
+func Typed[T any](lang *Language, build Constructors[T]) (*TypedLanguage[T], error) {
+	var unknown []string
+	for name := range build {
+		if !lang.hasRule(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, errors.New(fmt.Sprintf("constructors for undefined rules %s", strings.Join(unknown, ", ")))
+	}
+	return &TypedLanguage[T]{lang, build}, nil
+}
+
+// Parse parses the input and converts the tree.
+func (t *TypedLanguage[T]) Parse(r io.Reader) (T, error) {
+	tree, err := t.lang.Parse(r)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return t.Convert(tree)
+}
+
+// ParseString is identical to Parse, but operates on a string.
+func (t *TypedLanguage[T]) ParseString(source string) (T, error) {
+	return t.Parse(strings.NewReader(source))
+}
+
+// Convert builds the value of tree, which must produce exactly one.
+func (t *TypedLanguage[T]) Convert(tree *ParseTree) (T, error) {
+	var zero T
+	values, err := t.convert(tree, nil)
+	if err != nil {
+		return zero, err
+	}
+	if len(values) != 1 {
+		return zero, errors.New(fmt.Sprintf("%s produced %d values, expected 1", tree.Type, len(values)))
+	}
+	return values[0], nil
+}
+
+// convert appends the values built from tree to values.
+func (t *TypedLanguage[T]) convert(tree *ParseTree, values []T) ([]T, error) {
+	build, ok := t.build[tree.Type]
+	if !ok {
+		for _, child := range tree.Children {
+			var err error
+			if values, err = t.convert(child, values); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	var children []T
+	for _, child := range tree.Children {
+		var err error
+		if children, err = t.convert(child, children); err != nil {
+			return nil, err
+		}
+	}
+	v, err := construct(build, tree, children)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s at offset %d: %s", tree.Type, tree.Pos, err))
+	}
+	return append(values, v), nil
+}
+
+// construct calls build, returning a panic in it as an error so that a bug
+// in a constructor fails one conversion rather than the program.
+func construct[T any](build func(*ParseTree, []T) (T, error), tree *ParseTree, children []T) (v T, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("constructor panicked: %v", r))
+		}
+	}()
+	return build(tree, children)
+}
@@ -0,0 +1,85 @@
+package peg
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type typedExpr interface{ eval() int }
+
+type typedNum int
+
+func (n typedNum) eval() int { return int(n) }
+
+type typedSum []typedExpr
+
+func (s typedSum) eval() int {
+	total := 0
+	for _, e := range s {
+		total += e.eval()
+	}
+	return total
+}
+
+func TestTyped(t *testing.T) {
+	lang, err := NewLanguage("sum <- num more*\nmore <- '+'^ num\nnum <- ~'[0-9]+'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calc, err := Typed(lang, Constructors[typedExpr]{
+		"num": func(node *ParseTree, _ []typedExpr) (typedExpr, error) {
+			n, err := strconv.Atoi(string(node.Data))
+			if n == 13 {
+				err = errors.New("unlucky")
+			}
+			return typedNum(n), err
+		},
+		"sum": func(node *ParseTree, terms []typedExpr) (typedExpr, error) {
+			if len(terms) > 3 {
+				_ = terms[len(terms)]
+			}
+			return typedSum(terms), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		input string
+		value int
+		err   string
+	}{
+		{"1+2+3", 6, ""},
+		{"1", 1, ""},
+		{"1+13", 0, "num at offset 2: unlucky"},
+		{"1+2+3+4", 0, "sum at offset 0: constructor panicked: runtime error: index out of range [4] with length 4"},
+		{"+", 0, "expected ~`[0-9]+` at offset 0: \"+\""},
+	} {
+		e, err := calc.ParseString(tt.input)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, expected %s", tt.input, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("%q: %s", tt.input, err)
+		case e.eval() != tt.value:
+			t.Errorf("%q evaluated to %d, expected %d", tt.input, e.eval(), tt.value)
+		}
+	}
+
+	if _, err := Typed(lang, Constructors[int]{"nope": nil}); err == nil {
+		t.Errorf("expected an error for an undefined rule")
+	}
+	nums, err := Typed(lang, Constructors[int]{
+		"num": func(node *ParseTree, _ []int) (int, error) { return strconv.Atoi(string(node.Data)) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nums.ParseString("1+2"); err == nil || !strings.Contains(err.Error(), "produced 2 values") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
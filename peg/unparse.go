@@ -0,0 +1,411 @@
+package peg
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unparse renders tree, a tree of the language that may have been
+// rewritten since it was parsed, back into source text. The rules of the
+// grammar tell which node goes where and supply the text that leaves no
+// node, such as discarded punctuation and keywords. Discarded input other
+// than literals, such as comments, is left out, and a space is written
+// between tokens that would otherwise run together, such as two words or
+// two operators, where the grammar skips whitespace. Trees parsed in
+// Lossless mode and not modified are better reproduced by Text.
+//
+// templates override how the nodes of the given types are written. In a
+// template, $1 to $9 stand for the children of the node, written on their
+// own, $0 for the node as the grammar writes it and $$ for a dollar sign:
+//
+//	lang.Unparse(tree, map[string]string{"block": "{\n$1\n}"})
+//
+// Unparse fails if the tree is not one the grammar could produce.
+func (l *Language) Unparse(tree *ParseTree, templates map[string]string) ([]byte, error) {
+	u := &unparser{
+		lang:      l,
+		templates: templates,
+		regexps:   make(map[*Lexeme]*regexp.Regexp),
+		active:    make(map[unparseKey]bool),
+		templated: make(map[*ParseTree]bool),
+	}
+	kids := []*ParseTree{tree}
+	if !u.emit(l.root, kids, 0, func(i int) bool { return i == len(kids) }) {
+		if u.err != nil {
+			return nil, u.err
+		}
+		bad := tree
+		if u.rejected != nil {
+			bad = u.rejected
+		}
+		return nil, errors.New(fmt.Sprintf("cannot unparse %s node at offset %d: no rule produces it", bad.Type, bad.Pos))
+	}
+	return u.buf, u.err
+}
+
+// unparseKey identifies a rule written at a child of a node, to stop left
+// recursive rules from being tried over and over without writing anything.
+type unparseKey struct {
+	lex  *Lexeme
+	node *ParseTree
+	i    int
+}
+
+type unparser struct {
+	lang      *Language
+	templates map[string]string
+	regexps   map[*Lexeme]*regexp.Regexp
+	active    map[unparseKey]bool
+	templated map[*ParseTree]bool // the nodes whose templates are being written.
+	buf       []byte
+	space     bool // whether discarded input allows a space before the next token.
+	depth     int
+	rejected  *ParseTree // the deepest node no lexeme accepted.
+	rejDepth  int
+	err       error
+}
+
+// emit writes the text of lex producing the nodes kids[i:] of a node, up
+// to the child it calls k with, backtracking when k fails. It reports
+// whether k succeeded.
+func (u *unparser) emit(lex *Lexeme, kids []*ParseTree, i int, k func(i int) bool) bool {
+	if u.err != nil {
+		return false
+	}
+	var kid *ParseTree
+	if i < len(kids) {
+		kid = kids[i]
+	}
+	switch lex.kind {
+	case kindDefinition:
+		key := unparseKey{lex, kid, i}
+		if u.active[key] {
+			return false
+		}
+		u.active[key] = true
+		defer delete(u.active, key)
+		return u.emit(lex.Dependencies[0], kids, i, func(j int) bool {
+			delete(u.active, key)
+			defer func() { u.active[key] = true }()
+			return k(j)
+		})
+	case kindMemo, kindScope, kindWrap, kindCapture:
+		return u.emit(lex.Dependencies[0], kids, i, k)
+	case kindPredicate:
+		return k(i)
+	case kindDiscard:
+		return u.restore(func() bool {
+			u.discarded(lex.Dependencies[0])
+			return k(i)
+		})
+	case kindOption:
+		return u.emit(lex.Dependencies[0], kids, i, k) || k(i)
+	case kindAlternate, kindChoice:
+		for _, alt := range lex.Dependencies {
+			if u.emit(alt, kids, i, k) {
+				return true
+			}
+		}
+		return false
+	case kindConcat:
+		if kid != nil && kid.Type == lex.Name && kid.Data == nil {
+			done := func() bool { return k(i + 1) }
+			ok := u.take(kid, func(done func() bool) bool {
+				return u.children(kid, func(kids []*ParseTree, done func() bool) bool {
+					return u.seq(lex.Dependencies, kids, 0, func(j int) bool { return j == len(kids) && done() })
+				}, done)
+			}, done)
+			if ok {
+				return true
+			}
+		}
+		if kid == nil || !u.lang.collapses(lex.Name) {
+			return false
+		}
+		// A lone child replaces the sequence.
+		return u.seq(lex.Dependencies, kids, i, func(j int) bool { return j == i+1 && k(j) })
+	case kindPlus, kindStar, kindRepeat, kindLazy:
+		if kid == nil || kid.Type != closureType(lex) {
+			u.reject(kid)
+			return false
+		}
+		dep := lex.Dependencies[0]
+		if dep.merge {
+			return kid.Data != nil && u.take(kid, func(done func() bool) bool {
+				return u.token(kid.Data, done)
+			}, func() bool { return k(i + 1) })
+		}
+		min, max := closureBounds(lex)
+		return u.take(kid, func(done func() bool) bool {
+			return u.children(kid, func(kids []*ParseTree, done func() bool) bool {
+				return u.repeat(dep, kids, 0, 0, min, max, func(j int) bool { return j == len(kids) && done() })
+			}, done)
+		}, func() bool { return k(i + 1) })
+	case kindCall:
+		if strings.HasPrefix(lex.text, "warn(") {
+			return k(i)
+		}
+	case kindRule:
+		u.err = errors.New(fmt.Sprintf("unresolved rule %s", lex.text))
+		return false
+	}
+	// The lexeme produces a leaf, or a node of its own making.
+	if kid == nil || kid.Type != lex.Name || !u.leafMatches(lex, kid) {
+		u.reject(kid)
+		return false
+	}
+	return u.take(kid, func(done func() bool) bool {
+		switch {
+		case lex.unparse != nil:
+			return u.token(lex.unparse(kid), done)
+		case len(kid.Children) == 0:
+			return u.token(kid.Data, done)
+		}
+		return u.restore(func() bool {
+			u.standalone(kid)
+			return done()
+		})
+	}, func() bool { return k(i + 1) })
+}
+
+// seq writes the sequence deps producing kids from i.
+func (u *unparser) seq(deps []*Lexeme, kids []*ParseTree, i int, k func(i int) bool) bool {
+	if len(deps) == 0 {
+		return k(i)
+	}
+	return u.emit(deps[0], kids, i, func(j int) bool {
+		return u.seq(deps[1:], kids, j, k)
+	})
+}
+
+// repeat writes count or more matches of dep producing kids from i. Matches
+// past the minimum must produce a node, or they would repeat forever.
+func (u *unparser) repeat(dep *Lexeme, kids []*ParseTree, i, count, min, max int, k func(i int) bool) bool {
+	if max < 0 || count < max {
+		more := u.emit(dep, kids, i, func(j int) bool {
+			if j == i && count >= min {
+				return false
+			}
+			return u.repeat(dep, kids, j, count+1, min, max, k)
+		})
+		if more {
+			return true
+		}
+	}
+	return count >= min && k(i)
+}
+
+// closureBounds returns the least and most matches of the closure lex, the
+// most being negative if unbounded.
+func closureBounds(lex *Lexeme) (min, max int) {
+	switch lex.kind {
+	case kindPlus:
+		return 1, -1
+	case kindLazy:
+		if lex.text[0] == '+' {
+			return 1, -1
+		}
+	case kindRepeat:
+		if n, _ := fmt.Sscanf(lex.text, "{%d,%d}", &min, &max); n == 2 {
+			return min, max
+		}
+		if strings.HasSuffix(lex.text, ",}") {
+			return min, -1
+		}
+		return min, min
+	}
+	return 0, -1
+}
+
+// children writes the children of node with fn, which calls done once
+// they are written.
+func (u *unparser) children(node *ParseTree, fn func(kids []*ParseTree, done func() bool) bool, done func() bool) bool {
+	u.depth++
+	defer func() { u.depth-- }()
+	return fn(node.Children, func() bool {
+		u.depth--
+		defer func() { u.depth++ }()
+		return done()
+	})
+}
+
+// reject records node as one that no lexeme produces, if it is the deepest
+// such node so far.
+func (u *unparser) reject(node *ParseTree) {
+	if node != nil && (u.rejected == nil || u.depth >= u.rejDepth) {
+		u.rejected, u.rejDepth = node, u.depth
+	}
+}
+
+// leafMatches reports whether lex could have produced the leaf, as far as
+// its text tells.
+func (u *unparser) leafMatches(lex *Lexeme, leaf *ParseTree) bool {
+	switch lex.kind {
+	case kindLiteral:
+		if len(leaf.Children) > 0 {
+			return false
+		}
+		if u.lang.directives.caseInsensitive || u.lang.config.caseInsensitive {
+			return strings.EqualFold(string(leaf.Data), lex.text)
+		}
+		return string(leaf.Data) == lex.text
+	case kindRegexp:
+		if len(leaf.Children) > 0 {
+			return false
+		}
+		re, ok := u.regexps[lex]
+		if !ok {
+			re = regexp.MustCompile(`^(?:` + lex.text + `)$`)
+			u.regexps[lex] = re
+		}
+		return re.Match(leaf.Data)
+	}
+	return true
+}
+
+// take writes node, with its template if it has one, and otherwise as
+// write does.
+func (u *unparser) take(node *ParseTree, write func(done func() bool) bool, done func() bool) bool {
+	tmpl, ok := u.templates[node.Type]
+	if !ok || u.templated[node] {
+		return write(done)
+	}
+	return u.restore(func() bool {
+		u.templated[node] = true
+		defer delete(u.templated, node)
+		for tmpl != "" {
+			n := strings.IndexByte(tmpl, '$')
+			if n < 0 || n == len(tmpl)-1 {
+				u.buf = append(u.buf, tmpl...)
+				break
+			}
+			u.buf = append(u.buf, tmpl[:n]...)
+			switch c := tmpl[n+1]; {
+			case c == '0':
+				if !write(func() bool { return true }) {
+					return false
+				}
+			case '1' <= c && c <= '9':
+				if i := int(c - '1'); i < len(node.Children) {
+					u.standalone(node.Children[i])
+				}
+			default:
+				u.buf = append(u.buf, c)
+			}
+			tmpl = tmpl[n+2:]
+		}
+		return done()
+	})
+}
+
+// standalone writes node on its own, with its template or the rule of its
+// type if it has either, and otherwise as the text of its leaves.
+func (u *unparser) standalone(node *ParseTree) {
+	if _, ok := u.templates[node.Type]; !ok {
+		if r, ok := u.lang.rule(node.Type); ok {
+			kids := []*ParseTree{node}
+			if u.emit(r.lex, kids, 0, func(i int) bool { return i == 1 }) {
+				return
+			}
+		}
+	}
+	u.take(node, func(done func() bool) bool {
+		if len(node.Children) == 0 {
+			return u.token(node.Data, done)
+		}
+		for _, child := range node.Children {
+			u.standalone(child)
+		}
+		return done()
+	}, func() bool { return true })
+}
+
+// token writes text, after a space if it would run together with the text
+// before it and whitespace may go between them.
+func (u *unparser) token(text []byte, k func() bool) bool {
+	return u.restore(func() bool {
+		if len(text) > 0 && len(u.buf) > 0 && (u.lang.whitespace != nil || u.space) {
+			last, _ := utf8.DecodeLastRune(u.buf)
+			first, _ := utf8.DecodeRune(text)
+			if fuses(last, first) {
+				u.buf = append(u.buf, ' ')
+			}
+		}
+		if len(text) > 0 {
+			u.buf = append(u.buf, text...)
+			u.space = false
+		}
+		return k()
+	})
+}
+
+// restore runs fn, undoing what it wrote if it fails.
+func (u *unparser) restore(fn func() bool) bool {
+	n, space := len(u.buf), u.space
+	if fn() {
+		return true
+	}
+	u.buf, u.space = u.buf[:n], space
+	return false
+}
+
+// discarded writes the text of a discarded lexeme, if it is made of
+// literals. Other discarded input may be left out, as discarding never
+// fails, but allows a space between the tokens around it.
+func (u *unparser) discarded(lex *Lexeme) {
+	if text, ok := literalText(lex, map[*Lexeme]bool{}); ok {
+		u.token([]byte(text), func() bool { return true })
+	} else if lex.kind == kindRegexp && regexp.MustCompile(`^(?:`+lex.text+`)$`).MatchString(" ") {
+		u.space = true
+	} else if lex.kind == kindDefinition {
+		u.discarded(lex.Dependencies[0])
+	}
+}
+
+// literalText returns the text lex matches if it only matches literals.
+// Alternatives are written as the first.
+func literalText(lex *Lexeme, seen map[*Lexeme]bool) (string, bool) {
+	if seen[lex] {
+		return "", false
+	}
+	seen[lex] = true
+	defer delete(seen, lex)
+	switch lex.kind {
+	case kindLiteral:
+		return lex.text, true
+	case kindCall:
+		// Keywords, written as 'word' or keyword('word').
+		text := lex.text
+		if strings.HasPrefix(text, "keyword(") {
+			text = text[len("keyword(") : len(text)-1]
+		}
+		if len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'' {
+			word, err := unquote(text[1 : len(text)-1])
+			return word, err == nil
+		}
+	case kindConcat:
+		var buf strings.Builder
+		for _, dep := range lex.Dependencies {
+			text, ok := literalText(dep, seen)
+			if !ok {
+				return "", false
+			}
+			buf.WriteString(text)
+		}
+		return buf.String(), true
+	case kindDefinition, kindMemo, kindScope, kindWrap, kindCapture, kindDiscard, kindAlternate, kindChoice:
+		return literalText(lex.Dependencies[0], seen)
+	}
+	return "", false
+}
+
+// fuses reports whether a token ending in a and one starting with b would
+// be read as one, such as two words or two operators.
+func fuses(a, b rune) bool {
+	const operators = "!#$%&*+-./:<=>?@\\^|~"
+	return isIdentTailRune(a) && isIdentTailRune(b) ||
+		strings.ContainsRune(operators, a) && strings.ContainsRune(operators, b)
+}
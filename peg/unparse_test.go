@@ -0,0 +1,99 @@
+package peg
+
+import (
+	"testing"
+)
+
+const unparseGrammar = `%whitespace ws
+%keywords let
+%identifier name
+prgm <- stmt+
+stmt <- 'let'^ name '='^ expr ';'^ comment^
+expr <- sum / term
+sum <- term op term
+op <- '+' / '-'
+term <- call / name / num / str
+call <- name '(' args? ')'
+args <- expr more*
+more <- ','^ expr
+name <- ~'[a-z]+'
+num <- int
+str <- string('"', '\\')
+comment <- ~'#[^\n]*'
+ws <- ~'[ \t\n]+'`
+
+func TestUnparse(t *testing.T) {
+	lang, err := NewLanguage(unparseGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{"let x = 1;", "let x=1;"},
+		{"let x = a + b; # sum\nlet y = f(x, 2 - 1, g());", "let x=a+b;let y=f(x,2-1,g());"},
+		{"let s = \"a\\\"b\\n\";", "let s=\"a\\\"b\\n\";"},
+		{"let x=f (  y ) ;", "let x=f(y);"},
+	} {
+		tree, err := lang.ParseString(tt.in)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.in, err)
+		}
+		out, err := lang.Unparse(tree, nil)
+		if err != nil {
+			t.Errorf("%q: %s", tt.in, err)
+			continue
+		}
+		if string(out) != tt.out {
+			t.Errorf("%q unparsed to %q, want %q", tt.in, out, tt.out)
+		}
+		again, err := lang.ParseString(string(out))
+		if err != nil {
+			t.Errorf("%q unparsed to %q: %s", tt.in, out, err)
+			continue
+		}
+		if !again.Equal(tree, IgnorePositions()) {
+			t.Errorf("%q unparsed to %q, which parses to %s, want %s", tt.in, out, again, tree)
+		}
+	}
+}
+
+func TestUnparseRewritten(t *testing.T) {
+	lang, err := NewLanguage(unparseGrammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := lang.ParseString("let x = a + b;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRewriter("(sum a (op '+') b) -> (sum b (op '-') a)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree, err = r.Rewrite(tree); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		templates map[string]string
+		out       string
+	}{
+		{nil, "let x=b-a;"},
+		{map[string]string{"sum": "$1 $2 $3"}, "let x=b - a;"},
+		{map[string]string{"stmt": "$0\n"}, "let x=b-a;\n"},
+		{map[string]string{"name": "<$0>", "stmt": "$$$1"}, "$<x>"},
+	} {
+		out, err := lang.Unparse(tree, tt.templates)
+		if err != nil {
+			t.Errorf("%v: %s", tt.templates, err)
+		} else if string(out) != tt.out {
+			t.Errorf("%v: unparsed to %q, want %q", tt.templates, out, tt.out)
+		}
+	}
+
+	stmt := &ParseTree{Type: "stmt", Children: []*ParseTree{{Type: "name", Data: []byte("x")}, {Type: "name", Data: []byte("1")}}}
+	bad := &ParseTree{Type: "stmt+", Children: []*ParseTree{stmt}}
+	if _, err := lang.Unparse(bad, nil); err == nil || err.Error() != "cannot unparse name node at offset 0: no rule produces it" {
+		t.Errorf("got error %v", err)
+	}
+}
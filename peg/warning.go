@@ -0,0 +1,67 @@
+package peg
+
+import "fmt"
+
+// Warning is a problem with the input that does not fail the parse, such
+// as deprecated syntax.
+type Warning struct {
+	Pos int
+	Msg string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s at offset %d", w.Msg, w.Pos)
+}
+
+// Warn records a warning at pos. External matchers and predicates call it
+// while the source is parsed. Warnings recorded by matches that are
+// backtracked over are dropped.
+func (s *Source) Warn(pos int, msg string) {
+	s.warnings = append(s.warnings[:s.nwarnings], Warning{pos, msg})
+	s.nwarnings++
+}
+
+// Warnings returns the warnings of the last parse of s, in the order they
+// were recorded.
+func (s *Source) Warnings() []Warning {
+	return s.warnings[:s.nwarnings]
+}
+
+// NewWarnLexer consumes no input and records the warning msg at the next
+// token, after any %whitespace.
+func NewWarnLexer(typ, msg string) *Lexeme {
+	return &Lexeme{
+		Name: typ,
+		kind: kindCall,
+		text: "warn(" + quoteLiteral(msg) + ")",
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			s.Warn(pos+s.skipWhitespace(pos), msg)
+			return nil, nil, 0
+		},
+	}
+}
+
+// deprecation is a rule declared with %deprecated.
+type deprecation struct {
+	rule, msg string
+}
+
+// NewDeprecatedLexer matches as lex does and records a warning where each
+// match starts, after any %whitespace, as %deprecated rule 'msg' does in a
+// grammar. The warning reads "rule is deprecated: msg".
+func NewDeprecatedLexer(rule, msg string, lex *Lexeme) *Lexeme {
+	msg = rule + " is deprecated: " + msg
+	return WrapLexeme(lex, func(next LexFunc) LexFunc {
+		return func(s *Source, pos int) (*ParseTree, error, int) {
+			tree, err, n := next(s, pos)
+			if err == nil {
+				start := pos + s.skipWhitespace(pos)
+				if start > pos+n {
+					start = pos
+				}
+				s.Warn(start, msg)
+			}
+			return tree, err, n
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package peg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		language string
+		input    string
+		exp      []Warning
+	}{
+		{
+			"prgm <- stmt+\nstmt <- legacy / modern\nlegacy <- warn('var is deprecated') 'var ' name ';'\nmodern <- 'let ' name ';'\nname <- ~'[a-z]+'",
+			"let a;var b;",
+			[]Warning{{6, "var is deprecated"}},
+		},
+		{
+			"prgm <- bad / good\nbad <- warn('bad') 'a' 'b'\ngood <- 'a' 'c'",
+			"ac",
+			nil,
+		},
+		{
+			"%whitespace ws\n%memo legacy\nprgm <- stmt\nstmt <- bang / semi\nbang <- legacy '!'\nsemi <- legacy ';'\nlegacy <- warn('old') 'var'\nws <- ' '",
+			"  var;",
+			[]Warning{{2, "old"}},
+		},
+	}
+	for _, test := range tests {
+		lang, err := NewParser(strings.NewReader(test.language))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := SourceFromBytes([]byte(test.input))
+		tree, err := lang.ParseSource(s)
+		if err != nil || tree.End != len(test.input) {
+			t.Errorf("%q: %v", test.input, err)
+			continue
+		}
+		if w := s.Warnings(); !reflect.DeepEqual(w, test.exp) && (len(w) != 0 || test.exp != nil) {
+			t.Errorf("%q: got warnings %v, exp %v", test.input, w, test.exp)
+		}
+		if g := lang.Grammar(); !strings.Contains(g, "warn(") {
+			t.Errorf("Grammar() lost the warning:\n%s", g)
+		}
+	}
+
+	lang, err := NewParser(strings.NewReader("prgm <- @legacy 'x'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lang.Register("legacy", func(s *Source, pos int) (*ParseTree, error, int) {
+		s.Warn(pos, "legacy matcher")
+		return nil, nil, 0
+	})
+	s := SourceFromBytes([]byte("x"))
+	if _, err := lang.ParseSource(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lang.ParseSource(s); err != nil {
+		t.Fatal(err)
+	}
+	if w := s.Warnings(); len(w) != 1 || w[0].String() != "legacy matcher at offset 0" {
+		t.Errorf("unexpected warnings %v", w)
+	}
+
+	if _, err := NewParser(strings.NewReader("prgm <- warn()")); err == nil {
+		t.Errorf("expected an error for a warning without a message")
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	grammar := "%whitespace ws\n%deprecated legacy 'use let instead'\nprgm <- stmt+\nstmt <- legacy / modern\nlegacy <- 'var' name ';'^\nmodern <- 'let' name ';'^\nname <- ~'[a-z]+'\nws <- ' '"
+	lang, err := NewParser(strings.NewReader(grammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := SourceFromBytes([]byte("let a; var b;  var c;"))
+	if _, err := lang.ParseSource(s); err != nil {
+		t.Fatal(err)
+	}
+	exp := []Warning{{7, "legacy is deprecated: use let instead"}, {15, "legacy is deprecated: use let instead"}}
+	if w := s.Warnings(); !reflect.DeepEqual(w, exp) {
+		t.Errorf("got warnings %v, exp %v", w, exp)
+	}
+	if g := lang.Grammar(); !strings.Contains(g, "%deprecated legacy 'use let instead'\n") {
+		t.Errorf("Grammar() lost the deprecation:\n%s", g)
+	}
+
+	for _, bad := range []string{
+		"%deprecated legacy\nlegacy <- 'a'",
+		"%deprecated 'msg' legacy\nlegacy <- 'a'",
+		"%deprecated missing 'msg'\nlegacy <- 'a'",
+	} {
+		if _, err := NewParser(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
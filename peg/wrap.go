@@ -0,0 +1,32 @@
+package peg
+
+// WrapLexeme returns a lexeme that matches as lex does, through the LexFunc
+// that wrap builds around it, the way HTTP middleware wraps a handler.
+// wrap is called once, with a function that runs lex; the LexFunc it
+// returns may log, time or cache matches and decides whether to call it:
+//
+//	timed := peg.WrapLexeme(lex, func(next peg.LexFunc) peg.LexFunc {
+//		return func(s *peg.Source, pos int) (*peg.ParseTree, error, int) {
+//			start := time.Now()
+//			defer func() { log.Println(lex.Name, time.Since(start)) }()
+//			return next(s, pos)
+//		}
+//	})
+//
+// lex may be a reference made with NewRuleLexer, which is resolved when
+// the wrapped lexeme is added to a language. The grammar of the language
+// shows lex in place of the wrapped lexeme.
+func WrapLexeme(lex *Lexeme, wrap func(next LexFunc) LexFunc) *Lexeme {
+	wrapped := wrap(func(s *Source, pos int) (*ParseTree, error, int) {
+		return lex.Lexer(s, pos)
+	})
+	return &Lexeme{
+		Name:         lex.Name,
+		Dependencies: []*Lexeme{lex},
+		kind:         kindWrap,
+		Lexer: func(s *Source, pos int) (*ParseTree, error, int) {
+			defer s.guard("wrapper of "+lex.Name, pos)
+			return wrapped(s, pos)
+		},
+	}
+}
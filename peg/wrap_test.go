@@ -0,0 +1,56 @@
+package peg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrapLexeme(t *testing.T) {
+	host, err := NewParser(strings.NewReader("prgm <- item+\nitem <- plugin / num\nplugin <- '!'\nnum <- ~' ?[0-9]+'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls, matches int
+	counted := WrapLexeme(NewRuleLexer("num"), func(next LexFunc) LexFunc {
+		return func(s *Source, pos int) (*ParseTree, error, int) {
+			calls++
+			tree, err, n := next(s, pos)
+			if err == nil {
+				matches++
+			}
+			return tree, err, n
+		}
+	})
+	ext, err := host.ReplaceRule("plugin", counted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ext.ParseString("1 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Children) != 2 || string(tree.Children[1].Data) != " 2" {
+		t.Errorf("unexpected tree %s", tree.SExpr())
+	}
+	// The wrapper is tried for both items and fails at the end of input.
+	if calls != 3 || matches != 2 {
+		t.Errorf("got %d calls and %d matches, expected 3 and 2", calls, matches)
+	}
+	if !strings.Contains(ext.Grammar(), "plugin <- num\n") {
+		t.Errorf("wrapped lexeme not printed as its operand:\n%s", ext.Grammar())
+	}
+
+	// A wrapper can also stop the match.
+	never := WrapLexeme(NewLiteralLexer("plugin", "!"), func(next LexFunc) LexFunc {
+		return func(s *Source, pos int) (*ParseTree, error, int) {
+			return nil, s.failed(pos, "disabled"), 0
+		}
+	})
+	ext, err = host.ReplaceRule("plugin", never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ext.ParseString("!"); err == nil {
+		t.Errorf("expected the wrapper to fail the match")
+	}
+}